@@ -2,18 +2,63 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sethvargo/go-envconfig"
 
+	"distributed-encoder/logging"
+	"distributed-encoder/tracing"
 	"distributed-encoder/transcoder"
 	"distributed-encoder/worker"
 )
 
 type EnvConfig struct {
 	ServerAddr string `env:"SERVER_ADDR,default=http://localhost:1111"`
+
+	// Transport is http (long polling), push (jobs are pushed over WebSocket, tiles are fetched over HTTP)
+	// or grpc, the grpc transport connects to GRPCAddr
+	Transport string `env:"TRANSPORT,default=http"`
+	GRPCAddr  string `env:"GRPC_ADDR,default=localhost:1112"`
+
+	// WorkerSecret is a pre-shared secret the requests to the server are signed with
+	WorkerSecret string `env:"WORKER_SECRET"`
+
+	// TLSCAFile is a CA of the server certificate, TLSCertFile and TLSKeyFile are the worker client certificate
+	TLSCAFile   string `env:"TLS_CA_FILE"`
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+
+	// WorkerID identifies the worker in the logs and on the server without a client certificate, the hostname is a default
+	WorkerID string `env:"WORKER_ID"`
+
+	// ChunkedUpload uploads the results in resumable parts, they're sent in a single request when it's disabled
+	ChunkedUpload bool `env:"CHUNKED_UPLOAD,default=true"`
+	// UploadChunkSize is a size of the uploaded part in bytes
+	UploadChunkSize int64 `env:"UPLOAD_CHUNK_SIZE,default=8388608"`
+	// SpoolDir keeps the encoded results until they're uploaded, os.TempDir by default
+	SpoolDir string `env:"SPOOL_DIR"`
+
+	// MetricsAddr enables the prometheus metrics listener, e.g. :9100
+	MetricsAddr string `env:"METRICS_ADDR"`
+
+	// LogLevel is one of debug, info, warn or error
+	LogLevel string `env:"LOG_LEVEL,default=info"`
+	// LogFormat is one of json or text
+	LogFormat string `env:"LOG_FORMAT,default=json"`
+
+	// TraceExporter is one of none, stdout or otlp
+	TraceExporter string `env:"TRACE_EXPORTER,default=none"`
 }
 
 func main() {
@@ -22,7 +67,8 @@ func main() {
 	defer func() {
 		done()
 		if r := recover(); r != nil {
-			log.Fatal("application panic", "panic", r)
+			slog.Error("application panic", slog.Any("panic", r))
+			os.Exit(1)
 		}
 	}()
 
@@ -30,9 +76,10 @@ func main() {
 	done()
 
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("application failed", logging.Err(err))
+		os.Exit(1)
 	}
-	log.Println("successful shutdown")
+	slog.Info("successful shutdown")
 }
 
 func realMain(ctx context.Context) error {
@@ -41,19 +88,113 @@ func realMain(ctx context.Context) error {
 		return err
 	}
 
-	client := worker.NewClient(
-		cfg.ServerAddr+"/work/jobs",
-		cfg.ServerAddr+"/work/result",
-	)
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return err
+	}
+	if cfg.WorkerID == "" {
+		cfg.WorkerID, _ = os.Hostname()
+	}
+	slog.SetDefault(logger.With(logging.WorkerIDKey, cfg.WorkerID))
+
+	shutdownTracing, err := tracing.Setup(ctx, "encoder-worker", cfg.TraceExporter)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("tracing shutdown failed", logging.Err(err))
+		}
+	}()
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
 
 	w, err := worker.New(client, transcoder.New())
 	if err != nil {
 		return err
 	}
 
-	log.Println("Starting client")
+	if cfg.MetricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		if err := w.RegisterMetrics(registry); err != nil {
+			return err
+		}
+		go serveMetrics(ctx, cfg.MetricsAddr, registry)
+	}
+
+	slog.Info("starting worker")
 	if err := w.Start(ctx); err != nil {
 		return err
 	}
 	return nil
 }
+
+// serveMetrics runs the metrics listener until the context is closed
+func serveMetrics(ctx context.Context, addr string, registry *prometheus.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("metrics shutdown failed", logging.Err(err))
+		}
+	}()
+
+	slog.Info("metrics listener started", slog.String("addr", addr))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics listener failed", logging.Err(err))
+	}
+}
+
+func newClient(cfg EnvConfig) (worker.Client, error) {
+	switch cfg.Transport {
+	case "http", "push":
+		clientConfig := worker.ClientConfig{
+			PollEndpoint:     cfg.ServerAddr + "/work/jobs",
+			ResultEndpoint:   cfg.ServerAddr + "/work/result",
+			ProgressEndpoint: cfg.ServerAddr + "/work/progress",
+			FailureEndpoint:  cfg.ServerAddr + "/work/failure",
+			ChunkSize:        cfg.UploadChunkSize,
+			SpoolDir:         cfg.SpoolDir,
+			Secret:           cfg.WorkerSecret,
+			WorkerID:         cfg.WorkerID,
+			CAFile:           cfg.TLSCAFile,
+			CertFile:         cfg.TLSCertFile,
+			KeyFile:          cfg.TLSKeyFile,
+		}
+		if cfg.Transport == "push" {
+			clientConfig.PushEndpoint = "ws" + strings.TrimPrefix(cfg.ServerAddr, "http") + "/work/push"
+		}
+		if cfg.ChunkedUpload {
+			clientConfig.UploadEndpoint = cfg.ServerAddr + "/work/uploads"
+		}
+		return worker.NewClient(clientConfig)
+	case "grpc":
+		return worker.NewGRPCClient(worker.GRPCClientConfig{
+			Target:   cfg.GRPCAddr,
+			Secret:   cfg.WorkerSecret,
+			WorkerID: cfg.WorkerID,
+			CAFile:   cfg.TLSCAFile,
+			CertFile: cfg.TLSCertFile,
+			KeyFile:  cfg.TLSKeyFile,
+		})
+	default:
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"distributed-encoder/server"
+)
+
+// runBatch submits the newline-delimited requests of the file, the standard input is read for "-"
+func runBatch(ctx context.Context, c *client, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("batch", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	src := stdin
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	var result server.BatchResult
+	if err := c.do(ctx, http.MethodPost, "/work/batch", "application/x-ndjson", src, &result); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(stdout, result); err != nil {
+			return err
+		}
+	} else {
+		tw := newTable(stdout)
+		fmt.Fprintln(tw, "LINE\tJOB\tSTATE\tERROR")
+		for _, item := range result.Items {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", item.Line, item.JobID, item.State, item.Error)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "batch %s: %d accepted, %d rejected\n", result.ID, result.Accepted, result.Rejected)
+	}
+	if result.Rejected > 0 {
+		return errPartial
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"distributed-encoder/server"
+	"distributed-encoder/worker"
+)
+
+// requestTimeout limits the requests which are not streamed
+const requestTimeout = 30 * time.Second
+
+// client calls the job API of the server
+type client struct {
+	addr   string
+	apiKey string
+	http   *http.Client
+}
+
+func newClient(cfg EnvConfig) (*client, error) {
+	httpClient := &http.Client{}
+	if cfg.TLSCAFile != "" || cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		tlsConfig, err := worker.LoadClientTLSConfig(cfg.TLSCAFile, cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
+	return &client{
+		addr:   strings.TrimSuffix(cfg.ServerAddr, "/"),
+		apiKey: cfg.APIKey,
+		http:   httpClient,
+	}, nil
+}
+
+// apiError is the error response of the server
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("server responded %d: %s", e.Status, e.Message)
+}
+
+// newRequest creates the authenticated request to the path of the API
+func (c *client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
+// send sends the request, *apiError is returned for the error responses, the caller closes the body of the response
+func (c *client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		// the problem details, the detail lists the invalid fields too
+		var problem server.Problem
+		_ = json.NewDecoder(resp.Body).Decode(&problem)
+		message := problem.Detail
+		if message == "" {
+			message = problem.Error
+		}
+		return nil, &apiError{Status: resp.StatusCode, Message: message}
+	}
+	return resp, nil
+}
+
+// do sends the request with the body of the content type and decodes the JSON response to out
+func (c *client) do(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	return c.doRequest(ctx, method, path, body, func(req *http.Request) {
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}, out)
+}
+
+// doRequest sends the request prepared by prepare within requestTimeout and decodes the JSON response to out
+func (c *client) doRequest(ctx context.Context, method, path string, body io.Reader, prepare func(req *http.Request), out any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	prepare(req)
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("response can't be decoded: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"distributed-encoder/server"
+)
+
+// runSubmit triggers the encoding of the file
+func runSubmit(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("submit", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the job as JSON")
+	var request server.EncodeVideoRequest
+	flags.IntVar(&request.Tiles, "tiles", 4, "amount of the tiles")
+	flags.IntVar(&request.Width, "width", 0, "width of the video")
+	flags.IntVar(&request.Height, "height", 0, "height of the video")
+	flags.IntVar(&request.Priority, "priority", 0, "dispatch priority")
+	flags.StringVar(&request.Submitter, "submitter", "", "tenant of the request")
+	flags.StringVar(&request.CallbackURL, "callback", "", "url receiving the job events")
+	flags.StringVar(&request.JobID, "job-id", "", "id of the job chosen by the client")
+	idempotencyKey := flags.String("idempotency-key", "", "key of the retried request")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	request.FilePath = flags.Arg(0)
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	var status server.JobStatus
+	err = c.doRequest(ctx, http.MethodPost, "/work/trigger", bytes.NewReader(body), func(req *http.Request) {
+		req.Header.Set("Content-Type", "application/json")
+		if *idempotencyKey != "" {
+			req.Header.Set(server.IdempotencyKeyHeader, *idempotencyKey)
+		}
+	}, &status)
+	if err != nil {
+		return err
+	}
+	return printJob(stdout, &status, *asJSON)
+}
+
+// runStatus prints the job with its tiles
+func runStatus(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	return jobCommand(ctx, c, "status", http.MethodGet, "", args, stdout)
+}
+
+// runCancel cancels the unfinished tiles of the job
+func runCancel(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	return jobCommand(ctx, c, "cancel", http.MethodPost, "/cancel", args, stdout)
+}
+
+// runRetry queues the failed tiles of the job again
+func runRetry(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	return jobCommand(ctx, c, "retry", http.MethodPost, "/retry", args, stdout)
+}
+
+// jobCommand calls the endpoint of the job given as the only argument and prints the returned job
+func jobCommand(ctx context.Context, c *client, name, method, suffix string, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the job as JSON")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	var status server.JobStatus
+	if err := c.do(ctx, method, "/work/jobs/"+url.PathEscape(flags.Arg(0))+suffix, "", nil, &status); err != nil {
+		return err
+	}
+	return printJob(stdout, &status, *asJSON)
+}
+
+// printJob prints the job and the table of its tiles
+func printJob(w io.Writer, job *server.JobStatus, asJSON bool) error {
+	if asJSON {
+		return printJSON(w, job)
+	}
+	state := string(job.State)
+	if job.Cancelled {
+		state += " (cancelled)"
+	}
+	fmt.Fprintf(w, "job %s: %s %.1f%%, %s\n", job.ID, state, job.Percent, job.Request.FilePath)
+
+	tw := newTable(w)
+	fmt.Fprintln(tw, "TILE\tNAME\tSTATE\tWORKER\tPERCENT\tERROR")
+	for _, tile := range job.Tiles {
+		var percent float64
+		switch {
+		case tile.State == server.StateCompleted:
+			percent = 100
+		case tile.Progress != nil:
+			percent = tile.Progress.Percent
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%.1f\t%s\n", tile.Num, tile.Name, tile.State, tile.Worker, percent, tile.Error)
+	}
+	return tw.Flush()
+}
+
+// runList prints the page of the job history
+func runList(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the page as JSON")
+	query := url.Values{}
+	for _, name := range []string{"state", "file", "submitter", "batch", "since", "until", "limit", "cursor"} {
+		flags.Func(name, "the "+name+" filter of the history", func(value string) error {
+			query.Set(name, value)
+			return nil
+		})
+	}
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	var list server.JobList
+	if err := c.do(ctx, http.MethodGet, "/work/jobs?"+query.Encode(), "", nil, &list); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(stdout, list)
+	}
+
+	tw := newTable(stdout)
+	fmt.Fprintln(tw, "ID\tSTATE\tPERCENT\tTILES\tSUBMITTER\tCREATED\tFILE")
+	for _, job := range list.Jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%.1f\t%d/%d\t%s\t%s\t%s\n", job.ID, job.State, job.Percent,
+			job.Stats.CompletedTiles, job.Stats.Tiles, job.Request.Submitter, job.CreatedAt.Format(time.RFC3339), job.Request.FilePath)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if list.Next != nil {
+		cursor, err := list.Next.MarshalText()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "next page: -cursor %s\n", cursor)
+	}
+	return nil
+}
+
+// runWorkers prints the workers and their running tiles
+func runWorkers(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("workers", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the workers as JSON")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	var resp struct {
+		Workers []server.WorkerStatus `json:"workers"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/work/workers", "", nil, &resp); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(stdout, resp)
+	}
+
+	tw := newTable(stdout)
+	fmt.Fprintln(tw, "WORKER\tLAST SEEN\tTILES")
+	for _, w := range resp.Workers {
+		lastSeen := "-"
+		if !w.LastSeen.IsZero() {
+			lastSeen = w.LastSeen.Format(time.RFC3339)
+		}
+		var tiles []string
+		for _, tile := range w.Tiles {
+			tiles = append(tiles, fmt.Sprintf("%s/%d %.1f%%", tile.JobID, tile.TileNum, tile.Percent))
+		}
+		running := strings.Join(tiles, ", ")
+		if running == "" {
+			running = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", w.ID, lastSeen, running)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,177 @@
+// Command encoderctl manages the jobs of the encoder server over its HTTP API
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+type EnvConfig struct {
+	ServerAddr string `env:"SERVER_ADDR,default=http://localhost:1111"`
+	// APIKey authenticates the requests to the job API
+	APIKey string `env:"API_KEY"`
+	// TLSCAFile is a CA of the server certificate, TLSCertFile and TLSKeyFile are the client certificate
+	TLSCAFile   string `env:"TLS_CA_FILE"`
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+}
+
+// the exit codes of the commands
+const (
+	exitOK = 0
+	// exitFailed is returned when the command failed
+	exitFailed = 1
+	// exitUsage is returned when the command line is invalid
+	exitUsage = 2
+	// exitPartial is returned when some requests of the batch are rejected
+	exitPartial = 3
+	// exitJobFailed is returned when the watched job is failed
+	exitJobFailed = 4
+	// exitNotFound is returned when the job or the batch is not found
+	exitNotFound = 5
+)
+
+var (
+	// errPartial fails the command with exitPartial
+	errPartial = errors.New("some requests are rejected")
+
+	// errJobFailed fails the command with exitJobFailed
+	errJobFailed = errors.New("job is failed")
+)
+
+// command runs the subcommand with its arguments
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, c *client, args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = []command{
+	{name: "submit", usage: "submit [-json] -width W -height H [-tiles N] [-priority P] [-submitter S] [-callback URL] [-job-id ID] [-idempotency-key KEY] FILE", run: runSubmit},
+	{name: "batch", usage: "batch [-json] FILE|-", run: runBatch},
+	{name: "status", usage: "status [-json] JOB", run: runStatus},
+	{name: "watch", usage: "watch [-json] JOB", run: runWatch},
+	{name: "cancel", usage: "cancel [-json] JOB", run: runCancel},
+	{name: "retry", usage: "retry [-json] JOB", run: runRetry},
+	{name: "list", usage: "list [-json] [-state S,...] [-file F] [-submitter S] [-batch ID] [-since T] [-until T] [-limit N] [-cursor C]", run: runList},
+	{name: "workers", usage: "workers [-json]", run: runWorkers},
+}
+
+func main() {
+	ctx, done := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := realMain(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	done()
+	os.Exit(code)
+}
+
+func realMain(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return exitUsage
+	}
+	i := slices.IndexFunc(commands, func(cmd command) bool {
+		return cmd.name == args[0]
+	})
+	if i < 0 {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		printUsage(stderr)
+		return exitUsage
+	}
+	cmd := commands[i]
+
+	var cfg EnvConfig
+	if err := envconfig.Process(ctx, &cfg); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	c, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	err = cmd.run(ctx, c, args[1:], stdin, stdout)
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(stderr, err)
+
+	var usage usageError
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &usage):
+		fmt.Fprintf(stderr, "usage: encoderctl %s\n", cmd.usage)
+		return exitUsage
+	case errors.Is(err, errPartial):
+		return exitPartial
+	case errors.Is(err, errJobFailed):
+		return exitJobFailed
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound:
+		return exitNotFound
+	default:
+		return exitFailed
+	}
+}
+
+// usageError is returned when the arguments of the command are invalid
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+// parseFlags parses the flags of the command and checks the amount of its positional arguments
+func parseFlags(flags *flag.FlagSet, args []string, nargs int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != nargs {
+		return usageError{fmt.Errorf("%d argument(s) expected, %d given", nargs, flags.NArg())}
+	}
+	return nil
+}
+
+// printJSON prints the response as a JSON line
+func printJSON(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// newTable returns a writer aligning the tab separated columns, it's flushed by the caller
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: encoderctl COMMAND [ARGS]\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n", cmd.usage)
+	}
+	fmt.Fprintf(w, `
+the server is set by SERVER_ADDR and the API key by API_KEY, TLS_CA_FILE verifies the server
+and TLS_CERT_FILE with TLS_KEY_FILE is the client certificate
+
+exit codes:
+  %d  success
+  %d  the request failed
+  %d  the command line is invalid
+  %d  some requests of the batch are rejected
+  %d  the watched job is failed
+  %d  the job or the batch is not found
+`, exitOK, exitFailed, exitUsage, exitPartial, exitJobFailed, exitNotFound)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	jobJSON = `{"id":"1d2f","state":"running","percent":50,"request":{"tiles":2,"width":20,"height":10,"filePath":"/videos/video.mp4"},
+		"tiles":[{"num":0,"name":"video_tile_0","state":"completed","worker":"worker-1"},
+		{"num":1,"name":"video_tile_1","state":"failed","error":"disk is full"}],"createdAt":"2024-01-02T03:04:05Z","updatedAt":"2024-01-02T03:04:05Z"}`
+	// the table pads the empty last column
+	jobTable = "job 1d2f: running 50.0%, /videos/video.mp4\n" +
+		"TILE  NAME          STATE      WORKER    PERCENT  ERROR\n" +
+		"0     video_tile_0  completed  worker-1  100.0    \n" +
+		"1     video_tile_1  failed               0.0      disk is full\n"
+)
+
+// newAPI starts the job API answering the requests with the canned responses
+func newAPI(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	respond := func(pattern string, status int, body string) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		})
+	}
+	respond("POST /work/trigger", http.StatusOK, jobJSON)
+	respond("GET /work/jobs/1d2f", http.StatusOK, jobJSON)
+	respond("GET /work/jobs/unknown", http.StatusNotFound, `{"status":404,"detail":"job is not found"}`)
+	respond("GET /work/jobs/broken", http.StatusInternalServerError, `{"status":500,"detail":"store is unavailable"}`)
+	respond("POST /work/jobs/1d2f/cancel", http.StatusOK, strings.Replace(jobJSON, `"state":"running"`, `"state":"failed","cancelled":true`, 1))
+	respond("POST /work/jobs/1d2f/retry", http.StatusOK, strings.Replace(jobJSON, `"state":"failed","error":"disk is full"`, `"state":"queued"`, 1))
+	respond("POST /work/batch", http.StatusOK, `{"id":"b1c2","accepted":1,"rejected":1,"items":[
+		{"line":1,"jobId":"1d2f","state":"queued"},{"line":2,"error":"tiles: must be positive"}]}`)
+	respond("GET /work/jobs", http.StatusOK, `{"jobs":[{"id":"1d2f","state":"completed","percent":100,
+		"request":{"filePath":"/videos/video.mp4","submitter":"studio"},"tiles":[],"createdAt":"2024-01-02T03:04:05Z",
+		"stats":{"tiles":2,"completedTiles":2}}],"summary":{},"next":"MTcwNDE2NDY0NTAwMDAwMDAwMDoxZDJm"}`)
+	respond("GET /work/workers", http.StatusOK, `{"workers":[{"id":"worker-1","lastSeen":"2024-01-02T03:04:05Z",
+		"tiles":[{"jobId":"1d2f","tileNum":1,"percent":25}]},{"id":"worker-2","tiles":[]}]}`)
+	for id, state := range map[string]string{"1d2f": "completed", "a1b2": "failed"} {
+		respond("GET /work/jobs/"+id+"/events", http.StatusOK, fmt.Sprintf(`: keep-alive
+
+data: {"type":"tile.completed","jobId":"%[1]s","time":"2024-01-02T03:04:05Z","tile":{"num":0,"name":"video_tile_0","state":"completed","worker":"worker-1"}}
+
+data: {"type":"job.%[2]s","jobId":"%[1]s","time":"2024-01-02T03:04:05Z","job":{"id":"%[1]s","state":"%[2]s","percent":100,"tiles":[]}}
+
+`, id, state))
+	}
+	respond("GET /work/jobs/e5f6/events", http.StatusOK, "")
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRealMain(t *testing.T) {
+	srv := newAPI(t)
+	t.Setenv("SERVER_ADDR", srv.URL+"/")
+	t.Setenv("API_KEY", "secret")
+	eventTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Local().Format(time.TimeOnly)
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{name: "no command", code: exitUsage, stderr: "usage: encoderctl COMMAND"},
+		{name: "unknown command", args: []string{"stop"}, code: exitUsage, stderr: `unknown command "stop"`},
+		{name: "submit", args: []string{"submit", "-width", "20", "-height", "10", "-tiles", "2", "/videos/video.mp4"}, stdout: jobTable},
+		{name: "submit json", args: []string{"submit", "-json", "-width", "20", "-height", "10", "/videos/video.mp4"}, stdout: `{"id":"1d2f","state":"running"`},
+		{name: "submit without file", args: []string{"submit", "-width", "20"}, code: exitUsage, stderr: "usage: encoderctl submit"},
+		{name: "submit invalid flag", args: []string{"submit", "-tiles", "many", "video.mp4"}, code: exitUsage, stderr: "invalid value"},
+		{
+			name: "batch", args: []string{"batch", "-"}, stdin: "{}\n{}\n", code: exitPartial,
+			stdout: "LINE  JOB   STATE   ERROR\n" +
+				"1     1d2f  queued  \n" +
+				"2                   tiles: must be positive\n" +
+				"batch b1c2: 1 accepted, 1 rejected\n",
+		},
+		{name: "batch missing file", args: []string{"batch", "missing.jsonl"}, code: exitFailed, stderr: "no such file"},
+		{name: "status", args: []string{"status", "1d2f"}, stdout: jobTable},
+		{name: "status json", args: []string{"status", "-json", "1d2f"}, stdout: `"tiles":[{"num":0,"name":"video_tile_0","state":"completed"`},
+		{name: "status not found", args: []string{"status", "unknown"}, code: exitNotFound, stderr: "server responded 404: job is not found"},
+		{name: "status server error", args: []string{"status", "broken"}, code: exitFailed, stderr: "server responded 500: store is unavailable"},
+		{name: "status without job", args: []string{"status"}, code: exitUsage, stderr: "1 argument(s) expected, 0 given"},
+		{name: "watch completed", args: []string{"watch", "1d2f"}, stdout: eventTime + `  tile.completed   tile 0 completed on worker-1
+` + eventTime + `  job.completed    job 1d2f completed 100.0%
+`},
+		{name: "watch failed", args: []string{"watch", "a1b2"}, code: exitJobFailed, stderr: "job is failed", stdout: "job a1b2 failed"},
+		{name: "watch json", args: []string{"watch", "-json", "1d2f"}, stdout: `{"type":"tile.completed","jobId":"1d2f"`},
+		{name: "watch closed", args: []string{"watch", "e5f6"}, code: exitFailed, stderr: "event stream is closed before the job is finished"},
+		{name: "cancel", args: []string{"cancel", "1d2f"}, stdout: "job 1d2f: failed (cancelled) 50.0%"},
+		{name: "retry", args: []string{"retry", "1d2f"}, stdout: "1     video_tile_1  queued"},
+		{name: "list", args: []string{"list", "-state", "completed"}, stdout: `ID    STATE      PERCENT  TILES  SUBMITTER  CREATED               FILE
+1d2f  completed  100.0    2/2    studio     2024-01-02T03:04:05Z  /videos/video.mp4
+next page: -cursor MTcwNDE2NDY0NTAwMDAwMDAwMDoxZDJm
+`},
+		{name: "list with argument", args: []string{"list", "failed"}, code: exitUsage, stderr: "0 argument(s) expected, 1 given"},
+		{name: "workers", args: []string{"workers"}, stdout: `WORKER    LAST SEEN             TILES
+worker-1  2024-01-02T03:04:05Z  1d2f/1 25.0%
+worker-2  -                     -
+`},
+		{name: "workers json", args: []string{"workers", "-json"}, stdout: `{"workers":[{"id":"worker-1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := realMain(context.Background(), tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			require.Equal(t, tt.code, code, stderr.String())
+			require.Contains(t, stdout.String(), tt.stdout)
+			require.Contains(t, stderr.String(), tt.stderr)
+			if tt.code == exitOK {
+				require.Empty(t, stderr.String())
+			}
+		})
+	}
+
+	// the key is required by the API
+	t.Setenv("API_KEY", "")
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, exitFailed, realMain(context.Background(), []string{"status", "1d2f"}, nil, &stdout, &stderr))
+	require.Contains(t, stderr.String(), "server responded 401")
+}
+
+func TestRealMain_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir)
+	clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	clientCA, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, `{"workers":[]}`)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(clientCA)
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+	t.Setenv("SERVER_ADDR", srv.URL)
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		code   int
+		stderr string
+	}{
+		{name: "client certificate", env: map[string]string{"TLS_CA_FILE": caFile, "TLS_CERT_FILE": certFile, "TLS_KEY_FILE": keyFile}},
+		{name: "unknown server", env: map[string]string{"TLS_CERT_FILE": certFile, "TLS_KEY_FILE": keyFile}, code: exitFailed, stderr: "certificate"},
+		{name: "no client certificate", env: map[string]string{"TLS_CA_FILE": caFile}, code: exitFailed, stderr: "certificate"},
+		{name: "missing key", env: map[string]string{"TLS_CA_FILE": caFile, "TLS_CERT_FILE": certFile}, code: exitFailed, stderr: "can't load client certificate"},
+		{name: "missing CA", env: map[string]string{"TLS_CA_FILE": filepath.Join(dir, "missing.pem")}, code: exitFailed, stderr: "can't read CA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"TLS_CA_FILE", "TLS_CERT_FILE", "TLS_KEY_FILE"} {
+				t.Setenv(name, tt.env[name])
+			}
+			var stdout, stderr bytes.Buffer
+			code := realMain(context.Background(), []string{"workers"}, nil, &stdout, &stderr)
+
+			require.Equal(t, tt.code, code, stderr.String())
+			require.Contains(t, stderr.String(), tt.stderr)
+			if tt.code == exitOK {
+				require.Equal(t, "WORKER  LAST SEEN  TILES\n", stdout.String())
+			}
+		})
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "operator"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
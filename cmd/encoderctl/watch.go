@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"distributed-encoder/server"
+)
+
+// runWatch prints the events of the job until it's finished, errJobFailed is returned when the job fails
+func runWatch(ctx context.Context, c *client, args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the events as JSON lines")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/work/jobs/"+url.PathEscape(flags.Arg(0))+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the events are single data lines, the comments keep the stream alive
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e server.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return fmt.Errorf("event can't be decoded: %w", err)
+		}
+		if *asJSON {
+			fmt.Fprintln(stdout, data)
+		} else {
+			printEvent(stdout, &e)
+		}
+
+		if e.Job == nil || (e.Type != server.EventJobStatus && e.Type != server.EventJobCompleted && e.Type != server.EventJobFailed) {
+			continue
+		}
+		switch e.Job.State {
+		case server.StateCompleted:
+			return nil
+		case server.StateFailed:
+			return errJobFailed
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errors.New("event stream is closed before the job is finished")
+}
+
+// printEvent prints the event as a line
+func printEvent(w io.Writer, e *server.Event) {
+	ts := e.Time.Local().Format(time.TimeOnly)
+	switch {
+	case e.Tile != nil:
+		tile := e.Tile
+		line := fmt.Sprintf("%s  %-16s tile %d %s", ts, e.Type, tile.Num, tile.State)
+		if tile.Worker != "" {
+			line += " on " + tile.Worker
+		}
+		if tile.Progress != nil {
+			line += fmt.Sprintf(" %.1f%%", tile.Progress.Percent)
+		}
+		if tile.Error != "" {
+			line += ": " + tile.Error
+		}
+		fmt.Fprintln(w, line)
+	case e.Job != nil:
+		fmt.Fprintf(w, "%s  %-16s job %s %s %.1f%%\n", ts, e.Type, e.Job.ID, e.Job.State, e.Job.Percent)
+	default:
+		fmt.Fprintf(w, "%s  %s\n", ts, e.Type)
+	}
+}
@@ -2,24 +2,98 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sethvargo/go-envconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"distributed-encoder/logging"
 	"distributed-encoder/server"
+	"distributed-encoder/server/natsqueue"
+	"distributed-encoder/server/sqlstore"
+	"distributed-encoder/tracing"
 	"distributed-encoder/transcoder"
 )
 
 type EnvConfig struct {
-	Addr       string `env:"ADDR,default=:1111"`
+	Addr string `env:"ADDR,default=:1111"`
+	// GRPCAddr enables the gRPC worker service next to the HTTP endpoints, e.g. :1112
+	GRPCAddr   string `env:"GRPC_ADDR"`
 	ResultPath string `env:"RESULT_PATH"`
+	// InputRoots are the directories the input files are allowed from, any path is allowed when it's empty
+	InputRoots []string `env:"INPUT_ROOTS"`
+
+	// SchedulingPolicy and QueueSubmitterLimit apply to the in-memory queue only, fifo is a default policy
+	SchedulingPolicy string `env:"SCHEDULING_POLICY"`
+
+	QueueSize           int `env:"QUEUE_SIZE,default=1000"`
+	QueueSubmitterLimit int `env:"QUEUE_SUBMITTER_LIMIT"`
+
+	// IdempotencyKeyTTL is a time the Idempotency-Key of the trigger requests is remembered
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL,default=24h"`
+
+	// JobRetention is a time the finished jobs are kept in memory, the jobs in DBPath are kept forever
+	JobRetention time.Duration `env:"JOB_RETENTION,default=24h"`
+
+	// DBPath is a SQLite database of the jobs shared by the replicas, the jobs are kept in memory when it's empty.
+	// The tiles are queued in the database too unless NATSURL is set
+	DBPath string `env:"DB_PATH"`
+
+	// NATSURL moves the queue to a NATS JetStream stream, e.g. nats://localhost:4222, the queue is in memory when it's empty
+	NATSURL    string `env:"NATS_URL"`
+	NATSStream string `env:"NATS_STREAM,default=ENCODER_TILES"`
+
+	// LeaseTTL is a time the worker has to upload the result or report the progress before the tile is dispatched again
+	LeaseTTL time.Duration `env:"LEASE_TTL,default=10m"`
+	// UploadDir keeps the parts of the uploaded results, os.TempDir by default
+	UploadDir string `env:"UPLOAD_DIR"`
+	// MaxUploadLength is a maximum length of the result uploaded in parts, 4GiB by default
+	MaxUploadLength int64 `env:"MAX_UPLOAD_LENGTH"`
+
+	// TLSCertFile and TLSKeyFile enable HTTPS
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+	// TLSClientCAFile enables the client certificates verification, they're required on the worker endpoints
+	TLSClientCAFile string `env:"TLS_CLIENT_CA_FILE"`
+
+	// WorkerSecret is a pre-shared secret of the workers, worker endpoints are open when it's empty
+	WorkerSecret string `env:"WORKER_SECRET"`
+	// APIKeys are the keys of the job API, the API is open when they're empty
+	APIKeys []string `env:"API_KEYS"`
+
+	WebhookSecret      string `env:"WEBHOOK_SECRET"`
+	WebhookMaxAttempts int    `env:"WEBHOOK_MAX_ATTEMPTS,default=5"`
+	// WebhookAllowedHosts are the hosts and the CIDR ranges with internal addresses the callbacks may point to
+	WebhookAllowedHosts []string `env:"WEBHOOK_ALLOWED_HOSTS"`
+
+	// WatchConfig is a JSON file with the array of the watched folders, nothing is watched when it's empty
+	WatchConfig     string        `env:"WATCH_CONFIG"`
+	WatchInterval   time.Duration `env:"WATCH_INTERVAL,default=5s"`
+	WatchSettleTime time.Duration `env:"WATCH_SETTLE_TIME,default=10s"`
+
+	// LogLevel is one of debug, info, warn or error
+	LogLevel string `env:"LOG_LEVEL,default=info"`
+	// LogFormat is one of json or text
+	LogFormat string `env:"LOG_FORMAT,default=json"`
+
+	// TraceExporter is one of none, stdout or otlp
+	TraceExporter string `env:"TRACE_EXPORTER,default=none"`
 
 	WorkersAddr []string `env:"WORKERS_ADDR"`
 }
@@ -30,7 +104,8 @@ func main() {
 	defer func() {
 		done()
 		if r := recover(); r != nil {
-			log.Fatal("application panic", "panic", r)
+			slog.Error("application panic", slog.Any("panic", r))
+			os.Exit(1)
 		}
 	}()
 
@@ -38,45 +113,230 @@ func main() {
 	done()
 
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("application failed", logging.Err(err))
+		os.Exit(1)
+	}
+	slog.Info("successful shutdown")
+}
+
+// checkQueueConfig rejects the scheduling settings of the in-memory queue when the queue is in the database or NATS,
+// they'd be ignored there
+func checkQueueConfig(cfg *EnvConfig) error {
+	if cfg.DBPath == "" && cfg.NATSURL == "" {
+		return nil
+	}
+	if cfg.SchedulingPolicy != "" && server.SchedulingPolicy(cfg.SchedulingPolicy) != server.PolicyFIFO {
+		return fmt.Errorf("SCHEDULING_POLICY %q can't be used with DB_PATH or NATS_URL, their queues are FIFO", cfg.SchedulingPolicy)
+	}
+	if cfg.QueueSubmitterLimit != 0 {
+		return fmt.Errorf("QUEUE_SUBMITTER_LIMIT can't be used with DB_PATH or NATS_URL, it applies to the in-memory queue only")
 	}
-	log.Println("successful shutdown")
+	return nil
 }
 
 func realMain(ctx context.Context) error {
 	var cfg EnvConfig
 	if err := envconfig.Process(ctx, &cfg); err != nil {
-		log.Fatalf("Can't log env config: %s", err)
+		return fmt.Errorf("can't load env config: %w", err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(ctx, "encoder-server", cfg.TraceExporter)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("tracing shutdown failed", logging.Err(err))
+		}
+	}()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if err := checkQueueConfig(&cfg); err != nil {
 		return err
 	}
 
+	var jobStore server.JobStore
+	var queue server.Queue
+	var nonces server.NonceStore
+	if cfg.DBPath != "" {
+		db, err := sqlstore.Open(cfg.DBPath)
+		if err != nil {
+			return fmt.Errorf("can't open the database: %w", err)
+		}
+		defer db.Close()
+		jobStore = db
+		nonces = db
+		queue = sqlstore.NewQueue(db, sqlstore.QueueConfig{MaxQueuedTiles: cfg.QueueSize})
+		slog.Info("jobs are kept in the database", slog.String("path", cfg.DBPath))
+	}
+	if cfg.NATSURL != "" {
+		conn, err := nats.Connect(cfg.NATSURL, nats.Name("encoder-server"))
+		if err != nil {
+			return fmt.Errorf("can't connect to NATS: %w", err)
+		}
+		defer conn.Close()
+		if queue, err = natsqueue.New(natsqueue.Config{
+			Conn:           conn,
+			Stream:         cfg.NATSStream,
+			MaxQueuedTiles: cfg.QueueSize,
+		}); err != nil {
+			return err
+		}
+		slog.Info("jobs are queued in NATS", slog.String("url", cfg.NATSURL), slog.String("stream", cfg.NATSStream))
+	}
+
+	var watchFolders []server.WatchFolder
+	if cfg.WatchConfig != "" {
+		data, err := os.ReadFile(cfg.WatchConfig)
+		if err != nil {
+			return fmt.Errorf("can't read the watch config: %w", err)
+		}
+		if err := json.Unmarshal(data, &watchFolders); err != nil {
+			return fmt.Errorf("can't decode the watch config: %w", err)
+		}
+	}
+
 	srv, err := server.New(server.Config{
-		DispatchTimeout: 30 * time.Second,
+		JobStore:         jobStore,
+		Queue:            queue,
+		DispatchTimeout:  30 * time.Second,
+		SchedulingPolicy: server.SchedulingPolicy(cfg.SchedulingPolicy),
+
+		MaxQueuedTiles:             cfg.QueueSize,
+		MaxQueuedTilesPerSubmitter: cfg.QueueSubmitterLimit,
+		IdempotencyKeyTTL:          cfg.IdempotencyKeyTTL,
+		JobRetention:               cfg.JobRetention,
+		LeaseTTL:                   cfg.LeaseTTL,
+		UploadDir:                  cfg.UploadDir,
+		MaxUploadLength:            cfg.MaxUploadLength,
+
+		MetricsRegisterer: registry,
+		Logger:            logger,
+
+		Webhook: server.WebhookConfig{
+			Secret:       cfg.WebhookSecret,
+			MaxAttempts:  cfg.WebhookMaxAttempts,
+			AllowedHosts: cfg.WebhookAllowedHosts,
+		},
+
+		Watch: server.WatchConfig{
+			Folders:    watchFolders,
+			Interval:   cfg.WatchInterval,
+			SettleTime: cfg.WatchSettleTime,
+		},
+
+		InputRoots: cfg.InputRoots,
+
 		Store: &server.FSObjectStore{
 			Path: cfg.ResultPath,
 		},
 		TileStreamer: transcoder.New(),
 	})
 	if err != nil {
-		log.Fatalf("Can't start server service: %s", err)
-		return err
+		return fmt.Errorf("can't start server service: %w", err)
 	}
 	defer srv.Close()
 
-	workHandler := server.HTTPHandler{Service: srv}
+	workHandler := server.HTTPHandler{
+		Service: srv,
+		Logger:  logger.With(logging.ComponentKey, "http"),
+	}
+
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	auth := server.Auth{
+		WorkerSecret:      cfg.WorkerSecret,
+		APIKeys:           cfg.APIKeys,
+		RequireClientCert: cfg.TLSClientCAFile != "",
+		Nonces:            nonces,
+	}
+	if cfg.WorkerSecret == "" && !auth.RequireClientCert {
+		slog.Warn("WORKER_SECRET is empty, worker endpoints are not authenticated")
+	}
+	if len(cfg.InputRoots) == 0 {
+		slog.Warn("INPUT_ROOTS is empty, any file on the server can be encoded")
+	}
+	if len(cfg.APIKeys) == 0 {
+		slog.Warn("API_KEYS is empty, job API is not authenticated")
+	}
 
 	router := httprouter.New()
 
-	router.HandlerFunc(http.MethodPost, "/work/jobs", workHandler.Dispatch)
-	router.HandlerFunc(http.MethodPost, "/work/result", workHandler.AcceptResult)
-	router.HandlerFunc(http.MethodPost, "/work/trigger", workHandler.Trigger)
+	router.HandlerFunc(http.MethodPost, "/work/jobs", auth.Worker(workHandler.Dispatch))
+	router.HandlerFunc(http.MethodPost, "/work/result", auth.Worker(workHandler.AcceptResult))
+	router.HandlerFunc(http.MethodPost, "/work/progress", auth.Worker(workHandler.ReportProgress))
+	router.HandlerFunc(http.MethodPost, "/work/failure", auth.Worker(workHandler.ReportFailure))
+	router.HandlerFunc(http.MethodGet, "/work/push", auth.Worker(workHandler.Push))
+	router.HandlerFunc(http.MethodGet, "/work/jobs/:id/tiles/:num", auth.Worker(workHandler.StreamTile))
+	router.HandlerFunc(http.MethodPost, "/work/uploads", auth.Worker(workHandler.InitiateUpload))
+	router.HandlerFunc(http.MethodPut, "/work/uploads/:id", auth.Worker(workHandler.UploadPart))
+	router.HandlerFunc(http.MethodHead, "/work/uploads/:id", auth.Worker(workHandler.UploadStatus))
+	router.HandlerFunc(http.MethodPost, "/work/uploads/:id/complete", auth.Worker(workHandler.CompleteUpload))
+	router.HandlerFunc(http.MethodPost, "/work/trigger", auth.API(workHandler.Trigger))
+	router.HandlerFunc(http.MethodPost, "/work/batch", auth.API(workHandler.TriggerBatch))
+	router.HandlerFunc(http.MethodGet, "/work/batches/:id", auth.API(workHandler.BatchStatus))
+	router.HandlerFunc(http.MethodGet, "/work/jobs", auth.API(workHandler.ListJobs))
+	router.HandlerFunc(http.MethodGet, "/work/jobs/:id", auth.API(workHandler.JobStatus))
+	router.HandlerFunc(http.MethodGet, "/work/jobs/:id/events", auth.API(workHandler.JobEvents))
+	router.HandlerFunc(http.MethodPost, "/work/jobs/:id/cancel", auth.API(workHandler.CancelJob))
+	router.HandlerFunc(http.MethodPost, "/work/jobs/:id/retry", auth.API(workHandler.RetryJob))
+	router.HandlerFunc(http.MethodGet, "/work/workers", auth.API(workHandler.Workers))
+	router.Handler(http.MethodGet, "/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
-	log.Println("HTTP Server started on addr: ", cfg.Addr)
+	var tlsConfig *tls.Config
+	if cfg.TLSCertFile != "" {
+		if tlsConfig, err = server.LoadTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile); err != nil {
+			return err
+		}
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddr != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = server.NewGRPCServer(server.GRPCService{
+			Service: srv,
+			Logger:  logger.With(logging.ComponentKey, "grpc"),
+		}, auth, opts...)
+
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("can't listen gRPC: %w", err)
+		}
+		slog.Info("gRPC server started", slog.String("addr", cfg.GRPCAddr), slog.Bool("tls", tlsConfig != nil))
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				slog.Error("gRPC server failed", logging.Err(err))
+			}
+		}()
+	}
 
 	server := http.Server{
-		Addr:    cfg.Addr,
-		Handler: router,
+		Addr:      cfg.Addr,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
+	slog.Info("HTTP server started", slog.String("addr", cfg.Addr), slog.Bool("tls", server.TLSConfig != nil))
+	// long polls and event streams are not finished by Shutdown on their own
+	server.RegisterOnShutdown(func() {
+		srv.Close()
+	})
 
 	// Spawn a goroutine that listens for context closure. When the context is
 	// closed, the server is stopped.
@@ -84,15 +344,25 @@ func realMain(ctx context.Context) error {
 	go func() {
 		<-ctx.Done()
 
-		log.Println("server.Serve: context closed")
+		slog.Info("context closed")
 		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
 		defer done()
 
-		log.Println("server.Serve: shutting down")
-		errCh <- server.Shutdown(shutdownCtx)
+		slog.Info("shutting down")
+		err := server.Shutdown(shutdownCtx)
+		if grpcServer != nil {
+			// the jobs streams are finished by the closed service
+			grpcServer.GracefulStop()
+		}
+		errCh <- err
 	}()
 	// Run the server. This will block until the provided context is closed.
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("failed to serve: %w", err)
 	}
 
@@ -0,0 +1,72 @@
+// Package logging configures the structured logging for the binaries
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// FormatJSON writes a JSON object per record
+	FormatJSON = "json"
+	// FormatText writes key=value pairs per record
+	FormatText = "text"
+)
+
+// field keys shared by the components, so the records can be filtered by them
+const (
+	ComponentKey = "component"
+	JobIDKey     = "job_id"
+	TileKey      = "tile"
+	WorkerIDKey  = "worker_id"
+	ErrorKey     = "error"
+	StderrKey    = "stderr"
+)
+
+// New creates a logger writing records of the level and above in the format
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level: %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %q", format)
+	}
+}
+
+// Err is an error field. When the error wraps a slog.LogValuer error logged as a group, e.g. the failed command,
+// the fields of the group are added next to it, the error field itself holds the message of the whole chain
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.Attr{}
+	}
+	var valuer slog.LogValuer
+	if errors.As(err, &valuer) {
+		if value := valuer.LogValue().Resolve(); value.Kind() == slog.KindGroup {
+			attrs := []any{slog.String(ErrorKey, err.Error())}
+			for _, a := range value.Group() {
+				if a.Key != ErrorKey {
+					attrs = append(attrs, a)
+				}
+			}
+			// the group without a key is inlined by the handlers
+			return slog.Group("", attrs...)
+		}
+	}
+	return slog.String(ErrorKey, err.Error())
+}
+
+// Job returns the fields of the job tile
+func Job(jobID string, tile int) slog.Attr {
+	return slog.Group("", slog.String(JobIDKey, jobID), slog.Int(TileKey, tile))
+}
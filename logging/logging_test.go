@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cmdError logs the stderr of the failed command like transcoder.CmdError
+type cmdError struct {
+	stderr string
+}
+
+func (e *cmdError) Error() string {
+	return "ffmpeg: exit status 1"
+}
+
+func (e *cmdError) LogValue() slog.Value {
+	return slog.GroupValue(slog.String(ErrorKey, e.Error()), slog.String(StderrKey, e.stderr))
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", FormatJSON)
+	require.NoError(t, err)
+
+	logger.Info("skipped")
+	logger.Warn("tile failed", Job("1d2f", 3), Err(fmt.Errorf("encode: %w", &cmdError{
+		stderr: "pipe:: Invalid data found when processing input",
+	})))
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.Equal(t, "WARN", record["level"])
+	require.Equal(t, "tile failed", record["msg"])
+	require.Equal(t, "1d2f", record[JobIDKey])
+	require.Equal(t, float64(3), record[TileKey])
+	require.Equal(t, "encode: ffmpeg: exit status 1", record[ErrorKey])
+	require.Equal(t, "pipe:: Invalid data found when processing input", record[StderrKey])
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "loud", FormatJSON)
+	require.Error(t, err)
+
+	_, err = New(&bytes.Buffer{}, "info", "xml")
+	require.Error(t, err)
+}
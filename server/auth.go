@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"distributed-encoder/worker"
+)
+
+const (
+	// maxSignatureAge is a maximum difference between the worker request timestamp and the server time
+	maxSignatureAge = 5 * time.Minute
+	// maxSignedBody is a maximum size of the signed body, the larger bodies are streamed unsigned
+	maxSignedBody = 1 << 20
+)
+
+// Auth authenticates the requests of the workers and the job API clients,
+// each check is disabled when its credentials are not configured
+type Auth struct {
+	// WorkerSecret is a pre-shared secret the worker requests are signed with, see worker.SignRequest
+	WorkerSecret string
+	// APIKeys are accepted by the job API in the "Authorization: Bearer <key>" header
+	APIKeys []string
+	// RequireClientCert requires a verified client certificate on the worker endpoints, see LoadTLSConfig
+	RequireClientCert bool
+
+	// Nonces are the nonces of the accepted signatures, the replicas share them so a request can't be replayed
+	// to another one. The nonces of the process are used when it's nil
+	Nonces NonceStore
+
+	now func() time.Time
+}
+
+// NonceStore remembers the nonces of the accepted worker signatures until the signatures expire
+type NonceStore interface {
+	// AcceptNonce records the nonce until it expires, false is returned when the nonce is already accepted
+	AcceptNonce(nonce string, expires, now time.Time) (bool, error)
+}
+
+// Worker protects the worker endpoints, 401 is returned when the client certificate or the signature
+// is missing, invalid or expired, 403 when the request is made with an API key
+func (a Auth) Worker(next http.HandlerFunc) http.HandlerFunc {
+	if a.WorkerSecret == "" && !a.RequireClientCert {
+		return next
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := clientCertIdentity(req); a.RequireClientCert && !ok {
+			writeAuthProblem(w, req, http.StatusUnauthorized, "client certificate with a common name or a DNS name is required")
+			return
+		}
+		switch {
+		case a.WorkerSecret == "" || a.validSignature(req):
+			next(w, req)
+		case a.validAPIKey(req):
+			writeAuthProblem(w, req, http.StatusForbidden, "API key is not accepted by the worker endpoints")
+		default:
+			writeAuthProblem(w, req, http.StatusUnauthorized, "request signature is missing, invalid or expired")
+		}
+	}
+}
+
+// API protects the job API endpoints, 401 is returned when the API key is missing or invalid,
+// 403 when the request is signed by a worker
+func (a Auth) API(next http.HandlerFunc) http.HandlerFunc {
+	if len(a.APIKeys) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case a.validAPIKey(req):
+			next(w, req)
+		case a.validSignature(req):
+			writeAuthProblem(w, req, http.StatusForbidden, "worker signature is not accepted by the job API")
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAuthProblem(w, req, http.StatusUnauthorized, "API key is missing or invalid")
+		}
+	}
+}
+
+// writeAuthProblem responds with the problem of the rejected credentials
+func writeAuthProblem(w http.ResponseWriter, req *http.Request, status int, detail string) {
+	_ = writeProblem(w, req, Problem{Status: status, Detail: detail})
+}
+
+// validSignature verifies the signature of the request and its body unless it's unsigned,
+// the signed body is read and replaced with its copy
+func (a Auth) validSignature(req *http.Request) bool {
+	signed := worker.ParseSignedRequest(req)
+	return a.verifySignature(signed, req.Header.Get(worker.AuthSignatureHeader), func() bool {
+		if signed.ContentSHA256 == worker.UnsignedPayload {
+			return true
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxSignedBody+1))
+		if err != nil || len(body) > maxSignedBody {
+			return false
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return worker.ContentSHA256(body) == signed.ContentSHA256
+	})
+}
+
+// verifySignature checks the signature, the content of the request with validContent and
+// accepts the nonce, so the request can't be replayed
+func (a Auth) verifySignature(signed worker.SignedRequest, signature string, validContent func() bool) bool {
+	if a.WorkerSecret == "" || signed.Timestamp == "" || signed.Nonce == "" || signed.ContentSHA256 == "" || signature == "" {
+		return false
+	}
+	unix, err := strconv.ParseInt(signed.Timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	signedAt := time.Unix(unix, 0)
+	age := a.clock().Sub(signedAt)
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return false
+	}
+
+	expected := signed.Signature(a.WorkerSecret)
+	if !hmac.Equal([]byte(expected), []byte(signature)) || !validContent() {
+		return false
+	}
+	// the request is rejected when the nonce can't be checked, the worker retries it with a new one
+	accepted, err := a.nonceStore().AcceptNonce(signed.Nonce, signedAt.Add(maxSignatureAge), a.clock())
+	return err == nil && accepted
+}
+
+func (a Auth) nonceStore() NonceStore {
+	if a.Nonces == nil {
+		return processNonces
+	}
+	return a.Nonces
+}
+
+// processNonces are the nonces accepted by the process, the replicas don't share them
+var processNonces = newNonceCache()
+
+// nonceCache remembers the nonces of the accepted signatures until the signatures expire
+type nonceCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	// sweepAt is when the expired nonces are dropped next time
+	sweepAt time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{expires: make(map[string]time.Time)}
+}
+
+// AcceptNonce records the nonce until it expires, false is returned when the nonce is already accepted
+func (c *nonceCache) AcceptNonce(nonce string, expires, now time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.sweepAt) {
+		for n, at := range c.expires {
+			if now.After(at) {
+				delete(c.expires, n)
+			}
+		}
+		c.sweepAt = now.Add(maxSignatureAge)
+	}
+	if _, ok := c.expires[nonce]; ok {
+		return false, nil
+	}
+	c.expires[nonce] = expires
+	return true, nil
+}
+
+func (a Auth) validAPIKey(req *http.Request) bool {
+	return a.verifyAPIKey(req.Header.Get("Authorization"))
+}
+
+// verifyAPIKey checks the "Bearer <key>" authorization
+func (a Auth) verifyAPIKey(authorization string) bool {
+	key, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || key == "" {
+		return false
+	}
+	valid := false
+	// all the keys are compared, so the time doesn't depend on the matched key
+	for _, k := range a.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (a Auth) clock() time.Time {
+	if a.now == nil {
+		return time.Now()
+	}
+	return a.now()
+}
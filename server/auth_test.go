@@ -0,0 +1,172 @@
+package server
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestAuth(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	auth := Auth{
+		WorkerSecret: "worker-secret",
+		APIKeys:      []string{"key-1", "key-2"},
+		now:          func() time.Time { return now },
+		Nonces:       newNonceCache(),
+	}
+	ok := func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	signed := func(secret string, at time.Time) func(*http.Request) {
+		return func(req *http.Request) {
+			worker.SignRequest(req, secret, at)
+		}
+	}
+	bearer := func(key string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+	}
+
+	tests := map[string]struct {
+		handler http.HandlerFunc
+		path    string
+		prepare func(*http.Request)
+		want    int
+	}{
+		"worker signed":            {auth.Worker(ok), "/work/jobs", signed("worker-secret", now), http.StatusOK},
+		"worker clock skew":        {auth.Worker(ok), "/work/jobs", signed("worker-secret", now.Add(time.Minute)), http.StatusOK},
+		"worker no credentials":    {auth.Worker(ok), "/work/jobs", func(*http.Request) {}, http.StatusUnauthorized},
+		"worker wrong secret":      {auth.Worker(ok), "/work/jobs", signed("guess", now), http.StatusUnauthorized},
+		"worker expired signature": {auth.Worker(ok), "/work/jobs", signed("worker-secret", now.Add(-time.Hour)), http.StatusUnauthorized},
+		"worker with api key":      {auth.Worker(ok), "/work/jobs", bearer("key-1"), http.StatusForbidden},
+		"api key":                  {auth.API(ok), "/work/trigger", bearer("key-2"), http.StatusOK},
+		"api no credentials":       {auth.API(ok), "/work/trigger", func(*http.Request) {}, http.StatusUnauthorized},
+		"api wrong key":            {auth.API(ok), "/work/trigger", bearer("key-3"), http.StatusUnauthorized},
+		"api signed by worker":     {auth.API(ok), "/work/trigger", signed("worker-secret", now), http.StatusForbidden},
+		"disabled":                 {Auth{}.API(Auth{}.Worker(ok)), "/work/trigger", func(*http.Request) {}, http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, http.NoBody)
+			tt.prepare(req)
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+			require.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestAuth_SignatureBoundToPath(t *testing.T) {
+	now := time.Now()
+	auth := Auth{WorkerSecret: "worker-secret", now: func() time.Time { return now }, Nonces: newNonceCache()}
+
+	// the signature of the progress report can't be replayed to upload the result
+	req := httptest.NewRequest(http.MethodPost, "/work/progress", http.NoBody)
+	worker.SignRequest(req, "worker-secret", now)
+	replayed := httptest.NewRequest(http.MethodPost, "/work/result", http.NoBody)
+	replayed.Header = req.Header
+
+	w := httptest.NewRecorder()
+	auth.Worker(func(w http.ResponseWriter, req *http.Request) {})(w, replayed)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuth_Replay(t *testing.T) {
+	now := time.Now()
+	auth := Auth{WorkerSecret: "worker-secret", now: func() time.Time { return now }, Nonces: newNonceCache()}
+	handler := auth.Worker(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		_, _ = w.Write(body)
+	})
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	signedRequest := func(path, body string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		require.NoError(t, err)
+		worker.MarshalResultToHeader(&worker.Result{JobID: "1d2f", TileNum: 1, FileName: "video_tile_1.ts", Lease: "9a1c"}, req.Header)
+		worker.SignRequest(req, "worker-secret", now)
+		return req
+	}
+
+	// the signed body is passed to the handler, the request is accepted once
+	req := signedRequest("/work/progress", `{"jobId":"1d2f","tile":1,"lease":"9a1c"}`)
+	require.Equal(t, worker.ContentSHA256([]byte(`{"jobId":"1d2f","tile":1,"lease":"9a1c"}`)), req.Header.Get(worker.AuthContentHeader))
+	w := serve(req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"jobId":"1d2f","tile":1,"lease":"9a1c"}`, w.Body.String())
+	replayed := httptest.NewRequest(http.MethodPost, "/work/progress", strings.NewReader(`{"jobId":"1d2f","tile":1,"lease":"9a1c"}`))
+	replayed.Header = req.Header
+	require.Equal(t, http.StatusUnauthorized, serve(replayed).Code)
+
+	tests := map[string]func(req *http.Request){
+		"other body": func(req *http.Request) {
+			req.Body = io.NopCloser(strings.NewReader(`{"jobId":"1d2f","tile":2,"lease":"9a1c"}`))
+		},
+		"other tile": func(req *http.Request) {
+			req.Header.Set("X-Tile-Num", "2")
+		},
+		"other lease": func(req *http.Request) {
+			req.Header.Set(worker.LeaseHeader, "7b3e")
+		},
+		"other job": func(req *http.Request) {
+			req.Header.Set("X-Job-Id", "3e4f")
+		},
+		"other nonce": func(req *http.Request) {
+			req.Header.Set(worker.AuthNonceHeader, worker.NewNonce())
+		},
+		"body made unsigned": func(req *http.Request) {
+			req.Header.Set(worker.AuthContentHeader, worker.UnsignedPayload)
+		},
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := signedRequest("/work/progress", `{"jobId":"1d2f","tile":1,"lease":"9a1c"}`)
+			change(req)
+			require.Equal(t, http.StatusUnauthorized, serve(req).Code)
+		})
+	}
+
+	// the streamed result is unsigned, its headers are
+	result, err := http.NewRequest(http.MethodPost, "/work/result", io.NopCloser(strings.NewReader("encoded")))
+	require.NoError(t, err)
+	worker.MarshalResultToHeader(&worker.Result{JobID: "1d2f", TileNum: 1, FileName: "video_tile_1.ts", Lease: "9a1c"}, result.Header)
+	worker.SignRequest(result, "worker-secret", now)
+	require.Equal(t, worker.UnsignedPayload, result.Header.Get(worker.AuthContentHeader))
+	require.Equal(t, http.StatusOK, serve(result).Code)
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+	c := newNonceCache()
+	for _, tc := range []struct {
+		nonce    string
+		expires  time.Time
+		now      time.Time
+		accepted bool
+	}{
+		{"n1", now.Add(time.Minute), now, true},
+		{"n1", now.Add(time.Minute), now, false},
+		{"n2", now.Add(10 * time.Minute), now, true},
+		{"n3", now.Add(10 * time.Minute), now.Add(6 * time.Minute), true},
+	} {
+		accepted, err := c.AcceptNonce(tc.nonce, tc.expires, tc.now)
+		require.NoError(t, err)
+		require.Equal(t, tc.accepted, accepted, tc.nonce)
+	}
+	// the expired nonces are dropped
+	require.Equal(t, []string{"n2", "n3"}, slices.Sorted(maps.Keys(c.expires)))
+}
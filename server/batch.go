@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+const (
+	// maxBatchLines is a maximum amount of the requests of a batch
+	maxBatchLines = 1000
+	// maxBatchLineSize is a maximum size of a request line of a batch
+	maxBatchLineSize = 64 << 10
+)
+
+var (
+	// ErrEmptyBatch is returned when the batch has no requests
+	ErrEmptyBatch = errors.New("batch has no requests")
+
+	// ErrBatchNotFound is returned when no jobs are created by the batch
+	ErrBatchNotFound = errors.New("batch not found")
+)
+
+// BatchRequest is a request of the batch with its line number
+type BatchRequest struct {
+	// Line is a number of the request line in the batch starting from 1
+	Line    int
+	Request EncodeVideoRequest
+}
+
+// BatchItem is the outcome of the request of the batch, either the created job or the error
+type BatchItem struct {
+	Line  int      `json:"line"`
+	JobID string   `json:"jobId,omitempty"`
+	State JobState `json:"state,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// Errors are the invalid fields of the rejected request
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// BatchResult is the outcome of the submitted batch
+type BatchResult struct {
+	ID       string      `json:"id,omitempty"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Items    []BatchItem `json:"items"`
+}
+
+// reject adds the error of the request line
+func (b *BatchResult) reject(line int, err error) {
+	item := BatchItem{Line: line, Error: err.Error()}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		item.Errors = validationErr.Errors
+	}
+	b.Rejected++
+	b.Items = append(b.Items, item)
+}
+
+// BatchJob is the job of the batch
+type BatchJob struct {
+	ID       string   `json:"id"`
+	State    JobState `json:"state"`
+	Percent  float64  `json:"percent"`
+	FilePath string   `json:"filePath"`
+}
+
+// BatchStatus is the aggregate status of the jobs of the batch
+type BatchStatus struct {
+	ID string `json:"id"`
+	// State is completed when all the jobs are completed, failed when all the jobs are finished and some of them failed,
+	// queued when no job is started and running otherwise
+	State JobState `json:"state"`
+	// Percent is an average progress of the jobs
+	Percent float64 `json:"percent"`
+	// Counts are the amounts of the jobs by their states
+	Counts map[JobState]int `json:"counts"`
+	// Jobs are in the submission order
+	Jobs    []BatchJob `json:"jobs"`
+	Summary JobStats   `json:"summary"`
+}
+
+// TriggerBatch triggers the requests of the batch one by one, the jobs are tagged with the id of the batch.
+// A rejected request doesn't abort the batch, its error is reported in the item of its line
+func (s *Server) TriggerBatch(requests []BatchRequest) (*BatchResult, error) {
+	if len(requests) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	batch := &BatchResult{ID: s.newID(), Items: make([]BatchItem, 0, len(requests))}
+	for _, r := range requests {
+		request := r.Request
+		request.BatchID = batch.ID
+		status, err := s.TriggerWork(request)
+		if err != nil {
+			batch.reject(r.Line, err)
+			continue
+		}
+		batch.Accepted++
+		batch.Items = append(batch.Items, BatchItem{Line: r.Line, JobID: status.ID, State: status.State})
+	}
+	s.log.Info("batch is submitted",
+		slog.String("batch_id", batch.ID),
+		slog.Int("accepted", batch.Accepted),
+		slog.Int("rejected", batch.Rejected),
+	)
+	return batch, nil
+}
+
+// BatchStatus returns the aggregate status of the jobs created by the batch, ErrBatchNotFound is returned when there are none
+func (s *Server) BatchStatus(id string) (*BatchStatus, error) {
+	list, err := s.jobs.list(JobFilter{Batch: id})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Jobs) == 0 {
+		return nil, ErrBatchNotFound
+	}
+	// the history is listed the latest jobs first
+	slices.Reverse(list.Jobs)
+
+	status := &BatchStatus{
+		ID:      id,
+		Counts:  make(map[JobState]int),
+		Jobs:    make([]BatchJob, 0, len(list.Jobs)),
+		Summary: list.Summary,
+	}
+	for _, job := range list.Jobs {
+		status.Counts[job.State]++
+		status.Percent += job.Percent / float64(len(list.Jobs))
+		status.Jobs = append(status.Jobs, BatchJob{
+			ID:       job.ID,
+			State:    job.State,
+			Percent:  job.Percent,
+			FilePath: job.Request.FilePath,
+		})
+	}
+	finished := status.Counts[StateCompleted] + status.Counts[StateFailed]
+	switch {
+	case status.Counts[StateCompleted] == len(list.Jobs):
+		status.State = StateCompleted
+	case finished == len(list.Jobs):
+		status.State = StateFailed
+	case status.Counts[StateQueued] == len(list.Jobs):
+		status.State = StateQueued
+	default:
+		status.State = StateRunning
+	}
+	return status, nil
+}
+
+// POST /work/batch
+// The body is newline-delimited EncodeVideoRequests, the blank lines are skipped.
+// The malformed and the rejected lines are reported in the items of the response without aborting the batch
+func (h HTTPHandler) TriggerBatch(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	var requests []BatchRequest
+	var malformed []BatchItem
+	scanner := bufio.NewScanner(req.Body)
+	scanner.Buffer(nil, maxBatchLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if len(requests)+len(malformed) == maxBatchLines {
+			h.writeError(w, req, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d requests", maxBatchLines))
+			return
+		}
+		var request EncodeVideoRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			malformed = append(malformed, BatchItem{
+				Line:   line,
+				Error:  fmt.Sprintf("request is malformed: %v", err),
+				Errors: decodeFieldErrors(err),
+			})
+			continue
+		}
+		requests = append(requests, BatchRequest{Line: line, Request: request})
+	}
+	if err := scanner.Err(); err != nil {
+		h.logErr(req, err)
+		h.writeError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(requests) == 0 && len(malformed) == 0 {
+		h.writeError(w, req, http.StatusBadRequest, ErrEmptyBatch.Error())
+		return
+	}
+
+	result := &BatchResult{}
+	if len(requests) > 0 {
+		var err error
+		if result, err = h.Service.TriggerBatch(requests); err != nil {
+			h.writeInternalError(w, req, err)
+			return
+		}
+	}
+	result.Rejected += len(malformed)
+	result.Items = append(result.Items, malformed...)
+	slices.SortFunc(result.Items, func(a, b BatchItem) int {
+		return a.Line - b.Line
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logErr(req, err)
+	}
+}
+
+// GET /work/batches/:id
+func (h HTTPHandler) BatchStatus(w http.ResponseWriter, req *http.Request) {
+	status, err := h.Service.BatchStatus(jobIDParam(req))
+	if errors.Is(err, ErrBatchNotFound) {
+		h.writeError(w, req, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logErr(req, err)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_TriggerBatch(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/a.mp4").Return(true)
+	store.On("HasObject", "/videos/missing.mp4").Return(false)
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.TriggerBatch(nil)
+	require.ErrorIs(t, err, ErrEmptyBatch)
+
+	batch, err := s.TriggerBatch([]BatchRequest{
+		{Line: 1, Request: EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/a.mp4"}},
+		{Line: 2, Request: EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/missing.mp4"}},
+		{Line: 3, Request: EncodeVideoRequest{Tiles: 4, Width: 2, Height: 10, FilePath: "/videos/a.mp4"}},
+		{Line: 4, Request: EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/a.mp4"}},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, batch.ID)
+	require.Equal(t, 2, batch.Accepted)
+	require.Equal(t, 2, batch.Rejected)
+	require.Len(t, batch.Items, 4)
+	missing := batch.Items[1]
+	require.Equal(t, "filePath: file is not found in the storage: /videos/missing.mp4", missing.Error)
+	require.Equal(t, []FieldError{{Field: "filePath", Message: "file is not found in the storage: /videos/missing.mp4"}}, stripFieldErrors(missing.Errors))
+	require.Equal(t, []FieldError{{Field: "tiles", Message: "4 tiles split the frame into 2x2, it doesn't fit 2x10"}}, stripFieldErrors(batch.Items[2].Errors))
+	first, second := batch.Items[0], batch.Items[3]
+	require.Equal(t, StateQueued, first.State)
+
+	job, err := s.JobStatus(first.JobID)
+	require.NoError(t, err)
+	require.Equal(t, batch.ID, job.Request.BatchID)
+
+	status, err := s.BatchStatus(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, status.State)
+	require.Equal(t, map[JobState]int{StateQueued: 2}, status.Counts)
+	require.Equal(t, []BatchJob{
+		{ID: first.JobID, State: StateQueued, FilePath: "/videos/a.mp4"},
+		{ID: second.JobID, State: StateQueued, FilePath: "/videos/a.mp4"},
+	}, status.Jobs)
+	require.Equal(t, 2, status.Summary.Tiles)
+
+	s.jobs.tileFinished(first.JobID, 0, errFake)
+	status, err = s.BatchStatus(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, status.State)
+
+	s.jobs.tileFinished(second.JobID, 0, errFake)
+	status, err = s.BatchStatus(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, status.State)
+	require.Equal(t, map[JobState]int{StateFailed: 2}, status.Counts)
+
+	_, err = s.BatchStatus("unknown")
+	require.ErrorIs(t, err, ErrBatchNotFound)
+}
+
+func TestHTTPHandler_TriggerBatch(t *testing.T) {
+	var serviceMock serverMock
+	serviceMock.On("TriggerBatch", []BatchRequest{
+		{Line: 1, Request: EncodeVideoRequest{Tiles: 2, Width: 20, Height: 10, FilePath: "a.mp4"}},
+		{Line: 4, Request: EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "b.mp4"}},
+	}).Return(&BatchResult{
+		ID:       "b1c2",
+		Accepted: 1,
+		Rejected: 1,
+		Items: []BatchItem{{Line: 1, JobID: "1d2f", State: StateQueued}, {
+			Line:   4,
+			Error:  "filePath: file is not found in the storage: b.mp4",
+			Errors: []FieldError{{Field: "filePath", Message: "file is not found in the storage: b.mp4"}},
+		}},
+	}, nil).Once()
+	serviceMock.On("BatchStatus", "b1c2").Return(&BatchStatus{ID: "b1c2", State: StateRunning}, nil).Once()
+	serviceMock.On("BatchStatus", "unknown").Return(nil, ErrBatchNotFound).Once()
+	handler := HTTPHandler{Service: &serviceMock}
+
+	body := `{"tiles":2,"width":20,"height":10,"filePath":"a.mp4"}
+
+{"tiles":
+{"tiles":1,"width":10,"height":10,"filePath":"b.mp4"}
+{"tiles":"4","width":10,"height":10,"filePath":"c.mp4"}
+`
+	rr := httptest.NewRecorder()
+	handler.TriggerBatch(rr, httptest.NewRequest(http.MethodPost, "/work/batch", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"id":"b1c2","accepted":1,"rejected":3,"items":[
+		{"line":1,"jobId":"1d2f","state":"queued"},
+		{"line":3,"error":"request is malformed: unexpected end of JSON input"},
+		{"line":4,"error":"filePath: file is not found in the storage: b.mp4",
+			"errors":[{"field":"filePath","message":"file is not found in the storage: b.mp4"}]},
+		{"line":5,"error":"request is malformed: json: cannot unmarshal string into Go struct field EncodeVideoRequest.tiles of type int",
+			"errors":[{"field":"tiles","message":"must be a number, not string"}]}
+	]}`, rr.Body.String())
+
+	// the batch of the malformed lines isn't submitted
+	rr = httptest.NewRecorder()
+	handler.TriggerBatch(rr, httptest.NewRequest(http.MethodPost, "/work/batch", strings.NewReader("nope\n")))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"accepted":0,"rejected":1,"items":[{"line":1,"error":"request is malformed: invalid character 'o' in literal null (expecting 'u')"}]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler.TriggerBatch(rr, httptest.NewRequest(http.MethodPost, "/work/batch", strings.NewReader("\n \n")))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.TriggerBatch(rr, httptest.NewRequest(http.MethodPost, "/work/batch", strings.NewReader(strings.Repeat("{}\n", maxBatchLines+1))))
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/work/batches/:id", handler.BatchStatus)
+	for id, code := range map[string]int{"b1c2": http.StatusOK, "unknown": http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/work/batches/"+id, nil))
+		require.Equal(t, code, rr.Code)
+	}
+	serviceMock.AssertExpectations(t)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+// ObjectCopier copies the objects stored under the result keys, HasObject checks the source files instead
+type ObjectCopier interface {
+	HasStoredObject(key string) bool
+	CopyObject(src, dst string) error
+}
+
+// tileCache keeps the results of the tiles in the Store under the keys of the tile content, when the Store
+// implements ObjectCopier the queued tiles encoded before are copied to the job instead of the dispatch.
+// The sources are hashed in the background, so the job is enqueued without waiting for the hash
+type tileCache struct {
+	copier  ObjectCopier
+	profile string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// hashing serializes the hashing, so the jobs of the same source read it once
+	hashing sync.Mutex
+
+	mu sync.Mutex
+	// hashes are the content hashes of the source files by their paths, they're kept in memory only
+	hashes map[string]fileHash
+}
+
+// fileHash is the content hash of the file version
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+func newTileCache(store Store, profile string) *tileCache {
+	copier, ok := store.(ObjectCopier)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &tileCache{
+		copier:  copier,
+		profile: profile,
+		ctx:     ctx,
+		cancel:  cancel,
+		hashes:  make(map[string]fileHash),
+	}
+}
+
+// cachedResultExt is an extension of the cached results, the workers encode MPEG-TS
+const cachedResultExt = ".ts"
+
+// tileKey is a deterministic key of the tile encoded from the source content
+func (c *tileCache) tileKey(contentHash string, job TileJob) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d,%d,%dx%d|%s",
+		contentHash, job.PosX, job.PosY, job.Width, job.Height, c.profile))
+	return hex.EncodeToString(sum[:])
+}
+
+// objectKey is a key of the cached result in the store
+func (c *tileCache) objectKey(tileKey, ext string) string {
+	return path.Join("tiles", tileKey+ext)
+}
+
+// lookup hashes the source of the enqueued jobs in the background and copies the cached results to the job,
+// found is called with the keys of the tiles and the copied ones
+func (c *tileCache) lookup(jobs []TileJob, log *slog.Logger, found func(keys []string, cached []bool)) {
+	if c == nil || len(jobs) == 0 {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		hash, err := c.contentHash(jobs[0].Path)
+		if err != nil {
+			if c.ctx.Err() == nil {
+				log.Warn("source can't be hashed, tiles are not cached", logging.Err(err))
+			}
+			return
+		}
+
+		keys := make([]string, len(jobs))
+		cached := make([]bool, len(jobs))
+		for i, job := range jobs {
+			keys[i] = c.tileKey(hash, job)
+			src := c.objectKey(keys[i], cachedResultExt)
+			if !c.copier.HasStoredObject(src) {
+				continue
+			}
+			dst := path.Join(job.JobID, generateTileName(job.File, job.TileNum)+cachedResultExt)
+			if err := c.copier.CopyObject(src, dst); err != nil {
+				log.Warn("cached tile can't be copied", slog.Int(logging.TileKey, job.TileNum), logging.Err(err))
+				continue
+			}
+			cached[i] = true
+		}
+		found(keys, cached)
+	}()
+}
+
+// contentHash returns SHA-256 of the source file, the hash is kept until the size or the modification time is changed
+func (c *tileCache) contentHash(key string) (string, error) {
+	c.hashing.Lock()
+	defer c.hashing.Unlock()
+
+	f, err := os.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	known, ok := c.hashes[key]
+	c.mu.Unlock()
+	if ok && known.size == info.Size() && known.modTime.Equal(info.ModTime()) {
+		return known.hash, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, contextReader{ctx: c.ctx, r: f}); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes[key] = fileHash{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	return hash, nil
+}
+
+// save caches the stored result of the tile
+func (c *tileCache) save(tile TileStatus, resultKey string, log *slog.Logger) {
+	if c == nil || tile.Key == "" {
+		return
+	}
+	if err := c.copier.CopyObject(resultKey, c.objectKey(tile.Key, path.Ext(resultKey))); err != nil {
+		log.Warn("result can't be cached", logging.Err(err))
+	}
+}
+
+// close stops the hashing and waits for the lookups
+func (c *tileCache) close() {
+	if c == nil {
+		return
+	}
+	c.cancel()
+	c.wg.Wait()
+}
+
+// contextReader stops reading once the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestServer_TileCache(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "video.mp4")
+	require.NoError(t, os.WriteFile(source, []byte("video"), 0644))
+	dir := t.TempDir()
+	s, err := New(Config{DispatchTimeout: time.Second, Store: &FSObjectStore{Path: dir}, TileStreamer: tileStreamer{tile: "tile"}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	req := EncodeVideoRequest{Tiles: 2, Width: 20, Height: 10, FilePath: source}
+	// the source is hashed after the job is enqueued
+	keyed := func(id string) *JobStatus {
+		t.Helper()
+		var status *JobStatus
+		require.Eventually(t, func() bool {
+			status, err = s.JobStatus(id)
+			return err == nil && status.Tiles[0].Key != ""
+		}, time.Second, time.Millisecond)
+		return status
+	}
+	first, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	first = keyed(first.ID)
+	require.NotEqual(t, first.Tiles[0].Key, first.Tiles[1].Key)
+
+	// only the first tile is encoded, the second one fails
+	for _, encoded := range []bool{true, false} {
+		job, err := s.Dispatch("worker-1")
+		require.NoError(t, err)
+		if !encoded {
+			s.publish(s.jobs.tileFinished(job.JobID, job.TileNum, errFake)...)
+			continue
+		}
+		require.NoError(t, s.AcceptResult(&worker.Result{
+			JobID:    job.JobID,
+			TileNum:  job.TileNum,
+			FileName: job.TileName + ".ts",
+			Lease:    job.Lease,
+			Src:      strings.NewReader("encoded"),
+		}))
+	}
+
+	// the resubmitted request dispatches the failed tile only
+	second, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	second = keyed(second.ID)
+	require.Equal(t, first.Tiles[0].Key, second.Tiles[0].Key)
+	require.Equal(t, StateCompleted, second.Tiles[0].State)
+	require.True(t, second.Tiles[0].Cached)
+	require.Equal(t, StateQueued, second.Tiles[1].State)
+	data, err := os.ReadFile(filepath.Join(dir, second.ID, second.Tiles[0].Name+".ts"))
+	require.NoError(t, err)
+	require.Equal(t, "encoded", string(data))
+
+	// the cached tile is dropped from the queue
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	require.Equal(t, second.ID, job.JobID)
+	require.Equal(t, 1, job.TileNum)
+	require.NoError(t, s.AcceptResult(&worker.Result{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		FileName: job.TileName + ".ts",
+		Lease:    job.Lease,
+		Src:      strings.NewReader("encoded"),
+	}))
+
+	// all the tiles are cached, the job is completed at once
+	third, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, third.State)
+	third = keyed(third.ID)
+	require.Equal(t, StateCompleted, third.State)
+
+	// the changed source is encoded again
+	require.NoError(t, os.WriteFile(source, []byte("other video"), 0644))
+	changed, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	changed = keyed(changed.ID)
+	require.Equal(t, StateQueued, changed.State)
+	require.NotEqual(t, first.Tiles[0].Key, changed.Tiles[0].Key)
+}
+
+func TestTileCache_ContentHash(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "video.mp4")
+	require.NoError(t, os.WriteFile(source, []byte("video"), 0644))
+	cache := newTileCache(&FSObjectStore{}, "profile")
+	defer cache.close()
+
+	hash, err := cache.contentHash(source)
+	require.NoError(t, err)
+	require.Equal(t, "0cab1c9617404faf2b24e221e189ca5945813e14d3f766345b09ca13bbe28ffc", hash)
+	again, err := cache.contentHash(source)
+	require.NoError(t, err)
+	require.Equal(t, hash, again)
+
+	// the changed file is hashed again
+	require.NoError(t, os.WriteFile(source, []byte("other video"), 0644))
+	changed, err := cache.contentHash(source)
+	require.NoError(t, err)
+	require.NotEqual(t, hash, changed)
+
+	_, err = cache.contentHash(filepath.Join(t.TempDir(), "missing.mp4"))
+	require.Error(t, err)
+
+	// the closed cache stops hashing
+	require.NoError(t, os.WriteFile(source, []byte("another video"), 0644))
+	cache.close()
+	_, err = cache.contentHash(source)
+	require.ErrorIs(t, err, context.Canceled)
+}
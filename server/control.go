@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+var (
+	// ErrJobCancelled is the error of the tiles of the cancelled job
+	ErrJobCancelled = errors.New("job is cancelled")
+
+	// ErrJobFinished is returned when the finished job is cancelled
+	ErrJobFinished = errors.New("job is already finished")
+
+	// ErrJobNotFailed is returned when the job which is not failed is retried
+	ErrJobNotFailed = errors.New("job is not failed")
+)
+
+// CancelJob fails the unfinished tiles of the job with ErrJobCancelled, ErrJobFinished is returned when the job is done.
+// The queued tiles of the job are dropped, the results of the running tiles are rejected
+func (s *Server) CancelJob(id string) (*JobStatus, error) {
+	status, events, err := s.jobs.cancel(id)
+	if err != nil {
+		return nil, err
+	}
+	// the queues which can't drop the tiles keep them until the dispatch pops them
+	if q, ok := s.queue.(DropQueue); ok {
+		q.Drop(id)
+	}
+	s.publish(events...)
+	s.log.Info("job is cancelled", slog.String(logging.JobIDKey, id))
+	return &status, nil
+}
+
+// RetryJob queues again the failed tiles of the failed or cancelled job, ErrJobNotFailed is returned for the other jobs.
+// *QueueFullError is returned when the tiles can't be admitted, the tiles are failed with it then.
+// The tiles are queued with the new retry of the job, so the ones left in the queue by the cancel are not dispatched twice
+func (s *Server) RetryJob(id string) (*JobStatus, error) {
+	status, tiles, events, err := s.jobs.retry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []TileJob
+	now := time.Now()
+	buildCropJobs(status.Request, func(job TileJob) {
+		if slices.Contains(tiles, job.TileNum) {
+			job.JobID = id
+			job.Retry = status.Retries
+			job.enqueuedAt = now
+			jobs = append(jobs, job)
+		}
+	})
+	for _, num := range tiles {
+		if !slices.ContainsFunc(jobs, func(job TileJob) bool { return job.TileNum == num }) {
+			events = append(events, s.jobs.tileFinished(id, num, errTileNotSplit)...)
+		}
+	}
+	if status.Request.CallbackURL != "" {
+		s.webhooks.register(id, status.Request.CallbackURL)
+	}
+	if err := s.queue.Push(jobs...); err != nil {
+		for _, num := range tiles {
+			s.publish(s.jobs.tileFinished(id, num, err)...)
+		}
+		return nil, err
+	}
+	s.publish(events...)
+	s.log.Info("job is retried", slog.String(logging.JobIDKey, id), slog.Int("tiles", len(tiles)))
+	return &status, nil
+}
+
+// cancel fails the unfinished tiles of the job and drops their leases
+func (r *jobRegistry) cancel(id string) (JobStatus, []Event, error) {
+	var status JobStatus
+	var events []Event
+	err := updateJob(r.store, id, func(rec *JobRecord) error {
+		events = nil
+		job := &rec.Status
+		if job.done() {
+			return ErrJobFinished
+		}
+		now := r.now()
+		job.Cancelled = true
+		for i := range job.Tiles {
+			tile := &job.Tiles[i]
+			if tile.State == StateCompleted || tile.State == StateFailed {
+				continue
+			}
+			events = append(events, job.updateTile(tile, StateFailed, ErrJobCancelled, "", now)...)
+			rec.recordAttempt(tile, 0, now)
+		}
+		rec.Leases = nil
+		status = job.snapshot()
+		return nil
+	})
+	if err != nil {
+		return JobStatus{}, nil, err
+	}
+	return status, withCallback(events, status.Request.CallbackURL), nil
+}
+
+// retry marks the failed tiles of the failed job as queued and returns their numbers
+func (r *jobRegistry) retry(id string) (JobStatus, []int, []Event, error) {
+	var status JobStatus
+	var tiles []int
+	var events []Event
+	err := updateJob(r.store, id, func(rec *JobRecord) error {
+		tiles, events = nil, nil
+		job := &rec.Status
+		if job.State != StateFailed {
+			return ErrJobNotFailed
+		}
+		now := r.now()
+		job.State = StateQueued
+		job.Cancelled = false
+		job.Retries++
+		for i := range job.Tiles {
+			tile := &job.Tiles[i]
+			if tile.State != StateFailed {
+				continue
+			}
+			tile.Error = ""
+			events = append(events, job.updateTile(tile, StateQueued, nil, "", now)...)
+			tiles = append(tiles, tile.Num)
+		}
+		status = job.snapshot()
+		return nil
+	})
+	if err != nil {
+		return JobStatus{}, nil, nil, err
+	}
+	return status, tiles, withCallback(events, status.Request.CallbackURL), nil
+}
+
+// staleTile reports whether the queued tile is completed or failed, e.g. when its job is cancelled,
+// or it's queued before the last retry of the job. The tiles of the unknown jobs are not stale
+func (r *jobRegistry) staleTile(job TileJob) bool {
+	rec, err := r.store.LoadJob(job.JobID)
+	if err != nil || job.TileNum < 0 || job.TileNum >= len(rec.Status.Tiles) {
+		return false
+	}
+	state := rec.Status.Tiles[job.TileNum].State
+	return state == StateCompleted || state == StateFailed || job.Retry != rec.Status.Retries
+}
+
+// nextTile pops the next tile to dispatch, the stale tiles left in the queue by the cancelled jobs are dropped
+func (s *Server) nextTile(ctx context.Context) (TileJob, error) {
+	for {
+		job, err := s.queue.Pop(ctx)
+		if err != nil || !s.jobs.staleTile(job) {
+			return job, err
+		}
+		s.log.Debug("stale tile is dropped", logging.Job(job.JobID, job.TileNum))
+		s.ackTile(job)
+	}
+}
+
+// ackTile acks the popped tile to the queue which keeps it until then, it's called once the tile is leased,
+// requeued or finished. The tile which ack is lost is dispatched again, its previous lease is superseded
+func (s *Server) ackTile(job TileJob) {
+	q, ok := s.queue.(AckQueue)
+	if !ok {
+		return
+	}
+	if err := q.Ack(job); err != nil {
+		s.log.Error("tile can't be acked", logging.Job(job.JobID, job.TileNum), logging.Err(err))
+	}
+}
+
+// POST /work/jobs/:id/cancel
+func (h HTTPHandler) CancelJob(w http.ResponseWriter, req *http.Request) {
+	status, err := h.Service.CancelJob(jobIDParam(req))
+	h.writeJobChange(w, req, status, err)
+}
+
+// POST /work/jobs/:id/retry
+func (h HTTPHandler) RetryJob(w http.ResponseWriter, req *http.Request) {
+	status, err := h.Service.RetryJob(jobIDParam(req))
+	h.writeJobChange(w, req, status, err)
+}
+
+// writeJobChange responds with the changed job or the error of the change
+func (h HTTPHandler) writeJobChange(w http.ResponseWriter, req *http.Request, status *JobStatus, err error) {
+	var queueErr *QueueFullError
+	switch {
+	case errors.As(err, &queueErr):
+		h.logErr(req, err)
+		h.writeQueueFull(w, req, queueErr)
+		return
+	case errors.Is(err, ErrJobNotFound):
+		h.writeError(w, req, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrJobFinished), errors.Is(err, ErrJobNotFailed):
+		h.writeError(w, req, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logErr(req, err)
+	}
+}
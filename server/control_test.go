@@ -0,0 +1,167 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestServer_CancelRetryJob(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	store.On("WriteObject", mock.Anything, mock.Anything).Return(nil)
+	s, err := New(Config{DispatchTimeout: 50 * time.Millisecond, Store: &store, TileStreamer: tileStreamer{tile: "tile"}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 2, Width: 20, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+
+	workers, err := s.Workers()
+	require.NoError(t, err)
+	require.Len(t, workers, 1)
+	require.Equal(t, "worker-1", workers[0].ID)
+	require.False(t, workers[0].LastSeen.IsZero())
+	require.Equal(t, []WorkerTile{{JobID: status.ID, TileNum: job.TileNum}}, workers[0].Tiles)
+
+	_, events, cancel, err := s.SubscribeEvents(status.ID)
+	require.NoError(t, err)
+	defer cancel()
+
+	cancelled, err := s.CancelJob(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, cancelled.State)
+	require.True(t, cancelled.Cancelled)
+	for _, tile := range cancelled.Tiles {
+		require.Equal(t, StateFailed, tile.State)
+		require.Equal(t, ErrJobCancelled.Error(), tile.Error)
+	}
+	for _, want := range []EventType{EventTileFailed, EventTileFailed, EventJobFailed} {
+		require.Equal(t, want, (<-events).Type)
+	}
+	_, err = s.CancelJob(status.ID)
+	require.ErrorIs(t, err, ErrJobFinished)
+
+	// the queued tile is dropped, the result of the running one is rejected
+	_, err = s.Dispatch("worker-2")
+	require.ErrorIs(t, err, ErrDispatchTimeout)
+	err = s.AcceptResult(&worker.Result{JobID: job.JobID, TileNum: job.TileNum, FileName: job.TileName + ".ts", Lease: job.Lease})
+	require.ErrorIs(t, err, ErrTileNotLeased)
+	workers, err = s.Workers()
+	require.NoError(t, err)
+	require.Len(t, workers, 2)
+	require.Empty(t, workers[0].Tiles)
+
+	retried, err := s.RetryJob(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, retried.State)
+	require.False(t, retried.Cancelled)
+	require.Equal(t, TileStatus{Num: 0, Name: "video_tile_0", State: StateQueued}, retried.Tiles[0])
+	_, err = s.RetryJob(status.ID)
+	require.ErrorIs(t, err, ErrJobNotFailed)
+
+	for range retried.Tiles {
+		job, err := s.Dispatch("worker-1")
+		require.NoError(t, err)
+		require.NoError(t, s.AcceptResult(&worker.Result{
+			JobID:    job.JobID,
+			TileNum:  job.TileNum,
+			FileName: job.TileName + ".ts",
+			Lease:    job.Lease,
+			Src:      strings.NewReader("encoded"),
+		}))
+	}
+	completed, err := s.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateCompleted, completed.State)
+
+	_, err = s.CancelJob("unknown")
+	require.ErrorIs(t, err, ErrJobNotFound)
+}
+
+// keptQueue hides Drop of the queue, like the broker queues which keep the tiles of the cancelled jobs
+type keptQueue struct {
+	Queue
+}
+
+func TestServer_RetryCancelledQueued(t *testing.T) {
+	tests := map[string]Config{
+		// the tiles are dropped on cancel, so they don't count against the limit of the retry
+		"dropped": {MaxQueuedTiles: 2},
+		// the tiles queued before the retry are dropped by the dispatch
+		"kept": {Queue: keptQueue{newJobQueue(&fifoScheduler{}, queueLimits{})}},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			var store storeMock
+			store.On("HasObject", "/videos/video.mp4").Return(true)
+			cfg.Store = &store
+			cfg.TileStreamer = tileStreamer{tile: "tile"}
+			cfg.DispatchTimeout = 50 * time.Millisecond
+			s, err := New(cfg)
+			require.NoError(t, err)
+			defer s.Close()
+
+			status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 2, Width: 20, Height: 10, FilePath: "/videos/video.mp4"})
+			require.NoError(t, err)
+			_, err = s.CancelJob(status.ID)
+			require.NoError(t, err)
+			retried, err := s.RetryJob(status.ID)
+			require.NoError(t, err)
+			require.Equal(t, 1, retried.Retries)
+
+			var dispatched []int
+			for range 2 {
+				job, err := s.Dispatch("worker-1")
+				require.NoError(t, err)
+				dispatched = append(dispatched, job.TileNum)
+			}
+			require.ElementsMatch(t, []int{0, 1}, dispatched)
+			_, err = s.Dispatch("worker-1")
+			require.ErrorIs(t, err, ErrDispatchTimeout)
+		})
+	}
+}
+
+func TestHTTPHandler_CancelRetryJob(t *testing.T) {
+	var serviceMock serverMock
+	serviceMock.On("CancelJob", "1d2f").Return(&JobStatus{ID: "1d2f", State: StateFailed, Cancelled: true}, nil).Once()
+	serviceMock.On("CancelJob", "3e4f").Return(nil, ErrJobFinished).Once()
+	serviceMock.On("RetryJob", "1d2f").Return(nil, &QueueFullError{Depth: 10, Limit: 10, Requested: 2}).Once()
+	serviceMock.On("RetryJob", "unknown").Return(nil, ErrJobNotFound).Once()
+	serviceMock.On("Workers").Return([]WorkerStatus{{ID: "worker-1", Tiles: []WorkerTile{{JobID: "1d2f", TileNum: 1}}}}, nil).Once()
+	handler := HTTPHandler{Service: &serviceMock}
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/work/jobs/:id/cancel", handler.CancelJob)
+	router.HandlerFunc(http.MethodPost, "/work/jobs/:id/retry", handler.RetryJob)
+	router.HandlerFunc(http.MethodGet, "/work/workers", handler.Workers)
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{http.MethodPost, "/work/jobs/1d2f/cancel", http.StatusOK, `"cancelled":true`},
+		{http.MethodPost, "/work/jobs/3e4f/cancel", http.StatusConflict, `"status":409,"detail":"job is already finished"`},
+		{http.MethodPost, "/work/jobs/1d2f/retry", http.StatusServiceUnavailable, `"queueDepth":10`},
+		{http.MethodPost, "/work/jobs/unknown/retry", http.StatusNotFound, `"status":404,"detail":"job is not found"`},
+		{http.MethodGet, "/work/workers", http.StatusOK, `{"workers":[{"id":"worker-1","tiles":[{"jobId":"1d2f","tileNum":1,"percent":0}]}]}`},
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		require.Equal(t, tc.code, rr.Code, tc.path)
+		require.Contains(t, rr.Body.String(), tc.body, tc.path)
+	}
+	serviceMock.AssertExpectations(t)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+// EventType is a type of the job lifecycle event
+type EventType string
+
+const (
+	// EventJobAccepted is sent when the job is enqueued
+	EventJobAccepted EventType = "job.accepted"
+	// EventTileCompleted is sent when the tile result is stored
+	EventTileCompleted EventType = "tile.completed"
+	// EventTileFailed is sent when the tile can't be streamed or stored
+	EventTileFailed EventType = "tile.failed"
+	// EventJobCompleted is sent when all the tiles are completed
+	EventJobCompleted EventType = "job.completed"
+	// EventJobFailed is sent when all the tiles are done and some of them are failed
+	EventJobFailed EventType = "job.failed"
+
+	// EventTileDispatched is sent when the tile is streamed to a worker, it's not delivered to webhooks
+	EventTileDispatched EventType = "tile.dispatched"
+	// EventTileRequeued is sent when the lease of the tile is expired and it's queued again, it's not delivered to webhooks
+	EventTileRequeued EventType = "tile.requeued"
+	// EventTileProgress is sent when the worker reports the encoding progress, it's not delivered to webhooks
+	EventTileProgress EventType = "tile.progress"
+	// EventJobStatus is a snapshot of the job sent first to the event stream subscribers
+	EventJobStatus EventType = "job.status"
+)
+
+// Event represents a change of the job or the tile state
+type Event struct {
+	Type  EventType   `json:"type"`
+	JobID string      `json:"jobId"`
+	Time  time.Time   `json:"time"`
+	Job   *JobStatus  `json:"job,omitempty"`
+	Tile  *TileStatus `json:"tile,omitempty"`
+
+	// callback is a callback url of the job, the replica which doesn't deliver the job events yet delivers it there
+	callback string
+}
+
+func newJobEvent(eventType EventType, job JobStatus, now time.Time) Event {
+	return Event{
+		Type:  eventType,
+		JobID: job.ID,
+		Time:  now,
+		Job:   &job,
+	}
+}
+
+func newTileEvent(eventType EventType, jobID string, tile TileStatus, now time.Time) Event {
+	return Event{
+		Type:  eventType,
+		JobID: jobID,
+		Time:  now,
+		Tile:  &tile,
+	}
+}
+
+// withCallback sets the callback url of the job to the events
+func withCallback(events []Event, callback string) []Event {
+	for i := range events {
+		events[i].callback = callback
+	}
+	return events
+}
+
+// terminal reports whether the event is the last event of the job
+func (e Event) terminal() bool {
+	return e.Type == EventJobCompleted || e.Type == EventJobFailed
+}
+
+// subscriberBuffer is a maximum amount of events waiting for the slow subscriber
+const subscriberBuffer = 256
+
+// eventBroker fans out job events to the subscribers of the job
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan Event]struct{}
+	closed bool
+	log    *slog.Logger
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subs: make(map[string]map[chan Event]struct{}),
+		log:  slog.Default().With(logging.ComponentKey, "events"),
+	}
+}
+
+// subscribe returns a channel of the job events and the function to cancel the subscription,
+// the channel is closed when the subscription is canceled or the broker is closed
+func (b *eventBroker) subscribe(jobID string) (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(events)
+		return events, func() {}
+	}
+	if b.subs[jobID] == nil {
+		b.subs[jobID] = make(map[chan Event]struct{})
+	}
+	b.subs[jobID][events] = struct{}{}
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() { b.unsubscribe(jobID, events) })
+	}
+}
+
+func (b *eventBroker) unsubscribe(jobID string, events chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs, ok := b.subs[jobID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[events]; !ok {
+		return
+	}
+	delete(subs, events)
+	close(events)
+	if len(subs) == 0 {
+		delete(b.subs, jobID)
+	}
+}
+
+// publish sends the event to the job subscribers, events are dropped for the subscribers which don't keep up
+func (b *eventBroker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subs[e.JobID] {
+		select {
+		case events <- e:
+		default:
+			b.log.Warn("subscriber is slow, event is dropped",
+				slog.String(logging.JobIDKey, e.JobID),
+				slog.String("event", string(e.Type)),
+			)
+		}
+	}
+}
+
+// close closes all the subscriptions
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for jobID, subs := range b.subs {
+		for events := range subs {
+			close(events)
+		}
+		delete(b.subs, jobID)
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBroker(t *testing.T) {
+	b := newEventBroker()
+	events, cancel := b.subscribe("1d2f")
+	other, _ := b.subscribe("other")
+
+	b.publish(Event{Type: EventTileDispatched, JobID: "1d2f"})
+	require.Equal(t, Event{Type: EventTileDispatched, JobID: "1d2f"}, <-events)
+	require.Empty(t, other)
+
+	cancel()
+	cancel()
+	_, ok := <-events
+	require.False(t, ok)
+	b.publish(Event{Type: EventTileCompleted, JobID: "1d2f"})
+
+	b.close()
+	_, ok = <-other
+	require.False(t, ok)
+
+	closed, _ := b.subscribe("1d2f")
+	_, ok = <-closed
+	require.False(t, ok)
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/worker"
+	"distributed-encoder/worker/workerpb"
+)
+
+// GRPCService serves the workers over gRPC, it's an alternative to the HTTP worker endpoints of the same Service
+type GRPCService struct {
+	workerpb.UnimplementedWorkerServer
+
+	Service Service
+	// Logger receives the call errors, slog.Default is used when it's nil
+	Logger *slog.Logger
+}
+
+// NewGRPCServer creates the gRPC server of the worker service protected by the auth
+func NewGRPCServer(svc GRPCService, auth Auth, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(auth.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(auth.StreamInterceptor),
+	)
+	s := grpc.NewServer(opts...)
+	workerpb.RegisterWorkerServer(s, svc)
+	return s
+}
+
+// Jobs dispatches a job for each requested one, the job is sent in chunks ended with the EOF chunk
+func (g GRPCService) Jobs(stream grpc.BidiStreamingServer[workerpb.JobRequest, workerpb.JobChunk]) error {
+	ctx := stream.Context()
+	workerID := grpcWorkerIdentity(ctx)
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := int32(0); i < req.Jobs; i++ {
+			job, err := g.dispatch(ctx, workerID)
+			if err != nil {
+				return err
+			}
+			if err := g.sendJob(stream, job); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dispatch waits for the next job while the stream is open
+func (g GRPCService) dispatch(ctx context.Context, workerID string) (*worker.Job, error) {
+	for {
+		job, err := g.Service.Dispatch(workerID)
+		if errors.Is(err, ErrDispatchTimeout) {
+			if ctx.Err() != nil {
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+			continue
+		}
+		if errors.Is(err, ErrClosed) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		if err != nil {
+			g.logger().Error("dispatch failed", logging.Err(err))
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return job, nil
+	}
+}
+
+func (g GRPCService) sendJob(stream grpc.BidiStreamingServer[workerpb.JobRequest, workerpb.JobChunk], job *worker.Job) error {
+	log := g.logger().With(logging.Job(job.JobID, job.TileNum))
+	defer func() {
+		if err := job.Src.Close(); err != nil {
+			log.Error("tile stream failed", logging.Err(err))
+		}
+	}()
+
+	log.Debug("streaming tile")
+	if err := stream.Send(&workerpb.JobChunk{Header: job.JobHeader()}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, worker.GRPCChunkSize)
+	for {
+		n, err := job.Src.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&workerpb.JobChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return stream.Send(&workerpb.JobChunk{Eof: true})
+		}
+		if err != nil {
+			log.Error("tile streaming failed", logging.Err(err))
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// Upload accepts the result streamed after its header chunk
+func (g GRPCService) Upload(stream grpc.ClientStreamingServer[workerpb.ResultChunk, workerpb.UploadReply]) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Header == nil {
+		return status.Error(codes.InvalidArgument, "result header is missing")
+	}
+
+	src := &resultReader{stream: stream, buf: first.Data}
+	if err := g.Service.AcceptResult(worker.ResultFromHeader(first.Header, src)); err != nil {
+		return g.toStatus(err)
+	}
+	return stream.SendAndClose(&workerpb.UploadReply{Size: src.size})
+}
+
+// ReportProgress updates the progress of the running tile
+func (g GRPCService) ReportProgress(ctx context.Context, progress *workerpb.Progress) (*workerpb.Ack, error) {
+	if err := g.Service.ReportProgress(worker.ProgressFromMessage(progress)); err != nil {
+		return nil, g.toStatus(err)
+	}
+	return &workerpb.Ack{Ok: true}, nil
+}
+
+// ReportFailure fails the tile the worker couldn't encode
+func (g GRPCService) ReportFailure(ctx context.Context, failure *workerpb.Failure) (*workerpb.Ack, error) {
+	if err := g.Service.ReportFailure(worker.FailureFromMessage(failure)); err != nil {
+		return nil, g.toStatus(err)
+	}
+	return &workerpb.Ack{Ok: true}, nil
+}
+
+// toStatus maps the errors the same way as the HTTP handler maps them to the status codes
+func (g GRPCService) toStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidResultName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrTileNotLeased):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrJobNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case status.Code(err) != codes.Unknown:
+		// the stream error is returned as is
+		return err
+	default:
+		g.logger().Error("call failed", logging.Err(err))
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (g GRPCService) logger() *slog.Logger {
+	if g.Logger == nil {
+		return slog.Default()
+	}
+	return g.Logger
+}
+
+// resultReader reads the result data from the upload stream
+type resultReader struct {
+	stream grpc.ClientStreamingServer[workerpb.ResultChunk, workerpb.UploadReply]
+	buf    []byte
+	size   int64
+}
+
+func (r *resultReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.size += int64(n)
+	return n, nil
+}
+
+// UnaryInterceptor and StreamInterceptor protect the gRPC worker service the same way as Auth.Worker does
+// the HTTP endpoints, the calls are signed with the gRPC method as a path
+func (a Auth) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := a.authorizeCall(ctx, info.FullMethod, worker.MessageSHA256(req)); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a Auth) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorizeCall(ss.Context(), info.FullMethod, worker.UnsignedPayload); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// authorizeCall checks the signature of the call, content is the hash of the unary call message
+// or UnsignedPayload of the stream
+func (a Auth) authorizeCall(ctx context.Context, method, content string) error {
+	if a.WorkerSecret == "" && !a.RequireClientCert {
+		return nil
+	}
+	if _, ok := certIdentity(peerTLS(ctx)); a.RequireClientCert && !ok {
+		return status.Error(codes.Unauthenticated, "client certificate with a common name or a DNS name is required")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	switch {
+	case a.WorkerSecret == "" || a.verifySignature(worker.SignedRequest{
+		Method:        worker.GRPCMethod,
+		Path:          method,
+		Timestamp:     firstValue(md, worker.AuthTimestampHeader),
+		Nonce:         firstValue(md, worker.AuthNonceHeader),
+		ContentSHA256: firstValue(md, worker.AuthContentHeader),
+	}, firstValue(md, worker.AuthSignatureHeader), func() bool {
+		return firstValue(md, worker.AuthContentHeader) == content
+	}):
+		return nil
+	case a.verifyAPIKey(firstValue(md, "authorization")):
+		return status.Error(codes.PermissionDenied, "API keys are not allowed")
+	default:
+		return status.Error(codes.Unauthenticated, "signature is invalid")
+	}
+}
+
+// grpcWorkerIdentity identifies the worker by its client certificate, the self-reported id is used without it
+func grpcWorkerIdentity(ctx context.Context) string {
+	if id, ok := certIdentity(peerTLS(ctx)); ok {
+		return id
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return firstValue(md, worker.WorkerIDHeader)
+}
+
+func peerTLS(ctx context.Context) *tls.ConnectionState {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return &info.State
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
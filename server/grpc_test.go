@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"distributed-encoder/transcoder"
+	"distributed-encoder/worker"
+)
+
+// tileStreamer streams the same tile for any crop
+type tileStreamer struct {
+	tile string
+}
+
+func (s tileStreamer) StreamTile(args *transcoder.CropArgs) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(s.tile)), nil
+}
+
+func newBufconnClient(t *testing.T, srv *grpc.Server, cfg worker.GRPCClientConfig) *worker.GRPCClient {
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cfg.Target = "passthrough:///bufconn"
+	cfg.DialOptions = []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	client, err := worker.NewGRPCClient(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGRPCService(t *testing.T) {
+	store := &recordingStore{}
+	s, err := New(Config{
+		DispatchTimeout: 50 * time.Millisecond,
+		Store:           store,
+		// the tile is larger than a single message
+		TileStreamer: tileStreamer{tile: strings.Repeat("tile", 10<<10)},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	auth := Auth{WorkerSecret: "worker-secret"}
+	client := newBufconnClient(t, NewGRPCServer(GRPCService{Service: s}, auth),
+		worker.GRPCClientConfig{Secret: "worker-secret", WorkerID: "grpc-worker"})
+
+	status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 2, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+
+	// both transports serve the same server, the first tile goes to the gRPC worker
+	ctx, cancel := context.WithCancel(context.Background())
+	subscribed := make(chan error)
+	go func() {
+		subscribed <- client.Subscribe(ctx, func(job *worker.Job) error {
+			defer cancel()
+
+			tile, err := io.ReadAll(job.Src)
+			require.NoError(t, err)
+			require.Equal(t, strings.Repeat("tile", 10<<10), string(tile))
+
+			require.NoError(t, client.ReportProgress(&worker.Progress{
+				JobID: job.JobID, TileNum: job.TileNum, Frame: 10, Lease: job.Lease,
+			}))
+			return client.SendResult(&worker.Result{
+				JobID:    job.JobID,
+				TileNum:  job.TileNum,
+				FileName: job.TileName + ".ts",
+				Lease:    job.Lease,
+				Src:      strings.NewReader(strings.Repeat("encoded", 10<<10)),
+			})
+		})
+	}()
+	select {
+	case err := <-subscribed:
+		require.ErrorIs(t, err, worker.ErrCancelled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("job is not received")
+	}
+	require.Equal(t, strings.Repeat("encoded", 10<<10), store.data[status.ID+"/video_tile_0.ts"])
+
+	// and the second one to the HTTP worker
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/work/jobs", HTTPHandler{Service: s}.Dispatch)
+	router.HandlerFunc(http.MethodPost, "/work/result", HTTPHandler{Service: s}.AcceptResult)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/work/jobs", "", http.NoBody)
+	require.NoError(t, err)
+	job, err := worker.ParseJobFromHTTP(res)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, 1, job.TileNum)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/work/result", strings.NewReader("encoded"))
+	require.NoError(t, err)
+	worker.MarshalResultToHeader(&worker.Result{JobID: job.JobID, TileNum: 1, FileName: "video_tile_1.ts", Lease: job.Lease}, req.Header)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	status, err = s.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateCompleted, status.State)
+	require.Equal(t, "grpc-worker", status.Tiles[0].Worker)
+}
+
+func TestGRPCService_Rejected(t *testing.T) {
+	store := &recordingStore{}
+	s, err := New(Config{DispatchTimeout: 50 * time.Millisecond, Store: store, TileStreamer: tileStreamer{tile: "tile"}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	auth := Auth{WorkerSecret: "worker-secret"}
+	client := newBufconnClient(t, NewGRPCServer(GRPCService{Service: s}, auth), worker.GRPCClientConfig{Secret: "worker-secret"})
+
+	// the upload without a lease is rejected
+	err = client.SendResult(&worker.Result{JobID: "1d2f", FileName: "video_tile_0.ts", Src: strings.NewReader("encoded")})
+	require.ErrorContains(t, err, "FailedPrecondition")
+	require.Empty(t, store.data)
+	err = client.ReportFailure(&worker.Failure{JobID: "1d2f", Error: "exit status 1"})
+	require.ErrorContains(t, err, "FailedPrecondition")
+
+	// the unsigned worker is not subscribed
+	unsigned := newBufconnClient(t, NewGRPCServer(GRPCService{Service: s}, auth), worker.GRPCClientConfig{})
+	err = unsigned.Subscribe(context.Background(), func(job *worker.Job) error {
+		t.Fatal("job is dispatched to the unsigned worker")
+		return nil
+	})
+	require.ErrorIs(t, err, worker.ErrUnauthorized)
+	require.ErrorIs(t, unsigned.ReportProgress(&worker.Progress{JobID: "1d2f"}), worker.ErrUnauthorized)
+}
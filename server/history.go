@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobFilter selects the jobs of the history
+type JobFilter struct {
+	// States are the states of the listed jobs, any state when it's empty
+	States []JobState
+	// File is the source path of the request or its trailing part, e.g. the file name
+	File string
+	// Submitter of the request
+	Submitter string
+	// Batch is an id of the batch the requests are submitted with
+	Batch string
+	// Since is the earliest creation time of the listed jobs
+	Since time.Time
+	// Until is the creation time the listed jobs are created before
+	Until time.Time
+	// After is the last job of the previous page, the listing starts from the latest job when it's nil
+	After *JobCursor
+	// Limit is a maximum amount of the listed jobs, zero means no limit
+	Limit int
+}
+
+// Match reports whether the job is selected by the filter, the cursor and the limit are not checked
+func (f *JobFilter) Match(job *JobStatus) bool {
+	if len(f.States) > 0 && !slices.Contains(f.States, job.State) {
+		return false
+	}
+	if f.File != "" && job.Request.FilePath != f.File && !strings.HasSuffix(job.Request.FilePath, "/"+f.File) {
+		return false
+	}
+	if f.Submitter != "" && job.Request.Submitter != f.Submitter {
+		return false
+	}
+	if f.Batch != "" && job.Request.BatchID != f.Batch {
+		return false
+	}
+	if !f.Until.IsZero() && !job.CreatedAt.Before(f.Until) {
+		return false
+	}
+	return !job.CreatedAt.Before(f.Since)
+}
+
+// JobCursor is a position in the history listed the latest jobs first
+type JobCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// before reports whether the job is listed after the cursor
+func (c *JobCursor) before(job *JobStatus) bool {
+	return c == nil || compareJobs(&JobStatus{ID: c.ID, CreatedAt: c.CreatedAt}, job) < 0
+}
+
+// compareJobs orders the jobs the latest first, the jobs created at once are ordered by their ids
+func compareJobs(a, b *JobStatus) int {
+	if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// MarshalText encodes the cursor as an opaque token
+func (c JobCursor) MarshalText() ([]byte, error) {
+	token := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + ":" + c.ID
+	return []byte(base64.RawURLEncoding.EncodeToString([]byte(token))), nil
+}
+
+// UnmarshalText decodes the token of the cursor
+func (c *JobCursor) UnmarshalText(text []byte) error {
+	errInvalid := errors.New("cursor is invalid")
+	token, err := base64.RawURLEncoding.DecodeString(string(text))
+	if err != nil {
+		return errInvalid
+	}
+	nanos, id, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return errInvalid
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return errInvalid
+	}
+	*c = JobCursor{CreatedAt: time.Unix(0, n), ID: id}
+	return nil
+}
+
+// JobStats summarizes the processing of the jobs
+type JobStats struct {
+	// Jobs is an amount of the summarized jobs
+	Jobs           int `json:"jobs"`
+	Tiles          int `json:"tiles"`
+	CompletedTiles int `json:"completedTiles"`
+	FailedTiles    int `json:"failedTiles"`
+	// Attempts is an amount of the dispatches of the tiles
+	Attempts int `json:"attempts"`
+	// EncodeTime is a total time of the finished attempts in seconds
+	EncodeTime float64 `json:"encodeTime"`
+	// Bytes is a total size of the stored results
+	Bytes int64 `json:"bytes"`
+}
+
+func (s *JobStats) add(other JobStats) {
+	s.Jobs += other.Jobs
+	s.Tiles += other.Tiles
+	s.CompletedTiles += other.CompletedTiles
+	s.FailedTiles += other.FailedTiles
+	s.Attempts += other.Attempts
+	s.EncodeTime += other.EncodeTime
+	s.Bytes += other.Bytes
+}
+
+// stats returns the statistics of the job
+func (r *JobRecord) stats() JobStats {
+	stats := JobStats{
+		Jobs:     1,
+		Tiles:    len(r.Status.Tiles),
+		Attempts: len(r.Attempts),
+	}
+	for _, tile := range r.Status.Tiles {
+		switch tile.State {
+		case StateCompleted:
+			stats.CompletedTiles++
+		case StateFailed:
+			stats.FailedTiles++
+		}
+	}
+	for _, attempt := range r.Attempts {
+		if !attempt.FinishedAt.IsZero() {
+			stats.EncodeTime += attempt.FinishedAt.Sub(attempt.StartedAt).Seconds()
+		}
+		stats.Bytes += attempt.Bytes
+	}
+	return stats
+}
+
+// JobSummary is the job of the history with its statistics
+type JobSummary struct {
+	JobStatus
+	Stats JobStats `json:"stats"`
+}
+
+// JobList is a page of the job history
+type JobList struct {
+	Jobs []JobSummary `json:"jobs"`
+	// Summary is the statistics of all the jobs selected by the filter, not only of the page
+	Summary JobStats `json:"summary"`
+	// Next is the cursor of the next page, it's nil on the last page
+	Next *JobCursor `json:"next,omitempty"`
+}
+
+// list returns the page of the jobs selected by the filter, the latest jobs first
+func (r *jobRegistry) list(filter JobFilter) (*JobList, error) {
+	limit := filter.Limit
+	if limit > 0 {
+		// the extra job tells there is the next page
+		filter.Limit++
+	}
+	recs, err := r.store.ListJobs(filter)
+	if err != nil {
+		return nil, fmt.Errorf("jobs can't be listed: %w", err)
+	}
+	summary, err := r.store.SummarizeJobs(filter)
+	if err != nil {
+		return nil, fmt.Errorf("jobs can't be summarized: %w", err)
+	}
+
+	list := &JobList{Jobs: make([]JobSummary, 0, len(recs)), Summary: summary}
+	if limit > 0 && len(recs) > limit {
+		recs = recs[:limit]
+		last := recs[limit-1].Status
+		list.Next = &JobCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	for _, rec := range recs {
+		list.Jobs = append(list.Jobs, JobSummary{JobStatus: rec.Status.snapshot(), Stats: rec.stats()})
+	}
+	return list, nil
+}
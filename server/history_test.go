@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobRegistry_List(t *testing.T) {
+	store := newMemoryJobStore()
+	r := newJobRegistry(store)
+	created := time.Unix(1600000000, 0).UTC()
+	for i, file := range []string{"/videos/a.mp4", "/videos/b.mp4", "/other/a.mp4"} {
+		r.now = func() time.Time { return created.Add(time.Duration(i) * time.Hour) }
+		_, err := r.add(string(rune('a'+i)), EncodeVideoRequest{FilePath: file, Submitter: "team-a"},
+			[]TileJob{{TileNum: 0, File: "v.mp4"}, {TileNum: 1, File: "v.mp4"}}, 0)
+		require.NoError(t, err)
+	}
+	r.now = func() time.Time { return created }
+	r.tileDispatched("a", 0, "worker-1")
+	r.now = func() time.Time { return created.Add(90 * time.Second) }
+	r.tileStored("a", 0, 1024)
+
+	ids := func(list *JobList) []string {
+		var ids []string
+		for _, job := range list.Jobs {
+			ids = append(ids, job.ID)
+		}
+		return ids
+	}
+
+	// the pages are chained by the cursor, the summary covers all the pages
+	list, err := r.list(JobFilter{File: "a.mp4", Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, ids(list))
+	require.Equal(t, JobStats{Jobs: 2, Tiles: 4, CompletedTiles: 1, Attempts: 1, EncodeTime: 90, Bytes: 1024}, list.Summary)
+	require.NotNil(t, list.Next)
+
+	list, err = r.list(JobFilter{File: "a.mp4", Limit: 1, After: list.Next})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, ids(list))
+	require.Equal(t, JobStats{Jobs: 1, Tiles: 2, CompletedTiles: 1, Attempts: 1, EncodeTime: 90, Bytes: 1024}, list.Jobs[0].Stats)
+	require.Nil(t, list.Next)
+
+	list, err = r.list(JobFilter{Submitter: "team-b"})
+	require.NoError(t, err)
+	require.Empty(t, list.Jobs)
+}
+
+func TestJobCursor_Text(t *testing.T) {
+	cursor := JobCursor{CreatedAt: time.Unix(1600000000, 5), ID: "1d2f"}
+	token, err := cursor.MarshalText()
+	require.NoError(t, err)
+
+	var got JobCursor
+	require.NoError(t, got.UnmarshalText(token))
+	require.True(t, cursor.CreatedAt.Equal(got.CreatedAt))
+	require.Equal(t, "1d2f", got.ID)
+	require.Error(t, got.UnmarshalText([]byte("1d2f")))
+}
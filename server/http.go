@@ -2,45 +2,85 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
-	"mime"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/julienschmidt/httprouter"
+
+	"distributed-encoder/logging"
 	"distributed-encoder/worker"
 )
 
 type Service interface {
-	Dispatch() (*worker.Job, error)
-	AcceptResult(string, io.Reader) error
-	TriggerWork(EncodeVideoRequest) error
+	Dispatch(workerID string) (*worker.Job, error)
+	AcceptResult(*worker.Result) error
+	TriggerWork(EncodeVideoRequest) (*JobStatus, error)
+	TriggerBatch(requests []BatchRequest) (*BatchResult, error)
+	BatchStatus(id string) (*BatchStatus, error)
+	ReportProgress(*worker.Progress) error
+	ReportFailure(*worker.Failure) error
+	JobStatus(id string) (*JobStatus, error)
+	ListJobs(filter JobFilter) (*JobList, error)
+	CancelJob(id string) (*JobStatus, error)
+	RetryJob(id string) (*JobStatus, error)
+	Workers() ([]WorkerStatus, error)
+	SubscribeEvents(id string) (*JobStatus, <-chan Event, func(), error)
+	InitiateUpload(result *worker.Result, length int64) (*worker.UploadStatus, error)
+	UploadPart(id string, offset int64, src io.Reader) (*worker.UploadStatus, error)
+	UploadStatus(id string) (*worker.UploadStatus, error)
+	CompleteUpload(id string) error
+	Offer(ctx context.Context, workerID string) (*worker.Offer, error)
+	StreamOffer(jobID string, tileNum int, lease string) (*worker.Job, error)
+	RejectOffer(jobID string, tileNum int, lease string) error
 }
 
+// IdempotencyKeyHeader makes the repeated trigger requests return the job created by the first one
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type HTTPHandler struct {
 	Service Service
+	// Logger receives the request errors, slog.Default is used when it's nil
+	Logger *slog.Logger
 }
 
 // POST /work/
 func (h HTTPHandler) Dispatch(w http.ResponseWriter, req *http.Request) {
-	job, err := h.Service.Dispatch()
-	if err == ErrDispatchTimeout {
+	job, err := h.Service.Dispatch(workerIdentity(req))
+	if errors.Is(err, ErrDispatchTimeout) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	if err != nil {
-		logErr(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w, req, err)
 		return
 	}
-	defer job.Src.Close()
+	h.streamJob(w, req, job)
+}
 
-	log.Println("[HTTP] starting stream")
+// streamJob streams the tile of the job with its description in the headers
+func (h HTTPHandler) streamJob(w http.ResponseWriter, req *http.Request, job *worker.Job) {
+	log := h.logger().With(logging.Job(job.JobID, job.TileNum))
+	defer func() {
+		if err := job.Src.Close(); err != nil {
+			log.Error("tile stream failed", logging.Err(err))
+		}
+	}()
+
+	log.Debug("streaming tile")
 	req.Header.Set("Content-Type", "application/octet-stream")
 	worker.MarshalJobToHeader(job, w.Header())
 
 	if _, err := io.Copy(w, bufio.NewReader(job.Src)); err != nil {
-		log.Printf("Serving stream error: %s", err)
+		log.Error("tile streaming failed", logging.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -49,61 +89,360 @@ func (h HTTPHandler) Dispatch(w http.ResponseWriter, req *http.Request) {
 
 // POST /work/result
 func (h HTTPHandler) AcceptResult(w http.ResponseWriter, req *http.Request) {
-	log.Println("[HTTP] accepting result")
-	content := req.Header.Get("Content-Disposition")
-	_, params, err := mime.ParseMediaType(content)
+	defer req.Body.Close()
+
+	result, err := worker.ParseResultFromHTTP(req)
 	if err != nil {
+		h.logErr(req, err)
+		h.writeError(w, req, http.StatusBadRequest, err.Error())
 		return
 	}
-	fileName := params["filename"]
-	defer req.Body.Close()
 
-	err = h.Service.AcceptResult(fileName, req.Body)
-	if err != nil {
-		logErr(err)
+	err = h.Service.AcceptResult(&result)
+	switch {
+	case errors.Is(err, ErrInvalidResultName):
+		h.writeError(w, req, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrTileNotLeased):
+		h.writeError(w, req, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		h.writeInternalError(w, req, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-// POST /work/result
+// POST /work/trigger
+// 400 is returned for the malformed body, 422 with the invalid fields in the errors of the problem
+// when the request can't be encoded
 func (h HTTPHandler) Trigger(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	var encoderReq EncodeVideoRequest
-	dec := json.NewDecoder(req.Body)
-	err := dec.Decode(&encoderReq)
+	if err := json.NewDecoder(req.Body).Decode(&encoderReq); err != nil {
+		h.writeMalformed(w, req, err)
+		return
+	}
+
+	encoderReq.IdempotencyKey = req.Header.Get(IdempotencyKeyHeader)
+	// the jobs are tagged by TriggerBatch only, the batch id of the client is ignored
+	encoderReq.BatchID = ""
+
+	status, err := h.Service.TriggerWork(encoderReq)
+	var queueErr *QueueFullError
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &queueErr):
+		h.logErr(req, err)
+		h.writeQueueFull(w, req, queueErr)
+		return
+	case errors.As(err, &validationErr):
+		h.writeProblem(w, req, Problem{
+			Status: http.StatusUnprocessableEntity,
+			Detail: validationErr.Error(),
+			Errors: validationErr.Errors,
+		})
+		return
+	case errors.Is(err, ErrInputNotAllowed):
+		h.logErr(req, err)
+		h.writeError(w, req, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, ErrIdempotencyMismatch):
+		h.logErr(req, err)
+		h.writeError(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	case errors.Is(err, ErrRequestInProgress):
+		w.Header().Set("Retry-After", "1")
+		h.writeError(w, req, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logErr(req, err)
+	}
+}
+
+// writeMalformed responds with 400 for the JSON body which can't be decoded,
+// the fields of the wrong types are reported in the errors of the problem
+func (h HTTPHandler) writeMalformed(w http.ResponseWriter, req *http.Request, err error) {
+	detail := fmt.Sprintf("request is malformed: %v", err)
+	if errors.Is(err, io.EOF) {
+		detail = "request body is empty"
+	}
+	h.writeProblem(w, req, Problem{
+		Status: http.StatusBadRequest,
+		Detail: detail,
+		Errors: decodeFieldErrors(err),
+	})
+}
+
+// POST /work/progress
+func (h HTTPHandler) ReportProgress(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	var progress worker.Progress
+	if err := json.NewDecoder(req.Body).Decode(&progress); err != nil {
+		h.logErr(req, err)
+		h.writeMalformed(w, req, err)
+		return
+	}
+
+	err := h.Service.ReportProgress(&progress)
+	if errors.Is(err, ErrJobNotFound) {
+		h.writeError(w, req, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, ErrTileNotLeased) {
+		h.writeError(w, req, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
-		logErr(err)
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /work/failure
+func (h HTTPHandler) ReportFailure(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	var failure worker.Failure
+	if err := json.NewDecoder(req.Body).Decode(&failure); err != nil {
+		h.logErr(req, err)
+		h.writeMalformed(w, req, err)
 		return
 	}
 
-	err = h.Service.TriggerWork(encoderReq)
+	err := h.Service.ReportFailure(&failure)
+	if errors.Is(err, ErrTileNotLeased) {
+		h.writeError(w, req, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
-		logErr(err)
-		writeError(w, err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeInternalError(w, req, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func writeError(w io.Writer, msg string) {
-	b, err := json.Marshal(map[string]interface{}{
-		"error": msg,
-	})
+// GET /work/jobs/:id
+func (h HTTPHandler) JobStatus(w http.ResponseWriter, req *http.Request) {
+	status, err := h.Service.JobStatus(jobIDParam(req))
+	if errors.Is(err, ErrJobNotFound) {
+		h.writeError(w, req, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logErr(req, err)
+	}
+}
+
+const (
+	// defaultJobsLimit is an amount of the listed jobs when the limit isn't requested
+	defaultJobsLimit = 100
+	// maxJobsLimit is a maximum amount of the listed jobs
+	maxJobsLimit = 1000
+)
+
+// GET /work/jobs?state=failed&file=video.mp4&submitter=team-a&since=2024-01-02T15:04:05Z&until=...&limit=100&cursor=...
+// Lists the jobs the latest first with their statistics and the summary of all the selected jobs,
+// state accepts a comma-separated list of the states, next is the cursor of the next page
+func (h HTTPHandler) ListJobs(w http.ResponseWriter, req *http.Request) {
+	filter, err := parseJobFilter(req.URL.Query())
+	if err != nil {
+		h.writeError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := h.Service.ListJobs(filter)
+	if err != nil {
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logErr(req, err)
+	}
+}
+
+func parseJobFilter(query url.Values) (JobFilter, error) {
+	filter := JobFilter{
+		File:      query.Get("file"),
+		Submitter: query.Get("submitter"),
+		Batch:     query.Get("batch"),
+		Limit:     defaultJobsLimit,
+	}
+	if states := query.Get("state"); states != "" {
+		for _, state := range strings.Split(states, ",") {
+			switch state := JobState(state); state {
+			case StateQueued, StateRunning, StateCompleted, StateFailed:
+				filter.States = append(filter.States, state)
+			default:
+				return JobFilter{}, fmt.Errorf("state %q is unknown", state)
+			}
+		}
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return JobFilter{}, fmt.Errorf("%s is not a RFC 3339 time: %w", name, err)
+			}
+			*t = parsed
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxJobsLimit {
+			return JobFilter{}, fmt.Errorf("limit must be between 1 and %d", maxJobsLimit)
+		}
+		filter.Limit = n
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		filter.After = &JobCursor{}
+		if err := filter.After.UnmarshalText([]byte(cursor)); err != nil {
+			return JobFilter{}, err
+		}
+	}
+	return filter, nil
+}
+
+// sseHeartbeat is an interval of the comments sent to keep the idle event stream alive
+const sseHeartbeat = 15 * time.Second
+
+// GET /work/jobs/:id/events
+// Server-Sent Events stream of the job, the first event is a job.status snapshot,
+// the stream ends after job.completed or job.failed event
+func (h HTTPHandler) JobEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.writeInternalError(w, req, errors.New("streaming is not supported"))
+		return
+	}
+
+	status, events, cancel, err := h.Service.SubscribeEvents(jobIDParam(req))
+	if errors.Is(err, ErrJobNotFound) {
+		h.writeError(w, req, http.StatusNotFound, err.Error())
+		return
+	}
 	if err != nil {
+		h.writeInternalError(w, req, err)
+		return
+	}
+	defer cancel()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	snapshot := newJobEvent(EventJobStatus, *status, status.UpdatedAt)
+	if err := writeSSE(w, snapshot); err != nil || status.done() {
+		flusher.Flush()
 		return
 	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				h.logErr(req, err)
+				return
+			}
+			flusher.Flush()
+			if e.terminal() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w io.Writer, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
+
+func jobIDParam(req *http.Request) string {
+	return httprouter.ParamsFromContext(req.Context()).ByName("id")
+}
+
+func tileNumParam(req *http.Request) string {
+	return httprouter.ParamsFromContext(req.Context()).ByName("num")
+}
+
+// retryAfterSeconds is a hint for the client when the queue is full
+const retryAfterSeconds = "15"
+
+// writeQueueFull responds with 429 when the submitter's quota is reached and 503 when the whole queue is full,
+// the request which exceeds the limit on its own isn't retryable and gets 413
+func (h HTTPHandler) writeQueueFull(w http.ResponseWriter, req *http.Request, err *QueueFullError) {
+	status := http.StatusServiceUnavailable
+	switch {
+	case err.Oversized():
+		status = http.StatusRequestEntityTooLarge
+	case err.Submitter != "":
+		status = http.StatusTooManyRequests
+	}
 
-	if _, err = w.Write(b); err != nil {
-		logErr(err)
+	if !err.Oversized() {
+		w.Header().Set("Retry-After", retryAfterSeconds)
 	}
+	h.writeProblem(w, req, Problem{
+		Status:     status,
+		Detail:     err.Error(),
+		QueueDepth: &err.Depth,
+		QueueLimit: &err.Limit,
+	})
 }
 
-func logErr(err error) {
-	log.Printf("[HTTP] request error: %s ", err)
+func (h HTTPHandler) logErr(req *http.Request, err error) {
+	h.logger().Error("request failed",
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		logging.Err(err),
+	)
+}
+
+func (h HTTPHandler) logger() *slog.Logger {
+	if h.Logger == nil {
+		return slog.Default()
+	}
+	return h.Logger
 }
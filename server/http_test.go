@@ -1,12 +1,18 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
@@ -21,17 +27,128 @@ func TestHTTPHandler_AcceptResult(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Disposition", "attachment; filename="+fileName)
+	req.Header.Set("X-Job-Id", "1d2f")
+	req.Header.Set("X-Tile-Num", "2")
 
 	var serviceMock serverMock
 	h := HTTPHandler{Service: &serviceMock}
 
-	serviceMock.On("AcceptResult", fileName, body).Once()
+	serviceMock.On("AcceptResult", &worker.Result{
+		JobID:    "1d2f",
+		TileNum:  2,
+		FileName: fileName,
+		Src:      req.Body,
+	}).Return(nil).Once()
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(h.AcceptResult)
 	handler.ServeHTTP(rr, req)
 
 	require.Equal(t, http.StatusOK, rr.Code)
+	serviceMock.AssertExpectations(t)
+}
+
+func TestHTTPHandler_AcceptResultInvalid(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/result", strings.NewReader(""))
+	require.NoError(t, err)
+	req.Header.Set("Content-Disposition", "attachment; filename=file.mp4")
+
+	var serviceMock serverMock
+	h := HTTPHandler{Service: &serviceMock}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.AcceptResult).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	serviceMock.AssertNotCalled(t, "AcceptResult", mock.Anything)
+}
+
+func TestHTTPHandler_AcceptResultRejected(t *testing.T) {
+	tests := map[string]struct {
+		err          error
+		expectedCode int
+	}{
+		"invalid name":   {err: ErrInvalidResultName, expectedCode: http.StatusBadRequest},
+		"not dispatched": {err: ErrTileNotLeased, expectedCode: http.StatusConflict},
+		"store failure":  {err: errFake, expectedCode: http.StatusInternalServerError},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/result", strings.NewReader(""))
+			require.NoError(t, err)
+			req.Header.Set("Content-Disposition", "attachment; filename=file.ts")
+			req.Header.Set("X-Job-Id", "1d2f")
+			req.Header.Set("X-Tile-Num", "2")
+
+			var serviceMock serverMock
+			serviceMock.On("AcceptResult", mock.Anything).Return(tt.err).Once()
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(HTTPHandler{Service: &serviceMock}.AcceptResult).ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestHTTPHandler_Trigger(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/trigger", strings.NewReader(`{
+		"tiles": 4,
+		"width": 7680,
+		"height": 3840,
+		"filePath": "/mnt/videos/video.mp4",
+		"callbackUrl": "http://orchestrator/hooks",
+		"batchId": "b1c2"
+	}`))
+	require.NoError(t, err)
+
+	var serviceMock serverMock
+	serviceMock.On("TriggerWork", EncodeVideoRequest{
+		Tiles:       4,
+		Width:       7680,
+		Height:      3840,
+		FilePath:    "/mnt/videos/video.mp4",
+		CallbackURL: "http://orchestrator/hooks",
+	}).Return(&JobStatus{ID: "1d2f", State: StateQueued}, nil).Once()
+	h := HTTPHandler{Service: &serviceMock}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.Trigger).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Body.String(), `"id":"1d2f","state":"queued"`)
+}
+
+func TestHTTPHandler_TriggerIdempotent(t *testing.T) {
+	request := EncodeVideoRequest{Tiles: 1, FilePath: "/mnt/videos/video.mp4", IdempotencyKey: "a1b2"}
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{name: "repeated", expectedCode: http.StatusOK},
+		{name: "changed", err: fmt.Errorf("%w: job 1d2f", ErrIdempotencyMismatch), expectedCode: http.StatusUnprocessableEntity},
+		{name: "in progress", err: ErrRequestInProgress, expectedCode: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var serviceMock serverMock
+			status := &JobStatus{ID: "1d2f", State: StateQueued}
+			if tt.err != nil {
+				status = nil
+			}
+			serviceMock.On("TriggerWork", request).Return(status, tt.err).Once()
+
+			req := httptest.NewRequest(http.MethodPost, "/work/trigger", strings.NewReader(`{"tiles": 1, "filePath": "/mnt/videos/video.mp4"}`))
+			req.Header.Set(IdempotencyKeyHeader, "a1b2")
+			rr := httptest.NewRecorder()
+			HTTPHandler{Service: &serviceMock}.Trigger(rr, req)
+
+			require.Equal(t, tt.expectedCode, rr.Code)
+			serviceMock.AssertExpectations(t)
+		})
+	}
 }
 
 func TestHTTPHandler_Dispatch(t *testing.T) {
@@ -40,10 +157,11 @@ func TestHTTPHandler_Dispatch(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set(worker.WorkerIDHeader, "worker-1")
 	var serviceMock serverMock
 	h := HTTPHandler{Service: &serviceMock}
 
-	serviceMock.On("Dispatch").
+	serviceMock.On("Dispatch", "worker-1").
 		Return(nil, ErrDispatchTimeout).
 		Once()
 
@@ -54,21 +172,350 @@ func TestHTTPHandler_Dispatch(t *testing.T) {
 	require.Equal(t, http.StatusNotModified, rr.Code)
 }
 
+func TestHTTPHandler_TriggerQueueFull(t *testing.T) {
+	tests := map[string]struct {
+		err          error
+		expectedCode int
+		retryAfter   string
+		expectedBody string
+	}{
+		"queue is full": {
+			err:          &QueueFullError{Depth: 990, Limit: 1000, Requested: 16},
+			expectedCode: http.StatusServiceUnavailable,
+			retryAfter:   "15",
+			expectedBody: `{"type":"about:blank","title":"Service Unavailable","status":503,"instance":"/trigger",
+				"detail":"queue is full: 990 of 1000 tiles queued, 16 requested","error":"queue is full: 990 of 1000 tiles queued, 16 requested",
+				"queueDepth":990,"queueLimit":1000}`,
+		},
+		"submitter quota": {
+			err:          &QueueFullError{Submitter: "team-a", Depth: 10, Limit: 16, Requested: 16},
+			expectedCode: http.StatusTooManyRequests,
+			retryAfter:   "15",
+			expectedBody: `{"type":"about:blank","title":"Too Many Requests","status":429,"instance":"/trigger",
+				"detail":"submitter \"team-a\" queue is full: 10 of 16 tiles queued, 16 requested","error":"submitter \"team-a\" queue is full: 10 of 16 tiles queued, 16 requested",
+				"queueDepth":10,"queueLimit":16}`,
+		},
+		"larger than the queue": {
+			err:          &QueueFullError{Depth: 0, Limit: 8, Requested: 16},
+			expectedCode: http.StatusRequestEntityTooLarge,
+			expectedBody: `{"type":"about:blank","title":"Request Entity Too Large","status":413,"instance":"/trigger",
+				"detail":"16 tiles requested, queue is limited to 8 tiles","error":"16 tiles requested, queue is limited to 8 tiles",
+				"queueDepth":0,"queueLimit":8}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/trigger", strings.NewReader(`{"tiles":16}`))
+			require.NoError(t, err)
+
+			var serviceMock serverMock
+			serviceMock.On("TriggerWork", EncodeVideoRequest{Tiles: 16}).Return(nil, tt.err).Once()
+			h := HTTPHandler{Service: &serviceMock}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(h.Trigger).ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedCode, rr.Code)
+			require.Equal(t, tt.retryAfter, rr.Header().Get("Retry-After"))
+			require.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+			require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			serviceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHTTPHandler_JobStatus(t *testing.T) {
+	var serviceMock serverMock
+	serviceMock.On("JobStatus", "1d2f").Return(&JobStatus{ID: "1d2f", State: StateRunning}, nil).Once()
+	serviceMock.On("JobStatus", "unknown").Return(nil, ErrJobNotFound).Once()
+	// the store wraps the error with the job id
+	serviceMock.On("JobStatus", "5c6d").Return(nil, fmt.Errorf("job 5c6d: %w", ErrJobNotFound)).Once()
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/work/jobs/:id", HTTPHandler{Service: &serviceMock}.JobStatus)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/work/jobs/1d2f", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `"id":"1d2f","state":"running"`)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/work/jobs/unknown", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.JSONEq(t, `{"type":"about:blank","title":"Not Found","status":404,"instance":"/work/jobs/unknown",
+		"detail":"job is not found","error":"job is not found"}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/work/jobs/5c6d", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHTTPHandler_ListJobs(t *testing.T) {
+	since := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	cursor := &JobCursor{CreatedAt: since.Add(time.Hour), ID: "3e4f"}
+	token, err := cursor.MarshalText()
+	require.NoError(t, err)
+
+	var serviceMock serverMock
+	serviceMock.On("ListJobs", JobFilter{
+		States:    []JobState{StateFailed, StateQueued},
+		File:      "video.mp4",
+		Submitter: "team-a",
+		Since:     since,
+		Until:     since.Add(24 * time.Hour),
+		Limit:     1,
+	}).Return(&JobList{
+		Jobs:    []JobSummary{{JobStatus: JobStatus{ID: "3e4f", State: StateFailed}, Stats: JobStats{Jobs: 1, Tiles: 4, Bytes: 1024}}},
+		Summary: JobStats{Jobs: 2, Tiles: 8, Bytes: 2048},
+		Next:    cursor,
+	}, nil).Once()
+	serviceMock.On("ListJobs", mock.MatchedBy(func(filter JobFilter) bool {
+		return filter.Limit == defaultJobsLimit && filter.After.CreatedAt.Equal(cursor.CreatedAt) && filter.After.ID == cursor.ID
+	})).Return(&JobList{Jobs: []JobSummary{}}, nil).Once()
+	handler := HTTPHandler{Service: &serviceMock}
+
+	rr := httptest.NewRecorder()
+	handler.ListJobs(rr, httptest.NewRequest(http.MethodGet,
+		"/work/jobs?state=failed,queued&file=video.mp4&submitter=team-a&since=2024-01-02T15:04:05Z&until=2024-01-03T15:04:05Z&limit=1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `{"jobs":[{"id":"3e4f","state":"failed"`)
+	require.Contains(t, rr.Body.String(), `"stats":{"jobs":1,"tiles":4,"completedTiles":0,"failedTiles":0,"attempts":0,"encodeTime":0,"bytes":1024}`)
+	require.Contains(t, rr.Body.String(), `"summary":{"jobs":2,"tiles":8,`)
+	require.Contains(t, rr.Body.String(), `"next":"`+string(token)+`"`)
+
+	rr = httptest.NewRecorder()
+	handler.ListJobs(rr, httptest.NewRequest(http.MethodGet, "/work/jobs?cursor="+string(token), nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	for _, query := range []string{"state=done", "since=yesterday", "until=today", "limit=0", "limit=5000", "cursor=%3F%3F"} {
+		rr = httptest.NewRecorder()
+		handler.ListJobs(rr, httptest.NewRequest(http.MethodGet, "/work/jobs?"+query, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+	serviceMock.AssertExpectations(t)
+}
+
+func TestHTTPHandler_JobEvents(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	store.On("WriteObject", mock.Anything, mock.Anything).Return(nil)
+
+	srv, err := New(Config{Store: &store, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	status, err := srv.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/work/jobs/:id/events", HTTPHandler{Service: srv}.JobEvents)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/work/jobs/" + status.ID + "/events")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(res.Body)
+	require.Equal(t, EventJobStatus, readSSE(t, reader).Type)
+
+	job, err := srv.Dispatch("worker-1")
+	require.NoError(t, err)
+	require.NoError(t, srv.AcceptResult(&worker.Result{JobID: job.JobID, TileNum: job.TileNum, FileName: "video_tile_0.ts", Lease: job.Lease}))
+
+	require.Equal(t, EventTileDispatched, readSSE(t, reader).Type)
+	require.Equal(t, EventTileCompleted, readSSE(t, reader).Type)
+	last := readSSE(t, reader)
+	require.Equal(t, EventJobCompleted, last.Type)
+	require.Equal(t, StateCompleted, last.Job.State)
+
+	// stream is finished after the terminal event
+	_, err = reader.ReadString('\n')
+	require.Equal(t, io.EOF, err)
+}
+
+func readSSE(t *testing.T, reader *bufio.Reader) Event {
+	eventLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	dataLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+
+	var e Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(dataLine, "data: ")), &e))
+	require.Equal(t, "event: "+string(e.Type)+"\n", eventLine)
+	return e
+}
+
+func TestHTTPHandler_ReportProgress(t *testing.T) {
+	var serviceMock serverMock
+	serviceMock.On("ReportProgress", &worker.Progress{JobID: "1d2f", TileNum: 1, Frame: 10, OutTime: time.Second}).
+		Return(nil).Once()
+	serviceMock.On("ReportProgress", &worker.Progress{JobID: "unknown"}).Return(ErrJobNotFound).Once()
+	h := HTTPHandler{Service: &serviceMock}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/work/progress",
+		strings.NewReader(`{"jobId":"1d2f","tile":1,"frame":10,"outTime":1000000000}`))
+	http.HandlerFunc(h.ReportProgress).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/work/progress", strings.NewReader(`{"jobId":"unknown"}`))
+	http.HandlerFunc(h.ReportProgress).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/work/progress", strings.NewReader(`{`))
+	http.HandlerFunc(h.ReportProgress).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	serviceMock.AssertExpectations(t)
+}
+
+func TestHTTPHandler_ReportFailure(t *testing.T) {
+	var serviceMock serverMock
+	serviceMock.On("ReportFailure", &worker.Failure{JobID: "1d2f", TileNum: 1, Lease: "l1", Error: "exit status 1"}).
+		Return(nil).Once()
+	serviceMock.On("ReportFailure", &worker.Failure{JobID: "1d2f", Lease: "stale"}).Return(ErrLeaseInvalid).Once()
+	h := HTTPHandler{Service: &serviceMock}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/work/failure",
+		strings.NewReader(`{"jobId":"1d2f","tile":1,"lease":"l1","error":"exit status 1"}`))
+	http.HandlerFunc(h.ReportFailure).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/work/failure", strings.NewReader(`{"jobId":"1d2f","lease":"stale"}`))
+	http.HandlerFunc(h.ReportFailure).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/work/failure", strings.NewReader(`{`))
+	http.HandlerFunc(h.ReportFailure).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	serviceMock.AssertExpectations(t)
+}
+
 type serverMock struct {
 	mock.Mock
 }
 
-func (s *serverMock) Dispatch() (*worker.Job, error) {
-	args := s.Mock.Called()
+func (s *serverMock) Dispatch(workerID string) (*worker.Job, error) {
+	args := s.Mock.Called(workerID)
 	err := args.Get(1).(error)
 
 	return nil, err
 }
 
-func (s *serverMock) AcceptResult(s2 string, reader io.Reader) error {
-	return nil
+func (s *serverMock) AcceptResult(result *worker.Result) error {
+	args := s.Mock.Called(result)
+	return args.Error(0)
+}
+
+func (s *serverMock) ReportProgress(progress *worker.Progress) error {
+	args := s.Mock.Called(progress)
+	return args.Error(0)
+}
+
+func (s *serverMock) ReportFailure(failure *worker.Failure) error {
+	args := s.Mock.Called(failure)
+	return args.Error(0)
+}
+
+func (s *serverMock) JobStatus(id string) (*JobStatus, error) {
+	args := s.Mock.Called(id)
+	status, _ := args.Get(0).(*JobStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) TriggerBatch(requests []BatchRequest) (*BatchResult, error) {
+	args := s.Mock.Called(requests)
+	result, _ := args.Get(0).(*BatchResult)
+	return result, args.Error(1)
+}
+
+func (s *serverMock) BatchStatus(id string) (*BatchStatus, error) {
+	args := s.Mock.Called(id)
+	status, _ := args.Get(0).(*BatchStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) CancelJob(id string) (*JobStatus, error) {
+	args := s.Mock.Called(id)
+	status, _ := args.Get(0).(*JobStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) RetryJob(id string) (*JobStatus, error) {
+	args := s.Mock.Called(id)
+	status, _ := args.Get(0).(*JobStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) Workers() ([]WorkerStatus, error) {
+	args := s.Mock.Called()
+	workers, _ := args.Get(0).([]WorkerStatus)
+	return workers, args.Error(1)
+}
+
+func (s *serverMock) ListJobs(filter JobFilter) (*JobList, error) {
+	args := s.Mock.Called(filter)
+	list, _ := args.Get(0).(*JobList)
+	return list, args.Error(1)
+}
+
+func (s *serverMock) SubscribeEvents(id string) (*JobStatus, <-chan Event, func(), error) {
+	args := s.Mock.Called(id)
+	status, _ := args.Get(0).(*JobStatus)
+	return status, nil, func() {}, args.Error(1)
+}
+
+func (s *serverMock) TriggerWork(request EncodeVideoRequest) (*JobStatus, error) {
+	args := s.Mock.Called(request)
+	status, _ := args.Get(0).(*JobStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) InitiateUpload(result *worker.Result, length int64) (*worker.UploadStatus, error) {
+	args := s.Mock.Called(result, length)
+	status, _ := args.Get(0).(*worker.UploadStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) UploadPart(id string, offset int64, src io.Reader) (*worker.UploadStatus, error) {
+	args := s.Mock.Called(id, offset)
+	status, _ := args.Get(0).(*worker.UploadStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) UploadStatus(id string) (*worker.UploadStatus, error) {
+	args := s.Mock.Called(id)
+	status, _ := args.Get(0).(*worker.UploadStatus)
+	return status, args.Error(1)
+}
+
+func (s *serverMock) CompleteUpload(id string) error {
+	args := s.Mock.Called(id)
+	return args.Error(0)
+}
+
+func (s *serverMock) Offer(ctx context.Context, workerID string) (*worker.Offer, error) {
+	args := s.Mock.Called(ctx, workerID)
+	offer, _ := args.Get(0).(*worker.Offer)
+	return offer, args.Error(1)
+}
+
+func (s *serverMock) StreamOffer(jobID string, tileNum int, lease string) (*worker.Job, error) {
+	args := s.Mock.Called(jobID, tileNum, lease)
+	job, _ := args.Get(0).(*worker.Job)
+	return job, args.Error(1)
 }
 
-func (s *serverMock) TriggerWork(request EncodeVideoRequest) error {
-	panic("implement me")
+func (s *serverMock) RejectOffer(jobID string, tileNum int, lease string) error {
+	args := s.Mock.Called(jobID, tileNum, lease)
+	return args.Error(0)
 }
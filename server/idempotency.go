@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+var (
+	// ErrInvalidJobID is returned when the job id chosen by the client can't be used
+	ErrInvalidJobID = errors.New("job id must be 1-64 letters, digits, '-' or '_'")
+
+	// ErrIdempotencyMismatch is returned when the repeated request differs from the request which created the job
+	ErrIdempotencyMismatch = errors.New("request differs from the request which created the job")
+
+	// ErrRequestInProgress is returned when the repeated request arrives while the first one creates the job
+	ErrRequestInProgress = errors.New("request with the same idempotency key is in progress")
+)
+
+// defaultIdempotencyKeyTTL is a time the idempotency keys are kept
+const defaultIdempotencyKeyTTL = 24 * time.Hour
+
+// jobIDPattern is a job id chosen by the client, it's a part of the result keys in the store
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// idempotencyKey scopes the key of the request to its submitter
+func idempotencyKey(request EncodeVideoRequest) string {
+	return request.Submitter + "/" + request.IdempotencyKey
+}
+
+// existingJob returns the status of the job created by the first of the repeated requests
+func (s *Server) existingJob(id string, request EncodeVideoRequest) (*JobStatus, error) {
+	status, err := s.jobs.get(id)
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, ErrRequestInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !sameRequest(status.Request, request) {
+		return nil, fmt.Errorf("%w: job %s", ErrIdempotencyMismatch, id)
+	}
+	return &status, nil
+}
+
+// releaseKey unbinds the key of the request which failed to create the job, so the retry creates it
+func (s *Server) releaseKey(request EncodeVideoRequest, id string) {
+	if err := s.jobs.store.ReleaseKey(idempotencyKey(request), id); err != nil {
+		s.log.Error("idempotency key can't be released", slog.String(logging.JobIDKey, id), logging.Err(err))
+	}
+}
+
+// sameRequest compares the requests ignoring the idempotency key, it's not kept with the job
+func sameRequest(a, b EncodeVideoRequest) bool {
+	a.IdempotencyKey, b.IdempotencyKey = "", ""
+	a.BatchID, b.BatchID = "", ""
+	return a == b
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_TriggerWorkIdempotencyKey(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}, MaxQueuedTiles: 6, IdempotencyKeyTTL: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	req := EncodeVideoRequest{Tiles: 4, Width: 20, Height: 20, FilePath: "/videos/video.mp4", IdempotencyKey: "a1b2"}
+	first, err := s.TriggerWork(req)
+	require.NoError(t, err)
+
+	// the retry returns the existing job without enqueuing its tiles again
+	repeated, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, repeated.ID)
+	require.Equal(t, 4, s.QueueDepth())
+
+	changed := req
+	changed.Tiles = 1
+	_, err = s.TriggerWork(changed)
+	require.ErrorIs(t, err, ErrIdempotencyMismatch)
+
+	// the key is scoped to the submitter, the rejected request doesn't keep the key
+	other := req
+	other.Submitter = "team-b"
+	_, err = s.TriggerWork(other)
+	var full *QueueFullError
+	require.ErrorAs(t, err, &full)
+	other.Tiles = 1
+	created, err := s.TriggerWork(other)
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, created.ID)
+
+	// the key is forgotten after the retention window
+	time.Sleep(60 * time.Millisecond)
+	for range 2 {
+		_, err := s.queue.Pop(t.Context())
+		require.NoError(t, err)
+	}
+	retried, err := s.TriggerWork(changed)
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, retried.ID)
+}
+
+func TestServer_TriggerWorkJobID(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	req := EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4", JobID: "nightly-2024-01-02"}
+	first, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	require.Equal(t, "nightly-2024-01-02", first.ID)
+
+	repeated, err := s.TriggerWork(req)
+	require.NoError(t, err)
+	require.Equal(t, first.CreatedAt, repeated.CreatedAt)
+	require.Equal(t, 1, s.QueueDepth())
+
+	req.Priority = 10
+	_, err = s.TriggerWork(req)
+	require.ErrorIs(t, err, ErrIdempotencyMismatch)
+
+	req.JobID = "../etc"
+	_, err = s.TriggerWork(req)
+	require.ErrorIs(t, err, ErrInvalidJobID)
+}
+
+func TestServer_TriggerWorkInProgress(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the first request has reserved the key and hasn't created the job yet
+	req := EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4", IdempotencyKey: "a1b2"}
+	_, err = s.jobs.store.ReserveKey(idempotencyKey(req), "1d2f", time.Now(), time.Minute)
+	require.NoError(t, err)
+
+	_, err = s.TriggerWork(req)
+	require.ErrorIs(t, err, ErrRequestInProgress)
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/worker"
+)
+
+var (
+	// ErrJobNotFound is returned when there is no job with the requested id
+	ErrJobNotFound = errors.New("job is not found")
+)
+
+// JobState represents a state of the job or the tile
+type JobState string
+
+const (
+	// StateQueued is set when the job is waiting for the dispatch
+	StateQueued JobState = "queued"
+	// StateRunning is set when the job is dispatched to the worker
+	StateRunning JobState = "running"
+	// StateCompleted is set when the result is stored
+	StateCompleted JobState = "completed"
+	// StateFailed is set when the tile can't be streamed or stored,
+	// a job is failed when all its tiles are done and at least one of them is failed
+	StateFailed JobState = "failed"
+)
+
+// TileStatus represents the current state of the tile
+type TileStatus struct {
+	Num      int           `json:"num"`
+	Name     string        `json:"name"`
+	State    JobState      `json:"state"`
+	Error    string        `json:"error,omitempty"`
+	Progress *TileProgress `json:"progress,omitempty"`
+	// Worker is an identity of the worker the tile is dispatched to
+	Worker string `json:"worker,omitempty"`
+	// Key is a key of the tile content, the results are cached by it
+	Key string `json:"key,omitempty"`
+	// Cached is set when the result is copied from the tile encoded before, the tile is not dispatched
+	Cached bool `json:"cached,omitempty"`
+}
+
+// TileProgress is the latest encoding progress reported by the worker
+type TileProgress struct {
+	Frame int64   `json:"frame"`
+	FPS   float64 `json:"fps"`
+	Speed float64 `json:"speed"`
+	// OutTime is a timestamp of the encoded output in seconds
+	OutTime float64 `json:"outTime"`
+	// Percent and ETA in seconds are known when the source duration is probed
+	Percent float64 `json:"percent,omitempty"`
+	ETA     float64 `json:"eta,omitempty"`
+}
+
+// JobStatus is a snapshot of the encode request processing
+type JobStatus struct {
+	ID    string   `json:"id"`
+	State JobState `json:"state"`
+	// Percent is an average progress of the tiles, known when the source duration is probed
+	Percent float64            `json:"percent"`
+	Request EncodeVideoRequest `json:"request"`
+	// Duration of the source in seconds, zero when it's unknown
+	Duration float64      `json:"duration,omitempty"`
+	Tiles    []TileStatus `json:"tiles"`
+	// Cancelled is set when the job is failed by CancelJob
+	Cancelled bool `json:"cancelled,omitempty"`
+	// Retries is an amount of RetryJob calls
+	Retries   int       `json:"retries,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (j *JobStatus) done() bool {
+	return j.State == StateCompleted || j.State == StateFailed
+}
+
+// jobRegistry keeps track of the jobs and its tiles in the job store
+type jobRegistry struct {
+	store JobStore
+	now   func() time.Time
+	log   *slog.Logger
+}
+
+func newJobRegistry(store JobStore) *jobRegistry {
+	return &jobRegistry{
+		store: store,
+		now:   time.Now,
+		log:   slog.Default(),
+	}
+}
+
+// add registers a new queued job, duration of the source is used to calculate the progress
+func (r *jobRegistry) add(id string, req EncodeVideoRequest, tiles []TileJob, duration time.Duration) (JobStatus, error) {
+	now := r.now()
+	job := JobStatus{
+		ID:        id,
+		State:     StateQueued,
+		Request:   req,
+		Duration:  duration.Seconds(),
+		Tiles:     make([]TileStatus, 0, len(tiles)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, tile := range tiles {
+		job.Tiles = append(job.Tiles, TileStatus{
+			Num:   tile.TileNum,
+			Name:  generateTileName(tile.File, tile.TileNum),
+			State: StateQueued,
+		})
+	}
+	if err := r.store.CreateJob(JobRecord{Status: job}); err != nil {
+		return JobStatus{}, fmt.Errorf("job can't be saved: %w", err)
+	}
+	return job.snapshot(), nil
+}
+
+// remove drops the job from the registry
+func (r *jobRegistry) remove(id string) {
+	if err := r.store.DeleteJob(id); err != nil {
+		r.log.Error("job can't be removed", slog.String(logging.JobIDKey, id), logging.Err(err))
+	}
+}
+
+// get returns a snapshot of the job
+func (r *jobRegistry) get(id string) (JobStatus, error) {
+	rec, err := r.store.LoadJob(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return rec.Status.snapshot(), nil
+}
+
+// runningTile returns the tile dispatched to a worker, ErrTileNotLeased is returned when it's not running
+func (r *jobRegistry) runningTile(id string, tileNum int) (TileStatus, error) {
+	rec, err := r.store.LoadJob(id)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return TileStatus{}, err
+	}
+	job := rec.Status
+	if err != nil || tileNum < 0 || tileNum >= len(job.Tiles) || job.Tiles[tileNum].State != StateRunning {
+		return TileStatus{}, ErrTileNotLeased
+	}
+	return job.Tiles[tileNum], nil
+}
+
+// tileRequeued marks the running tile as queued again
+func (r *jobRegistry) tileRequeued(id string, tileNum int) []Event {
+	return r.updateTile(id, tileNum, StateQueued, nil, "", 0)
+}
+
+// tileDispatched marks the tile as running on the worker
+func (r *jobRegistry) tileDispatched(id string, tileNum int, workerID string) []Event {
+	return r.updateTile(id, tileNum, StateRunning, nil, workerID, 0)
+}
+
+// tileFinished marks the tile as completed or failed when err is set
+func (r *jobRegistry) tileFinished(id string, tileNum int, err error) []Event {
+	if err != nil {
+		return r.updateTile(id, tileNum, StateFailed, err, "", 0)
+	}
+	return r.updateTile(id, tileNum, StateCompleted, nil, "", 0)
+}
+
+// tileStored marks the tile as completed with the size of its stored result
+func (r *jobRegistry) tileStored(id string, tileNum int, size int64) []Event {
+	return r.updateTile(id, tileNum, StateCompleted, nil, "", size)
+}
+
+// tilesCached sets the content keys of the tiles and completes the queued ones which results are copied from the cache
+func (r *jobRegistry) tilesCached(id string, keys []string, cached []bool) []Event {
+	var events []Event
+	err := updateJob(r.store, id, func(rec *JobRecord) error {
+		events = nil
+		job := &rec.Status
+		if job.done() || len(keys) != len(job.Tiles) {
+			return errNoChange
+		}
+		now := r.now()
+		for i := range job.Tiles {
+			tile := &job.Tiles[i]
+			tile.Key = keys[i]
+			// the tile dispatched meanwhile is encoded again
+			if cached[i] && tile.State == StateQueued {
+				tile.Cached = true
+				events = append(events, job.updateTile(tile, StateCompleted, nil, "", now)...)
+			}
+		}
+		events = withCallback(events, job.Request.CallbackURL)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		r.log.Error("tile keys can't be saved", slog.String(logging.JobIDKey, id), logging.Err(err))
+		return nil
+	}
+	return events
+}
+
+// tileProgress updates the progress of the running tile
+func (r *jobRegistry) tileProgress(id string, tileNum int, p *worker.Progress) ([]Event, error) {
+	var events []Event
+	err := updateJob(r.store, id, func(rec *JobRecord) error {
+		events = nil
+		job := &rec.Status
+		if tileNum < 0 || tileNum >= len(job.Tiles) {
+			return ErrJobNotFound
+		}
+		tile := &job.Tiles[tileNum]
+		if tile.State != StateRunning {
+			return errNoChange
+		}
+
+		progress := &TileProgress{
+			Frame:   p.Frame,
+			FPS:     p.FPS,
+			Speed:   p.Speed,
+			OutTime: p.OutTime.Seconds(),
+		}
+		if job.Duration > 0 {
+			progress.Percent = math.Min(100, progress.OutTime/job.Duration*100)
+			if p.Speed > 0 {
+				progress.ETA = math.Max(0, job.Duration-progress.OutTime) / p.Speed
+			}
+		}
+		tile.Progress = progress
+
+		now := r.now()
+		job.UpdatedAt = now
+		events = withCallback([]Event{newTileEvent(EventTileProgress, job.ID, *tile, now)}, job.Request.CallbackURL)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// updateTile changes the state of the tile and returns the events caused by the change,
+// the worker of the tile is kept when workerID is empty, size of the result is recorded in the attempt
+func (r *jobRegistry) updateTile(id string, tileNum int, state JobState, err error, workerID string, size int64) []Event {
+	var events []Event
+	updateErr := updateJob(r.store, id, func(rec *JobRecord) error {
+		events = nil
+		job := &rec.Status
+		if tileNum < 0 || tileNum >= len(job.Tiles) || job.done() {
+			return errNoChange
+		}
+		tile := &job.Tiles[tileNum]
+		if tile.State == StateCompleted || tile.State == StateFailed {
+			return errNoChange
+		}
+		now := r.now()
+		events = withCallback(job.updateTile(tile, state, err, workerID, now), job.Request.CallbackURL)
+		rec.recordAttempt(tile, size, now)
+		return nil
+	})
+	if updateErr != nil && !errors.Is(updateErr, ErrJobNotFound) {
+		r.log.Error("tile state can't be saved", logging.Job(id, tileNum),
+			slog.String("state", string(state)), logging.Err(updateErr))
+		return nil
+	}
+	return events
+}
+
+// recordAttempt starts the attempt of the dispatched tile or finishes the running one
+func (r *JobRecord) recordAttempt(tile *TileStatus, size int64, now time.Time) {
+	if tile.State == StateRunning {
+		r.Attempts = append(r.Attempts, TileAttempt{
+			TileNum:   tile.Num,
+			Worker:    tile.Worker,
+			State:     StateRunning,
+			StartedAt: now,
+		})
+		return
+	}
+	for i := len(r.Attempts) - 1; i >= 0; i-- {
+		attempt := &r.Attempts[i]
+		if attempt.TileNum != tile.Num {
+			continue
+		}
+		if attempt.State == StateRunning {
+			attempt.State = tile.State
+			attempt.FinishedAt = now
+			attempt.Bytes = size
+			if tile.State == StateFailed {
+				attempt.Error = tile.Error
+			}
+		}
+		return
+	}
+}
+
+// updateTile changes the state of the tile of the job and returns the events caused by the change
+func (j *JobStatus) updateTile(tile *TileStatus, state JobState, err error, workerID string, now time.Time) []Event {
+	tile.State = state
+	if err != nil {
+		tile.Error = err.Error()
+	}
+	if workerID != "" {
+		tile.Worker = workerID
+	}
+	if state == StateCompleted && tile.Progress != nil {
+		tile.Progress.Percent = 100
+		tile.Progress.ETA = 0
+	}
+	j.UpdatedAt = now
+
+	var events []Event
+	switch state {
+	case StateQueued:
+		tile.Worker = ""
+		tile.Progress = nil
+		return []Event{newTileEvent(EventTileRequeued, j.ID, *tile, now)}
+	case StateRunning:
+		if j.State == StateQueued {
+			j.State = StateRunning
+		}
+		return []Event{newTileEvent(EventTileDispatched, j.ID, *tile, now)}
+	case StateCompleted:
+		events = append(events, newTileEvent(EventTileCompleted, j.ID, *tile, now))
+	case StateFailed:
+		events = append(events, newTileEvent(EventTileFailed, j.ID, *tile, now))
+	}
+
+	if jobState, finished := j.result(); finished {
+		j.State = jobState
+		eventType := EventJobCompleted
+		if jobState == StateFailed {
+			eventType = EventJobFailed
+		}
+		events = append(events, newJobEvent(eventType, j.snapshot(), now))
+	}
+
+	return events
+}
+
+// result returns the final state of the job when all its tiles are done
+func (j *JobStatus) result() (JobState, bool) {
+	state := StateCompleted
+	for _, tile := range j.Tiles {
+		switch tile.State {
+		case StateCompleted:
+		case StateFailed:
+			state = StateFailed
+		default:
+			return "", false
+		}
+	}
+	return state, true
+}
+
+// snapshot copies the job and calculates its progress
+func (j *JobStatus) snapshot() JobStatus {
+	s := *j
+	s.Tiles = make([]TileStatus, len(j.Tiles))
+	var percent float64
+	for i, tile := range j.Tiles {
+		if tile.Progress != nil {
+			progress := *tile.Progress
+			tile.Progress = &progress
+		}
+		s.Tiles[i] = tile
+
+		switch {
+		case tile.State == StateCompleted:
+			percent += 100
+		case tile.Progress != nil:
+			percent += tile.Progress.Percent
+		}
+	}
+	if len(j.Tiles) > 0 {
+		s.Percent = percent / float64(len(j.Tiles))
+	}
+	return s
+}
+
+// newJobID generates a random job id
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestJobRegistry_Progress(t *testing.T) {
+	r := newJobRegistry(newMemoryJobStore())
+	r.add("1d2f", EncodeVideoRequest{}, []TileJob{{TileNum: 0, File: "v.mp4"}, {TileNum: 1, File: "v.mp4"}}, 10*time.Second)
+
+	// progress of the queued tile is ignored
+	events, err := r.tileProgress("1d2f", 0, &worker.Progress{OutTime: time.Second})
+	require.NoError(t, err)
+	require.Nil(t, events)
+
+	r.tileDispatched("1d2f", 0, "worker-1")
+	events, err = r.tileProgress("1d2f", 0, &worker.Progress{Frame: 96, FPS: 48, OutTime: 4 * time.Second, Speed: 2})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, EventTileProgress, events[0].Type)
+	require.Equal(t, &TileProgress{Frame: 96, FPS: 48, Speed: 2, OutTime: 4, Percent: 40, ETA: 3}, events[0].Tile.Progress)
+
+	status, err := r.get("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, float64(20), status.Percent)
+
+	r.tileFinished("1d2f", 0, nil)
+	status, err = r.get("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, float64(50), status.Percent)
+	require.Equal(t, float64(100), status.Tiles[0].Progress.Percent)
+
+	_, err = r.tileProgress("unknown", 0, &worker.Progress{})
+	require.Equal(t, ErrJobNotFound, err)
+	_, err = r.tileProgress("1d2f", 2, &worker.Progress{})
+	require.Equal(t, ErrJobNotFound, err)
+}
+
+func TestJobRegistry_Attempts(t *testing.T) {
+	store := newMemoryJobStore()
+	r := newJobRegistry(store)
+	r.add("1d2f", EncodeVideoRequest{}, []TileJob{{TileNum: 0, File: "v.mp4"}, {TileNum: 1, File: "v.mp4"}}, 0)
+
+	started := time.Unix(1600000000, 0).UTC()
+	r.now = func() time.Time { return started }
+	r.tileDispatched("1d2f", 0, "worker-1")
+	r.tileDispatched("1d2f", 1, "worker-2")
+	finished := started.Add(time.Minute)
+	r.now = func() time.Time { return finished }
+	r.tileRequeued("1d2f", 0)
+	r.tileDispatched("1d2f", 0, "worker-2")
+	r.tileFinished("1d2f", 1, errors.New("disk is full"))
+
+	rec, err := store.LoadJob("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, []TileAttempt{
+		{TileNum: 0, Worker: "worker-1", State: StateQueued, StartedAt: started, FinishedAt: finished},
+		{TileNum: 1, Worker: "worker-2", State: StateFailed, Error: "disk is full", StartedAt: started, FinishedAt: finished},
+		{TileNum: 0, Worker: "worker-2", State: StateRunning, StartedAt: finished},
+	}, rec.Attempts)
+}
+
+func TestServer_ReportProgress(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+
+	prober := &proberMock{}
+	prober.On("Duration", "/videos/video.mp4").Return(20*time.Second, nil)
+
+	s, err := New(Config{Store: &store, TileStreamer: prober})
+	require.NoError(t, err)
+	defer s.Close()
+
+	status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	require.Equal(t, float64(20), status.Duration)
+
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	// the progress without the lease is rejected
+	err = s.ReportProgress(&worker.Progress{JobID: job.JobID, TileNum: job.TileNum, OutTime: 5 * time.Second, Speed: 1})
+	require.ErrorIs(t, err, ErrTileNotLeased)
+	require.NoError(t, s.ReportProgress(&worker.Progress{JobID: job.JobID, TileNum: job.TileNum, Lease: job.Lease, OutTime: 5 * time.Second, Speed: 1}))
+
+	status, err = s.JobStatus(job.JobID)
+	require.NoError(t, err)
+	require.Equal(t, float64(25), status.Percent)
+	require.Equal(t, float64(15), status.Tiles[0].Progress.ETA)
+}
+
+func TestServer_ReportFailure(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+
+	failure := &worker.Failure{JobID: job.JobID, TileNum: job.TileNum, Lease: "stale", Error: "exit status 1"}
+	require.ErrorIs(t, s.ReportFailure(failure), ErrLeaseInvalid)
+
+	failure.Lease = job.Lease
+	require.NoError(t, s.ReportFailure(failure))
+	status, err := s.JobStatus(job.JobID)
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, status.State)
+	require.Equal(t, "tile can't be encoded: exit status 1", status.Tiles[0].Error)
+
+	// the lease is dropped, the tile isn't requeued when it would expire
+	require.ErrorIs(t, s.ReportFailure(failure), ErrLeaseInvalid)
+	s.leases.now = func() time.Time { return time.Now().Add(time.Hour) }
+	s.expireLeases()
+	require.Zero(t, s.QueueDepth())
+}
+
+type proberMock struct {
+	streamerMock
+}
+
+func (p *proberMock) Duration(path string) (time.Duration, error) {
+	args := p.Mock.Called(path)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
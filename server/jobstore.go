@@ -0,0 +1,291 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrVersionConflict is returned by JobStore.UpdateJob when the record is changed since it's loaded
+	ErrVersionConflict = errors.New("job record is changed concurrently")
+
+	// ErrJobExists is returned by JobStore.CreateJob when there is a job with the same id
+	ErrJobExists = errors.New("job already exists")
+
+	// errNoChange skips the update of the record
+	errNoChange = errors.New("job record is not changed")
+)
+
+// defaultJobRetention is a time the finished jobs are kept in memory
+const defaultJobRetention = 24 * time.Hour
+
+// JobRecord is the state of the job shared by the server replicas
+type JobRecord struct {
+	Status JobStatus `json:"status"`
+	// Leases are the current leases of the dispatched tiles by the tile number
+	Leases map[int]*Lease `json:"leases,omitempty"`
+	// Attempts are the dispatches of the tiles in the dispatch order
+	Attempts []TileAttempt `json:"attempts,omitempty"`
+	// Version is incremented on each update, the record is saved only when it's unchanged since the load
+	Version int64 `json:"-"`
+}
+
+// Lease is the right of the worker to upload the result of the dispatched tile
+type Lease struct {
+	Token   string    `json:"token"`
+	Job     TileJob   `json:"job"`
+	Expires time.Time `json:"expires"`
+	// Claimed is set when the upload is started, so the concurrent duplicate is rejected,
+	// the upload renews the claimed lease while the result is received
+	Claimed bool `json:"claimed,omitempty"`
+}
+
+// TileAttempt is a dispatch of the tile to a worker
+type TileAttempt struct {
+	TileNum int    `json:"tileNum"`
+	Worker  string `json:"worker"`
+	// State is running until the attempt is finished, it's queued when the tile is dispatched again
+	State      JobState  `json:"state"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+	// Bytes is a size of the stored result
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// LeaseExpiry returns the earliest expiry of the leases
+func (r *JobRecord) LeaseExpiry() (time.Time, bool) {
+	var expiry time.Time
+	for _, l := range r.Leases {
+		if expiry.IsZero() || l.Expires.Before(expiry) {
+			expiry = l.Expires
+		}
+	}
+	return expiry, !expiry.IsZero()
+}
+
+// copy returns a deep copy of the record
+func (r *JobRecord) copy() JobRecord {
+	c := *r
+	c.Status.Tiles = make([]TileStatus, len(r.Status.Tiles))
+	for i, tile := range r.Status.Tiles {
+		if tile.Progress != nil {
+			progress := *tile.Progress
+			tile.Progress = &progress
+		}
+		c.Status.Tiles[i] = tile
+	}
+	c.Attempts = slices.Clone(r.Attempts)
+	if r.Leases != nil {
+		c.Leases = make(map[int]*Lease, len(r.Leases))
+		for num, l := range r.Leases {
+			lease := *l
+			c.Leases[num] = &lease
+		}
+	}
+	return c
+}
+
+// JobStore keeps the job records, the server replicas sharing the store change them with compare-and-swap,
+// so any replica can dispatch the tiles and accept the results of any job
+type JobStore interface {
+	// CreateJob saves a new job record, ErrJobExists is returned when the id is taken
+	CreateJob(rec JobRecord) error
+	// LoadJob returns the job record, ErrJobNotFound is returned when there is no such job
+	LoadJob(id string) (JobRecord, error)
+	// UpdateJob saves the record when its version is unchanged since the load and increments the version,
+	// ErrVersionConflict is returned otherwise
+	UpdateJob(rec *JobRecord) error
+	// DeleteJob drops the job record
+	DeleteJob(id string) error
+	// ExpiredLeases returns the ids of the jobs which have leases expired at now
+	ExpiredLeases(now time.Time) ([]string, error)
+	// ListJobs returns the records of the jobs selected by the filter, the latest jobs first
+	ListJobs(filter JobFilter) ([]JobRecord, error)
+	// SummarizeJobs returns the statistics of all the jobs selected by the filter ignoring its cursor and limit
+	SummarizeJobs(filter JobFilter) (JobStats, error)
+	// ReserveKey binds the idempotency key to the job id for ttl unless the key is bound and unexpired at now,
+	// the id of the job bound to the key is returned
+	ReserveKey(key, jobID string, now time.Time, ttl time.Duration) (string, error)
+	// ReleaseKey unbinds the key from the job id
+	ReleaseKey(key, jobID string) error
+}
+
+// updateJob applies fn to the record until it's saved without a conflict, fn returns errNoChange to skip the update
+func updateJob(store JobStore, id string, fn func(rec *JobRecord) error) error {
+	for {
+		rec, err := store.LoadJob(id)
+		if err != nil {
+			return err
+		}
+		if err := fn(&rec); err != nil {
+			if errors.Is(err, errNoChange) {
+				return nil
+			}
+			return err
+		}
+		if err := store.UpdateJob(&rec); !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+	}
+}
+
+// memoryJobStore keeps the records of a single server
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*JobRecord
+	keys map[string]boundKey
+	// retention is a time the finished jobs are kept since their last update, zero keeps them forever
+	retention time.Duration
+	now       func() time.Time
+}
+
+// boundKey is the job of the idempotency key
+type boundKey struct {
+	jobID   string
+	expires time.Time
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{
+		jobs: make(map[string]*JobRecord),
+		keys: make(map[string]boundKey),
+		now:  time.Now,
+	}
+}
+
+func (m *memoryJobStore) CreateJob(rec JobRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dropFinished()
+	if _, ok := m.jobs[rec.Status.ID]; ok {
+		return ErrJobExists
+	}
+	rec = rec.copy()
+	rec.Version = 1
+	m.jobs[rec.Status.ID] = &rec
+	return nil
+}
+
+// dropFinished drops the jobs finished longer than the retention ago, it's called with m.mu held
+func (m *memoryJobStore) dropFinished() {
+	if m.retention <= 0 {
+		return
+	}
+	before := m.now().Add(-m.retention)
+	for id, rec := range m.jobs {
+		if rec.Status.done() && rec.Status.UpdatedAt.Before(before) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (m *memoryJobStore) LoadJob(id string) (JobRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.jobs[id]
+	if !ok {
+		return JobRecord{}, ErrJobNotFound
+	}
+	return rec.copy(), nil
+}
+
+func (m *memoryJobStore) UpdateJob(rec *JobRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.jobs[rec.Status.ID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if current.Version != rec.Version {
+		return ErrVersionConflict
+	}
+	rec.Version++
+	saved := rec.copy()
+	m.jobs[rec.Status.ID] = &saved
+	return nil
+}
+
+func (m *memoryJobStore) DeleteJob(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memoryJobStore) ExpiredLeases(now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for id, rec := range m.jobs {
+		if expiry, ok := rec.LeaseExpiry(); ok && now.After(expiry) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memoryJobStore) ListJobs(filter JobFilter) ([]JobRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var recs []JobRecord
+	for _, rec := range m.jobs {
+		if filter.Match(&rec.Status) && filter.After.before(&rec.Status) {
+			recs = append(recs, rec.copy())
+		}
+	}
+	slices.SortFunc(recs, func(a, b JobRecord) int {
+		return compareJobs(&a.Status, &b.Status)
+	})
+	if filter.Limit > 0 && len(recs) > filter.Limit {
+		recs = recs[:filter.Limit]
+	}
+	return recs, nil
+}
+
+func (m *memoryJobStore) SummarizeJobs(filter JobFilter) (JobStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats JobStats
+	for _, rec := range m.jobs {
+		if filter.Match(&rec.Status) {
+			stats.add(rec.stats())
+		}
+	}
+	return stats, nil
+}
+
+func (m *memoryJobStore) ReserveKey(key, jobID string, now time.Time, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, bound := range m.keys {
+		if !now.Before(bound.expires) {
+			delete(m.keys, k)
+		}
+	}
+	if bound, ok := m.keys[key]; ok {
+		return bound.jobID, nil
+	}
+	m.keys[key] = boundKey{jobID: jobID, expires: now.Add(ttl)}
+	return jobID, nil
+}
+
+func (m *memoryJobStore) ReleaseKey(key, jobID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if bound, ok := m.keys[key]; ok && bound.jobID == jobID {
+		delete(m.keys, key)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryJobStore_UpdateJob(t *testing.T) {
+	store := newMemoryJobStore()
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "1d2f", Tiles: []TileStatus{{Num: 0}}}}))
+
+	first, err := store.LoadJob("1d2f")
+	require.NoError(t, err)
+	second, err := store.LoadJob("1d2f")
+	require.NoError(t, err)
+
+	first.Status.Tiles[0].State = StateRunning
+	require.NoError(t, store.UpdateJob(&first))
+	second.Status.Tiles[0].State = StateFailed
+	require.ErrorIs(t, store.UpdateJob(&second), ErrVersionConflict)
+
+	// the loaded record is a copy
+	got, err := store.LoadJob("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, got.Status.Tiles[0].State)
+	require.Equal(t, first.Version, got.Version)
+}
+
+func TestMemoryJobStore_Retention(t *testing.T) {
+	store := newMemoryJobStore()
+	store.retention = time.Hour
+	now := time.Unix(1600000000, 0).UTC()
+	store.now = func() time.Time { return now }
+	for id, status := range map[string]JobStatus{
+		"1d2f": {State: StateCompleted, UpdatedAt: now.Add(-2 * time.Hour)},
+		"3e4f": {State: StateFailed, UpdatedAt: now.Add(-time.Minute)},
+		"5a6b": {State: StateRunning, UpdatedAt: now.Add(-2 * time.Hour)},
+	} {
+		status.ID = id
+		require.NoError(t, store.CreateJob(JobRecord{Status: status}))
+	}
+
+	// the jobs finished before the retention are dropped by the next job
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "7c8d", State: StateQueued, UpdatedAt: now}}))
+	_, err := store.LoadJob("1d2f")
+	require.ErrorIs(t, err, ErrJobNotFound)
+	for _, id := range []string{"3e4f", "5a6b", "7c8d"} {
+		_, err := store.LoadJob(id)
+		require.NoError(t, err, id)
+	}
+}
+
+func TestUpdateJob_Concurrent(t *testing.T) {
+	store := newMemoryJobStore()
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "1d2f"}}))
+
+	// the conflicting updates are retried, so none of them is lost
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, updateJob(store, "1d2f", func(rec *JobRecord) error {
+				rec.Status.Tiles = append(rec.Status.Tiles, TileStatus{})
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+
+	got, err := store.LoadJob("1d2f")
+	require.NoError(t, err)
+	require.Len(t, got.Status.Tiles, 50)
+	require.ErrorIs(t, updateJob(store, "3e4f", func(rec *JobRecord) error { return nil }), ErrJobNotFound)
+}
+
+func TestMemoryJobStore_ListJobs(t *testing.T) {
+	store := newMemoryJobStore()
+	created := time.Unix(1600000000, 0).UTC()
+	for i, state := range []JobState{StateCompleted, StateFailed, StateFailed, StateRunning} {
+		require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{
+			ID:        string(rune('a' + i)),
+			State:     state,
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+		}}))
+	}
+
+	ids := func(recs []JobRecord) []string {
+		var ids []string
+		for _, rec := range recs {
+			ids = append(ids, rec.Status.ID)
+		}
+		return ids
+	}
+	recs, err := store.ListJobs(JobFilter{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"d", "c", "b", "a"}, ids(recs))
+
+	recs, err = store.ListJobs(JobFilter{States: []JobState{StateFailed, StateCompleted}, Since: created.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b"}, ids(recs))
+
+	recs, err = store.ListJobs(JobFilter{States: []JobState{StateFailed}, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, ids(recs))
+}
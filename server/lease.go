@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+var (
+	// ErrLeaseInvalid happens when the lease token is missing, unknown, superseded by a newer dispatch
+	// or already used by another upload
+	ErrLeaseInvalid = fmt.Errorf("%w: lease is invalid", ErrTileNotLeased)
+	// ErrLeaseExpired happens when the lease token is not renewed in time, the tile is dispatched again
+	ErrLeaseExpired = fmt.Errorf("%w: lease is expired", ErrTileNotLeased)
+)
+
+// defaultLeaseTTL is a time the worker has to upload the result or report the progress
+const defaultLeaseTTL = 10 * time.Minute
+
+// leaseTable keeps a single current lease per tile in the job records, a dispatch of the tile supersedes
+// its previous lease. The leases are changed with compare-and-swap, so the replicas sharing the job store agree on them
+type leaseTable struct {
+	store JobStore
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+func newLeaseTable(store JobStore, ttl time.Duration) *leaseTable {
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	return &leaseTable{
+		store: store,
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+// issue creates the lease of the dispatched tile and returns its token
+func (t *leaseTable) issue(job TileJob) (string, error) {
+	token := newLeaseToken()
+	err := updateJob(t.store, job.JobID, func(rec *JobRecord) error {
+		if rec.Leases == nil {
+			rec.Leases = make(map[int]*Lease)
+		}
+		rec.Leases[job.TileNum] = &Lease{
+			Token:   token,
+			Job:     job,
+			Expires: t.now().Add(t.ttl),
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("lease can't be issued: %w", err)
+	}
+	return token, nil
+}
+
+// claim reserves the lease for the upload and returns its tile, only the first upload with the current unexpired token
+// succeeds. The claimed lease expires when the upload doesn't renew it for the TTL, the tile is dispatched again then
+func (t *leaseTable) claim(jobID string, tileNum int, token string) (TileJob, error) {
+	var job TileJob
+	err := t.update(jobID, tileNum, token, func(rec *JobRecord, l *Lease) error {
+		if l.Claimed {
+			return fmt.Errorf("%w: the result is already being uploaded", ErrLeaseInvalid)
+		}
+		l.Claimed = true
+		l.Expires = t.now().Add(t.ttl)
+		job = l.Job
+		return nil
+	})
+	return job, err
+}
+
+// renew extends the lease, the worker renews it reporting the progress and the upload while the result is received
+func (t *leaseTable) renew(jobID string, tileNum int, token string) error {
+	return t.update(jobID, tileNum, token, func(rec *JobRecord, l *Lease) error {
+		l.Expires = t.now().Add(t.ttl)
+		return nil
+	})
+}
+
+// release drops the lease after the upload, later uploads with its token are rejected
+func (t *leaseTable) release(jobID string, tileNum int, token string) error {
+	err := updateJob(t.store, jobID, func(rec *JobRecord) error {
+		if l, ok := rec.Leases[tileNum]; !ok || l.Token != token {
+			return errNoChange
+		}
+		delete(rec.Leases, tileNum)
+		return nil
+	})
+	if errors.Is(err, ErrJobNotFound) {
+		return nil
+	}
+	return err
+}
+
+// finish drops the claimed lease after the upload, ErrLeaseExpired is returned when the claim expired
+// before and the tile is dispatched again, the result of the upload is stale then
+func (t *leaseTable) finish(jobID string, tileNum int, token string) error {
+	err := updateJob(t.store, jobID, func(rec *JobRecord) error {
+		if l, ok := rec.Leases[tileNum]; !ok || l.Token != token || !l.Claimed {
+			return ErrLeaseExpired
+		}
+		delete(rec.Leases, tileNum)
+		return nil
+	})
+	if errors.Is(err, ErrJobNotFound) {
+		return ErrLeaseExpired
+	}
+	return err
+}
+
+// offered returns the tile of the current lease which upload is not started
+func (t *leaseTable) offered(jobID string, tileNum int, token string) (TileJob, error) {
+	rec, err := t.store.LoadJob(jobID)
+	if errors.Is(err, ErrJobNotFound) {
+		return TileJob{}, ErrLeaseInvalid
+	}
+	if err != nil {
+		return TileJob{}, err
+	}
+	l, err := t.get(&rec, tileNum, token)
+	if err != nil {
+		return TileJob{}, err
+	}
+	if l.Claimed {
+		return TileJob{}, fmt.Errorf("%w: the result is already being uploaded", ErrLeaseInvalid)
+	}
+	return l.Job, nil
+}
+
+// check returns the error of the lease when it's not the current unexpired lease of the tile
+func (t *leaseTable) check(jobID string, tileNum int, token string) error {
+	rec, err := t.store.LoadJob(jobID)
+	if errors.Is(err, ErrJobNotFound) {
+		return ErrLeaseInvalid
+	}
+	if err != nil {
+		return err
+	}
+	_, err = t.get(&rec, tileNum, token)
+	return err
+}
+
+// revoke drops the current lease which upload is not started and returns its tile
+func (t *leaseTable) revoke(jobID string, tileNum int, token string) (TileJob, error) {
+	var job TileJob
+	err := t.update(jobID, tileNum, token, func(rec *JobRecord, l *Lease) error {
+		if l.Claimed {
+			return fmt.Errorf("%w: the result is already being uploaded", ErrLeaseInvalid)
+		}
+		job = l.Job
+		delete(rec.Leases, tileNum)
+		return nil
+	})
+	return job, err
+}
+
+// expire drops the expired leases and returns their tiles, the uploads of the expired claims are discarded
+// when they finish. Each lease is dropped by a single replica
+func (t *leaseTable) expire() ([]TileJob, error) {
+	now := t.now()
+	ids, err := t.store.ExpiredLeases(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []TileJob
+	var errs []error
+	for _, id := range ids {
+		var expired []TileJob
+		err := updateJob(t.store, id, func(rec *JobRecord) error {
+			expired = nil
+			for num, l := range rec.Leases {
+				if now.After(l.Expires) {
+					delete(rec.Leases, num)
+					expired = append(expired, l.Job)
+				}
+			}
+			if len(expired) == 0 {
+				return errNoChange
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrJobNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		jobs = append(jobs, expired...)
+	}
+	return jobs, errors.Join(errs...)
+}
+
+// update applies fn to the current unexpired lease of the tile
+func (t *leaseTable) update(jobID string, tileNum int, token string, fn func(rec *JobRecord, l *Lease) error) error {
+	err := updateJob(t.store, jobID, func(rec *JobRecord) error {
+		l, err := t.get(rec, tileNum, token)
+		if err != nil {
+			return err
+		}
+		return fn(rec, l)
+	})
+	if errors.Is(err, ErrJobNotFound) {
+		return ErrLeaseInvalid
+	}
+	return err
+}
+
+func (t *leaseTable) get(rec *JobRecord, tileNum int, token string) (*Lease, error) {
+	l, ok := rec.Leases[tileNum]
+	if !ok || token == "" || l.Token != token {
+		return nil, ErrLeaseInvalid
+	}
+	if t.now().After(l.Expires) {
+		return nil, ErrLeaseExpired
+	}
+	return l, nil
+}
+
+// renewingReader renews the claimed lease while the result is received, the read fails when the lease is lost
+type renewingReader struct {
+	r     io.Reader
+	every time.Duration
+	now   func() time.Time
+	renew func() error
+	// renewed is when the lease was renewed last time
+	renewed time.Time
+}
+
+func (r *renewingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 && r.now().Sub(r.renewed) >= r.every {
+		if err := r.renew(); err != nil {
+			return n, err
+		}
+		r.renewed = r.now()
+	}
+	return n, err
+}
+
+func newLeaseToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// reapLeases dispatches the tiles of the expired leases again and drops the abandoned uploads
+// until the context is canceled
+func (s *Server) reapLeases(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireLeases()
+			if n := s.uploads.expire(s.leases.ttl); n > 0 {
+				s.log.Warn("abandoned uploads are dropped", slog.Int("uploads", n))
+			}
+		}
+	}
+}
+
+// expireLeases requeues the tiles of the expired leases, the tiles skip the queue limits as they were admitted
+func (s *Server) expireLeases() {
+	jobs, err := s.leases.expire()
+	if err != nil {
+		s.log.Error("leases can't be expired", logging.Err(err))
+	}
+	for _, job := range jobs {
+		if s.requeue(job) {
+			s.log.Warn("lease is expired, tile is requeued", logging.Job(job.JobID, job.TileNum))
+		}
+	}
+}
+
+// requeue queues the running tile again, false is returned when the tile is not running anymore
+func (s *Server) requeue(job TileJob) bool {
+	events := s.jobs.tileRequeued(job.JobID, job.TileNum)
+	if len(events) == 0 {
+		return false
+	}
+	if err := s.queue.Requeue(job); err != nil {
+		s.publish(s.jobs.tileFinished(job.JobID, job.TileNum, err)...)
+		return false
+	}
+	s.publish(events...)
+	return true
+}
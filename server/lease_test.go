@@ -0,0 +1,287 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestLeaseTable(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	store := newMemoryJobStore()
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "1d2f"}}))
+	leases := newLeaseTable(store, time.Minute)
+	leases.now = func() time.Time { return now }
+	job := TileJob{JobID: "1d2f", TileNum: 0}
+
+	first, err := leases.issue(job)
+	require.NoError(t, err)
+	second, err := leases.issue(job)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	// the lease of the previous dispatch is superseded
+	for _, tt := range []struct {
+		tileNum int
+		token   string
+		err     error
+	}{
+		{tileNum: 0, token: first, err: ErrLeaseInvalid},
+		{tileNum: 0, token: "", err: ErrLeaseInvalid},
+		{tileNum: 1, token: second, err: ErrTileNotLeased},
+	} {
+		_, err := leases.claim("1d2f", tt.tileNum, tt.token)
+		require.ErrorIs(t, err, tt.err)
+	}
+
+	// the progress renews the lease
+	now = now.Add(50 * time.Second)
+	require.NoError(t, leases.renew("1d2f", 0, second))
+	now = now.Add(50 * time.Second)
+	require.Empty(t, expireLeases(t, leases))
+
+	// the duplicate upload is rejected while the first one is in progress
+	claimed, err := leases.claim("1d2f", 0, second)
+	require.NoError(t, err)
+	require.Equal(t, job, claimed)
+	_, err = leases.claim("1d2f", 0, second)
+	require.ErrorIs(t, err, ErrLeaseInvalid)
+
+	// the upload renews the claimed lease
+	now = now.Add(50 * time.Second)
+	require.NoError(t, leases.renew("1d2f", 0, second))
+	now = now.Add(50 * time.Second)
+	require.Empty(t, expireLeases(t, leases))
+
+	// the token can't be reused after the upload
+	require.NoError(t, leases.finish("1d2f", 0, second))
+	_, err = leases.claim("1d2f", 0, second)
+	require.ErrorIs(t, err, ErrLeaseInvalid)
+}
+
+func TestLeaseTable_Expired(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	store := newMemoryJobStore()
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "1d2f"}}))
+	leases := newLeaseTable(store, time.Minute)
+	leases.now = func() time.Time { return now }
+	job := TileJob{JobID: "1d2f", TileNum: 0}
+
+	token, err := leases.issue(job)
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = leases.claim("1d2f", 0, token)
+	require.ErrorIs(t, err, ErrLeaseExpired)
+	require.ErrorIs(t, leases.renew("1d2f", 0, token), ErrLeaseExpired)
+	require.Equal(t, []TileJob{job}, expireLeases(t, leases))
+	_, err = leases.claim("1d2f", 0, token)
+	require.ErrorIs(t, err, ErrLeaseInvalid)
+}
+
+func TestLeaseTable_ExpiredClaim(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	store := newMemoryJobStore()
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "1d2f"}}))
+	leases := newLeaseTable(store, time.Minute)
+	leases.now = func() time.Time { return now }
+	job := TileJob{JobID: "1d2f", TileNum: 0}
+
+	token, err := leases.issue(job)
+	require.NoError(t, err)
+	_, err = leases.claim("1d2f", 0, token)
+	require.NoError(t, err)
+
+	// the stalled upload loses the lease, its result is discarded
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, []TileJob{job}, expireLeases(t, leases))
+	require.ErrorIs(t, leases.finish("1d2f", 0, token), ErrLeaseExpired)
+}
+
+func expireLeases(t *testing.T, leases *leaseTable) []TileJob {
+	jobs, err := leases.expire()
+	require.NoError(t, err)
+	return jobs
+}
+
+func TestServer_AcceptResultConcurrentDuplicate(t *testing.T) {
+	store := &blockingStore{started: make(chan struct{}), unblock: make(chan struct{})}
+	jobStore := newMemoryJobStore()
+	s := Server{
+		store:    store,
+		jobs:     newJobRegistry(jobStore),
+		webhooks: newWebhookNotifier(WebhookConfig{}),
+		events:   newEventBroker(),
+		leases:   newLeaseTable(jobStore, time.Minute),
+		log:      slog.Default(),
+	}
+	s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{{JobID: "1d2f", TileNum: 0, File: "video.mp4"}}, 0)
+	s.jobs.tileDispatched("1d2f", 0, "worker-1")
+	lease, err := s.leases.issue(TileJob{JobID: "1d2f", TileNum: 0})
+	require.NoError(t, err)
+
+	result := func() *worker.Result {
+		return &worker.Result{
+			JobID:    "1d2f",
+			TileNum:  0,
+			FileName: "video_tile_0.ts",
+			Lease:    lease,
+			Src:      strings.NewReader("encoded"),
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		require.NoError(t, s.AcceptResult(result()))
+	}()
+	<-store.started
+
+	// the retry of the same upload is rejected while the first one is written
+	require.ErrorIs(t, s.AcceptResult(result()), ErrLeaseInvalid)
+	close(store.unblock)
+	wg.Wait()
+
+	// and after it's completed
+	require.ErrorIs(t, s.AcceptResult(result()), ErrTileNotLeased)
+	require.Equal(t, 1, store.writes)
+}
+
+func TestServer_expireLeases(t *testing.T) {
+	jobStore := newMemoryJobStore()
+	s := Server{
+		queue:    newJobQueue(&fifoScheduler{}, queueLimits{total: 1}),
+		jobs:     newJobRegistry(jobStore),
+		webhooks: newWebhookNotifier(WebhookConfig{}),
+		events:   newEventBroker(),
+		leases:   newLeaseTable(jobStore, time.Minute),
+		log:      slog.Default(),
+	}
+	now := time.Unix(1600000000, 0)
+	s.leases.now = func() time.Time { return now }
+
+	job := TileJob{JobID: "1d2f", TileNum: 0, File: "video.mp4"}
+	s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{job}, 0)
+	s.jobs.tileDispatched("1d2f", 0, "worker-1")
+	token, err := s.leases.issue(job)
+	require.NoError(t, err)
+	// the requeued tile doesn't count against the queue limits
+	require.NoError(t, s.queue.Push(TileJob{JobID: "3e4f"}))
+
+	_, events, cancel, err := s.SubscribeEvents("1d2f")
+	require.NoError(t, err)
+	defer cancel()
+
+	now = now.Add(2 * time.Minute)
+	s.expireLeases()
+
+	require.Equal(t, EventTileRequeued, (<-events).Type)
+	status, err := s.JobStatus("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, TileStatus{Num: 0, Name: "video_tile_0", State: StateQueued}, status.Tiles[0])
+	require.Equal(t, 2, s.queue.Len())
+
+	// the late upload of the expired lease is discarded
+	err = s.AcceptResult(&worker.Result{JobID: "1d2f", TileNum: 0, FileName: "video_tile_0.ts", Lease: token})
+	require.ErrorIs(t, err, ErrTileNotLeased)
+}
+
+func TestServer_AcceptResultRequeue(t *testing.T) {
+	var store storeMock
+	store.On("WriteObject", "1d2f/video_tile_0.ts", mock.Anything).Return(errFake)
+	jobStore := newMemoryJobStore()
+	s := Server{
+		store:    &store,
+		queue:    newJobQueue(&fifoScheduler{}, queueLimits{}),
+		jobs:     newJobRegistry(jobStore),
+		webhooks: newWebhookNotifier(WebhookConfig{}),
+		events:   newEventBroker(),
+		leases:   newLeaseTable(jobStore, time.Minute),
+		log:      slog.Default(),
+	}
+	job := TileJob{JobID: "1d2f", TileNum: 0, File: "video.mp4"}
+	s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{job}, 0)
+	s.jobs.tileDispatched("1d2f", 0, "worker-1")
+	lease, err := s.leases.issue(job)
+	require.NoError(t, err)
+
+	// the tile which result can't be stored is encoded again
+	err = s.AcceptResult(&worker.Result{JobID: "1d2f", TileNum: 0, FileName: "video_tile_0.ts", Lease: lease, Src: strings.NewReader("encoded")})
+	require.ErrorIs(t, err, errFake)
+	status, err := s.JobStatus("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, status.Tiles[0].State)
+	require.Equal(t, 1, s.queue.Len())
+}
+
+func TestServer_AcceptResultStalled(t *testing.T) {
+	jobStore := newMemoryJobStore()
+	s := Server{
+		store:    &FSObjectStore{Path: t.TempDir()},
+		queue:    newJobQueue(&fifoScheduler{}, queueLimits{}),
+		jobs:     newJobRegistry(jobStore),
+		webhooks: newWebhookNotifier(WebhookConfig{}),
+		events:   newEventBroker(),
+		leases:   newLeaseTable(jobStore, time.Minute),
+		log:      slog.Default(),
+	}
+	now := time.Unix(1600000000, 0)
+	s.leases.now = func() time.Time { return now }
+	job := TileJob{JobID: "1d2f", TileNum: 0, File: "video.mp4"}
+	s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{job}, 0)
+	s.jobs.tileDispatched("1d2f", 0, "worker-1")
+	lease, err := s.leases.issue(job)
+	require.NoError(t, err)
+
+	// the upload stalls until its claim expires and the tile is requeued
+	src := &stallingReader{r: strings.NewReader("encoded"), stall: func() {
+		now = now.Add(2 * time.Minute)
+		s.expireLeases()
+	}}
+	err = s.AcceptResult(&worker.Result{JobID: "1d2f", TileNum: 0, FileName: "video_tile_0.ts", Lease: lease, Src: src})
+	require.ErrorIs(t, err, ErrLeaseExpired)
+	status, err := s.JobStatus("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, status.Tiles[0].State)
+	require.Equal(t, 1, s.queue.Len())
+}
+
+// stallingReader calls stall before the first read
+type stallingReader struct {
+	r       io.Reader
+	stall   func()
+	stalled bool
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if !r.stalled {
+		r.stalled = true
+		r.stall()
+	}
+	return r.r.Read(p)
+}
+
+type blockingStore struct {
+	started chan struct{}
+	unblock chan struct{}
+	writes  int
+}
+
+func (s *blockingStore) WriteObject(key string, src io.Reader) error {
+	s.writes++
+	close(s.started)
+	<-s.unblock
+	return nil
+}
+
+func (s *blockingStore) HasObject(key string) bool {
+	return true
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "encoder_server"
+
+// serverMetrics holds the server collectors, nil metrics are not collected
+type serverMetrics struct {
+	queueDepth      prometheus.GaugeFunc
+	activePolls     prometheus.Gauge
+	tilesDispatched prometheus.Counter
+	tilesCompleted  prometheus.Counter
+	tilesFailed     prometheus.Counter
+	dispatchWait    prometheus.Histogram
+	bytesStreamed   prometheus.Counter
+	bytesReceived   prometheus.Counter
+	resultWrite     prometheus.Histogram
+}
+
+func newServerMetrics(reg prometheus.Registerer, queueDepth func() float64) (*serverMetrics, error) {
+	if reg == nil {
+		return nil, nil
+	}
+
+	m := &serverMetrics{
+		queueDepth: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_depth",
+			Help:      "Amount of tiles waiting for the dispatch.",
+		}, queueDepth),
+		activePolls: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_long_polls",
+			Help:      "Amount of workers waiting for a job.",
+		}),
+		tilesDispatched: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tiles_dispatched_total",
+			Help:      "Amount of tiles dispatched to the workers.",
+		}),
+		tilesCompleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tiles_completed_total",
+			Help:      "Amount of tiles which results are stored.",
+		}),
+		tilesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tiles_failed_total",
+			Help:      "Amount of tiles failed to be streamed or stored.",
+		}),
+		dispatchWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "dispatch_wait_seconds",
+			Help:      "Time the tile spent in the queue before the dispatch.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 8),
+		}),
+		bytesStreamed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tile_bytes_streamed_total",
+			Help:      "Amount of raw tile bytes streamed to the workers.",
+		}),
+		bytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "result_bytes_received_total",
+			Help:      "Amount of encoded bytes received from the workers.",
+		}),
+		resultWrite: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "result_write_seconds",
+			Help:      "Time of receiving and writing the result to the store.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+		}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.queueDepth, m.activePolls, m.tilesDispatched, m.tilesCompleted, m.tilesFailed,
+		m.dispatchWait, m.bytesStreamed, m.bytesReceived, m.resultWrite,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// pollStarted tracks the active long poll, returned function is called when the poll is finished
+func (m *serverMetrics) pollStarted() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.activePolls.Inc()
+	return m.activePolls.Dec
+}
+
+func (m *serverMetrics) dispatched(enqueuedAt time.Time) {
+	if m == nil {
+		return
+	}
+	m.tilesDispatched.Inc()
+	if !enqueuedAt.IsZero() {
+		m.dispatchWait.Observe(time.Since(enqueuedAt).Seconds())
+	}
+}
+
+// observeEvent counts the completed and failed tiles
+func (m *serverMetrics) observeEvent(e Event) {
+	if m == nil {
+		return
+	}
+	switch e.Type {
+	case EventTileCompleted:
+		m.tilesCompleted.Inc()
+	case EventTileFailed:
+		m.tilesFailed.Inc()
+	}
+}
+
+func (m *serverMetrics) resultWritten(started time.Time) {
+	if m == nil {
+		return
+	}
+	m.resultWrite.Observe(time.Since(started).Seconds())
+}
+
+// countStreamed counts bytes read from the tile stream
+func (m *serverMetrics) countStreamed(r io.ReadCloser) io.ReadCloser {
+	if m == nil || r == nil {
+		return r
+	}
+	return &countingReadCloser{ReadCloser: r, counter: m.bytesStreamed}
+}
+
+// countReceived counts bytes read from the result
+func (m *serverMetrics) countReceived(r io.Reader) io.Reader {
+	if m == nil || r == nil {
+		return r
+	}
+	return &countingReadCloser{ReadCloser: io.NopCloser(r), counter: m.bytesReceived}
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
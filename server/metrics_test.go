@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	store.On("WriteObject", "1d2f/video_tile_0.ts", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		_, _ = io.Copy(io.Discard, args.Get(1).(io.Reader))
+	})
+
+	reg := prometheus.NewRegistry()
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}, MetricsRegisterer: reg})
+	require.NoError(t, err)
+	defer s.Close()
+	s.newID = func() string { return "1d2f" }
+
+	_, err = s.TriggerWork(EncodeVideoRequest{Tiles: 2, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	require.Equal(t, float64(2), testutil.ToFloat64(s.metrics.queueDepth))
+
+	for i := 0; i < 2; i++ {
+		job, err := s.Dispatch("worker-1")
+		require.NoError(t, err)
+		if job.TileNum == 1 {
+			require.NoError(t, s.ReportFailure(&worker.Failure{JobID: job.JobID, TileNum: job.TileNum, Lease: job.Lease, Error: "exit status 1"}))
+			continue
+		}
+		_ = s.AcceptResult(&worker.Result{
+			JobID:    job.JobID,
+			Lease:    job.Lease,
+			TileNum:  job.TileNum,
+			FileName: job.TileName + ".ts",
+			Src:      strings.NewReader("encoded"),
+		})
+	}
+
+	require.Equal(t, float64(0), testutil.ToFloat64(s.metrics.queueDepth))
+	require.Equal(t, float64(0), testutil.ToFloat64(s.metrics.activePolls))
+	require.Equal(t, float64(2), testutil.ToFloat64(s.metrics.tilesDispatched))
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.tilesCompleted))
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.tilesFailed))
+	require.Equal(t, float64(len("encoded")), testutil.ToFloat64(s.metrics.bytesReceived))
+	require.Equal(t, uint64(1), histogramCount(t, s.metrics.resultWrite))
+	require.Equal(t, uint64(2), histogramCount(t, s.metrics.dispatchWait))
+
+	count, err := testutil.GatherAndCount(reg)
+	require.NoError(t, err)
+	require.Equal(t, 9, count)
+}
+
+func histogramCount(t *testing.T, h prometheus.Histogram) uint64 {
+	var m dto.Metric
+	require.NoError(t, h.Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestNew_MetricsRegistered(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cfg := Config{Store: &storeMock{}, TileStreamer: &streamerMock{}, MetricsRegisterer: reg}
+
+	_, err := New(cfg)
+	require.NoError(t, err)
+
+	// the same registry can't be used twice
+	_, err = New(cfg)
+	require.Error(t, err)
+}
@@ -0,0 +1,279 @@
+// Package natsqueue is a server.Queue on top of a NATS JetStream work queue stream.
+// The servers sharing the stream and the consumer pull the tiles from it, each tile is delivered to one of them
+// and delivered again when the server doesn't ack it in time
+package natsqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"distributed-encoder/server"
+)
+
+const (
+	defaultStream   = "ENCODER_TILES"
+	defaultSubject  = "encoder.tiles"
+	defaultConsumer = "dispatch"
+
+	// fetchWait bounds a single pull request, Pop sends a new one until its context is done
+	fetchWait = 30 * time.Second
+	// requestTimeout bounds the JetStream API calls which don't have a context of their own
+	requestTimeout = 5 * time.Second
+	defaultAckWait = 30 * time.Second
+)
+
+// Config of the queue
+type Config struct {
+	// Conn is a connection to the NATS server with JetStream enabled, the queue doesn't close it
+	Conn *nats.Conn
+
+	// Stream is a name of the work queue stream, it's created when it doesn't exist, ENCODER_TILES is a default
+	Stream string
+	// Subject the tiles are published to, encoder.tiles is a default
+	Subject string
+	// Consumer is a durable consumer shared by the servers, dispatch is a default
+	Consumer string
+
+	// MaxQueuedTiles is a maximum amount of tiles in the stream, zero means no limit
+	MaxQueuedTiles int
+	// AckWait is a time the server has to lease the popped tile, the tile is delivered again after it,
+	// 30 seconds is a default
+	AckWait time.Duration
+}
+
+// Queue carries the tile jobs in a JetStream stream, the tiles are popped in the publish order,
+// the scheduling policies and the submitter limits of the server don't apply to it
+type Queue struct {
+	js       jetstream.JetStream
+	stream   jetstream.Stream
+	consumer jetstream.Consumer
+	subject  string
+	limit    int
+
+	// ctx is canceled on Close to stop the waiting consumers
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	// popped are the messages of the popped jobs waiting for the ack, the earliest first
+	popped map[tileKey][]jetstream.Msg
+}
+
+// tileKey identifies the popped job, the same tile may be popped again when it's requeued
+type tileKey struct {
+	jobID   string
+	tileNum int
+}
+
+var _ server.AckQueue = (*Queue)(nil)
+
+// New creates the queue, the stream and the consumer are created or updated
+func New(cfg Config) (*Queue, error) {
+	if cfg.Conn == nil {
+		return nil, fmt.Errorf("connection is empty")
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = defaultStream
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = defaultSubject
+	}
+	if cfg.Consumer == "" {
+		cfg.Consumer = defaultConsumer
+	}
+	if cfg.AckWait <= 0 {
+		cfg.AckWait = defaultAckWait
+	}
+
+	js, err := jetstream.New(cfg.Conn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      cfg.Stream,
+		Subjects:  []string{cfg.Subject},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stream can't be created: %w", err)
+	}
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       cfg.Consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       cfg.AckWait,
+		FilterSubject: cfg.Subject,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("consumer can't be created: %w", err)
+	}
+
+	q := &Queue{
+		js:       js,
+		stream:   stream,
+		consumer: consumer,
+		subject:  cfg.Subject,
+		limit:    cfg.MaxQueuedTiles,
+		popped:   make(map[tileKey][]jetstream.Msg),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	return q, nil
+}
+
+// Push publishes the jobs, the limit is checked for all of them at once, but the publishing isn't atomic,
+// the jobs published before a failure stay queued
+func (q *Queue) Push(jobs ...server.TileJob) error {
+	if q.isClosed() {
+		return server.ErrClosed
+	}
+	if q.limit > 0 {
+		if depth := q.Len(); depth+len(jobs) > q.limit {
+			return &server.QueueFullError{Depth: depth, Limit: q.limit, Requested: len(jobs)}
+		}
+	}
+	for _, job := range jobs {
+		if err := q.publish(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Requeue publishes the job again bypassing the limit
+func (q *Queue) Requeue(job server.TileJob) error {
+	if q.isClosed() {
+		return server.ErrClosed
+	}
+	return q.publish(job)
+}
+
+func (q *Queue) publish(job server.TileJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(q.ctx, requestTimeout)
+	defer cancel()
+
+	if _, err := q.js.Publish(ctx, q.subject, data); err != nil {
+		return fmt.Errorf("job %s tile %d can't be published: %w", job.JobID, job.TileNum, err)
+	}
+	return nil
+}
+
+// Pop waits for the next job until the context is done, the job stays in the stream until it's acked
+// and it's delivered again when it isn't acked in AckWait
+func (q *Queue) Pop(ctx context.Context) (server.TileJob, error) {
+	for {
+		if q.isClosed() {
+			return server.TileJob{}, server.ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return server.TileJob{}, err
+		}
+
+		msg, err := q.next(ctx)
+		if err != nil {
+			if q.isClosed() {
+				return server.TileJob{}, server.ErrClosed
+			}
+			if ctx.Err() != nil {
+				return server.TileJob{}, ctx.Err()
+			}
+			if errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+				continue
+			}
+			return server.TileJob{}, err
+		}
+
+		var job server.TileJob
+		if err := json.Unmarshal(msg.Data(), &job); err != nil {
+			// the malformed job would be redelivered forever
+			_ = msg.Term()
+			return server.TileJob{}, fmt.Errorf("job can't be decoded: %w", err)
+		}
+		key := tileKey{jobID: job.JobID, tileNum: job.TileNum}
+		q.mu.Lock()
+		q.popped[key] = append(q.popped[key], msg)
+		q.mu.Unlock()
+		return job, nil
+	}
+}
+
+// Ack removes the earliest popped message of the job from the stream
+func (q *Queue) Ack(job server.TileJob) error {
+	key := tileKey{jobID: job.JobID, tileNum: job.TileNum}
+	q.mu.Lock()
+	msgs := q.popped[key]
+	if len(msgs) == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	msg := msgs[0]
+	if len(msgs) == 1 {
+		delete(q.popped, key)
+	} else {
+		q.popped[key] = msgs[1:]
+	}
+	q.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := msg.DoubleAck(ctx); err != nil {
+		return fmt.Errorf("job %s tile %d can't be acked: %w", job.JobID, job.TileNum, err)
+	}
+	return nil
+}
+
+// next sends a single pull request, it's finished early when ctx is done or the queue is closed
+func (q *Queue) next(ctx context.Context) (jetstream.Msg, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchWait)
+	defer cancel()
+	stop := context.AfterFunc(q.ctx, cancel)
+	defer stop()
+
+	return q.consumer.Next(jetstream.FetchContext(fetchCtx))
+}
+
+// Len returns amount of the jobs in the stream which are not popped, zero is returned when the stream info
+// isn't available
+func (q *Queue) Len() int {
+	ctx, cancel := context.WithTimeout(q.ctx, requestTimeout)
+	defer cancel()
+
+	info, err := q.stream.Info(ctx)
+	if err != nil {
+		return 0
+	}
+	consumer, err := q.consumer.Info(ctx)
+	if err != nil {
+		return 0
+	}
+	return max(int(info.State.Msgs)-consumer.NumAckPending, 0)
+}
+
+// Close stops the waiting consumers and rejects new jobs, the queued jobs stay in the stream
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cancel()
+	return nil
+}
+
+func (q *Queue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
@@ -0,0 +1,163 @@
+package natsqueue
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/server"
+	"distributed-encoder/server/servertest"
+	"distributed-encoder/worker"
+)
+
+// runBroker starts an embedded NATS server with JetStream
+func runBroker(t *testing.T) *nats.Conn {
+	ns, err := natsserver.NewServer(&natsserver.Options{
+		Host:      "127.0.0.1",
+		Port:      natsserver.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	require.True(t, ns.ReadyForConnections(5*time.Second), "broker is not started")
+
+	conn, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func popTimeout(t *testing.T, q *Queue, timeout time.Duration) (server.TileJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Pop(ctx)
+}
+
+func TestQueue(t *testing.T) {
+	conn := runBroker(t)
+	q, err := New(Config{Conn: conn, MaxQueuedTiles: 3})
+	require.NoError(t, err)
+
+	jobs := []server.TileJob{
+		{JobID: "1d2f", TileNum: 0, File: "video.mp4", Path: "/videos/video.mp4", Width: 10, Height: 10},
+		{JobID: "1d2f", TileNum: 1, File: "video.mp4", Path: "/videos/video.mp4", PosX: 10, Width: 10, Height: 10},
+	}
+	require.NoError(t, q.Push(jobs...))
+	require.Equal(t, 2, q.Len())
+
+	var full *server.QueueFullError
+	require.ErrorAs(t, q.Push(jobs...), &full)
+	require.Equal(t, server.QueueFullError{Depth: 2, Limit: 3, Requested: 2}, *full)
+
+	job, err := popTimeout(t, q, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, jobs[0], job)
+	require.Equal(t, 1, q.Len())
+	require.NoError(t, q.Ack(job))
+
+	// the requeued job bypasses the limit and goes after the queued ones
+	require.NoError(t, q.Requeue(job))
+	require.NoError(t, q.Requeue(job))
+	require.NoError(t, q.Requeue(job))
+	require.Equal(t, 4, q.Len())
+	for _, want := range []server.TileJob{jobs[1], jobs[0], jobs[0], jobs[0]} {
+		job, err := popTimeout(t, q, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, want, job)
+		require.NoError(t, q.Ack(job))
+	}
+	require.Empty(t, q.popped)
+
+	_, err = popTimeout(t, q, 100*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the waiting consumer is woken up by Close
+	popped := make(chan error)
+	go func() {
+		_, err := q.Pop(context.Background())
+		popped <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, q.Close())
+	select {
+	case err := <-popped:
+		require.ErrorIs(t, err, server.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer is not woken up")
+	}
+	require.ErrorIs(t, q.Push(jobs...), server.ErrClosed)
+}
+
+// Servers sharing the stream pull the tiles from it, the tile popped by a server which didn't ack it
+// is delivered to another one
+func TestQueue_SharedStream(t *testing.T) {
+	conn := runBroker(t)
+	first, err := New(Config{Conn: conn, AckWait: 500 * time.Millisecond})
+	require.NoError(t, err)
+	second, err := New(Config{Conn: conn, AckWait: 500 * time.Millisecond})
+	require.NoError(t, err)
+	defer second.Close()
+
+	require.NoError(t, first.Push(server.TileJob{JobID: "1d2f", TileNum: 0}, server.TileJob{JobID: "1d2f", TileNum: 1}))
+	job, err := popTimeout(t, first, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 0, job.TileNum)
+	require.NoError(t, first.Close())
+
+	job, err = popTimeout(t, second, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 1, job.TileNum)
+	require.NoError(t, second.Ack(job))
+	require.Zero(t, second.Len())
+
+	job, err = popTimeout(t, second, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 0, job.TileNum)
+	require.NoError(t, second.Ack(job))
+	_, err = popTimeout(t, second, time.Second)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestQueue_Server(t *testing.T) {
+	conn := runBroker(t)
+	q, err := New(Config{Conn: conn})
+	require.NoError(t, err)
+
+	store := servertest.NewMemoryStore()
+	s := servertest.NewServer(t, server.Config{Queue: q, Store: store})
+
+	status, err := s.TriggerWork(server.EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	// the tile is acked once it's leased
+	require.Empty(t, q.popped)
+	tile, err := io.ReadAll(job.Src)
+	require.NoError(t, err)
+	require.Equal(t, servertest.Tile, string(tile))
+	require.NoError(t, job.Src.Close())
+
+	require.NoError(t, s.AcceptResult(&worker.Result{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		FileName: job.TileName + ".ts",
+		Lease:    job.Lease,
+		Src:      strings.NewReader("encoded"),
+	}))
+	require.Equal(t, "encoded", store.Result(status.ID+"/video_tile_0.ts"))
+
+	status, err = s.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, server.StateCompleted, status.State)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/transcoder"
+	"distributed-encoder/worker"
+)
+
+// Offer leases the next tile to the worker without streaming it, it waits for the tile until the context is done.
+// The worker fetches the tile with StreamOffer or returns it to the queue with RejectOffer
+func (s *Server) Offer(ctx context.Context, workerID string) (*worker.Offer, error) {
+	pollFinished := s.metrics.pollStarted()
+	s.workers.seen(workerID)
+	job, err := s.nextTile(ctx)
+	pollFinished()
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("offering tile", logging.Job(job.JobID, job.TileNum), slog.String(logging.WorkerIDKey, workerID))
+	s.publish(s.jobs.tileDispatched(job.JobID, job.TileNum, workerID)...)
+	s.metrics.dispatched(job.enqueuedAt)
+	lease, err := s.leases.issue(job)
+	if err != nil {
+		s.requeue(job)
+		s.ackTile(job)
+		return nil, err
+	}
+	s.ackTile(job)
+
+	return &worker.Offer{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		TileName: generateTileName(job.File, job.TileNum),
+		Width:    job.Width,
+		Height:   job.Height,
+		Lease:    lease,
+	}, nil
+}
+
+// StreamOffer streams the offered tile, the tile can be streamed until its upload is started
+func (s *Server) StreamOffer(jobID string, tileNum int, lease string) (*worker.Job, error) {
+	job, err := s.leases.offered(jobID, tileNum, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := trace.ContextWithSpanContext(context.Background(), job.trace)
+	ctx, span := tracer().Start(ctx, "server.Dispatch", tileAttributes(job),
+		trace.WithAttributes(attribute.Bool("dispatch.offer", true)))
+
+	stream, err := s.tileStreamer.StreamTile(&transcoder.CropArgs{
+		Input:  job.Path,
+		X:      job.PosX,
+		Y:      job.PosY,
+		Height: job.Height,
+		Width:  job.Width,
+	})
+	if err != nil {
+		s.log.Error("tile stream can't be started", logging.Job(jobID, tileNum), logging.Err(err))
+		endSpan(span, err)
+		if err := s.leases.release(jobID, tileNum, lease); err != nil {
+			s.log.Error("lease can't be released", logging.Job(jobID, tileNum), logging.Err(err))
+		}
+		s.publish(s.jobs.tileFinished(jobID, tileNum, err)...)
+		return nil, err
+	}
+
+	return &worker.Job{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		TileName: generateTileName(job.File, job.TileNum),
+		Width:    job.Width,
+		Height:   job.Height,
+		Lease:    lease,
+		Trace:    injectTrace(ctx),
+		Src:      endSpanOnClose(s.metrics.countStreamed(stream), span),
+	}, nil
+}
+
+// RejectOffer returns the offered tile to the queue, it's called when the worker nacks the offer,
+// doesn't answer in time or disconnects
+func (s *Server) RejectOffer(jobID string, tileNum int, lease string) error {
+	job, err := s.leases.revoke(jobID, tileNum, lease)
+	if err != nil {
+		return err
+	}
+	if s.requeue(job) {
+		s.log.Info("offer is rejected, tile is requeued", logging.Job(jobID, tileNum))
+	}
+	return nil
+}
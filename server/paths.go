@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrInvalidResultName happens when the result name doesn't match the name of the dispatched tile
+	ErrInvalidResultName = errors.New("result name is invalid")
+	// ErrTileNotLeased happens when the result is uploaded for a tile which is not dispatched
+	ErrTileNotLeased = errors.New("tile is not dispatched")
+	// ErrInputNotAllowed happens when the input file is outside of the allowed roots
+	ErrInputNotAllowed = errors.New("input file is not allowed")
+	// ErrFileNotFound happens when the input file doesn't exist
+	ErrFileNotFound = errors.New("file is not found in the storage")
+)
+
+// resultExt is an extension of the encoded tile chosen by the worker
+var resultExt = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// resultKey returns the store key of the tile result, the key is scoped by the job,
+// so the results of the jobs of the same source don't overwrite each other
+func resultKey(jobID, tileName, name string) (string, error) {
+	ext := path.Ext(name)
+	if strings.ContainsAny(name, `/\`) || name != tileName+ext || !resultExt.MatchString(ext) {
+		return "", fmt.Errorf("%w: %q, %s with an extension is expected", ErrInvalidResultName, name, tileName)
+	}
+	return path.Join(jobID, name), nil
+}
+
+// resolveInput returns the clean absolute input path, when the roots are set the symlinks are resolved
+// and ErrInputNotAllowed is returned for the path outside of them
+func resolveInput(input string, roots []string) (string, error) {
+	if !filepath.IsAbs(input) {
+		return "", fmt.Errorf("%w: %s, absolute path is expected", ErrInputNotAllowed, input)
+	}
+	if len(roots) == 0 {
+		return filepath.Clean(input), nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(input)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, input)
+	}
+
+	for _, root := range roots {
+		root, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && filepath.IsLocal(rel) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is outside of the allowed roots", ErrInputNotAllowed, input)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveInput(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "video.mp4"), []byte("video"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.mp4"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.mp4"), filepath.Join(root, "link.mp4")))
+	roots := []string{root}
+
+	resolved, err := resolveInput(filepath.Join(root, "video.mp4"), roots)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "video.mp4"), resolved)
+
+	resolved, err = resolveInput(filepath.Join(root, "nested", "..", "video.mp4"), roots)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "video.mp4"), resolved)
+
+	for _, input := range []string{
+		filepath.Join(root, "..", filepath.Base(outside), "secret.mp4"),
+		filepath.Join(outside, "secret.mp4"),
+		filepath.Join(root, "link.mp4"),
+		"/etc/passwd",
+		"video.mp4",
+	} {
+		_, err := resolveInput(input, roots)
+		require.ErrorIs(t, err, ErrInputNotAllowed, input)
+	}
+
+	// without the roots any absolute path is allowed
+	resolved, err = resolveInput("/videos/../videos/video.mp4", nil)
+	require.NoError(t, err)
+	require.Equal(t, "/videos/video.mp4", resolved)
+	_, err = resolveInput("../video.mp4", nil)
+	require.ErrorIs(t, err, ErrInputNotAllowed)
+}
+
+func TestServer_TriggerWorkInputRoots(t *testing.T) {
+	root := t.TempDir()
+	input := filepath.Join(root, "video.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("video"), 0644))
+
+	var store storeMock
+	store.On("HasObject", input).Return(true)
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}, InputRoots: []string{root}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: root + "/../" + filepath.Base(root) + "/video.mp4"})
+	require.NoError(t, err)
+
+	outside := filepath.Join(t.TempDir(), "secret.mp4")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+	traversal, err := filepath.Rel(root, outside)
+	require.NoError(t, err)
+
+	_, err = s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: root + "/" + traversal})
+	require.ErrorIs(t, err, ErrInputNotAllowed)
+	store.AssertNumberOfCalls(t, "HasObject", 1)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is a media type of the error responses
+const ProblemContentType = "application/problem+json"
+
+// Problem is the RFC 9457 problem details of the error response of all the handlers
+type Problem struct {
+	// Type is always "about:blank", the problems are identified by Status
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request
+	Instance string `json:"instance,omitempty"`
+	// Error repeats Detail, or Title without it, for the clients reading {"error": "..."} responses
+	Error string `json:"error"`
+	// Errors are the invalid fields of the request
+	Errors []FieldError `json:"errors,omitempty"`
+
+	// QueueDepth and QueueLimit describe the full queue of 429 and 503 responses
+	QueueDepth *int `json:"queueDepth,omitempty"`
+	QueueLimit *int `json:"queueLimit,omitempty"`
+}
+
+// writeProblem fills the common members of the problem and writes it with its status
+func writeProblem(w http.ResponseWriter, req *http.Request, p Problem) error {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.Instance = req.URL.Path
+	p.Error = p.Detail
+	if p.Error == "" {
+		p.Error = p.Title
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
+
+// writeError responds with the problem of the status and the detail
+func (h HTTPHandler) writeError(w http.ResponseWriter, req *http.Request, status int, detail string) {
+	h.writeProblem(w, req, Problem{Status: status, Detail: detail})
+}
+
+// writeInternalError logs the error and responds with 500, the detail of the error is not disclosed
+func (h HTTPHandler) writeInternalError(w http.ResponseWriter, req *http.Request, err error) {
+	h.logErr(req, err)
+	h.writeProblem(w, req, Problem{Status: http.StatusInternalServerError})
+}
+
+func (h HTTPHandler) writeProblem(w http.ResponseWriter, req *http.Request, p Problem) {
+	if err := writeProblem(w, req, p); err != nil {
+		h.logErr(req, err)
+	}
+}
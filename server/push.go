@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/worker"
+)
+
+const (
+	// pushAckTimeout is a time the worker has to ack or nack the offer, the tile is queued again after it
+	pushAckTimeout = 30 * time.Second
+	// pushPingInterval and pushPongWait detect the broken push channels
+	pushPingInterval = 20 * time.Second
+	pushPongWait     = 2 * pushPingInterval
+)
+
+var pushUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Error: func(w http.ResponseWriter, req *http.Request, status int, reason error) {
+		_ = writeProblem(w, req, Problem{Status: status, Detail: reason.Error()})
+	},
+}
+
+// GET /work/push
+// WebSocket channel the jobs are pushed to as soon as they're queued, the worker sends "ready" when it's free,
+// the server answers with an offer and the worker fetches the tile from /work/jobs/:id/tiles/:num and acks it,
+// or nacks it and the tile is queued again
+func (h HTTPHandler) Push(w http.ResponseWriter, req *http.Request) {
+	conn, err := pushUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// the upgrader responds with the error itself
+		h.logErr(req, err)
+		return
+	}
+	defer conn.Close()
+
+	session := pushSession{
+		service:  h.Service,
+		conn:     conn,
+		workerID: workerIdentity(req),
+		log:      h.logger().With(slog.String(logging.WorkerIDKey, workerIdentity(req))),
+	}
+	session.run()
+}
+
+type offerResult struct {
+	offer *worker.Offer
+	err   error
+}
+
+// pushSession offers the tiles to a single worker, an offer is made for each "ready" message
+type pushSession struct {
+	service  Service
+	conn     *websocket.Conn
+	workerID string
+	log      *slog.Logger
+
+	// pending is the offer which is not acked yet
+	pending *worker.Offer
+}
+
+func (p *pushSession) run() {
+	p.log.Info("push channel is opened")
+	defer p.log.Info("push channel is closed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_ = p.conn.SetReadDeadline(time.Now().Add(pushPongWait))
+	p.conn.SetPongHandler(func(string) error {
+		return p.conn.SetReadDeadline(time.Now().Add(pushPongWait))
+	})
+	messages := make(chan worker.PushMessage)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			var msg worker.PushMessage
+			if err := p.conn.ReadJSON(&msg); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pushPingInterval)
+	defer ping.Stop()
+
+	offers := make(chan offerResult, 1)
+	var ready int
+	var offering bool
+	var ackTimeout <-chan time.Time
+	defer func() {
+		// the tiles offered to the gone worker are queued again
+		cancel()
+		if offering {
+			if r := <-offers; r.offer != nil {
+				p.reject(r.offer, "push channel is closed")
+			}
+		}
+		if p.pending != nil {
+			p.reject(p.pending, "push channel is closed")
+		}
+	}()
+
+	for {
+		if ready > 0 && p.pending == nil && !offering {
+			ready--
+			offering = true
+			go func() {
+				offer, err := p.service.Offer(ctx, p.workerID)
+				offers <- offerResult{offer: offer, err: err}
+			}()
+		}
+
+		select {
+		case err := <-readErr:
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				p.log.Warn("push channel is broken", logging.Err(err))
+			}
+			return
+		case msg := <-messages:
+			switch msg.Type {
+			case worker.PushReady:
+				ready++
+			case worker.PushAck:
+				if p.isPending(msg) {
+					p.pending, ackTimeout = nil, nil
+				}
+			case worker.PushNack:
+				if p.isPending(msg) {
+					p.reject(p.pending, msg.Reason)
+					p.pending, ackTimeout = nil, nil
+				}
+			}
+		case r := <-offers:
+			offering = false
+			if r.err != nil {
+				if errors.Is(r.err, ErrClosed) {
+					_ = p.conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is closed"), time.Now().Add(time.Second))
+				} else {
+					p.log.Error("tile can't be offered", logging.Err(r.err))
+				}
+				return
+			}
+			p.pending, ackTimeout = r.offer, time.After(pushAckTimeout)
+			if err := p.conn.WriteJSON(worker.PushMessage{Type: worker.PushOffer, Offer: r.offer}); err != nil {
+				p.log.Warn("offer can't be sent", logging.Err(err))
+				return
+			}
+		case <-ackTimeout:
+			p.reject(p.pending, "offer is not acked in time")
+			p.pending, ackTimeout = nil, nil
+		case <-ping.C:
+			if err := p.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pushPingInterval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (p *pushSession) isPending(msg worker.PushMessage) bool {
+	return p.pending != nil && p.pending.JobID == msg.JobID && p.pending.TileNum == msg.TileNum && p.pending.Lease == msg.Lease
+}
+
+func (p *pushSession) reject(offer *worker.Offer, reason string) {
+	log := p.log.With(logging.Job(offer.JobID, offer.TileNum))
+	log.Info("offer is rejected", slog.String("reason", reason))
+	if err := p.service.RejectOffer(offer.JobID, offer.TileNum, offer.Lease); err != nil {
+		log.Warn("offer can't be rejected", logging.Err(err))
+	}
+}
+
+// GET /work/jobs/:id/tiles/:num
+// streams the offered tile, the X-Lease header of the offer is required
+func (h HTTPHandler) StreamTile(w http.ResponseWriter, req *http.Request) {
+	tileNum, err := strconv.Atoi(tileNumParam(req))
+	if err != nil {
+		h.writeError(w, req, http.StatusBadRequest, "invalid tile number")
+		return
+	}
+
+	job, err := h.Service.StreamOffer(jobIDParam(req), tileNum, req.Header.Get(worker.LeaseHeader))
+	if errors.Is(err, ErrTileNotLeased) {
+		h.writeError(w, req, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		h.writeInternalError(w, req, err)
+		return
+	}
+	h.streamJob(w, req, job)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func newPushServer(t *testing.T, s *Server) *httptest.Server {
+	h := HTTPHandler{Service: s}
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/work/push", h.Push)
+	router.HandlerFunc(http.MethodGet, "/work/jobs/:id/tiles/:num", h.StreamTile)
+	router.HandlerFunc(http.MethodPost, "/work/result", h.AcceptResult)
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestPush(t *testing.T) {
+	store := &recordingStore{}
+	s, err := New(Config{DispatchTimeout: time.Minute, Store: store, TileStreamer: tileStreamer{tile: "tile"}})
+	require.NoError(t, err)
+	defer s.Close()
+	ts := newPushServer(t, s)
+
+	client, err := worker.NewClient(worker.ClientConfig{
+		PollEndpoint:     ts.URL + "/work/jobs",
+		ResultEndpoint:   ts.URL + "/work/result",
+		ProgressEndpoint: ts.URL + "/work/progress",
+		PushEndpoint:     "ws" + strings.TrimPrefix(ts.URL, "http") + "/work/push",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subscribed := make(chan error)
+	go func() {
+		subscribed <- client.Subscribe(ctx, func(job *worker.Job) error {
+			defer cancel()
+
+			tile, err := io.ReadAll(job.Src)
+			require.NoError(t, err)
+			require.Equal(t, "tile", string(tile))
+			return client.SendResult(&worker.Result{
+				JobID:    job.JobID,
+				TileNum:  job.TileNum,
+				FileName: job.TileName + ".ts",
+				Lease:    job.Lease,
+				Src:      strings.NewReader("encoded"),
+			})
+		})
+	}()
+
+	// the worker is subscribed before the job is triggered, the tile is pushed without waiting for the next poll
+	status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	select {
+	case err := <-subscribed:
+		require.ErrorIs(t, err, worker.ErrCancelled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("job is not pushed")
+	}
+	require.Equal(t, "encoded", store.data[status.ID+"/video_tile_0.ts"])
+
+	status, err = s.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateCompleted, status.State)
+}
+
+func TestPush_Rejected(t *testing.T) {
+	s, err := New(Config{DispatchTimeout: time.Minute, Store: &recordingStore{}, TileStreamer: tileStreamer{tile: "tile"}})
+	require.NoError(t, err)
+	defer s.Close()
+	ts := newPushServer(t, s)
+
+	status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/work/push", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	offer := func() *worker.Offer {
+		require.NoError(t, conn.WriteJSON(worker.PushMessage{Type: worker.PushReady}))
+		var msg worker.PushMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, worker.PushOffer, msg.Type)
+		return msg.Offer
+	}
+
+	// the nacked tile is offered again with a new lease
+	first := offer()
+	require.NoError(t, conn.WriteJSON(worker.PushMessage{
+		Type: worker.PushNack, JobID: first.JobID, TileNum: first.TileNum, Lease: first.Lease, Reason: "busy",
+	}))
+	second := offer()
+	require.Equal(t, first.JobID, second.JobID)
+	require.NotEqual(t, first.Lease, second.Lease)
+
+	// the tile can't be fetched with the stale lease
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/work/jobs/"+first.JobID+"/tiles/0", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set(worker.LeaseHeader, first.Lease)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+
+	// the tile offered to the disconnected worker is queued again
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		status, err := s.JobStatus(status.ID)
+		require.NoError(t, err)
+		return status.Tiles[0].State == StateQueued
+	}, 5*time.Second, 10*time.Millisecond)
+}
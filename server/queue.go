@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueueFullError is returned when the jobs can't be admitted to the queue
+type QueueFullError struct {
+	// Submitter is set when the submitter's limit is reached, otherwise the whole queue is full
+	Submitter string
+	// Depth is a current amount of queued tiles, for the submitter if it's set
+	Depth int
+	// Limit is the maximum amount of queued tiles
+	Limit int
+	// Requested is an amount of tiles that was rejected
+	Requested int
+}
+
+func (e *QueueFullError) Error() string {
+	if e.Oversized() {
+		if e.Submitter != "" {
+			return fmt.Sprintf("%v tiles requested, submitter %q queue is limited to %v tiles", e.Requested, e.Submitter, e.Limit)
+		}
+		return fmt.Sprintf("%v tiles requested, queue is limited to %v tiles", e.Requested, e.Limit)
+	}
+	if e.Submitter != "" {
+		return fmt.Sprintf("submitter %q queue is full: %v of %v tiles queued, %v requested",
+			e.Submitter, e.Depth, e.Limit, e.Requested)
+	}
+	return fmt.Sprintf("queue is full: %v of %v tiles queued, %v requested", e.Depth, e.Limit, e.Requested)
+}
+
+// Oversized reports whether the requested tiles exceed the limit on their own, so they're never admitted
+func (e *QueueFullError) Oversized() bool {
+	return e.Requested > e.Limit
+}
+
+// queueLimits bounds the amount of queued tiles, zero value means no limit
+type queueLimits struct {
+	total        int
+	perSubmitter int
+}
+
+// Queue carries the tile jobs from TriggerWork to Dispatch, only the job descriptors are queued,
+// the tiles themselves are streamed to the workers by the server
+type Queue interface {
+	// Push enqueues either all the jobs or none of them, *QueueFullError is returned when a limit is reached
+	Push(jobs ...TileJob) error
+	// Requeue enqueues the dispatched job again bypassing the limits
+	Requeue(job TileJob) error
+	// Pop waits for the next job until the context is done, ErrClosed is returned when the queue is closed
+	Pop(ctx context.Context) (TileJob, error)
+	// Len returns amount of queued jobs
+	Len() int
+	// Close wakes up waiting consumers and rejects new jobs
+	Close() error
+}
+
+// AckQueue is a Queue which keeps the popped jobs until they're acked and delivers them again otherwise,
+// so the tiles popped by a crashed server are not lost. The server acks the job once its dispatch is saved
+type AckQueue interface {
+	Queue
+	// Ack drops the popped job from the queue
+	Ack(job TileJob) error
+}
+
+// DropQueue is a Queue which drops the queued jobs of the cancelled job, so they don't count against the limits
+type DropQueue interface {
+	Queue
+	// Drop removes the queued jobs of the job and returns their amount
+	Drop(jobID string) int
+}
+
+// jobQueue is a thread safe bounded queue of tile jobs ordered by the scheduler
+type jobQueue struct {
+	mu        sync.Mutex
+	scheduler scheduler
+	limits    queueLimits
+	seq       uint64
+	closed    bool
+
+	// submitters holds amount of queued jobs per submitter
+	submitters map[string]int
+
+	// ready is closed and replaced each time jobs are pushed to wake up waiting consumers
+	ready chan struct{}
+}
+
+func newJobQueue(s scheduler, limits queueLimits) *jobQueue {
+	return &jobQueue{
+		scheduler:  s,
+		limits:     limits,
+		submitters: make(map[string]int),
+		ready:      make(chan struct{}),
+	}
+}
+
+// Push enqueues either all the jobs or none of them, when a limit is reached *QueueFullError is returned
+func (q *jobQueue) Push(jobs ...TileJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+	if err := q.admit(jobs); err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		q.seq++
+		job.seq = q.seq
+		q.scheduler.push(job)
+		q.submitters[job.Submitter]++
+	}
+	close(q.ready)
+	q.ready = make(chan struct{})
+
+	return nil
+}
+
+// Requeue enqueues the job again bypassing the limits, it keeps the enqueue order of the job
+func (q *jobQueue) Requeue(job TileJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+	q.scheduler.push(job)
+	q.submitters[job.Submitter]++
+	close(q.ready)
+	q.ready = make(chan struct{})
+
+	return nil
+}
+
+// Pop waits for the next job until the context is done
+func (q *jobQueue) Pop(ctx context.Context) (TileJob, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return TileJob{}, ErrClosed
+		}
+		job, ok := q.scheduler.pop()
+		if ok {
+			q.release(job.Submitter)
+		}
+		ready := q.ready
+		q.mu.Unlock()
+
+		if ok {
+			return job, nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return TileJob{}, ctx.Err()
+		}
+	}
+}
+
+// Drop removes the queued jobs of the job and returns their amount
+func (q *jobQueue) Drop(jobID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := q.scheduler.drop(jobID)
+	for _, job := range dropped {
+		q.release(job.Submitter)
+	}
+	return len(dropped)
+}
+
+func (q *jobQueue) admit(jobs []TileJob) error {
+	if limit := q.limits.total; limit > 0 {
+		if depth := q.scheduler.len(); depth+len(jobs) > limit {
+			return &QueueFullError{Depth: depth, Limit: limit, Requested: len(jobs)}
+		}
+	}
+	if limit := q.limits.perSubmitter; limit > 0 {
+		requested := make(map[string]int)
+		for _, job := range jobs {
+			requested[job.Submitter]++
+		}
+		for submitter, n := range requested {
+			if depth := q.submitters[submitter]; depth+n > limit {
+				return &QueueFullError{Submitter: submitter, Depth: depth, Limit: limit, Requested: n}
+			}
+		}
+	}
+	return nil
+}
+
+func (q *jobQueue) release(submitter string) {
+	q.submitters[submitter]--
+	if q.submitters[submitter] <= 0 {
+		delete(q.submitters, submitter)
+	}
+}
+
+// Len returns amount of queued jobs
+func (q *jobQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.scheduler.len()
+}
+
+// Close wakes up waiting consumers and rejects new jobs
+func (q *jobQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.ready)
+	return nil
+}
+
+// popTimeout waits for the next job until timeout is reached
+func popTimeout(pop func(ctx context.Context) (TileJob, error), timeout time.Duration) (TileJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	job, err := pop(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return TileJob{}, ErrDispatchTimeout
+	}
+	return job, err
+}
+
+// tileJobWire adds the enqueue time and the trace context to TileJob, so the broker queues keep them
+type tileJobWire struct {
+	tileJobFields
+	EnqueuedAt time.Time              `json:"enqueuedAt"`
+	Trace      propagation.MapCarrier `json:"trace,omitempty"`
+}
+
+// tileJobFields has the fields of TileJob without its JSON methods
+type tileJobFields TileJob
+
+// MarshalJSON encodes the job for the broker queues
+func (j TileJob) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tileJobWire{
+		tileJobFields: tileJobFields(j),
+		EnqueuedAt:    j.enqueuedAt,
+		Trace:         injectTrace(trace.ContextWithSpanContext(context.Background(), j.trace)),
+	})
+}
+
+// UnmarshalJSON decodes the job encoded with MarshalJSON
+func (j *TileJob) UnmarshalJSON(b []byte) error {
+	var wire tileJobWire
+	if err := json.Unmarshal(b, &wire); err != nil {
+		return err
+	}
+	*j = TileJob(wire.tileJobFields)
+	j.enqueuedAt = wire.EnqueuedAt
+	j.trace = trace.SpanContextFromContext(extractTrace(wire.Trace))
+	return nil
+}
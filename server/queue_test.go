@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestJobQueue_Admission(t *testing.T) {
+	q := newJobQueue(&fifoScheduler{}, queueLimits{total: 4, perSubmitter: 3})
+
+	require.NoError(t, q.Push(TileJob{Submitter: "a"}, TileJob{Submitter: "a"}))
+
+	// submitter quota is checked for the whole request
+	err := q.Push(TileJob{Submitter: "a"}, TileJob{Submitter: "a"})
+	require.Equal(t, &QueueFullError{Submitter: "a", Depth: 2, Limit: 3, Requested: 2}, err)
+	require.Equal(t, 2, q.Len())
+
+	// the whole queue limit
+	err = q.Push(TileJob{Submitter: "b"}, TileJob{Submitter: "b"}, TileJob{Submitter: "b"})
+	require.Equal(t, &QueueFullError{Depth: 2, Limit: 4, Requested: 3}, err)
+	require.False(t, err.(*QueueFullError).Oversized())
+	require.Equal(t, 2, q.Len())
+
+	// the request larger than the limit can't be admitted even to the empty queue
+	err = q.Push(make([]TileJob, 5)...)
+	require.True(t, err.(*QueueFullError).Oversized())
+	require.EqualError(t, err, "5 tiles requested, queue is limited to 4 tiles")
+
+	// dispatched jobs free the quota
+	_, err = popTimeout(q.Pop, time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(TileJob{Submitter: "a"}, TileJob{Submitter: "a"}))
+	require.Equal(t, 3, q.Len())
+	require.Equal(t, 3, q.submitters["a"])
+}
+
+func TestJobQueue_Unlimited(t *testing.T) {
+	q := newJobQueue(&fifoScheduler{}, queueLimits{})
+	jobs := make([]TileJob, 100)
+
+	require.NoError(t, q.Push(jobs...))
+	require.Equal(t, 100, q.Len())
+}
+
+func TestTileJob_JSON(t *testing.T) {
+	prevPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prevPropagator)
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	job := TileJob{
+		JobID: "1d2f", TileNum: 1, File: "video.mp4", Path: "/videos/video.mp4", PosX: 10, Width: 10, Height: 10,
+		Submitter:  "team-a",
+		seq:        3,
+		enqueuedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		trace:      spanContext,
+	}
+	b, err := json.Marshal(job)
+	require.NoError(t, err)
+
+	var got TileJob
+	require.NoError(t, json.Unmarshal(b, &got))
+	// the enqueue order is local to the queue
+	require.Zero(t, got.seq)
+	require.Equal(t, job.enqueuedAt, got.enqueuedAt)
+	require.Equal(t, spanContext.TraceID(), got.trace.TraceID())
+	require.Equal(t, spanContext.SpanID(), got.trace.SpanID())
+	got.seq, got.trace, job.trace = job.seq, trace.SpanContext{}, trace.SpanContext{}
+	require.Equal(t, job, got)
+}
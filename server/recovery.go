@@ -0,0 +1,81 @@
+package server
+
+import (
+	"log/slog"
+
+	"distributed-encoder/logging"
+)
+
+// recoverJobs queues again the tiles of the unfinished jobs lost by the stopped processes of the server:
+// the running tiles without a lease, the tiles which uploads were claimed longer than the lease TTL ago
+// and the queued tiles when the queue was in memory. The other running tiles are dispatched again when their leases expire.
+// A replica started while another one dispatches a tile may requeue it before its lease is issued,
+// the tile is dispatched twice then and the result of the stale dispatch is rejected
+func (s *Server) recoverJobs(queueLost bool) error {
+	recs, err := s.jobs.store.ListJobs(JobFilter{States: []JobState{StateQueued, StateRunning}})
+	if err != nil {
+		return err
+	}
+
+	now := s.leases.now()
+	for _, rec := range recs {
+		var queued, running []int
+		err := updateJob(s.jobs.store, rec.Status.ID, func(rec *JobRecord) error {
+			queued, running = nil, nil
+			changed := false
+			for _, tile := range rec.Status.Tiles {
+				l, leased := rec.Leases[tile.Num]
+				switch {
+				case tile.State == StateQueued && queueLost:
+					queued = append(queued, tile.Num)
+				case tile.State == StateRunning && !leased:
+					running = append(running, tile.Num)
+				case tile.State == StateRunning && l.Claimed && now.After(l.Expires):
+					delete(rec.Leases, tile.Num)
+					running = append(running, tile.Num)
+					changed = true
+				}
+			}
+			if !changed {
+				return errNoChange
+			}
+			return nil
+		})
+		if err != nil {
+			s.log.Error("job can't be recovered", slog.String(logging.JobIDKey, rec.Status.ID), logging.Err(err))
+			continue
+		}
+		if len(queued) == 0 && len(running) == 0 {
+			continue
+		}
+
+		jobs := make(map[int]TileJob, len(rec.Status.Tiles))
+		buildCropJobs(rec.Status.Request, func(job TileJob) {
+			job.JobID = rec.Status.ID
+			job.Retry = rec.Status.Retries
+			job.enqueuedAt = now
+			jobs[job.TileNum] = job
+		})
+		for _, num := range queued {
+			job, ok := jobs[num]
+			if !ok {
+				s.publish(s.jobs.tileFinished(rec.Status.ID, num, errTileNotSplit)...)
+				continue
+			}
+			if err := s.queue.Requeue(job); err != nil {
+				s.publish(s.jobs.tileFinished(rec.Status.ID, num, err)...)
+			}
+		}
+		for _, num := range running {
+			job, ok := jobs[num]
+			if !ok {
+				s.publish(s.jobs.tileFinished(rec.Status.ID, num, errTileNotSplit)...)
+				continue
+			}
+			s.requeue(job)
+		}
+		s.log.Warn("job is recovered", slog.String(logging.JobIDKey, rec.Status.ID),
+			slog.Int("queued", len(queued)), slog.Int("running", len(running)))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_RecoverJobs(t *testing.T) {
+	store := newMemoryJobStore()
+	req := EncodeVideoRequest{Tiles: 4, Width: 20, Height: 20, FilePath: "/videos/video.mp4"}
+	now := time.Now()
+	require.NoError(t, store.CreateJob(JobRecord{
+		Status: JobStatus{ID: "1d2f", State: StateRunning, Request: req, Tiles: []TileStatus{
+			{Num: 0, State: StateQueued},
+			{Num: 1, State: StateRunning, Worker: "worker-1"},
+			{Num: 2, State: StateRunning, Worker: "worker-2"},
+			{Num: 3, State: StateRunning, Worker: "worker-3"},
+		}},
+		Leases: map[int]*Lease{
+			// the upload is interrupted by the stop of the server
+			2: {Token: "9a1c", Job: TileJob{JobID: "1d2f", TileNum: 2}, Expires: now.Add(-time.Minute), Claimed: true},
+			// the worker still encodes the tile
+			3: {Token: "3b7e", Job: TileJob{JobID: "1d2f", TileNum: 3}, Expires: now.Add(time.Minute)},
+		},
+	}))
+	require.NoError(t, store.CreateJob(JobRecord{Status: JobStatus{ID: "5c6d", State: StateCompleted, Request: req}}))
+
+	var streamer streamerMock
+	s, err := New(Config{JobStore: store, Store: &storeMock{}, TileStreamer: &streamer})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.Equal(t, 3, s.QueueDepth())
+	status, err := s.JobStatus("1d2f")
+	require.NoError(t, err)
+	var states []JobState
+	for _, tile := range status.Tiles {
+		states = append(states, tile.State)
+	}
+	require.Equal(t, []JobState{StateQueued, StateQueued, StateQueued, StateRunning}, states)
+
+	rec, err := store.LoadJob("1d2f")
+	require.NoError(t, err)
+	require.NotContains(t, rec.Leases, 2)
+	require.Contains(t, rec.Leases, 3)
+
+	// the recovered tiles are the tiles of the request
+	for _, num := range []int{0, 1, 2} {
+		job, err := s.queue.Pop(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, num, job.TileNum)
+		require.Equal(t, "/videos/video.mp4", job.Path)
+		require.Equal(t, 10, job.Width)
+	}
+}
+
+// The tiles of the jobs saved before the frame was split exactly are failed
+func TestServer_RecoverJobsNotSplit(t *testing.T) {
+	store := newMemoryJobStore()
+	req := EncodeVideoRequest{Tiles: 4, Width: 12, Height: 10, FilePath: "/videos/video.mp4"}
+	require.NoError(t, store.CreateJob(JobRecord{
+		Status: JobStatus{ID: "1d2f", State: StateQueued, Request: req, Tiles: []TileStatus{
+			{Num: 0, State: StateQueued},
+			{Num: 1, State: StateQueued},
+			{Num: 2, State: StateQueued},
+			{Num: 3, State: StateQueued},
+			{Num: 4, State: StateQueued},
+			{Num: 5, State: StateQueued},
+		}},
+	}))
+
+	s, err := New(Config{JobStore: store, Store: &storeMock{}, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.Equal(t, 4, s.QueueDepth())
+	status, err := s.JobStatus("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, status.Tiles[4].State)
+	require.Equal(t, errTileNotSplit.Error(), status.Tiles[5].Error)
+}
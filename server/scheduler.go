@@ -0,0 +1,249 @@
+package server
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// SchedulingPolicy selects the order in which queued tiles are dispatched
+type SchedulingPolicy string
+
+const (
+	// PolicyFIFO dispatches tiles in the order they were enqueued
+	PolicyFIFO SchedulingPolicy = "fifo"
+
+	// PolicyPriority dispatches tiles of higher priority requests first, FIFO within the same priority
+	PolicyPriority SchedulingPolicy = "priority"
+
+	// PolicyFair dispatches higher priorities first and alternates between submitters within the same priority,
+	// so one huge request can't starve the others
+	PolicyFair SchedulingPolicy = "fair"
+)
+
+// scheduler orders queued tile jobs, implementations are not thread safe
+type scheduler interface {
+	push(job TileJob)
+	pop() (TileJob, bool)
+	len() int
+	// drop removes the jobs of the job id and returns them
+	drop(jobID string) []TileJob
+}
+
+// dropJobs removes the jobs of the job id in place and returns them
+func dropJobs(jobs []TileJob, jobID string) (kept, dropped []TileJob) {
+	kept = jobs[:0]
+	for _, job := range jobs {
+		if job.JobID == jobID {
+			dropped = append(dropped, job)
+		} else {
+			kept = append(kept, job)
+		}
+	}
+	clear(jobs[len(kept):])
+	return kept, dropped
+}
+
+func newScheduler(policy SchedulingPolicy) (scheduler, error) {
+	switch policy {
+	case PolicyFIFO:
+		return &fifoScheduler{}, nil
+	case PolicyPriority:
+		return &priorityScheduler{}, nil
+	case PolicyFair:
+		return newFairScheduler(), nil
+	default:
+		return nil, fmt.Errorf("unknown scheduling policy: %q", policy)
+	}
+}
+
+type fifoScheduler struct {
+	jobs []TileJob
+}
+
+func (s *fifoScheduler) push(job TileJob) {
+	s.jobs = append(s.jobs, job)
+}
+
+func (s *fifoScheduler) pop() (TileJob, bool) {
+	if len(s.jobs) == 0 {
+		return TileJob{}, false
+	}
+	job := s.jobs[0]
+	s.jobs[0] = TileJob{}
+	s.jobs = s.jobs[1:]
+
+	return job, true
+}
+
+func (s *fifoScheduler) len() int {
+	return len(s.jobs)
+}
+
+func (s *fifoScheduler) drop(jobID string) []TileJob {
+	var dropped []TileJob
+	s.jobs, dropped = dropJobs(s.jobs, jobID)
+	return dropped
+}
+
+// priorityScheduler is a max heap by priority, jobs with the same priority are ordered by seq
+type priorityScheduler struct {
+	jobs jobHeap
+}
+
+func (s *priorityScheduler) push(job TileJob) {
+	heap.Push(&s.jobs, job)
+}
+
+func (s *priorityScheduler) pop() (TileJob, bool) {
+	if len(s.jobs) == 0 {
+		return TileJob{}, false
+	}
+	return heap.Pop(&s.jobs).(TileJob), true
+}
+
+func (s *priorityScheduler) len() int {
+	return len(s.jobs)
+}
+
+func (s *priorityScheduler) drop(jobID string) []TileJob {
+	var dropped []TileJob
+	s.jobs, dropped = dropJobs(s.jobs, jobID)
+	heap.Init(&s.jobs)
+	return dropped
+}
+
+// peek returns the next job without removing it
+func (s *priorityScheduler) peek() TileJob {
+	return s.jobs[0]
+}
+
+type jobHeap []TileJob
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap) Push(x interface{}) {
+	*h = append(*h, x.(TileJob))
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = TileJob{}
+	*h = old[:n-1]
+
+	return job
+}
+
+// fairScheduler keeps a priority queue per submitter.
+// The next job is taken from the highest pending priority, and among the submitters
+// having jobs on that priority the one which was served least recently wins.
+type fairScheduler struct {
+	queues map[string]*priorityScheduler
+	// order is a list of submitters with pending jobs in order of arrival
+	order []string
+	// lastServed holds the tick when submitter was served last time, it's kept when the queue of the submitter
+	// empties, so a submitter which drains and refills its queue doesn't go ahead of the waiting ones
+	lastServed map[string]uint64
+	tick       uint64
+	size       int
+}
+
+func newFairScheduler() *fairScheduler {
+	return &fairScheduler{
+		queues:     make(map[string]*priorityScheduler),
+		lastServed: make(map[string]uint64),
+	}
+}
+
+func (s *fairScheduler) push(job TileJob) {
+	q, ok := s.queues[job.Submitter]
+	if !ok {
+		q = &priorityScheduler{}
+		s.queues[job.Submitter] = q
+		s.order = append(s.order, job.Submitter)
+	}
+	q.push(job)
+	s.size++
+}
+
+func (s *fairScheduler) pop() (TileJob, bool) {
+	if s.size == 0 {
+		return TileJob{}, false
+	}
+
+	next := -1
+	var best TileJob
+	for i, submitter := range s.order {
+		head := s.queues[submitter].peek()
+		if next == -1 || s.before(head, best) {
+			next, best = i, head
+		}
+	}
+
+	submitter := s.order[next]
+	q := s.queues[submitter]
+	job, _ := q.pop()
+	s.size--
+
+	s.tick++
+	s.lastServed[submitter] = s.tick
+	if q.len() == 0 {
+		delete(s.queues, submitter)
+		s.order = append(s.order[:next], s.order[next+1:]...)
+		s.forgetIdle()
+	}
+
+	return job, true
+}
+
+func (s *fairScheduler) drop(jobID string) []TileJob {
+	var dropped []TileJob
+	for i := 0; i < len(s.order); i++ {
+		submitter := s.order[i]
+		q := s.queues[submitter]
+		dropped = append(dropped, q.drop(jobID)...)
+		if q.len() == 0 {
+			delete(s.queues, submitter)
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			i--
+		}
+	}
+	s.size -= len(dropped)
+	return dropped
+}
+
+// forgetIdle drops the ticks of the idle submitters served before all the pending ones,
+// they're ordered first either way, like the submitters which were never served
+func (s *fairScheduler) forgetIdle() {
+	oldest := s.tick
+	for _, submitter := range s.order {
+		oldest = min(oldest, s.lastServed[submitter])
+	}
+	for submitter, tick := range s.lastServed {
+		if _, pending := s.queues[submitter]; !pending && tick < oldest {
+			delete(s.lastServed, submitter)
+		}
+	}
+}
+
+// before reports whether job a should be dispatched before job b
+func (s *fairScheduler) before(a, b TileJob) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return s.lastServed[a.Submitter] < s.lastServed[b.Submitter]
+}
+
+func (s *fairScheduler) len() int {
+	return s.size
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	// enqueue order: a big low priority request from "a", then small requests from "b" and "c"
+	jobs := []TileJob{
+		{Submitter: "a", TileNum: 0},
+		{Submitter: "a", TileNum: 1},
+		{Submitter: "a", TileNum: 2},
+		{Submitter: "a", TileNum: 3},
+		{Submitter: "b", TileNum: 0},
+		{Submitter: "b", TileNum: 1},
+		{Submitter: "c", TileNum: 0, Priority: 10},
+		{Submitter: "a", TileNum: 4, Priority: 10},
+	}
+
+	tests := map[string]struct {
+		policy   SchedulingPolicy
+		expected []string
+	}{
+		"fifo": {
+			policy:   PolicyFIFO,
+			expected: []string{"a0", "a1", "a2", "a3", "b0", "b1", "c0", "a4"},
+		},
+		"priority": {
+			policy:   PolicyPriority,
+			expected: []string{"c0", "a4", "a0", "a1", "a2", "a3", "b0", "b1"},
+		},
+		"fair": {
+			policy:   PolicyFair,
+			expected: []string{"a4", "c0", "b0", "a0", "b1", "a1", "a2", "a3"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := newScheduler(tt.policy)
+			require.NoError(t, err)
+
+			for i, job := range jobs {
+				job.seq = uint64(i + 1)
+				s.push(job)
+			}
+			require.Equal(t, len(jobs), s.len())
+
+			var result []string
+			for {
+				job, ok := s.pop()
+				if !ok {
+					break
+				}
+				result = append(result, job.Submitter+string(rune('0'+job.TileNum)))
+			}
+			require.Equal(t, tt.expected, result)
+			require.Equal(t, 0, s.len())
+		})
+	}
+}
+
+func TestFairScheduler_Interleave(t *testing.T) {
+	s := newFairScheduler()
+	for i := 0; i < 3; i++ {
+		s.push(TileJob{Submitter: "big", TileNum: i, seq: uint64(i)})
+	}
+
+	job, _ := s.pop()
+	require.Equal(t, "big", job.Submitter)
+
+	// late submitter is served right away even though "big" has earlier jobs
+	s.push(TileJob{Submitter: "small", seq: 10})
+	job, _ = s.pop()
+	require.Equal(t, "small", job.Submitter)
+
+	job, _ = s.pop()
+	require.Equal(t, TileJob{Submitter: "big", TileNum: 1, seq: 1}, job)
+}
+
+func TestFairScheduler_Refill(t *testing.T) {
+	s := newFairScheduler()
+	s.push(TileJob{Submitter: "a", seq: 1})
+	s.push(TileJob{Submitter: "b", seq: 2})
+	s.push(TileJob{Submitter: "b", seq: 3})
+
+	job, _ := s.pop()
+	require.Equal(t, "a", job.Submitter)
+
+	// "a" drains its queue and refills it, it's still served after "b" which waits longer
+	s.push(TileJob{Submitter: "a", seq: 4})
+	var result []uint64
+	for s.len() > 0 {
+		job, _ = s.pop()
+		result = append(result, job.seq)
+	}
+	require.Equal(t, []uint64{2, 4, 3}, result)
+
+	// the idle submitters served before the pending ones are forgotten
+	require.Equal(t, map[string]uint64{"b": 4}, s.lastServed)
+}
+
+func TestScheduler_Drop(t *testing.T) {
+	for _, policy := range []SchedulingPolicy{PolicyFIFO, PolicyPriority, PolicyFair} {
+		t.Run(string(policy), func(t *testing.T) {
+			s, err := newScheduler(policy)
+			require.NoError(t, err)
+			for i, job := range []TileJob{
+				{JobID: "1d2f", Submitter: "a", TileNum: 0},
+				{JobID: "3e4f", Submitter: "b", TileNum: 0},
+				{JobID: "1d2f", Submitter: "a", TileNum: 1},
+				{JobID: "5a6b", Submitter: "a", TileNum: 0, Priority: 10},
+			} {
+				job.seq = uint64(i + 1)
+				s.push(job)
+			}
+
+			require.Len(t, s.drop("1d2f"), 2)
+			require.Empty(t, s.drop("1d2f"))
+			require.Equal(t, 2, s.len())
+			var result []string
+			for {
+				job, ok := s.pop()
+				if !ok {
+					break
+				}
+				result = append(result, job.JobID)
+			}
+			require.ElementsMatch(t, []string{"3e4f", "5a6b"}, result)
+		})
+	}
+}
@@ -1,15 +1,22 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"distributed-encoder/logging"
 	"distributed-encoder/transcoder"
 	"distributed-encoder/worker"
 )
@@ -17,21 +24,50 @@ import (
 var (
 	// ErrDispatchTimeout is returned when a Dispatch function ends
 	ErrDispatchTimeout = errors.New("dispatch timeout")
+
+	// ErrClosed is returned when the server is closed
+	ErrClosed = errors.New("server is closed")
+
+	// ErrEncodeFailed is the error of the tile the worker reported it couldn't encode
+	ErrEncodeFailed = errors.New("tile can't be encoded")
+
+	// errTileNotSplit fails the tiles of the jobs saved before the frame was split exactly, they aren't built again
+	errTileNotSplit = errors.New("tile is out of the split of the frame")
 )
 
 // EncodeVideoRequest represents parameters of the video encode request
 type EncodeVideoRequest struct {
 	// Tiles is an amount of tiles for the video
-	Tiles int
+	Tiles int `json:"tiles"`
 
 	// Height is a resolution of the video
-	Height int
+	Height int `json:"height"`
 
 	// Width is a resolution of the video
-	Width int
+	Width int `json:"width"`
 
 	// FilePath is a path for the file
-	FilePath string
+	FilePath string `json:"filePath"`
+
+	// Priority is a dispatch priority of the request, higher values are dispatched first
+	Priority int `json:"priority,omitempty"`
+
+	// Submitter identifies a tenant who submitted the request, used for the fair scheduling
+	Submitter string `json:"submitter,omitempty"`
+
+	// CallbackURL receives the job events as signed JSON POST requests
+	CallbackURL string `json:"callbackUrl,omitempty"`
+
+	// JobID is an id of the job chosen by the client, the repeated request with the id returns the existing job
+	JobID string `json:"jobId,omitempty"`
+
+	// IdempotencyKey makes the repeated requests of the submitter return the job created by the first one
+	// within the retention window, it's passed in the Idempotency-Key header and ignored when JobID is set
+	IdempotencyKey string `json:"-"`
+
+	// BatchID is an id of the batch the request is submitted with, it's set by TriggerBatch and
+	// reported in the job status, the batch id of a single request is ignored by the trigger endpoint
+	BatchID string `json:"batchId,omitempty"`
 }
 
 // Store is a store for the service
@@ -45,24 +81,95 @@ type TileStreamer interface {
 	StreamTile(args *transcoder.CropArgs) (io.ReadCloser, error)
 }
 
-type tileJob struct {
-	TileNum int
-	File    string
-	Path    string
-
-	PosX int
-	PosY int
+// DurationProber probes the duration of the source, when TileStreamer implements it
+// the job status contains the progress percentage and ETA of the tiles
+type DurationProber interface {
+	Duration(path string) (time.Duration, error)
+}
 
-	Width  int
-	Height int
+// TileJob describes the tile waiting for the dispatch, it's what the Queue carries
+type TileJob struct {
+	JobID   string `json:"jobId"`
+	TileNum int    `json:"tileNum"`
+	File    string `json:"file"`
+	Path    string `json:"path"`
+
+	PosX int `json:"posX"`
+	PosY int `json:"posY"`
+
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	Priority  int    `json:"priority,omitempty"`
+	Submitter string `json:"submitter,omitempty"`
+	// Retry is the retry of the job the tile is queued by, the tiles queued before the last retry are dropped
+	Retry int `json:"retry,omitempty"`
+
+	// seq is an enqueue order of the job
+	seq        uint64
+	enqueuedAt time.Time
+	// trace is a span context of the trigger
+	trace trace.SpanContext
 }
 
 // Config represents available server configuration
 type Config struct {
+	// LeaseTTL is a time the worker has to upload the result or report the progress of the dispatched tile,
+	// the tile is dispatched again when it's expired, 10 minutes is a default
+	LeaseTTL time.Duration
+
+	// UploadDir keeps the parts of the results uploaded in parts, os.TempDir is used when it's empty
+	UploadDir string
+	// MaxUploadLength is a maximum length of the result uploaded in parts, 4GiB is a default
+	MaxUploadLength int64
+
 	// DispatchTimeout is a maximum wait time for the client per job request session 15 seconds is a default
 	DispatchTimeout time.Duration
 
-	// Store is a store for the results
+	// SchedulingPolicy defines the order of the tiles dispatch, PolicyFIFO is a default
+	SchedulingPolicy SchedulingPolicy
+
+	// JobStore keeps the jobs and the leases of their tiles, the replicas sharing it and the Queue
+	// dispatch the tiles and accept the results of any job, an in-memory store is a default
+	JobStore JobStore
+
+	// Queue carries the tile jobs to Dispatch, e.g. a message broker, an in-memory queue is a default.
+	// SchedulingPolicy and the queue limits apply to the in-memory queue only
+	Queue Queue
+
+	// MaxQueuedTiles is a maximum amount of tiles waiting for the dispatch, 1000 is a default
+	MaxQueuedTiles int
+
+	// MaxQueuedTilesPerSubmitter is a maximum amount of queued tiles of a single submitter, zero means no limit
+	MaxQueuedTilesPerSubmitter int
+
+	// IdempotencyKeyTTL is a time the idempotency keys of the requests are kept, 24 hours is a default
+	IdempotencyKeyTTL time.Duration
+
+	// JobRetention is a time the finished jobs are kept since their last update when JobStore isn't set,
+	// the jobs are in memory then. 24 hours is a default
+	JobRetention time.Duration
+
+	// Webhook configures the delivery of the job events to the request's CallbackURL
+	Webhook WebhookConfig
+
+	// Watch configures the input folders the new video files are triggered from, nothing is watched by default
+	Watch WatchConfig
+
+	// MetricsRegisterer registers the server metrics, metrics are not collected when it's nil
+	MetricsRegisterer prometheus.Registerer
+
+	// Logger is a logger of the server, slog.Default is used when it's nil
+	Logger *slog.Logger
+
+	// InputRoots are the directories the input files are allowed from, any absolute path is allowed when it's empty
+	InputRoots []string
+
+	// EncodeProfile identifies the encoding settings of the workers in the keys of the cached tiles,
+	// transcoder.Profile is a default
+	EncodeProfile string
+
+	// Store is a store for the results, the tiles are cached in it when it implements ObjectCopier
 	Store Store
 	// TileStreamer is a video tile stream
 	TileStreamer TileStreamer
@@ -71,10 +178,25 @@ type Config struct {
 // Server splits a video file into tile jobs and distributes it as a byte stream to clients
 type Server struct {
 	store        Store
+	cache        *tileCache
 	tileStreamer TileStreamer
+	inputRoots   []string
 
 	dispatchTimeout time.Duration
-	jobChan         chan tileJob
+	idempotencyTTL  time.Duration
+	queue           Queue
+	jobs            *jobRegistry
+	leases          *leaseTable
+	uploads         *uploadTable
+	workers         *workerTable
+	stopReaper      context.CancelFunc
+	stopWatcher     context.CancelFunc
+	watcherDone     chan struct{}
+	webhooks        *webhookNotifier
+	events          *eventBroker
+	metrics         *serverMetrics
+	log             *slog.Logger
+	newID           func() string
 }
 
 // New creates a new server
@@ -88,60 +210,281 @@ func New(cfg Config) (*Server, error) {
 	if cfg.DispatchTimeout == time.Duration(0) {
 		cfg.DispatchTimeout = 15 * time.Second
 	}
+	if cfg.MaxQueuedTiles == 0 {
+		cfg.MaxQueuedTiles = 1000
+	}
+	if cfg.EncodeProfile == "" {
+		cfg.EncodeProfile = transcoder.Profile
+	}
+	if cfg.IdempotencyKeyTTL <= 0 {
+		cfg.IdempotencyKeyTTL = defaultIdempotencyKeyTTL
+	}
+	if cfg.SchedulingPolicy == "" {
+		cfg.SchedulingPolicy = PolicyFIFO
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.JobStore == nil {
+		store := newMemoryJobStore()
+		store.retention = cfg.JobRetention
+		if store.retention <= 0 {
+			store.retention = defaultJobRetention
+		}
+		cfg.JobStore = store
+	}
+	// the tiles queued in memory by the previous process are lost
+	queueLost := cfg.Queue == nil
+	if cfg.Queue == nil {
+		sched, err := newScheduler(cfg.SchedulingPolicy)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Queue = newJobQueue(sched, queueLimits{
+			total:        cfg.MaxQueuedTiles,
+			perSubmitter: cfg.MaxQueuedTilesPerSubmitter,
+		})
+	}
 
 	s := &Server{
 		store:           cfg.Store,
+		cache:           newTileCache(cfg.Store, cfg.EncodeProfile),
 		tileStreamer:    cfg.TileStreamer,
+		inputRoots:      cfg.InputRoots,
 		dispatchTimeout: cfg.DispatchTimeout,
+		idempotencyTTL:  cfg.IdempotencyKeyTTL,
+
+		queue:    cfg.Queue,
+		jobs:     newJobRegistry(cfg.JobStore),
+		leases:   newLeaseTable(cfg.JobStore, cfg.LeaseTTL),
+		uploads:  newUploadTable(cfg.UploadDir, cfg.MaxUploadLength),
+		webhooks: newWebhookNotifier(cfg.Webhook),
+		events:   newEventBroker(),
+		log:      cfg.Logger.With(logging.ComponentKey, "server"),
+		newID:    newJobID,
+	}
+	s.jobs.log = s.log
+	s.workers = newWorkerTable(s.leases.ttl)
+	s.webhooks.log = cfg.Logger.With(logging.ComponentKey, "webhook")
+	s.events.log = cfg.Logger.With(logging.ComponentKey, "events")
+	var err error
+	if s.metrics, err = newServerMetrics(cfg.MetricsRegisterer, s.queueDepth); err != nil {
+		return nil, err
+	}
+
+	if err := s.recoverJobs(queueLost); err != nil {
+		return nil, fmt.Errorf("jobs can't be recovered: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopReaper = cancel
+	go s.reapLeases(ctx, s.leases.ttl/4)
 
-		jobChan: make(chan tileJob),
+	watcher, err := newFolderWatcher(cfg.Watch, cfg.InputRoots, s.TriggerWork, cfg.Logger.With(logging.ComponentKey, "watch"))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if watcher != nil {
+		ctx, s.stopWatcher = context.WithCancel(context.Background())
+		s.watcherDone = make(chan struct{})
+		go func() {
+			defer close(s.watcherDone)
+			watcher.run(ctx)
+		}()
 	}
 
 	return s, nil
 }
 
-// TriggerWork triggers video encoding work
-func (s *Server) TriggerWork(request EncodeVideoRequest) error {
-	log.Printf("Work is triggered %+v", request)
-	if !s.store.HasObject(request.FilePath) {
-		return fmt.Errorf("file: %s is not found in a storage", request.FilePath)
+// TriggerWork triggers video encoding work and returns the status of the created job
+// All the tiles of the request are enqueued at once, *QueueFullError is returned when queue limits are reached.
+// The repeated request with the same JobID or IdempotencyKey returns the status of the existing job,
+// ErrIdempotencyMismatch is returned when the repeated request differs from the first one.
+// *ValidationError is returned for the invalid fields of the request and the missing input file
+func (s *Server) TriggerWork(request EncodeVideoRequest) (status *JobStatus, err error) {
+	id := request.JobID
+	if id == "" {
+		id = s.newID()
 	}
-	go func() {
-		buildCropJobs(request, func(job tileJob) {
-			log.Printf("[Job] enqueued: %s-%v", job.Path, job.TileNum)
-			s.jobChan <- job
-		})
+	_, span := tracer().Start(context.Background(), "server.TriggerWork", trace.WithAttributes(
+		attribute.String("job.id", id),
+		attribute.String("job.file", request.FilePath),
+		attribute.Int("job.tiles", request.Tiles),
+	))
+	defer func() {
+		endSpan(span, err)
 	}()
 
-	return nil
+	log := s.log.With(slog.String(logging.JobIDKey, id))
+	log.Debug("work is triggered", slog.Any("request", request))
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	if request.CallbackURL != "" {
+		if err := s.webhooks.checkCallback(request.CallbackURL); err != nil {
+			return nil, invalidField("callbackUrl", err)
+		}
+	}
+	if request.FilePath, err = resolveInput(request.FilePath, s.inputRoots); errors.Is(err, ErrFileNotFound) {
+		return nil, invalidField("filePath", err)
+	} else if err != nil {
+		return nil, err
+	}
+	if !s.store.HasObject(request.FilePath) {
+		return nil, invalidField("filePath", fmt.Errorf("%w: %s", ErrFileNotFound, request.FilePath))
+	}
+
+	// the job id chosen by the client makes the request idempotent by itself
+	if request.IdempotencyKey != "" && request.JobID == "" {
+		boundID, reserveErr := s.jobs.store.ReserveKey(idempotencyKey(request), id, time.Now(), s.idempotencyTTL)
+		if reserveErr != nil {
+			return nil, fmt.Errorf("idempotency key can't be reserved: %w", reserveErr)
+		}
+		if boundID != id {
+			log.Info("request is repeated", slog.String("existing_job_id", boundID))
+			return s.existingJob(boundID, request)
+		}
+		defer func() {
+			if err != nil {
+				s.releaseKey(request, id)
+			}
+		}()
+	}
+
+	var jobs []TileJob
+	now := time.Now()
+	buildCropJobs(request, func(job TileJob) {
+		job.JobID = id
+		job.enqueuedAt = now
+		job.trace = span.SpanContext()
+		jobs = append(jobs, job)
+	})
+
+	var duration time.Duration
+	if prober, ok := s.tileStreamer.(DurationProber); ok {
+		var err error
+		if duration, err = prober.Duration(request.FilePath); err != nil {
+			log.Warn("duration is unknown, progress won't be calculated", logging.Err(err))
+		}
+	}
+
+	// job is registered before the push, so its tiles can't be dispatched before it's known
+	created, err := s.jobs.add(id, request, jobs, duration)
+	if errors.Is(err, ErrJobExists) && request.JobID != "" {
+		log.Info("request is repeated")
+		return s.existingJob(id, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the hook is registered before the push too, so the events of the dispatched tiles are delivered through it
+	if request.CallbackURL != "" {
+		s.webhooks.register(id, request.CallbackURL)
+	}
+	if err := s.queue.Push(jobs...); err != nil {
+		s.webhooks.unregister(id)
+		s.jobs.remove(id)
+		return nil, err
+	}
+	log.Info("job is enqueued",
+		slog.String("file", request.FilePath),
+		slog.Int("tiles", len(jobs)),
+		slog.Int("priority", request.Priority),
+		slog.String("submitter", request.Submitter),
+	)
+
+	s.publish(newJobEvent(EventJobAccepted, created, created.CreatedAt))
+	// the queued tiles encoded before are completed once the source is hashed, the dispatch drops them
+	s.cache.lookup(jobs, log, func(keys []string, cached []bool) {
+		s.publish(s.jobs.tilesCached(id, keys, cached)...)
+	})
+
+	return &created, nil
+}
+
+// JobStatus returns the current status of the job
+func (s *Server) JobStatus(id string) (*JobStatus, error) {
+	status, err := s.jobs.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ListJobs returns the page of the jobs selected by the filter with their statistics, the latest jobs first
+func (s *Server) ListJobs(filter JobFilter) (*JobList, error) {
+	return s.jobs.list(filter)
+}
+
+// SubscribeEvents subscribes for the job events, the returned status is a snapshot of the job at the moment of subscription
+// The channel is closed when cancel is called or the server is closed
+func (s *Server) SubscribeEvents(id string) (status *JobStatus, events <-chan Event, cancel func(), err error) {
+	events, cancel = s.events.subscribe(id)
+	status, err = s.JobStatus(id)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return status, events, cancel, nil
 }
 
-// Dispatch sends a tile job stream when jobs are requested
+// Dispatch sends a tile job stream to the worker when jobs are requested
 // When timeout is reached returns ErrDispatchTimeout error
-func (s *Server) Dispatch() (*worker.Job, error) {
-	select {
-	case job := <-s.jobChan:
-		log.Printf("Dispatching job: %s, tile: %v", job.Path, job.TileNum)
-		stream, err := s.tileStreamer.StreamTile(&transcoder.CropArgs{
-			Input:  job.Path,
-			X:      job.PosX,
-			Y:      job.PosY,
-			Height: job.Height,
-			Width:  job.Width,
-		})
-		if err != nil {
-			return nil, err
-		}
+func (s *Server) Dispatch(workerID string) (*worker.Job, error) {
+	pollFinished := s.metrics.pollStarted()
+	s.workers.seen(workerID)
+	job, err := popTimeout(s.nextTile, s.dispatchTimeout)
+	pollFinished()
+	if err != nil {
+		return nil, err
+	}
 
-		return &worker.Job{
-			TileName: generateTileName(job.File, job.TileNum),
-			Width:    job.Width,
-			Height:   job.Height,
-			Src:      stream,
-		}, nil
-	case <-time.After(s.dispatchTimeout):
-		return nil, ErrDispatchTimeout
+	ctx := trace.ContextWithSpanContext(context.Background(), job.trace)
+	ctx, span := tracer().Start(ctx, "server.Dispatch", tileAttributes(job),
+		trace.WithAttributes(attribute.String("worker.id", workerID)))
+
+	log := s.log.With(logging.Job(job.JobID, job.TileNum), slog.String(logging.WorkerIDKey, workerID))
+	log.Info("dispatching tile", slog.String("file", job.Path))
+	stream, err := s.tileStreamer.StreamTile(&transcoder.CropArgs{
+		Input:  job.Path,
+		X:      job.PosX,
+		Y:      job.PosY,
+		Height: job.Height,
+		Width:  job.Width,
+	})
+	if err != nil {
+		log.Error("tile stream can't be started", logging.Err(err))
+		endSpan(span, err)
+		s.publish(s.jobs.tileFinished(job.JobID, job.TileNum, err)...)
+		s.ackTile(job)
+		return nil, err
+	}
+	s.publish(s.jobs.tileDispatched(job.JobID, job.TileNum, workerID)...)
+	s.metrics.dispatched(job.enqueuedAt)
+	lease, err := s.leases.issue(job)
+	if err != nil {
+		log.Error("tile is requeued", logging.Err(err))
+		endSpan(span, err)
+		if stream != nil {
+			stream.Close()
+		}
+		s.requeue(job)
+		s.ackTile(job)
+		return nil, err
 	}
+	s.ackTile(job)
+
+	return &worker.Job{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		TileName: generateTileName(job.File, job.TileNum),
+		Width:    job.Width,
+		Height:   job.Height,
+		Lease:    lease,
+		Trace:    injectTrace(ctx),
+		Src:      endSpanOnClose(s.metrics.countStreamed(stream), span),
+	}, nil
 }
 
 func generateTileName(filename string, tileNum int) string {
@@ -150,47 +493,197 @@ func generateTileName(filename string, tileNum int) string {
 	return fmt.Sprint(name, "_tile_", tileNum)
 }
 
-// AcceptResult receives the result stream and saves it to the store
-func (s *Server) AcceptResult(name string, input io.Reader) error {
-	if err := s.store.WriteObject(name, input); err != nil {
+// AcceptResult receives the result stream of the dispatched tile and saves it to the store under the job,
+// ErrTileNotLeased and ErrInvalidResultName are returned for the results the server didn't ask for,
+// only the first upload with the current lease of the tile is accepted, stale and duplicate uploads are discarded
+func (s *Server) AcceptResult(result *worker.Result) (err error) {
+	_, span := tracer().Start(extractTrace(result.Trace), "server.AcceptResult", trace.WithAttributes(
+		attribute.String("job.id", result.JobID),
+		attribute.Int("tile.num", result.TileNum),
+		attribute.String("result.name", result.FileName),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+	log := s.log.With(logging.Job(result.JobID, result.TileNum))
+
+	tile, err := s.jobs.runningTile(result.JobID, result.TileNum)
+	if err != nil {
+		log.Warn("result is rejected", logging.Err(err))
+		return err
+	}
+	key, err := resultKey(result.JobID, tile.Name, result.FileName)
+	if err != nil {
+		log.Warn("result is rejected", logging.Err(err))
+		return err
+	}
+	job, err := s.leases.claim(result.JobID, result.TileNum, result.Lease)
+	if err != nil {
+		log.Warn("result is discarded", logging.Err(err))
+		return err
+	}
+
+	started := time.Now()
+	counter := &countingReader{r: s.metrics.countReceived(result.Src)}
+	var src io.Reader
+	if result.Src != nil {
+		src = &renewingReader{
+			r:       counter,
+			every:   s.leases.ttl / 3,
+			now:     s.leases.now,
+			renewed: s.leases.now(),
+			renew: func() error {
+				return s.leases.renew(result.JobID, result.TileNum, result.Lease)
+			},
+		}
+	}
+	err = s.store.WriteObject(key, src)
+	s.metrics.resultWritten(started)
+	if err := s.leases.finish(result.JobID, result.TileNum, result.Lease); err != nil {
+		log.Warn("result is discarded", logging.Err(err))
+		return err
+	}
+
+	if err != nil {
+		// the upload may be interrupted or the store unavailable for a while, another worker encodes the tile again
+		log.Error("result can't be stored", logging.Err(err))
+		if s.requeue(job) {
+			log.Warn("tile is requeued")
+		}
 		return err
 	}
+	s.cache.save(tile, key, log)
+	s.publish(s.jobs.tileStored(result.JobID, result.TileNum, counter.n)...)
+	log.Info("result is stored", slog.String("key", key))
 	return nil
 }
 
+// ReportProgress updates the encoding progress of the tile and renews its lease,
+// the progress without the current lease is rejected
+func (s *Server) ReportProgress(progress *worker.Progress) error {
+	if err := s.leases.renew(progress.JobID, progress.TileNum, progress.Lease); err != nil {
+		return err
+	}
+	events, err := s.jobs.tileProgress(progress.JobID, progress.TileNum, progress)
+	if err != nil {
+		return err
+	}
+	s.publish(events...)
+	return nil
+}
+
+// maxFailureLength bounds the error reported by the worker, the tail of the encoder output is kept
+const maxFailureLength = 4096
+
+// ReportFailure fails the tile the worker couldn't encode, the failure of the stale lease is rejected.
+// The lease is dropped, so the tile isn't dispatched again when the lease would expire
+func (s *Server) ReportFailure(failure *worker.Failure) error {
+	log := s.log.With(logging.Job(failure.JobID, failure.TileNum))
+	if _, err := s.leases.revoke(failure.JobID, failure.TileNum, failure.Lease); err != nil {
+		log.Warn("failure is discarded", logging.Err(err))
+		return err
+	}
+
+	msg := failure.Error
+	if len(msg) > maxFailureLength {
+		msg = strings.ToValidUTF8(msg[len(msg)-maxFailureLength:], "")
+	}
+	err := fmt.Errorf("%w: %s", ErrEncodeFailed, msg)
+	s.publish(s.jobs.tileFinished(failure.JobID, failure.TileNum, err)...)
+	log.Warn("tile is failed by the worker", logging.Err(err))
+	return nil
+}
+
+// publish delivers the events to the subscribers
+func (s *Server) publish(events ...Event) {
+	for _, e := range events {
+		s.webhooks.notify(e)
+		s.events.publish(e)
+		s.metrics.observeEvent(e)
+	}
+}
+
+// QueueDepth returns amount of tiles waiting for the dispatch
+func (s *Server) QueueDepth() int {
+	return s.queue.Len()
+}
+
+func (s *Server) queueDepth() float64 {
+	return float64(s.queue.Len())
+}
+
+// Close stops the dispatching of the queued jobs
 func (s *Server) Close() error {
-	close(s.jobChan)
+	if s.stopWatcher != nil {
+		s.stopWatcher()
+		<-s.watcherDone
+	}
+	if s.stopReaper != nil {
+		s.stopReaper()
+	}
+	s.cache.close()
+	if s.uploads != nil {
+		s.uploads.close()
+	}
+	_ = s.queue.Close()
+	s.events.close()
+	s.webhooks.close()
 	return nil
 }
 
-func buildCropJobs(req EncodeVideoRequest, jobFunc func(tileJob)) {
+func buildCropJobs(req EncodeVideoRequest, jobFunc func(TileJob)) {
 	_, file := path.Split(req.FilePath)
 
 	cols, rows := calcColumnRows(req.Tiles)
 
-	wRes := req.Width / cols
-	hRes := req.Height / rows
+	// the columns and the rows are even for yuv420p, the last ones take the remainder of the frame
+	wRes := (req.Width / cols) &^ 1
+	hRes := (req.Height / rows) &^ 1
 
 	tileNum := 0
-	for x := 0; x < req.Width; x += wRes {
-		for y := 0; y < req.Height; y += hRes {
-			jobFunc(tileJob{
+	for col := range cols {
+		x, width := col*wRes, wRes
+		if col == cols-1 {
+			width = req.Width - x
+		}
+		for row := range rows {
+			y, height := row*hRes, hRes
+			if row == rows-1 {
+				height = req.Height - y
+			}
+			jobFunc(TileJob{
 				TileNum: tileNum,
 				File:    file,
 				Path:    req.FilePath,
 				PosX:    x,
 				PosY:    y,
-				Width:   wRes,
-				Height:  hRes,
+				Width:   width,
+				Height:  height,
+
+				Priority:  req.Priority,
+				Submitter: req.Submitter,
 			})
 			tileNum++
 		}
 	}
 }
 
+// calcColumnRows splits the tiles into the columns and the rows, the split is exact when cols*rows == tiles
 func calcColumnRows(tiles int) (col int, rows int) {
 	numColumns := int(math.Sqrt(float64(tiles)))
 	numRows := tiles / numColumns
 
 	return numColumns, numRows
 }
+
+// countingReader counts the bytes of the result read by the store
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"errors"
 	"io"
+	"log/slog"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +15,8 @@ import (
 	"distributed-encoder/worker"
 )
 
+var errFake = errors.New("fake")
+
 func TestNew(t *testing.T) {
 	store := &FSObjectStore{}
 	encoder := &transcoder.Transcoder{}
@@ -45,6 +49,14 @@ func TestNew(t *testing.T) {
 				dispatchTimeout: 15 * time.Second,
 			},
 		},
+		"unknown policy": {
+			cfg: Config{
+				SchedulingPolicy: "random",
+				Store:            store,
+				TileStreamer:     encoder,
+			},
+			wantErr: true,
+		},
 		"no store": {
 			cfg: Config{
 				TileStreamer: encoder,
@@ -69,21 +81,105 @@ func TestNew(t *testing.T) {
 			require.Equal(t, tt.want.store, got.store)
 			require.Equal(t, tt.want.tileStreamer, got.tileStreamer)
 			require.Equal(t, tt.want.dispatchTimeout, got.dispatchTimeout)
-			require.NotNil(t, got.jobChan)
+			require.IsType(t, &jobQueue{}, got.queue)
+			require.Equal(t, queueLimits{total: 1000}, got.queue.(*jobQueue).limits)
+			require.Equal(t, defaultLeaseTTL, got.leases.ttl)
+			require.NoError(t, got.Close())
 		})
 	}
 }
 
 func TestServer_AcceptResult(t *testing.T) {
-	var mock storeMock
-	s := Server{
-		store: &mock,
+	tests := map[string]struct {
+		result  worker.Result
+		wantKey string
+		wantErr error
+	}{
+		"dispatched tile": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: "input_tile_0.ts"},
+			wantKey: "1d2f/input_tile_0.ts",
+		},
+		"parent directory": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: "../input_tile_0.ts"},
+			wantErr: ErrInvalidResultName,
+		},
+		"nested traversal": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: "input_tile_0.ts/../../../etc/cron.d/x"},
+			wantErr: ErrInvalidResultName,
+		},
+		"absolute path": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: "/etc/passwd"},
+			wantErr: ErrInvalidResultName,
+		},
+		"windows separator": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: `..\input_tile_0.ts`},
+			wantErr: ErrInvalidResultName,
+		},
+		"name of the other tile": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: "input_tile_1.ts"},
+			wantErr: ErrInvalidResultName,
+		},
+		"tile is not dispatched": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 1, FileName: "input_tile_1.ts"},
+			wantErr: ErrTileNotLeased,
+		},
+		"stale lease": {
+			result:  worker.Result{JobID: "1d2f", TileNum: 0, FileName: "input_tile_0.ts", Lease: "stale"},
+			wantErr: ErrLeaseInvalid,
+		},
+		"unknown job": {
+			result:  worker.Result{JobID: "3e4f", TileNum: 0, FileName: "input_tile_0.ts"},
+			wantErr: ErrTileNotLeased,
+		},
 	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var store storeMock
+			jobStore := newMemoryJobStore()
+			s := Server{
+				store:    &store,
+				jobs:     newJobRegistry(jobStore),
+				webhooks: newWebhookNotifier(WebhookConfig{}),
+				events:   newEventBroker(),
+				leases:   newLeaseTable(jobStore, time.Minute),
+				log:      slog.Default(),
+			}
+			var tiles []TileJob
+			buildCropJobs(EncodeVideoRequest{Tiles: 2, Width: 10, Height: 10, FilePath: "/videos/input.mp4"}, func(job TileJob) {
+				tiles = append(tiles, job)
+			})
+			s.jobs.add("1d2f", EncodeVideoRequest{}, tiles, 0)
+			s.jobs.tileDispatched("1d2f", 0, "worker-1")
+			lease, err := s.leases.issue(TileJob{JobID: "1d2f", TileNum: 0})
+			require.NoError(t, err)
+			if tt.result.Lease == "" {
+				tt.result.Lease = lease
+			}
 
-	reader := strings.NewReader("file")
-	mock.On("WriteObject", "input.mp4", reader).Once()
-	err := s.AcceptResult("input.mp4", strings.NewReader("file"))
-	require.NoError(t, err)
+			reader := strings.NewReader("file")
+			if tt.wantKey != "" {
+				store.On("WriteObject", tt.wantKey, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+					b, err := io.ReadAll(args.Get(1).(io.Reader))
+					require.NoError(t, err)
+					require.Equal(t, "file", string(b))
+				})
+			}
+			tt.result.Src = reader
+			err = s.AcceptResult(&tt.result)
+			require.ErrorIs(t, err, tt.wantErr)
+			store.AssertExpectations(t)
+
+			// the rejected upload doesn't fail the tile
+			status, _ := s.jobs.get("1d2f")
+			if tt.wantErr != nil {
+				require.Equal(t, StateRunning, status.Tiles[0].State)
+				return
+			}
+			rec, err := s.jobs.store.LoadJob("1d2f")
+			require.NoError(t, err)
+			require.Equal(t, int64(len("file")), rec.Attempts[0].Bytes)
+		})
+	}
 }
 
 type storeMock struct {
@@ -91,27 +187,38 @@ type storeMock struct {
 }
 
 func (s *storeMock) WriteObject(key string, src io.Reader) error {
-	return nil
+	args := s.Mock.Called(key, src)
+	return args.Error(0)
 }
 
 func (s *storeMock) HasObject(key string) bool {
-	panic("implement me")
+	args := s.Mock.Called(key)
+	return args.Bool(0)
 }
 
 func TestServer_Dispatch(t *testing.T) {
 	var streamer streamerMock
+	jobStore := newMemoryJobStore()
 	s := Server{
 		dispatchTimeout: 1 * time.Millisecond,
 		tileStreamer:    &streamer,
-		jobChan:         make(chan tileJob),
+		queue:           newJobQueue(&fifoScheduler{}, queueLimits{}),
+		jobs:            newJobRegistry(jobStore),
+		webhooks:        newWebhookNotifier(WebhookConfig{}),
+		events:          newEventBroker(),
+		leases:          newLeaseTable(jobStore, time.Minute),
+		log:             slog.Default(),
 	}
 
-	_, err := s.Dispatch()
+	_, err := s.Dispatch("worker-1")
 	require.Equal(t, ErrDispatchTimeout, err)
 
 	s.dispatchTimeout = 5 * time.Second
+	_, err = s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{{TileNum: 0, File: "file"}}, 0)
+	require.NoError(t, err)
 	go func() {
-		s.jobChan <- tileJob{
+		_ = s.queue.Push(TileJob{
+			JobID:   "1d2f",
 			TileNum: 0,
 			File:    "file",
 			Path:    "path",
@@ -119,7 +226,7 @@ func TestServer_Dispatch(t *testing.T) {
 			PosY:    2,
 			Width:   3,
 			Height:  4,
-		}
+		})
 	}()
 	streamer.On("StreamTile", &transcoder.CropArgs{
 		Input:  "path",
@@ -128,17 +235,35 @@ func TestServer_Dispatch(t *testing.T) {
 		Width:  3,
 		Height: 4,
 	}).Once()
-	job, err := s.Dispatch()
+	job, err := s.Dispatch("worker-1")
 
 	require.NoError(t, err)
+	require.NotEmpty(t, job.Lease)
 	require.Equal(t, &worker.Job{
+		JobID:    "1d2f",
 		TileName: "file_tile_0",
+		Lease:    job.Lease,
 		Height:   4,
 		Width:    3,
 		Src:      nil,
 	}, job)
 }
 
+func TestServer_DispatchClosed(t *testing.T) {
+	s := Server{
+		dispatchTimeout: 5 * time.Second,
+		queue:           newJobQueue(&fifoScheduler{}, queueLimits{}),
+		webhooks:        newWebhookNotifier(WebhookConfig{}),
+		events:          newEventBroker(),
+		log:             slog.Default(),
+	}
+	go s.Close()
+
+	_, err := s.Dispatch("worker-1")
+	require.Equal(t, ErrClosed, err)
+	require.Equal(t, ErrClosed, s.queue.Push(TileJob{}))
+}
+
 type streamerMock struct {
 	mock.Mock
 }
@@ -150,7 +275,7 @@ func (e *streamerMock) StreamTile(args *transcoder.CropArgs) (io.ReadCloser, err
 func Test_buildCropJobs(t *testing.T) {
 	tests := map[string]struct {
 		req      EncodeVideoRequest
-		expected []tileJob
+		expected []TileJob
 	}{
 		"4 tiles": {
 			req: EncodeVideoRequest{
@@ -158,7 +283,7 @@ func Test_buildCropJobs(t *testing.T) {
 				Height: 1280,
 				Width:  720,
 			},
-			expected: []tileJob{
+			expected: []TileJob{
 				{
 					TileNum: 0,
 					PosX:    0,
@@ -196,7 +321,7 @@ func Test_buildCropJobs(t *testing.T) {
 				Width:    720,
 				FilePath: "/tmp/v.mp4",
 			},
-			expected: []tileJob{
+			expected: []TileJob{
 				{
 					TileNum: 0,
 					File:    "v.mp4",
@@ -217,15 +342,63 @@ func Test_buildCropJobs(t *testing.T) {
 				},
 			},
 		},
+		"frame not divisible by the tiles": {
+			req: EncodeVideoRequest{
+				Tiles:  4,
+				Height: 10,
+				Width:  14,
+			},
+			expected: []TileJob{
+				{TileNum: 0, PosX: 0, PosY: 0, Width: 6, Height: 4},
+				{TileNum: 1, PosX: 0, PosY: 4, Width: 6, Height: 6},
+				{TileNum: 2, PosX: 6, PosY: 0, Width: 8, Height: 4},
+				{TileNum: 3, PosX: 6, PosY: 4, Width: 8, Height: 6},
+			},
+		},
+		"6 tiles": {
+			req: EncodeVideoRequest{
+				Tiles:  6,
+				Height: 14,
+				Width:  10,
+			},
+			expected: []TileJob{
+				{TileNum: 0, PosX: 0, PosY: 0, Width: 4, Height: 4},
+				{TileNum: 1, PosX: 0, PosY: 4, Width: 4, Height: 4},
+				{TileNum: 2, PosX: 0, PosY: 8, Width: 4, Height: 6},
+				{TileNum: 3, PosX: 4, PosY: 0, Width: 6, Height: 4},
+				{TileNum: 4, PosX: 4, PosY: 4, Width: 6, Height: 4},
+				{TileNum: 5, PosX: 4, PosY: 8, Width: 6, Height: 6},
+			},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			var result []tileJob
-			buildCropJobs(tt.req, func(job tileJob) {
+			var result []TileJob
+			buildCropJobs(tt.req, func(job TileJob) {
 				result = append(result, job)
 			})
 
 			require.Equal(t, tt.expected, result)
 		})
 	}
+
+	// the tiles cover the frame exactly and their sizes are even
+	var checked int
+	for tiles := 1; tiles <= 16; tiles++ {
+		req := EncodeVideoRequest{Tiles: tiles, Width: 1000, Height: 778, FilePath: "/videos/video.mp4"}
+		if req.Validate() != nil {
+			continue
+		}
+		var area, count int
+		buildCropJobs(req, func(job TileJob) {
+			require.Zero(t, job.Width%2)
+			require.Zero(t, job.Height%2)
+			area += job.Width * job.Height
+			count++
+		})
+		checked++
+		require.Equal(t, tiles, count)
+		require.Equal(t, req.Width*req.Height, area)
+	}
+	require.Equal(t, 10, checked)
 }
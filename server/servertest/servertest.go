@@ -0,0 +1,86 @@
+// Package servertest has the fixtures of the server tests shared by the packages of the queues and the job stores
+package servertest
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/server"
+	"distributed-encoder/transcoder"
+)
+
+// Tile is the content of the tiles streamed by TileStreamer
+const Tile = "tile"
+
+// MemoryStore keeps the results in memory, any source file exists in it
+type MemoryStore struct {
+	mu      sync.Mutex
+	results map[string]string
+}
+
+var _ server.Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{results: make(map[string]string)}
+}
+
+// WriteObject keeps the result under the key
+func (s *MemoryStore) WriteObject(key string, src io.Reader) error {
+	b, err := io.ReadAll(src)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[key] = string(b)
+	return err
+}
+
+// HasObject reports that any source file exists
+func (s *MemoryStore) HasObject(key string) bool {
+	return true
+}
+
+// Result returns the result stored under the key
+func (s *MemoryStore) Result(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.results[key]
+}
+
+// Len returns amount of the stored results
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.results)
+}
+
+// TileStreamer streams the same Tile for any crop
+type TileStreamer struct{}
+
+// StreamTile returns the stream of Tile
+func (TileStreamer) StreamTile(args *transcoder.CropArgs) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(Tile)), nil
+}
+
+// NewServer creates the server closed on the test cleanup, the empty Store and TileStreamer of cfg
+// are a new MemoryStore and TileStreamer, the dispatch waits for a second by default
+func NewServer(t testing.TB, cfg server.Config) *server.Server {
+	t.Helper()
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
+	if cfg.TileStreamer == nil {
+		cfg.TileStreamer = TileStreamer{}
+	}
+	if cfg.DispatchTimeout == 0 {
+		cfg.DispatchTimeout = time.Second
+	}
+	s, err := server.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
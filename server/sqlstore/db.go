@@ -0,0 +1,376 @@
+// Package sqlstore keeps the jobs and the tile queue in a SQLite database shared by the server replicas of the same host,
+// the database is in the WAL mode which doesn't work over the network file systems. The replicas don't elect a leader, the job records and the leases of their tiles are changed with compare-and-swap.
+// The tiles and the dispatch attempts of the jobs are copied to their own tables to query the history
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"distributed-encoder/server"
+)
+
+// busyTimeout is a time the connection waits for the lock of the database held by another replica
+const busyTimeout = 5 * time.Second
+
+// DB is a server.JobStore in SQLite
+type DB struct {
+	db *sql.DB
+}
+
+var _ server.JobStore = (*DB)(nil)
+
+// Open opens the database file and migrates its schema, the replicas open the same file
+func Open(path string) (*DB, error) {
+	// the transactions take the write lock at once, so the concurrent replicas wait for each other instead of failing
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)",
+		path, busyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("schema can't be migrated: %w", err)
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// CreateJob saves a new job record, server.ErrJobExists is returned when the id is taken
+func (d *DB) CreateJob(rec server.JobRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	createdAt := rec.Status.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	res, err := tx.Exec(`INSERT INTO jobs (id, version, record, state, file, submitter, batch_id, lease_expiry, created_at, updated_at)
+		VALUES (?, 1, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		rec.Status.ID, data, rec.Status.State, rec.Status.Request.FilePath, rec.Status.Request.Submitter, rec.Status.Request.BatchID,
+		leaseExpiry(&rec), createdAt.UnixNano(), now.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return server.ErrJobExists
+	}
+	if err := saveHistory(tx, &rec, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadJob returns the job record, server.ErrJobNotFound is returned when there is no such job
+func (d *DB) LoadJob(id string) (server.JobRecord, error) {
+	var rec server.JobRecord
+	var data []byte
+	err := d.db.QueryRow(`SELECT version, record FROM jobs WHERE id = ?`, id).Scan(&rec.Version, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return server.JobRecord{}, server.ErrJobNotFound
+	}
+	if err != nil {
+		return server.JobRecord{}, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return server.JobRecord{}, fmt.Errorf("job %s record can't be decoded: %w", id, err)
+	}
+	return rec, nil
+}
+
+// UpdateJob saves the record when its version is unchanged since the load, server.ErrVersionConflict is returned otherwise
+func (d *DB) UpdateJob(rec *server.JobRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the saved record is read in the transaction to write only the history rows changed since it
+	var saved []byte
+	err = tx.QueryRow(`SELECT record FROM jobs WHERE id = ? AND version = ?`, rec.Status.ID, rec.Version).Scan(&saved)
+	if errors.Is(err, sql.ErrNoRows) {
+		return server.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	var prev server.JobRecord
+	if err := json.Unmarshal(saved, &prev); err != nil {
+		return fmt.Errorf("job %s record can't be decoded: %w", rec.Status.ID, err)
+	}
+
+	res, err := tx.Exec(`UPDATE jobs SET version = version + 1, record = ?, state = ?, lease_expiry = ?, updated_at = ? WHERE id = ? AND version = ?`,
+		data, rec.Status.State, leaseExpiry(rec), time.Now().UnixNano(), rec.Status.ID, rec.Version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return server.ErrVersionConflict
+	}
+	if err := saveHistory(tx, rec, &prev); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	rec.Version++
+	return nil
+}
+
+// saveHistory copies the tiles and the attempts of the record changed since prev to their tables,
+// all of them are copied when prev is nil
+func saveHistory(tx *sql.Tx, rec, prev *server.JobRecord) error {
+	for i, tile := range rec.Status.Tiles {
+		if prev != nil && i < len(prev.Status.Tiles) && !tileChanged(prev.Status.Tiles[i], tile) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO tiles (job_id, num, name, state, worker, error) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (job_id, num) DO UPDATE SET state = excluded.state, worker = excluded.worker, error = excluded.error`,
+			rec.Status.ID, tile.Num, tile.Name, tile.State, tile.Worker, tile.Error); err != nil {
+			return err
+		}
+	}
+	for seq, attempt := range rec.Attempts {
+		if prev != nil && seq < len(prev.Attempts) && !attemptChanged(prev.Attempts[seq], attempt) {
+			continue
+		}
+		var finishedAt any
+		if !attempt.FinishedAt.IsZero() {
+			finishedAt = attempt.FinishedAt.UnixNano()
+		}
+		if _, err := tx.Exec(`INSERT INTO attempts (job_id, seq, tile_num, worker, state, error, started_at, finished_at, bytes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (job_id, seq) DO UPDATE SET state = excluded.state, error = excluded.error,
+				finished_at = excluded.finished_at, bytes = excluded.bytes`,
+			rec.Status.ID, seq, attempt.TileNum, attempt.Worker, attempt.State, attempt.Error,
+			attempt.StartedAt.UnixNano(), finishedAt, attempt.Bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tileChanged reports whether the columns of the tile row differ
+func tileChanged(prev, tile server.TileStatus) bool {
+	return prev.State != tile.State || prev.Worker != tile.Worker || prev.Error != tile.Error
+}
+
+// attemptChanged reports whether the updated columns of the attempt row differ
+func attemptChanged(prev, attempt server.TileAttempt) bool {
+	return prev.State != attempt.State || prev.Error != attempt.Error ||
+		!prev.FinishedAt.Equal(attempt.FinishedAt) || prev.Bytes != attempt.Bytes
+}
+
+// DeleteJob drops the job record with its history
+func (d *DB) DeleteJob(id string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM jobs WHERE id = ?`,
+		`DELETE FROM tiles WHERE job_id = ?`,
+		`DELETE FROM attempts WHERE job_id = ?`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ExpiredLeases returns the ids of the jobs which have unclaimed leases expired at now
+func (d *DB) ExpiredLeases(now time.Time) ([]string, error) {
+	rows, err := d.db.Query(`SELECT id FROM jobs WHERE lease_expiry < ?`, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListJobs returns the records of the jobs selected by the filter, the latest jobs first
+func (d *DB) ListJobs(filter server.JobFilter) ([]server.JobRecord, error) {
+	where, args := jobConditions(filter)
+	if c := filter.After; c != nil {
+		where += ` AND (created_at < ? OR (created_at = ? AND id > ?))`
+		args = append(args, c.CreatedAt.UnixNano(), c.CreatedAt.UnixNano(), c.ID)
+	}
+	query := `SELECT version, record FROM jobs WHERE ` + where + ` ORDER BY created_at DESC, id`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []server.JobRecord
+	for rows.Next() {
+		var rec server.JobRecord
+		var data []byte
+		if err := rows.Scan(&rec.Version, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("job record can't be decoded: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// SummarizeJobs returns the statistics of all the jobs selected by the filter ignoring its cursor and limit
+func (d *DB) SummarizeJobs(filter server.JobFilter) (server.JobStats, error) {
+	where, args := jobConditions(filter)
+	var stats server.JobStats
+	var encodeTime int64
+	err := d.db.QueryRow(`
+		WITH selected AS (SELECT id FROM jobs WHERE `+where+`)
+		SELECT
+			(SELECT COUNT(*) FROM selected),
+			COUNT(*),
+			COALESCE(SUM(state = 'completed'), 0),
+			COALESCE(SUM(state = 'failed'), 0),
+			(SELECT COUNT(*) FROM attempts WHERE job_id IN selected),
+			(SELECT COALESCE(SUM(finished_at - started_at), 0) FROM attempts WHERE job_id IN selected AND finished_at IS NOT NULL),
+			(SELECT COALESCE(SUM(bytes), 0) FROM attempts WHERE job_id IN selected)
+		FROM tiles WHERE job_id IN selected`, args...).
+		Scan(&stats.Jobs, &stats.Tiles, &stats.CompletedTiles, &stats.FailedTiles, &stats.Attempts, &encodeTime, &stats.Bytes)
+	if err != nil {
+		return server.JobStats{}, err
+	}
+	stats.EncodeTime = time.Duration(encodeTime).Seconds()
+	return stats, nil
+}
+
+// jobConditions returns the WHERE clause selecting the jobs by the filter, the cursor and the limit are not applied
+func jobConditions(filter server.JobFilter) (string, []any) {
+	conds := []string{`1 = 1`}
+	var args []any
+	if len(filter.States) > 0 {
+		conds = append(conds, `state IN (?`+strings.Repeat(`, ?`, len(filter.States)-1)+`)`)
+		for _, state := range filter.States {
+			args = append(args, state)
+		}
+	}
+	if filter.File != "" {
+		conds = append(conds, `(file = ? OR file LIKE ? ESCAPE '\')`)
+		args = append(args, filter.File, "%/"+likeEscaper.Replace(filter.File))
+	}
+	if filter.Submitter != "" {
+		conds = append(conds, `submitter = ?`)
+		args = append(args, filter.Submitter)
+	}
+	if filter.Batch != "" {
+		conds = append(conds, `batch_id = ?`)
+		args = append(args, filter.Batch)
+	}
+	if !filter.Since.IsZero() {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, filter.Until.UnixNano())
+	}
+	return strings.Join(conds, ` AND `), args
+}
+
+// likeEscaper escapes the wildcards of the LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ReserveKey binds the idempotency key to the job id for ttl unless the key is bound and unexpired at now,
+// the id of the job bound to the key is returned
+func (d *DB) ReserveKey(key, jobID string, now time.Time, ttl time.Duration) (string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.UnixNano()); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`INSERT INTO idempotency_keys (key, job_id, expires_at) VALUES (?, ?, ?) ON CONFLICT (key) DO NOTHING`,
+		key, jobID, now.Add(ttl).UnixNano()); err != nil {
+		return "", err
+	}
+	var boundID string
+	if err := tx.QueryRow(`SELECT job_id FROM idempotency_keys WHERE key = ?`, key).Scan(&boundID); err != nil {
+		return "", err
+	}
+	return boundID, tx.Commit()
+}
+
+// ReleaseKey unbinds the key from the job id
+func (d *DB) ReleaseKey(key, jobID string) error {
+	_, err := d.db.Exec(`DELETE FROM idempotency_keys WHERE key = ? AND job_id = ?`, key, jobID)
+	return err
+}
+
+// AcceptNonce records the nonce of the worker signature until it expires, false is returned
+// when the nonce is already accepted by any replica
+func (d *DB) AcceptNonce(nonce string, expires, now time.Time) (bool, error) {
+	if _, err := d.db.Exec(`DELETE FROM nonces WHERE expires_at < ?`, now.UnixNano()); err != nil {
+		return false, err
+	}
+	res, err := d.db.Exec(`INSERT INTO nonces (nonce, expires_at) VALUES (?, ?) ON CONFLICT (nonce) DO NOTHING`,
+		nonce, expires.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// leaseExpiry is the indexed expiry of the record leases, nil when there are no leases
+func leaseExpiry(rec *server.JobRecord) any {
+	expiry, ok := rec.LeaseExpiry()
+	if !ok {
+		return nil
+	}
+	return expiry.UnixNano()
+}
@@ -0,0 +1,489 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/server"
+	"distributed-encoder/server/servertest"
+	"distributed-encoder/worker"
+)
+
+func TestDB_UpdateJob(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.LoadJob("1d2f")
+	require.ErrorIs(t, err, server.ErrJobNotFound)
+
+	expires := time.Unix(1600000000, 0).UTC()
+	require.NoError(t, db.CreateJob(server.JobRecord{Status: server.JobStatus{ID: "1d2f", State: server.StateQueued}}))
+	first, err := db.LoadJob("1d2f")
+	require.NoError(t, err)
+	second, err := db.LoadJob("1d2f")
+	require.NoError(t, err)
+
+	first.Leases = map[int]*server.Lease{0: {Token: "9a1c", Job: server.TileJob{JobID: "1d2f"}, Expires: expires}}
+	require.NoError(t, db.UpdateJob(&first))
+	require.Equal(t, int64(2), first.Version)
+
+	// the record loaded before the update is stale
+	second.Status.State = server.StateFailed
+	require.ErrorIs(t, db.UpdateJob(&second), server.ErrVersionConflict)
+
+	got, err := db.LoadJob("1d2f")
+	require.NoError(t, err)
+	require.Equal(t, server.StateQueued, got.Status.State)
+	require.Equal(t, "9a1c", got.Leases[0].Token)
+	require.Equal(t, expires, got.Leases[0].Expires)
+
+	ids, err := db.ExpiredLeases(expires)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	ids, err = db.ExpiredLeases(expires.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, []string{"1d2f"}, ids)
+
+	// the claim renewed by the upload moves the expiry
+	got.Leases[0].Claimed = true
+	got.Leases[0].Expires = expires.Add(time.Minute)
+	require.NoError(t, db.UpdateJob(&got))
+	ids, err = db.ExpiredLeases(expires.Add(time.Second))
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	ids, err = db.ExpiredLeases(expires.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"1d2f"}, ids)
+
+	require.NoError(t, db.DeleteJob("1d2f"))
+	_, err = db.LoadJob("1d2f")
+	require.ErrorIs(t, err, server.ErrJobNotFound)
+}
+
+// Only the tile and attempt rows changed by the update are written
+func TestDB_UpdateJobHistory(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	started := time.Unix(1600000000, 0).UTC()
+	require.NoError(t, db.CreateJob(server.JobRecord{
+		Status: server.JobStatus{ID: "1d2f", State: server.StateRunning, Tiles: []server.TileStatus{
+			{Num: 0, Name: "video_tile_0", State: server.StateRunning, Worker: "worker-1"},
+			{Num: 1, Name: "video_tile_1", State: server.StateRunning, Worker: "worker-2"},
+		}},
+		Attempts: []server.TileAttempt{
+			{TileNum: 0, Worker: "worker-1", State: server.StateRunning, StartedAt: started},
+			{TileNum: 1, Worker: "worker-2", State: server.StateRunning, StartedAt: started},
+		},
+	}))
+	// the rows of the tile 0 are marked to see whether they're written again
+	_, err = db.db.Exec(`UPDATE tiles SET error = 'unchanged' WHERE job_id = '1d2f' AND num = 0`)
+	require.NoError(t, err)
+	_, err = db.db.Exec(`UPDATE attempts SET error = 'unchanged' WHERE job_id = '1d2f' AND seq = 0`)
+	require.NoError(t, err)
+
+	rec, err := db.LoadJob("1d2f")
+	require.NoError(t, err)
+	rec.Status.Tiles[1].State = server.StateCompleted
+	rec.Attempts[1].State = server.StateCompleted
+	rec.Attempts[1].FinishedAt = started.Add(time.Second)
+	rec.Attempts[1].Bytes = 7
+	rec.Attempts = append(rec.Attempts, server.TileAttempt{TileNum: 0, Worker: "worker-3", State: server.StateRunning, StartedAt: started})
+	require.NoError(t, db.UpdateJob(&rec))
+
+	tileRow := func(num int) (state, errMsg string) {
+		require.NoError(t, db.db.QueryRow(`SELECT state, error FROM tiles WHERE job_id = '1d2f' AND num = ?`, num).Scan(&state, &errMsg))
+		return state, errMsg
+	}
+	state, errMsg := tileRow(0)
+	require.Equal(t, "unchanged", errMsg)
+	state, _ = tileRow(1)
+	require.Equal(t, string(server.StateCompleted), state)
+
+	var bytes int64
+	require.NoError(t, db.db.QueryRow(`SELECT error FROM attempts WHERE job_id = '1d2f' AND seq = 0`).Scan(&errMsg))
+	require.Equal(t, "unchanged", errMsg)
+	require.NoError(t, db.db.QueryRow(`SELECT state, bytes FROM attempts WHERE job_id = '1d2f' AND seq = 1`).Scan(&state, &bytes))
+	require.Equal(t, string(server.StateCompleted), state)
+	require.Equal(t, int64(7), bytes)
+	var workerID string
+	require.NoError(t, db.db.QueryRow(`SELECT worker FROM attempts WHERE job_id = '1d2f' AND seq = 2`).Scan(&workerID))
+	require.Equal(t, "worker-3", workerID)
+}
+
+func TestDB_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	initial, err := migrations.ReadFile("migrations/0001_jobs.sql")
+	require.NoError(t, err)
+
+	// the database is created before the history tables
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(string(initial))
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO jobs (id, version, record, created_at, updated_at) VALUES ('1d2f', 1, ?, 0, 0)`,
+		`{"status":{"id":"1d2f","state":"failed","tiles":[{"num":0,"name":"video_tile_0","state":"failed","error":"disk is full"}]}}`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	for range 2 {
+		store, err := Open(path)
+		require.NoError(t, err)
+		recs, err := store.ListJobs(server.JobFilter{States: []server.JobState{server.StateFailed}})
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+
+		var name, errMsg string
+		require.NoError(t, store.db.QueryRow(`SELECT name, error FROM tiles WHERE job_id = '1d2f' AND num = 0`).Scan(&name, &errMsg))
+		require.Equal(t, "video_tile_0", name)
+		require.Equal(t, "disk is full", errMsg)
+		require.NoError(t, store.Close())
+	}
+}
+
+func TestDB_ListJobs(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	created := time.Unix(1600000000, 0).UTC()
+	for i, file := range []string{"/videos/a.mp4", "/videos/b.mp4", "/videos/a_b.mp4", "/other/a.mp4"} {
+		require.NoError(t, db.CreateJob(server.JobRecord{Status: server.JobStatus{
+			ID:        string(rune('a' + i)),
+			State:     server.StateFailed,
+			Request:   server.EncodeVideoRequest{FilePath: file, Submitter: "team-" + string(rune('a'+i%2)), BatchID: "batch-" + string(rune('a'+i/2))},
+			Tiles:     []server.TileStatus{{Num: 0, State: server.StateFailed}},
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+		}}))
+	}
+
+	list := func(filter server.JobFilter) []string {
+		recs, err := db.ListJobs(filter)
+		require.NoError(t, err)
+		var ids []string
+		for _, rec := range recs {
+			ids = append(ids, rec.Status.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []string{"d", "c", "b", "a"}, list(server.JobFilter{}))
+	require.Equal(t, []string{"d", "a"}, list(server.JobFilter{File: "a.mp4"}))
+	require.Equal(t, []string{"a"}, list(server.JobFilter{File: "/videos/a.mp4"}))
+	require.Equal(t, []string{"c", "a"}, list(server.JobFilter{Submitter: "team-a"}))
+	require.Equal(t, []string{"d", "c"}, list(server.JobFilter{Batch: "batch-b"}))
+	require.Equal(t, []string{"c", "b"}, list(server.JobFilter{Since: created.Add(time.Hour), Until: created.Add(3 * time.Hour)}))
+	require.Equal(t, []string{"b", "a"}, list(server.JobFilter{After: &server.JobCursor{CreatedAt: created.Add(2 * time.Hour), ID: "c"}}))
+	require.Equal(t, []string{"d"}, list(server.JobFilter{Limit: 1}))
+
+	stats, err := db.SummarizeJobs(server.JobFilter{Submitter: "team-b", Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, server.JobStats{Jobs: 2, Tiles: 2, FailedTiles: 2}, stats)
+}
+
+func TestDB_ReserveKey(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateJob(server.JobRecord{Status: server.JobStatus{ID: "1d2f"}}))
+	require.ErrorIs(t, db.CreateJob(server.JobRecord{Status: server.JobStatus{ID: "1d2f"}}), server.ErrJobExists)
+
+	now := time.Unix(1600000000, 0)
+	id, err := db.ReserveKey("team-a/a1b2", "1d2f", now, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "1d2f", id)
+	id, err = db.ReserveKey("team-a/a1b2", "3e4f", now.Add(time.Second), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "1d2f", id)
+
+	// the expired key is bound again
+	id, err = db.ReserveKey("team-a/a1b2", "3e4f", now.Add(time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "3e4f", id)
+
+	// only the job bound to the key releases it
+	require.NoError(t, db.ReleaseKey("team-a/a1b2", "1d2f"))
+	id, err = db.ReserveKey("team-a/a1b2", "5a6b", now.Add(time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "3e4f", id)
+	require.NoError(t, db.ReleaseKey("team-a/a1b2", "3e4f"))
+	id, err = db.ReserveKey("team-a/a1b2", "5a6b", now.Add(time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "5a6b", id)
+}
+
+func TestDB_AcceptNonce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	other, err := Open(path)
+	require.NoError(t, err)
+	defer other.Close()
+
+	now := time.Unix(1600000000, 0)
+	accepted, err := db.AcceptNonce("n1", now.Add(time.Minute), now)
+	require.NoError(t, err)
+	require.True(t, accepted)
+	// the nonce accepted by another replica is rejected
+	accepted, err = other.AcceptNonce("n1", now.Add(time.Minute), now)
+	require.NoError(t, err)
+	require.False(t, accepted)
+
+	// the expired nonces are dropped
+	accepted, err = other.AcceptNonce("n2", now.Add(10*time.Minute), now.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.True(t, accepted)
+	var nonces int
+	require.NoError(t, db.db.QueryRow(`SELECT COUNT(*) FROM nonces`).Scan(&nonces))
+	require.Equal(t, 1, nonces)
+}
+
+func TestReplicas_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	handlers := make([]http.HandlerFunc, 2)
+	for i := range handlers {
+		db, err := Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		auth := server.Auth{WorkerSecret: "worker-secret", Nonces: db}
+		handlers[i] = auth.Worker(func(w http.ResponseWriter, req *http.Request) {})
+	}
+
+	// the request accepted by one replica is rejected by the other one
+	req := httptest.NewRequest(http.MethodPost, "/work/progress", http.NoBody)
+	worker.SignRequest(req, "worker-secret", time.Now())
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		handlers[i](w, req)
+		require.Equal(t, want, w.Code, i)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	q := NewQueue(db, QueueConfig{MaxQueuedTiles: 3})
+
+	jobs := []server.TileJob{{JobID: "1d2f", TileNum: 0}, {JobID: "1d2f", TileNum: 1}}
+	require.NoError(t, q.Push(jobs...))
+	var full *server.QueueFullError
+	require.ErrorAs(t, q.Push(jobs...), &full)
+	require.Equal(t, 2, q.Len())
+
+	// the replica polls the jobs pushed by another one
+	other, err := Open(path)
+	require.NoError(t, err)
+	defer other.Close()
+	otherQueue := NewQueue(other, QueueConfig{PollInterval: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	job, err := otherQueue.Pop(ctx)
+	require.NoError(t, err)
+	require.Equal(t, jobs[0], job)
+	job, err = q.Pop(ctx)
+	require.NoError(t, err)
+	require.Equal(t, jobs[1], job)
+
+	popped := make(chan server.TileJob)
+	go func() {
+		job, _ := otherQueue.Pop(ctx)
+		popped <- job
+	}()
+	require.NoError(t, q.Requeue(jobs[1]))
+	require.Equal(t, jobs[1], <-popped)
+
+	require.NoError(t, q.Close())
+	_, err = q.Pop(ctx)
+	require.ErrorIs(t, err, server.ErrClosed)
+	require.ErrorIs(t, q.Push(jobs...), server.ErrClosed)
+}
+
+// newReplica starts a server on the shared database
+func newReplica(t *testing.T, path string, store server.Store) *server.Server {
+	db, err := Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return servertest.NewServer(t, server.Config{
+		JobStore: db,
+		Queue:    NewQueue(db, QueueConfig{PollInterval: 10 * time.Millisecond}),
+		Store:    store,
+	})
+}
+
+func TestReplicas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store := servertest.NewMemoryStore()
+	first := newReplica(t, path, store)
+	second := newReplica(t, path, store)
+
+	status, err := first.TriggerWork(server.EncodeVideoRequest{Tiles: 2, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+
+	// any replica dispatches the tiles and accepts the results of any job
+	tile0, err := second.Dispatch("worker-1")
+	require.NoError(t, err)
+	tile1, err := first.Dispatch("worker-2")
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1}, []int{tile0.TileNum, tile1.TileNum})
+
+	result := func(job *worker.Job) *worker.Result {
+		return &worker.Result{
+			JobID:    job.JobID,
+			TileNum:  job.TileNum,
+			FileName: job.TileName + ".ts",
+			Lease:    job.Lease,
+			Src:      strings.NewReader("encoded"),
+		}
+	}
+	require.NoError(t, first.AcceptResult(result(tile0)))
+	// the lease is already used on the other replica
+	require.ErrorIs(t, second.AcceptResult(result(tile0)), server.ErrTileNotLeased)
+
+	require.NoError(t, second.ReportProgress(&worker.Progress{JobID: tile1.JobID, TileNum: 1, Frame: 10, Lease: tile1.Lease}))
+	got, err := first.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, server.StateRunning, got.State)
+	require.Equal(t, "worker-1", got.Tiles[0].Worker)
+	require.Equal(t, int64(10), got.Tiles[1].Progress.Frame)
+
+	require.NoError(t, second.AcceptResult(result(tile1)))
+	got, err = first.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, server.StateCompleted, got.State)
+	require.Equal(t, 2, store.Len())
+}
+
+func TestReplicas_ConcurrentUpload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store := servertest.NewMemoryStore()
+	replicas := []*server.Server{newReplica(t, path, store), newReplica(t, path, store), newReplica(t, path, store)}
+
+	_, err := replicas[0].TriggerWork(server.EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	job, err := replicas[1].Dispatch("worker-1")
+	require.NoError(t, err)
+
+	// the retries of the upload race on all the replicas, only one of them claims the lease
+	var wg sync.WaitGroup
+	errs := make(chan error, len(replicas))
+	for _, s := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.AcceptResult(&worker.Result{
+				JobID:    job.JobID,
+				TileNum:  job.TileNum,
+				FileName: job.TileName + ".ts",
+				Lease:    job.Lease,
+				Src:      strings.NewReader("encoded"),
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var accepted int
+	for err := range errs {
+		if err == nil {
+			accepted++
+			continue
+		}
+		require.ErrorIs(t, err, server.ErrTileNotLeased)
+	}
+	require.Equal(t, 1, accepted)
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	s := newReplica(t, path, servertest.NewMemoryStore())
+
+	started := time.Now().Add(-time.Second)
+	status, err := s.TriggerWork(server.EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	require.NoError(t, s.AcceptResult(&worker.Result{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		FileName: job.TileName + ".ts",
+		Lease:    job.Lease,
+		Src:      strings.NewReader("encoded"),
+	}))
+
+	list, err := s.ListJobs(server.JobFilter{States: []server.JobState{server.StateCompleted}, Since: started, File: "video.mp4"})
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 1)
+	require.Equal(t, status.ID, list.Jobs[0].ID)
+	stats := list.Jobs[0].Stats
+	require.Equal(t, 1, stats.CompletedTiles)
+	require.Equal(t, 1, stats.Attempts)
+	require.Equal(t, int64(len("encoded")), stats.Bytes)
+	require.Positive(t, stats.EncodeTime)
+	// the summary is calculated by the database
+	require.Equal(t, stats.Bytes, list.Summary.Bytes)
+	require.Equal(t, 1, list.Summary.Jobs)
+	require.InDelta(t, stats.EncodeTime, list.Summary.EncodeTime, 1e-6)
+
+	list, err = s.ListJobs(server.JobFilter{States: []server.JobState{server.StateFailed}})
+	require.NoError(t, err)
+	require.Empty(t, list.Jobs)
+	require.Zero(t, list.Summary)
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	var workerID, state string
+	var finishedAt sql.NullInt64
+	require.NoError(t, db.db.QueryRow(`SELECT worker, state, finished_at FROM attempts WHERE job_id = ? AND tile_num = 0`, status.ID).
+		Scan(&workerID, &state, &finishedAt))
+	require.Equal(t, "worker-1", workerID)
+	require.Equal(t, string(server.StateCompleted), state)
+	require.True(t, finishedAt.Valid)
+}
+
+// The tiles queued in memory are queued again by the restarted server
+func TestRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	start := func() (*server.Server, *DB) {
+		db, err := Open(path)
+		require.NoError(t, err)
+		s, err := server.New(server.Config{
+			JobStore:        db,
+			DispatchTimeout: time.Second,
+			Store:           servertest.NewMemoryStore(),
+			TileStreamer:    servertest.TileStreamer{},
+		})
+		require.NoError(t, err)
+		return s, db
+	}
+
+	s, db := start()
+	status, err := s.TriggerWork(server.EncodeVideoRequest{Tiles: 2, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	require.NoError(t, db.Close())
+
+	s, db = start()
+	defer db.Close()
+	defer s.Close()
+	require.Equal(t, 2, s.QueueDepth())
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	require.Equal(t, status.ID, job.JobID)
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS jobs (
+	id           TEXT PRIMARY KEY,
+	version      INTEGER NOT NULL,
+	record       TEXT NOT NULL,
+	lease_expiry INTEGER,
+	created_at   INTEGER NOT NULL,
+	updated_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_lease_expiry ON jobs (lease_expiry) WHERE lease_expiry IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS queue (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	job TEXT NOT NULL
+);
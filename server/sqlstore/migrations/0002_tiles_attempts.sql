@@ -0,0 +1,36 @@
+-- the tiles and the attempts are copied from the job record on each update, so the history can be queried
+ALTER TABLE jobs ADD COLUMN state TEXT NOT NULL DEFAULT '';
+UPDATE jobs SET state = json_extract(record, '$.status.state');
+CREATE INDEX jobs_state_created_at ON jobs (state, created_at);
+CREATE INDEX jobs_created_at ON jobs (created_at);
+
+CREATE TABLE tiles (
+	job_id TEXT NOT NULL,
+	num    INTEGER NOT NULL,
+	name   TEXT NOT NULL,
+	state  TEXT NOT NULL,
+	worker TEXT NOT NULL,
+	error  TEXT NOT NULL,
+	PRIMARY KEY (job_id, num)
+);
+INSERT INTO tiles (job_id, num, name, state, worker, error)
+SELECT jobs.id,
+	json_extract(tile.value, '$.num'),
+	json_extract(tile.value, '$.name'),
+	json_extract(tile.value, '$.state'),
+	COALESCE(json_extract(tile.value, '$.worker'), ''),
+	COALESCE(json_extract(tile.value, '$.error'), '')
+FROM jobs, json_each(jobs.record, '$.status.tiles') AS tile;
+
+CREATE TABLE attempts (
+	job_id      TEXT NOT NULL,
+	seq         INTEGER NOT NULL,
+	tile_num    INTEGER NOT NULL,
+	worker      TEXT NOT NULL,
+	state       TEXT NOT NULL,
+	error       TEXT NOT NULL,
+	started_at  INTEGER NOT NULL,
+	finished_at INTEGER,
+	PRIMARY KEY (job_id, seq)
+);
+CREATE INDEX attempts_worker ON attempts (worker, started_at);
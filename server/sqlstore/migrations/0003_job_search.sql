@@ -0,0 +1,10 @@
+-- the jobs are searched by the source file and the submitter, the attempts keep the size of the results
+ALTER TABLE jobs ADD COLUMN file TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN submitter TEXT NOT NULL DEFAULT '';
+UPDATE jobs SET
+	file = COALESCE(json_extract(record, '$.status.request.filePath'), ''),
+	submitter = COALESCE(json_extract(record, '$.status.request.submitter'), '');
+CREATE INDEX jobs_file ON jobs (file);
+CREATE INDEX jobs_submitter_created_at ON jobs (submitter, created_at);
+
+ALTER TABLE attempts ADD COLUMN bytes INTEGER NOT NULL DEFAULT 0;
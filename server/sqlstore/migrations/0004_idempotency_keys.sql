@@ -0,0 +1,6 @@
+CREATE TABLE idempotency_keys (
+	key        TEXT PRIMARY KEY,
+	job_id     TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,4 @@
+-- the jobs of a batch are listed by the id of the batch
+ALTER TABLE jobs ADD COLUMN batch_id TEXT NOT NULL DEFAULT '';
+UPDATE jobs SET batch_id = COALESCE(json_extract(record, '$.status.request.batchId'), '');
+CREATE INDEX jobs_batch_id ON jobs (batch_id);
@@ -0,0 +1,6 @@
+-- the nonces of the accepted worker signatures are shared, so a request can't be replayed to another replica
+CREATE TABLE nonces (
+	nonce      TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX nonces_expires_at ON nonces (expires_at);
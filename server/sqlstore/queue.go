@@ -0,0 +1,196 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"distributed-encoder/server"
+)
+
+// defaultPollInterval is how often the consumer checks the jobs pushed by the other replicas
+const defaultPollInterval = 500 * time.Millisecond
+
+// QueueConfig of the queue
+type QueueConfig struct {
+	// MaxQueuedTiles is a maximum amount of queued tiles of all the replicas, zero means no limit
+	MaxQueuedTiles int
+	// PollInterval is how often the waiting consumer checks the jobs pushed by the other replicas, 500ms is a default,
+	// the jobs pushed by the same replica wake it up at once
+	PollInterval time.Duration
+}
+
+// Queue is a server.Queue in the shared database, the tiles are popped in the push order,
+// the scheduling policies and the submitter limits of the server don't apply to it
+type Queue struct {
+	db           *sql.DB
+	limit        int
+	pollInterval time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	// ready is closed and replaced each time jobs are pushed to wake up waiting consumers
+	ready chan struct{}
+}
+
+var _ server.Queue = (*Queue)(nil)
+
+// NewQueue creates the queue in the database
+func NewQueue(db *DB, cfg QueueConfig) *Queue {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	return &Queue{
+		db:           db.db,
+		limit:        cfg.MaxQueuedTiles,
+		pollInterval: cfg.PollInterval,
+		ready:        make(chan struct{}),
+	}
+}
+
+// Push enqueues either all the jobs or none of them, *server.QueueFullError is returned when the limit is reached
+func (q *Queue) Push(jobs ...server.TileJob) error {
+	if q.isClosed() {
+		return server.ErrClosed
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if q.limit > 0 {
+		var depth int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM queue`).Scan(&depth); err != nil {
+			return err
+		}
+		if depth+len(jobs) > q.limit {
+			return &server.QueueFullError{Depth: depth, Limit: q.limit, Requested: len(jobs)}
+		}
+	}
+	for _, job := range jobs {
+		if err := insertJob(tx, job); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	q.wakeUp()
+	return nil
+}
+
+// Requeue enqueues the job again bypassing the limit
+func (q *Queue) Requeue(job server.TileJob) error {
+	if q.isClosed() {
+		return server.ErrClosed
+	}
+	if err := insertJob(q.db, job); err != nil {
+		return err
+	}
+	q.wakeUp()
+	return nil
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertJob(db execer, job server.TileJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO queue (job) VALUES (?)`, data)
+	return err
+}
+
+// Pop waits for the next job until the context is done, a job is taken by a single replica
+func (q *Queue) Pop(ctx context.Context) (server.TileJob, error) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.mu.Lock()
+		closed, ready := q.closed, q.ready
+		q.mu.Unlock()
+		if closed {
+			return server.TileJob{}, server.ErrClosed
+		}
+
+		job, ok, err := q.take()
+		if err != nil || ok {
+			return job, err
+		}
+
+		select {
+		case <-ready:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return server.TileJob{}, ctx.Err()
+		}
+	}
+}
+
+// take deletes the first job of the queue, the single statement is atomic between the replicas.
+// It's not interrupted by the context of Pop, so the deleted job can't be lost
+func (q *Queue) take() (server.TileJob, bool, error) {
+	var data []byte
+	err := q.db.QueryRow(`DELETE FROM queue WHERE seq = (SELECT MIN(seq) FROM queue) RETURNING job`).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return server.TileJob{}, false, nil
+	}
+	if err != nil {
+		return server.TileJob{}, false, err
+	}
+
+	var job server.TileJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return server.TileJob{}, false, fmt.Errorf("job can't be decoded: %w", err)
+	}
+	return job, true, nil
+}
+
+// Len returns amount of queued jobs of all the replicas, zero is returned when the database isn't available
+func (q *Queue) Len() int {
+	var n int
+	if err := q.db.QueryRow(`SELECT COUNT(*) FROM queue`).Scan(&n); err != nil {
+		return 0
+	}
+	return n
+}
+
+// Close wakes up waiting consumers and rejects new jobs, the queued jobs stay in the database
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.ready)
+	return nil
+}
+
+func (q *Queue) wakeUp() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	close(q.ready)
+	q.ready = make(chan struct{})
+}
+
+func (q *Queue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
@@ -2,48 +2,155 @@ package server
 
 import (
 	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
+
+	"distributed-encoder/logging"
 )
 
-// FSObjectStore represents simple file storage
+// FSObjectStore represents simple file storage, objects are written under the Path only
 type FSObjectStore struct {
 	Path string
 }
 
-// WriteObjects create a file and write data from src reader
-func (s *FSObjectStore) WriteObject(key string, src io.Reader) error {
-	f, err := ioutil.TempFile(s.Path, key)
+var _ ObjectCopier = (*FSObjectStore)(nil)
+
+// WriteObject writes data from src reader to the key under the Path,
+// the object appears when it's fully written, keys escaping the Path are rejected
+func (s *FSObjectStore) WriteObject(key string, src io.Reader) (err error) {
+	if !filepath.IsLocal(key) {
+		return fmt.Errorf("object key %q is outside of the store", key)
+	}
+	root, err := os.OpenRoot(s.root())
 	if err != nil {
 		return err
 	}
-	writer := bufio.NewWriter(f)
-	defer writer.Flush()
+	defer root.Close()
 
-	if _, err := io.Copy(writer, src); err != nil {
-		log.Println("Error store: ", err)
-		s.Remove(f.Name())
+	if dir := path.Dir(key); dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
 
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	tmp := key + "." + hex.EncodeToString(suffix) + ".tmp"
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			s.remove(root, tmp)
+		}
+	}()
 
-	return nil
+	writer := bufio.NewWriter(f)
+	if _, err := io.Copy(writer, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return root.Rename(tmp, key)
 }
 
-// Remove removes file from the fs
-func (FSObjectStore) Remove(key string) {
-	if err := os.Remove(key); err != nil {
-		log.Println("can't remove file: ", err)
+func (s FSObjectStore) root() string {
+	if s.Path == "" {
+		return "."
+	}
+	return s.Path
+}
+
+// Remove removes the object under the Path, keys escaping the Path are not removed
+func (s FSObjectStore) Remove(key string) {
+	if !filepath.IsLocal(key) {
+		slog.Warn("file outside of the store can't be removed", slog.String(logging.ComponentKey, "store"), slog.String("file", key))
+		return
+	}
+	root, err := os.OpenRoot(s.root())
+	if err != nil {
+		slog.Warn("file can't be removed", slog.String(logging.ComponentKey, "store"), slog.String("file", key), logging.Err(err))
+		return
+	}
+	defer root.Close()
+	s.remove(root, key)
+}
+
+func (FSObjectStore) remove(root *os.Root, key string) {
+	if err := root.Remove(key); err != nil {
+		slog.Warn("file can't be removed", slog.String(logging.ComponentKey, "store"), slog.String("file", key), logging.Err(err))
 	}
 }
 
 // HasObject checks does object exists
 func (FSObjectStore) HasObject(key string) bool {
 	info, err := os.Stat(key)
-	if os.IsNotExist(err) {
+	if err != nil {
 		return false
 	}
 	return !info.IsDir()
 }
+
+// HasStoredObject checks does object exists under the Path, keys escaping the Path are not found
+func (s FSObjectStore) HasStoredObject(key string) bool {
+	if !filepath.IsLocal(key) {
+		return false
+	}
+	return s.HasObject(filepath.Join(s.root(), key))
+}
+
+// CopyObject copies the object under the Path to another key, the copy is a hard link when it's possible
+func (s *FSObjectStore) CopyObject(src, dst string) error {
+	if !filepath.IsLocal(src) || !filepath.IsLocal(dst) {
+		return fmt.Errorf("object keys %q and %q must be inside of the store", src, dst)
+	}
+	root, err := os.OpenRoot(s.root())
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	// the rename of a link over the same file does nothing, so the link would be left
+	if srcInfo, err := root.Stat(src); err != nil {
+		return err
+	} else if dstInfo, err := root.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return nil
+	}
+
+	if dir := path.Dir(dst); dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	tmp := dst + "." + hex.EncodeToString(suffix) + ".tmp"
+	if err := root.Link(src, tmp); err == nil {
+		if err := root.Rename(tmp, dst); err != nil {
+			s.remove(root, tmp)
+			return err
+		}
+		return nil
+	}
+
+	f, err := root.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.WriteObject(dst, f)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSObjectStore_WriteObject(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+	store := FSObjectStore{Path: dir}
+
+	require.NoError(t, store.WriteObject("1d2f/video_tile_0.ts", strings.NewReader("encoded")))
+	data, err := os.ReadFile(filepath.Join(dir, "1d2f", "video_tile_0.ts"))
+	require.NoError(t, err)
+	require.Equal(t, "encoded", string(data))
+
+	for _, key := range []string{
+		"../video_tile_0.ts",
+		"1d2f/../../video_tile_0.ts",
+		filepath.Join(outside, "video_tile_0.ts"),
+		"link/video_tile_0.ts",
+		"",
+	} {
+		require.Error(t, store.WriteObject(key, strings.NewReader("encoded")), key)
+	}
+
+	entries, err := os.ReadDir(outside)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	// no temporary files are left
+	entries, err = os.ReadDir(filepath.Join(dir, "1d2f"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestFSObjectStore_Remove(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "video_tile_0.ts"), []byte("encoded"), 0644))
+	store := FSObjectStore{Path: dir}
+	require.NoError(t, store.WriteObject("1d2f/video_tile_0.ts", strings.NewReader("encoded")))
+
+	store.Remove("1d2f/video_tile_0.ts")
+	require.False(t, store.HasObject("1d2f/video_tile_0.ts"))
+	// the missing objects and the keys escaping the path are ignored
+	store.Remove("1d2f/video_tile_0.ts")
+	store.Remove(filepath.Join(outside, "video_tile_0.ts"))
+	store.Remove("../" + filepath.Base(outside) + "/video_tile_0.ts")
+	require.FileExists(t, filepath.Join(outside, "video_tile_0.ts"))
+}
+
+func TestFSObjectStore_HasObject(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "video.mp4"), []byte("video"), 0644))
+
+	store := FSObjectStore{}
+	require.True(t, store.HasObject(filepath.Join(dir, "video.mp4")))
+	require.False(t, store.HasObject(dir))
+	require.False(t, store.HasObject(filepath.Join(dir, "missing.mp4")))
+
+}
+
+func TestFSObjectStore_HasStoredObject(t *testing.T) {
+	dir := t.TempDir()
+	store := FSObjectStore{Path: filepath.Join(dir, "results")}
+	require.NoError(t, os.Mkdir(store.Path, 0755))
+	require.NoError(t, store.WriteObject("tiles/a1b2.ts", strings.NewReader("encoded")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "video.mp4"), []byte("video"), 0644))
+
+	require.True(t, store.HasStoredObject("tiles/a1b2.ts"))
+	require.False(t, store.HasStoredObject("tiles"))
+	require.False(t, store.HasStoredObject("tiles/c3d4.ts"))
+	// the keys are under the path only, the source files are checked by HasObject
+	require.False(t, store.HasStoredObject("../video.mp4"))
+	require.False(t, store.HasStoredObject(filepath.Join(dir, "video.mp4")))
+}
+
+func TestFSObjectStore_CopyObject(t *testing.T) {
+	dir := t.TempDir()
+	store := FSObjectStore{Path: dir}
+	require.NoError(t, store.WriteObject("1d2f/video_tile_0.ts", strings.NewReader("encoded")))
+
+	require.NoError(t, store.CopyObject("1d2f/video_tile_0.ts", "tiles/a1b2.ts"))
+	// the existing object is replaced
+	require.NoError(t, store.CopyObject("1d2f/video_tile_0.ts", "tiles/a1b2.ts"))
+	data, err := os.ReadFile(filepath.Join(dir, "tiles", "a1b2.ts"))
+	require.NoError(t, err)
+	require.Equal(t, "encoded", string(data))
+
+	require.Error(t, store.CopyObject("1d2f/missing.ts", "tiles/c3d4.ts"))
+	require.Error(t, store.CopyObject("1d2f/video_tile_0.ts", "../video_tile_0.ts"))
+	entries, err := os.ReadDir(filepath.Join(dir, "tiles"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
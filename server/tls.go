@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"distributed-encoder/worker"
+)
+
+// LoadTLSConfig loads the server certificate, client certificates are verified against clientCAFile when it's set,
+// the certificate is optional on the handshake, so the job API stays available without it, see Auth.RequireClientCert
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't load server certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA %s has no certificates", clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return cfg, nil
+}
+
+// clientCertIdentity returns the common name of the verified client certificate, or its first DNS name,
+// false is returned without a verified certificate or when the certificate names no one
+func clientCertIdentity(req *http.Request) (string, bool) {
+	return certIdentity(req.TLS)
+}
+
+func certIdentity(state *tls.ConnectionState) (string, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := state.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, true
+	}
+	if len(cert.DNSNames) > 0 && cert.DNSNames[0] != "" {
+		return cert.DNSNames[0], true
+	}
+	return "", false
+}
+
+// workerIdentity identifies the worker by its client certificate, the self-reported id is used without it.
+// The self-reported id is informational only, the results are authorized by the leases
+func workerIdentity(req *http.Request) string {
+	if id, ok := clientCertIdentity(req); ok {
+		return id
+	}
+	return req.Header.Get(worker.WorkerIDHeader)
+}
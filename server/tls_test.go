@@ -0,0 +1,211 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+// testPKI is a CA with the server and worker certificates generated for the test
+type testPKI struct {
+	caFile, serverCert, serverKey, workerCert, workerKey, untrustedCert, untrustedKey string
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	dir := t.TempDir()
+	ca, caKey := newCert(t, nil, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "encoder-ca"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	other, otherKey := newCert(t, nil, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+
+	pki := testPKI{caFile: writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw)}
+	pki.serverCert, pki.serverKey = writeCert(t, dir, "server", ca, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "encoder-server"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	pki.workerCert, pki.workerKey = writeCert(t, dir, "worker", ca, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "worker-7"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	pki.untrustedCert, pki.untrustedKey = writeCert(t, dir, "untrusted", other, otherKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "worker-7"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return pki
+}
+
+func newCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writeCert(t *testing.T, dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, tmpl *x509.Certificate) (string, string) {
+	cert, key := newCert(t, ca, caKey, tmpl)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, dir, name+".pem", "CERTIFICATE", cert.Raw), writePEM(t, dir, name+"-key.pem", "EC PRIVATE KEY", der)
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+func TestCertIdentity(t *testing.T) {
+	verified := func(cert *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	tests := map[string]struct {
+		state  *tls.ConnectionState
+		wantID string
+		wantOK bool
+	}{
+		"common name":   {state: verified(&x509.Certificate{Subject: pkix.Name{CommonName: "worker-7"}, DNSNames: []string{"w7.local"}}), wantID: "worker-7", wantOK: true},
+		"dns name":      {state: verified(&x509.Certificate{DNSNames: []string{"w7.local"}}), wantID: "w7.local", wantOK: true},
+		"no names":      {state: verified(&x509.Certificate{})},
+		"not verified":  {state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "worker-7"}}}}},
+		"no connection": {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			id, ok := certIdentity(tt.state)
+			require.Equal(t, tt.wantID, id)
+			require.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tlsConfig, err := LoadTLSConfig(pki.serverCert, pki.serverKey, pki.caFile)
+	require.NoError(t, err)
+
+	var serviceMock serverMock
+	serviceMock.On("ReportProgress", mock.Anything).Return(nil)
+	var identity string
+	auth := Auth{RequireClientCert: true}
+	ts := httptest.NewUnstartedServer(auth.Worker(func(w http.ResponseWriter, req *http.Request) {
+		identity = workerIdentity(req)
+		HTTPHandler{Service: &serviceMock}.ReportProgress(w, req)
+	}))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := map[string]struct {
+		cfg           worker.ClientConfig
+		wantErr       error
+		wantHandshake bool
+	}{
+		"worker certificate": {
+			cfg: worker.ClientConfig{CAFile: pki.caFile, CertFile: pki.workerCert, KeyFile: pki.workerKey},
+		},
+		"no certificate": {
+			cfg:     worker.ClientConfig{CAFile: pki.caFile},
+			wantErr: worker.ErrUnauthorized,
+		},
+		"untrusted certificate": {
+			// the certificate of the other CA is not sent on the handshake
+			cfg:     worker.ClientConfig{CAFile: pki.caFile, CertFile: pki.untrustedCert, KeyFile: pki.untrustedKey},
+			wantErr: worker.ErrUnauthorized,
+		},
+		"untrusted server": {
+			cfg:           worker.ClientConfig{CertFile: pki.workerCert, KeyFile: pki.workerKey},
+			wantHandshake: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			identity = ""
+			tt.cfg.PollEndpoint = ts.URL + "/work/jobs"
+			tt.cfg.ResultEndpoint = ts.URL + "/work/result"
+			tt.cfg.ProgressEndpoint = ts.URL + "/work/progress"
+			tt.cfg.WorkerID = "spoofed"
+			c, err := worker.NewClient(tt.cfg)
+			require.NoError(t, err)
+
+			err = c.ReportProgress(&worker.Progress{JobID: "1d2f"})
+			switch {
+			case tt.wantHandshake:
+				var urlErr *url.Error
+				require.ErrorAs(t, err, &urlErr)
+				require.Empty(t, identity)
+			case tt.wantErr != nil:
+				require.ErrorIs(t, err, tt.wantErr)
+			default:
+				require.NoError(t, err)
+				// the certificate identity is preferred over the self-reported one
+				require.Equal(t, "worker-7", identity)
+			}
+		})
+	}
+}
+
+func TestWorkerIdentity(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/work/jobs", http.NoBody)
+	require.Empty(t, workerIdentity(req))
+
+	req.Header.Set(worker.WorkerIDHeader, "worker-1")
+	require.Equal(t, "worker-1", workerIdentity(req))
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+
+	cfg, err := LoadTLSConfig(pki.serverCert, pki.serverKey, "")
+	require.NoError(t, err)
+	require.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	cfg, err = LoadTLSConfig(pki.serverCert, pki.serverKey, pki.caFile)
+	require.NoError(t, err)
+	require.Equal(t, tls.VerifyClientCertIfGiven, cfg.ClientAuth)
+
+	_, err = LoadTLSConfig(pki.serverCert, pki.serverKey, pki.serverKey)
+	require.Error(t, err)
+	_, err = LoadTLSConfig(pki.serverCert, pki.workerKey, "")
+	require.Error(t, err)
+}
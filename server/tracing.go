@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "distributed-encoder/server"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+func tileAttributes(job TileJob) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("job.id", job.JobID),
+		attribute.Int("tile.num", job.TileNum),
+		attribute.String("tile.file", job.File),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTrace returns the trace context of ctx, nil when there is nothing to propagate
+func injectTrace(ctx context.Context) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+func extractTrace(carrier propagation.MapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+}
+
+// endSpanOnClose ends the span when the stream is closed, so the span covers the whole streaming
+func endSpanOnClose(stream io.ReadCloser, span trace.Span) io.ReadCloser {
+	if stream == nil {
+		span.End()
+		return nil
+	}
+	return &spanReadCloser{ReadCloser: stream, span: span}
+}
+
+type spanReadCloser struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+func (r *spanReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	endSpan(r.span, err)
+	return err
+}
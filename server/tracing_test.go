@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"distributed-encoder/worker"
+)
+
+func TestServer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	store.On("WriteObject", "1d2f/video_tile_0.ts", mock.Anything).Return(nil)
+
+	s, err := New(Config{Store: &store, TileStreamer: &streamerMock{}})
+	require.NoError(t, err)
+	defer s.Close()
+	s.newID = func() string { return "1d2f" }
+
+	_, err = s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	require.NotEmpty(t, job.Trace)
+
+	// the trace context is passed to the worker and back in the headers
+	header := http.Header{}
+	worker.MarshalJobToHeader(job, header)
+	received, err := worker.ParseJobFromHTTP(&http.Response{Header: header, Body: io.NopCloser(strings.NewReader(""))})
+	require.NoError(t, err)
+
+	require.NoError(t, s.AcceptResult(&worker.Result{
+		JobID:    received.JobID,
+		Lease:    received.Lease,
+		TileNum:  received.TileNum,
+		FileName: "video_tile_0.ts",
+		Trace:    received.Trace,
+		Src:      strings.NewReader("encoded"),
+	}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	trigger, dispatch, accept := spans[0], spans[1], spans[2]
+	require.Equal(t, "server.TriggerWork", trigger.Name())
+	require.Equal(t, "server.Dispatch", dispatch.Name())
+	require.Equal(t, "server.AcceptResult", accept.Name())
+
+	require.Equal(t, trigger.SpanContext().TraceID(), dispatch.SpanContext().TraceID())
+	require.Equal(t, trigger.SpanContext().SpanID(), dispatch.Parent().SpanID())
+	require.Equal(t, dispatch.SpanContext().SpanID(), accept.Parent().SpanID())
+}
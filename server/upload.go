@@ -0,0 +1,321 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/worker"
+)
+
+var (
+	// ErrUploadNotFound happens when the upload is unknown, completed or abandoned
+	ErrUploadNotFound = errors.New("upload is not found")
+	// ErrUploadIncomplete happens when the upload is completed before all its bytes are received
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrUploadTooLarge happens when the part exceeds the length of the upload
+	ErrUploadTooLarge = errors.New("part exceeds the upload length")
+	// ErrUploadTooLong happens when the length of the upload exceeds the limit
+	ErrUploadTooLong = errors.New("upload length exceeds the limit")
+)
+
+// defaultMaxUploadLength is a maximum length of the result uploaded in parts
+const defaultMaxUploadLength = 4 << 30
+
+// OffsetMismatchError is returned when the part doesn't start at the end of the received bytes
+type OffsetMismatchError struct {
+	// Offset is an amount of the received bytes, the part should start at it
+	Offset int64
+	// Given is the offset of the rejected part
+	Given int64
+}
+
+func (e *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("upload offset is %v, part at %v is given", e.Offset, e.Given)
+}
+
+// upload is a result received in parts, the parts are appended to the spool file
+type upload struct {
+	mu      sync.Mutex
+	id      string
+	result  worker.Result
+	length  int64
+	offset  int64
+	file    *os.File
+	updated time.Time
+	// done is set when the upload is completed or dropped, its file is removed then
+	done bool
+}
+
+func (u *upload) status() *worker.UploadStatus {
+	return &worker.UploadStatus{ID: u.id, Offset: u.offset, Length: u.length}
+}
+
+// discard removes the spool file, u.mu must be held
+func (u *upload) discard() {
+	u.done = true
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
+
+// uploadTable keeps the uploads in progress, a single one per lease, an upload is locked before the table
+type uploadTable struct {
+	mu        sync.Mutex
+	dir       string
+	maxLength int64
+	uploads   map[string]*upload
+	// leases are the ids of the uploads by their lease tokens
+	leases map[string]string
+	now    func() time.Time
+}
+
+func newUploadTable(dir string, maxLength int64) *uploadTable {
+	if maxLength <= 0 {
+		maxLength = defaultMaxUploadLength
+	}
+	return &uploadTable{
+		dir:       dir,
+		maxLength: maxLength,
+		uploads:   make(map[string]*upload),
+		leases:    make(map[string]string),
+		now:       time.Now,
+	}
+}
+
+// add adds the upload and returns the previous upload of its lease, which is superseded
+func (t *uploadTable) add(u *upload) *upload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.uploads[t.leases[u.result.Lease]]
+	if prev != nil {
+		delete(t.uploads, prev.id)
+	}
+	t.uploads[u.id] = u
+	t.leases[u.result.Lease] = u.id
+	return prev
+}
+
+func (t *uploadTable) get(id string) (*upload, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.uploads[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	return u, nil
+}
+
+func (t *uploadTable) remove(u *upload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.uploads, u.id)
+	if t.leases[u.result.Lease] == u.id {
+		delete(t.leases, u.result.Lease)
+	}
+}
+
+func (t *uploadTable) list() []*upload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	uploads := make([]*upload, 0, len(t.uploads))
+	for _, u := range t.uploads {
+		uploads = append(uploads, u)
+	}
+	return uploads
+}
+
+// expire drops the uploads which didn't receive parts for the ttl and returns their amount
+func (t *uploadTable) expire(ttl time.Duration) int {
+	var expired int
+	for _, u := range t.list() {
+		u.mu.Lock()
+		if !u.done && t.now().Sub(u.updated) > ttl {
+			t.remove(u)
+			u.discard()
+			expired++
+		}
+		u.mu.Unlock()
+	}
+	return expired
+}
+
+// close drops all the uploads
+func (t *uploadTable) close() {
+	for _, u := range t.list() {
+		u.mu.Lock()
+		if !u.done {
+			t.remove(u)
+			u.discard()
+		}
+		u.mu.Unlock()
+	}
+}
+
+// InitiateUpload starts the upload of the result in parts, the result is checked the same way as in AcceptResult,
+// the lease is renewed but not claimed until the upload is completed. The upload supersedes the previous upload
+// with the lease, ErrUploadTooLong is returned when the length exceeds the limit
+func (s *Server) InitiateUpload(result *worker.Result, length int64) (*worker.UploadStatus, error) {
+	log := s.log.With(logging.Job(result.JobID, result.TileNum))
+
+	if length > s.uploads.maxLength {
+		return nil, fmt.Errorf("%w: %v of %v bytes", ErrUploadTooLong, length, s.uploads.maxLength)
+	}
+
+	tile, err := s.jobs.runningTile(result.JobID, result.TileNum)
+	if err != nil {
+		log.Warn("upload is rejected", logging.Err(err))
+		return nil, err
+	}
+	if _, err := resultKey(result.JobID, tile.Name, result.FileName); err != nil {
+		log.Warn("upload is rejected", logging.Err(err))
+		return nil, err
+	}
+	if err := s.leases.renew(result.JobID, result.TileNum, result.Lease); err != nil {
+		log.Warn("upload is rejected", logging.Err(err))
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(s.uploads.dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("can't create upload: %w", err)
+	}
+	u := &upload{
+		id: newJobID(),
+		result: worker.Result{
+			JobID:    result.JobID,
+			TileNum:  result.TileNum,
+			FileName: result.FileName,
+			Lease:    result.Lease,
+			Trace:    result.Trace,
+		},
+		length:  length,
+		file:    file,
+		updated: s.uploads.now(),
+	}
+	if prev := s.uploads.add(u); prev != nil {
+		prev.mu.Lock()
+		if !prev.done {
+			prev.discard()
+		}
+		prev.mu.Unlock()
+		log.Warn("upload is superseded", "upload_id", prev.id)
+	}
+	log.Debug("upload is started", "upload_id", u.id, "length", length)
+
+	return u.status(), nil
+}
+
+// UploadPart appends the part at the offset to the upload, the part has to start at the end of the received bytes,
+// otherwise *OffsetMismatchError is returned. The bytes received before an error are kept, so the part can be resumed
+func (s *Server) UploadPart(id string, offset int64, src io.Reader) (*worker.UploadStatus, error) {
+	u, err := s.uploads.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.done {
+		return nil, ErrUploadNotFound
+	}
+	if offset != u.offset {
+		return nil, &OffsetMismatchError{Offset: u.offset, Given: offset}
+	}
+	if err := s.leases.renew(u.result.JobID, u.result.TileNum, u.result.Lease); err != nil {
+		return nil, s.dropLeaseLost(u, err)
+	}
+
+	n, err := io.Copy(u.file, io.LimitReader(src, u.length-u.offset+1))
+	u.offset += n
+	u.updated = s.uploads.now()
+	if err != nil {
+		return u.status(), fmt.Errorf("part is interrupted: %w", err)
+	}
+	if u.offset > u.length {
+		// the extra byte can't be trusted, the worker resends it in the next part
+		u.offset = u.length
+		if err := u.file.Truncate(u.length); err != nil {
+			return nil, err
+		}
+		if _, err := u.file.Seek(u.length, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return u.status(), fmt.Errorf("%w of %v bytes", ErrUploadTooLarge, u.length)
+	}
+
+	return u.status(), nil
+}
+
+// UploadStatus returns the amount of the received bytes, the interrupted upload is resumed from it,
+// the upload which lease is lost is dropped
+func (s *Server) UploadStatus(id string) (*worker.UploadStatus, error) {
+	u, err := s.uploads.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.done {
+		return nil, ErrUploadNotFound
+	}
+	if err := s.checkUploadLease(u); err != nil {
+		return nil, err
+	}
+	return u.status(), nil
+}
+
+// checkUploadLease drops the upload when its lease is superseded or expired, u.mu must be held
+func (s *Server) checkUploadLease(u *upload) error {
+	return s.dropLeaseLost(u, s.leases.check(u.result.JobID, u.result.TileNum, u.result.Lease))
+}
+
+// dropLeaseLost drops the upload when err tells its lease is lost and returns err, u.mu must be held
+func (s *Server) dropLeaseLost(u *upload, err error) error {
+	if errors.Is(err, ErrTileNotLeased) {
+		s.uploads.remove(u)
+		u.discard()
+	}
+	return err
+}
+
+// CompleteUpload accepts the received result, the upload is dropped whether the result is accepted or not
+func (s *Server) CompleteUpload(id string) error {
+	u, err := s.uploads.get(id)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.done {
+		return ErrUploadNotFound
+	}
+	if u.offset != u.length {
+		return fmt.Errorf("%w: %v of %v bytes received", ErrUploadIncomplete, u.offset, u.length)
+	}
+	if err := s.checkUploadLease(u); err != nil {
+		return err
+	}
+	s.uploads.remove(u)
+	defer u.discard()
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	result := u.result
+	result.Src = u.file
+
+	return s.AcceptResult(&result)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"distributed-encoder/worker"
+)
+
+// POST /work/uploads
+// starts the upload of the result in parts, the result headers are the same as for /work/result
+func (h HTTPHandler) InitiateUpload(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	result, err := worker.ParseResultFromHTTP(req)
+	if err != nil {
+		h.logErr(req, err)
+		h.writeError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	length, err := strconv.ParseInt(req.Header.Get(worker.UploadLengthHeader), 10, 64)
+	if err != nil || length < 0 {
+		h.writeError(w, req, http.StatusBadRequest, "invalid "+worker.UploadLengthHeader)
+		return
+	}
+
+	status, err := h.Service.InitiateUpload(&result, length)
+	if err != nil {
+		h.writeUploadError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Location", req.URL.Path+"/"+status.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logErr(req, err)
+	}
+}
+
+// PUT /work/uploads/:id
+// appends the part at X-Upload-Offset, the response has the amount of the received bytes
+func (h HTTPHandler) UploadPart(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	offset, err := strconv.ParseInt(req.Header.Get(worker.UploadOffsetHeader), 10, 64)
+	if err != nil {
+		h.writeError(w, req, http.StatusBadRequest, "invalid "+worker.UploadOffsetHeader)
+		return
+	}
+
+	status, err := h.Service.UploadPart(uploadIDParam(req), offset, req.Body)
+	var offsetErr *OffsetMismatchError
+	if errors.As(err, &offsetErr) {
+		status, err = h.Service.UploadStatus(uploadIDParam(req))
+		if err == nil {
+			writeUploadStatus(w, status)
+			h.writeError(w, req, http.StatusConflict, offsetErr.Error())
+			return
+		}
+	}
+	if err != nil {
+		h.writeUploadError(w, req, err)
+		return
+	}
+
+	writeUploadStatus(w, status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HEAD /work/uploads/:id
+// returns the amount of the received bytes, the interrupted upload is resumed from it
+func (h HTTPHandler) UploadStatus(w http.ResponseWriter, req *http.Request) {
+	status, err := h.Service.UploadStatus(uploadIDParam(req))
+	if err != nil {
+		h.writeUploadError(w, req, err)
+		return
+	}
+
+	writeUploadStatus(w, status)
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /work/uploads/:id/complete
+func (h HTTPHandler) CompleteUpload(w http.ResponseWriter, req *http.Request) {
+	if err := h.Service.CompleteUpload(uploadIDParam(req)); err != nil {
+		h.writeUploadError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h HTTPHandler) writeUploadError(w http.ResponseWriter, req *http.Request, err error) {
+	var status int
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidResultName):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrTileNotLeased), errors.Is(err, ErrUploadIncomplete):
+		status = http.StatusConflict
+	case errors.Is(err, ErrUploadTooLarge), errors.Is(err, ErrUploadTooLong):
+		status = http.StatusRequestEntityTooLarge
+	default:
+		h.writeInternalError(w, req, err)
+		return
+	}
+	h.writeError(w, req, status, err.Error())
+}
+
+func writeUploadStatus(w http.ResponseWriter, status *worker.UploadStatus) {
+	w.Header().Set(worker.UploadOffsetHeader, strconv.FormatInt(status.Offset, 10))
+	w.Header().Set(worker.UploadLengthHeader, strconv.FormatInt(status.Length, 10))
+}
+
+func uploadIDParam(req *http.Request) string {
+	return httprouter.ParamsFromContext(req.Context()).ByName("id")
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+func TestServer_UploadParts(t *testing.T) {
+	store := &recordingStore{}
+	jobStore := newMemoryJobStore()
+	s := Server{
+		store:    store,
+		jobs:     newJobRegistry(jobStore),
+		webhooks: newWebhookNotifier(WebhookConfig{}),
+		events:   newEventBroker(),
+		leases:   newLeaseTable(jobStore, time.Minute),
+		uploads:  newUploadTable(t.TempDir(), 0),
+		log:      slog.Default(),
+	}
+	defer s.uploads.close()
+	s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{{JobID: "1d2f", TileNum: 0, File: "video.mp4"}}, 0)
+	s.jobs.tileDispatched("1d2f", 0, "worker-1")
+	lease, err := s.leases.issue(TileJob{JobID: "1d2f", TileNum: 0})
+	require.NoError(t, err)
+
+	_, err = s.InitiateUpload(&worker.Result{JobID: "1d2f", TileNum: 0, FileName: "video_tile_0.ts", Lease: "stale"}, 7)
+	require.ErrorIs(t, err, ErrLeaseInvalid)
+	_, err = s.InitiateUpload(&worker.Result{JobID: "1d2f", TileNum: 0, FileName: "../video_tile_0.ts", Lease: lease}, 7)
+	require.ErrorIs(t, err, ErrInvalidResultName)
+
+	status, err := s.InitiateUpload(&worker.Result{JobID: "1d2f", TileNum: 0, FileName: "video_tile_0.ts", Lease: lease}, 7)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), status.Offset)
+
+	status, err = s.UploadPart(status.ID, 0, strings.NewReader("enc"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), status.Offset)
+
+	var offsetErr *OffsetMismatchError
+	_, err = s.UploadPart(status.ID, 0, strings.NewReader("enc"))
+	require.ErrorAs(t, err, &offsetErr)
+	require.Equal(t, int64(3), offsetErr.Offset)
+
+	require.ErrorIs(t, s.CompleteUpload(status.ID), ErrUploadIncomplete)
+
+	_, err = s.UploadPart(status.ID, 3, strings.NewReader("oded!"))
+	require.ErrorIs(t, err, ErrUploadTooLarge)
+	status, err = s.UploadStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), status.Offset)
+
+	require.NoError(t, s.CompleteUpload(status.ID))
+	require.Equal(t, "encoded", store.data["1d2f/video_tile_0.ts"])
+
+	_, err = s.UploadStatus(status.ID)
+	require.ErrorIs(t, err, ErrUploadNotFound)
+	require.ErrorIs(t, s.CompleteUpload(status.ID), ErrUploadNotFound)
+}
+
+func TestServer_UploadLease(t *testing.T) {
+	jobStore := newMemoryJobStore()
+	s := Server{
+		store:    &recordingStore{},
+		jobs:     newJobRegistry(jobStore),
+		webhooks: newWebhookNotifier(WebhookConfig{}),
+		events:   newEventBroker(),
+		leases:   newLeaseTable(jobStore, time.Minute),
+		uploads:  newUploadTable(t.TempDir(), 8),
+		log:      slog.Default(),
+	}
+	defer s.uploads.close()
+	job := TileJob{JobID: "1d2f", TileNum: 0, File: "video.mp4"}
+	s.jobs.add("1d2f", EncodeVideoRequest{}, []TileJob{job}, 0)
+	s.jobs.tileDispatched("1d2f", 0, "worker-1")
+	lease, err := s.leases.issue(job)
+	require.NoError(t, err)
+	result := &worker.Result{JobID: "1d2f", TileNum: 0, FileName: "video_tile_0.ts", Lease: lease}
+
+	_, err = s.InitiateUpload(result, 9)
+	require.ErrorIs(t, err, ErrUploadTooLong)
+
+	// the new upload with the lease supersedes the previous one
+	first, err := s.InitiateUpload(result, 7)
+	require.NoError(t, err)
+	second, err := s.InitiateUpload(result, 7)
+	require.NoError(t, err)
+	_, err = s.UploadStatus(first.ID)
+	require.ErrorIs(t, err, ErrUploadNotFound)
+	_, err = s.UploadPart(second.ID, 0, strings.NewReader("encoded"))
+	require.NoError(t, err)
+
+	// the upload is dropped once its lease is superseded by another dispatch
+	_, err = s.leases.issue(job)
+	require.NoError(t, err)
+	_, err = s.UploadStatus(second.ID)
+	require.ErrorIs(t, err, ErrLeaseInvalid)
+	require.ErrorIs(t, s.CompleteUpload(second.ID), ErrUploadNotFound)
+	require.Empty(t, s.uploads.list())
+	require.Empty(t, s.uploads.leases)
+}
+
+func TestUploadTable_Expire(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	dir := t.TempDir()
+	uploads := newUploadTable(dir, 0)
+	uploads.now = func() time.Time { return now }
+
+	file, err := os.CreateTemp(dir, "upload-*")
+	require.NoError(t, err)
+	uploads.add(&upload{id: "1d2f", file: file, updated: now})
+
+	require.Equal(t, 0, uploads.expire(time.Minute))
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, 1, uploads.expire(time.Minute))
+
+	_, err = uploads.get("1d2f")
+	require.ErrorIs(t, err, ErrUploadNotFound)
+	require.NoFileExists(t, file.Name())
+}
+
+func TestChunkedUpload_Resume(t *testing.T) {
+	store := &recordingStore{}
+	s, err := New(Config{
+		Store:        store,
+		TileStreamer: &streamerMock{},
+		UploadDir:    t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	status, err := s.TriggerWork(EncodeVideoRequest{Tiles: 1, Width: 10, Height: 10, FilePath: "/videos/video.mp4"})
+	require.NoError(t, err)
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+
+	h := HTTPHandler{Service: s}
+	var interrupted bool
+	var mu sync.Mutex
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/work/uploads", h.InitiateUpload)
+	router.HandlerFunc(http.MethodPut, "/work/uploads/:id", func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// the connection of the second part is broken in the middle
+		if !interrupted && req.Header.Get(worker.UploadOffsetHeader) == "4" {
+			interrupted = true
+			req.Body = io.NopCloser(io.MultiReader(io.LimitReader(req.Body, 2), errReader{}))
+		}
+		h.UploadPart(w, req)
+	})
+	router.HandlerFunc(http.MethodHead, "/work/uploads/:id", h.UploadStatus)
+	router.HandlerFunc(http.MethodPost, "/work/uploads/:id/complete", h.CompleteUpload)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	client, err := worker.NewClient(worker.ClientConfig{
+		PollEndpoint:     ts.URL + "/work/jobs",
+		ResultEndpoint:   ts.URL + "/work/result",
+		ProgressEndpoint: ts.URL + "/work/progress",
+		UploadEndpoint:   ts.URL + "/work/uploads",
+		ChunkSize:        4,
+		SpoolDir:         t.TempDir(),
+		UploadRetryDelay: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, client.SendResult(&worker.Result{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		FileName: job.TileName + ".ts",
+		Lease:    job.Lease,
+		Src:      strings.NewReader("i'm an encoded file"),
+	}))
+	require.True(t, interrupted)
+	require.Equal(t, "i'm an encoded file", store.data[status.ID+"/video_tile_0.ts"])
+
+	status, err = s.JobStatus(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateCompleted, status.State)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+// recordingStore keeps the written objects in memory
+type recordingStore struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (s *recordingStore) WriteObject(key string, src io.Reader) error {
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, src); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		s.data = make(map[string]string)
+	}
+	s.data[key] = b.String()
+	return nil
+}
+
+func (s *recordingStore) HasObject(key string) bool {
+	return true
+}
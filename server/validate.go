@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldError describes the invalid field of the request, Field is its JSON name
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	err error
+}
+
+// ValidationError is returned when the fields of the request are invalid, errors.Is matches the errors of the fields
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, f := range e.Errors {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	var errs []error
+	for _, f := range e.Errors {
+		if f.err != nil {
+			errs = append(errs, f.err)
+		}
+	}
+	return errs
+}
+
+// add records the error of the field
+func (e *ValidationError) add(field string, err error) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: err.Error(), err: err})
+}
+
+// addf records the message of the field
+func (e *ValidationError) addf(field, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// orNil returns nil when no field is invalid
+func (e *ValidationError) orNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// invalidField returns *ValidationError of the single field
+func invalidField(field string, err error) error {
+	e := &ValidationError{}
+	e.add(field, err)
+	return e
+}
+
+// Validate checks the fields of the request before it's split into the tiles, *ValidationError is returned.
+// The input file itself is checked by TriggerWork
+func (r EncodeVideoRequest) Validate() error {
+	e := &ValidationError{}
+	if r.FilePath == "" {
+		e.addf("filePath", "is required")
+	}
+	if r.Tiles <= 0 {
+		e.addf("tiles", "must be positive")
+	}
+	if r.Width <= 0 {
+		e.addf("width", "must be positive")
+	}
+	if r.Height <= 0 {
+		e.addf("height", "must be positive")
+	}
+	// the tiles are encoded in yuv420p, so their sizes must be even as the frame's
+	if r.Width > 0 && r.Width%2 != 0 {
+		e.addf("width", "must be even")
+	}
+	if r.Height > 0 && r.Height%2 != 0 {
+		e.addf("height", "must be even")
+	}
+	if r.Tiles > 0 && r.Width > 0 && r.Height > 0 {
+		// the frame must be at least 2 pixels per tile in each direction
+		cols, rows := calcColumnRows(r.Tiles)
+		switch {
+		case cols*rows != r.Tiles:
+			e.addf("tiles", "%d tiles don't make a grid of columns and rows, %dx%d makes %d", r.Tiles, cols, rows, cols*rows)
+		case r.Width < 2*cols || r.Height < 2*rows:
+			e.addf("tiles", "%d tiles split the frame into %dx%d, it doesn't fit %dx%d", r.Tiles, cols, rows, r.Width, r.Height)
+		}
+	}
+	if r.CallbackURL != "" {
+		if err := validateCallbackURL(r.CallbackURL); err != nil {
+			e.add("callbackUrl", err)
+		}
+	}
+	if r.JobID != "" && !jobIDPattern.MatchString(r.JobID) {
+		e.add("jobId", ErrInvalidJobID)
+	}
+	return e.orNil()
+}
+
+// decodeFieldErrors returns the field errors of the JSON decoding error, nil when it's not bound to a field
+func decodeFieldErrors(err error) []FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []FieldError{{Field: typeErr.Field, Message: fmt.Sprintf("must be %s, not %s", jsonType(typeErr.Type), typeErr.Value)}}
+	}
+	return nil
+}
+
+// jsonType names the JSON type of the Go type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// stripFieldErrors drops the wrapped errors, so the field errors can be compared
+func stripFieldErrors(errs []FieldError) []FieldError {
+	stripped := make([]FieldError, 0, len(errs))
+	for _, e := range errs {
+		stripped = append(stripped, FieldError{Field: e.Field, Message: e.Message})
+	}
+	return stripped
+}
+
+func TestEncodeVideoRequest_Validate(t *testing.T) {
+	valid := EncodeVideoRequest{Tiles: 4, Width: 1920, Height: 1080, FilePath: "/videos/video.mp4"}
+	require.NoError(t, valid.Validate())
+	// the frame which isn't divisible by the tiles is split too
+	for _, tiles := range []int{1, 2, 3, 6, 8, 9, 12} {
+		request := valid
+		request.Tiles, request.Width, request.Height = tiles, 1000, 778
+		require.NoError(t, request.Validate(), tiles)
+	}
+
+	tests := map[string]struct {
+		change   func(r *EncodeVideoRequest)
+		expected []FieldError
+	}{
+		"empty": {
+			change: func(r *EncodeVideoRequest) {
+				*r = EncodeVideoRequest{}
+			},
+			expected: []FieldError{
+				{Field: "filePath", Message: "is required"},
+				{Field: "tiles", Message: "must be positive"},
+				{Field: "width", Message: "must be positive"},
+				{Field: "height", Message: "must be positive"},
+			},
+		},
+		"negative tiles": {
+			change: func(r *EncodeVideoRequest) {
+				r.Tiles = -4
+			},
+			expected: []FieldError{{Field: "tiles", Message: "must be positive"}},
+		},
+		"tiles larger than the frame": {
+			change: func(r *EncodeVideoRequest) {
+				r.Tiles, r.Width, r.Height = 9, 2, 1080
+			},
+			expected: []FieldError{{Field: "tiles", Message: "9 tiles split the frame into 3x3, it doesn't fit 2x1080"}},
+		},
+		"odd frame": {
+			change: func(r *EncodeVideoRequest) {
+				r.Width, r.Height = 1919, 1079
+			},
+			expected: []FieldError{
+				{Field: "width", Message: "must be even"},
+				{Field: "height", Message: "must be even"},
+			},
+		},
+		"tiles narrower than 2 pixels": {
+			change: func(r *EncodeVideoRequest) {
+				r.Tiles, r.Width = 4, 2
+			},
+			expected: []FieldError{{Field: "tiles", Message: "4 tiles split the frame into 2x2, it doesn't fit 2x1080"}},
+		},
+		"tiles not split exactly": {
+			change: func(r *EncodeVideoRequest) {
+				r.Tiles = 5
+			},
+			expected: []FieldError{{Field: "tiles", Message: "5 tiles don't make a grid of columns and rows, 2x2 makes 4"}},
+		},
+		"callback and job id": {
+			change: func(r *EncodeVideoRequest) {
+				r.CallbackURL, r.JobID = "ftp://hooks", "a/b"
+			},
+			expected: []FieldError{
+				{Field: "callbackUrl", Message: "callback url is invalid: ftp://hooks, absolute http(s) url is expected"},
+				{Field: "jobId", Message: ErrInvalidJobID.Error()},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := valid
+			tt.change(&request)
+			err := request.Validate()
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			require.Equal(t, tt.expected, stripFieldErrors(validationErr.Errors))
+		})
+	}
+
+	err := EncodeVideoRequest{Tiles: 1, Width: 2, Height: 2, FilePath: "/videos/video.mp4", JobID: "a/b"}.Validate()
+	require.ErrorIs(t, err, ErrInvalidJobID)
+	require.EqualError(t, err, "jobId: "+ErrInvalidJobID.Error())
+}
+
+func TestHTTPHandler_TriggerValidation(t *testing.T) {
+	var serviceMock serverMock
+	serviceMock.On("TriggerWork", mock.Anything).Return(nil, EncodeVideoRequest{FilePath: "/videos/video.mp4"}.Validate()).Once()
+	handler := HTTPHandler{Service: &serviceMock}
+
+	tests := map[string]struct {
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		"invalid": {
+			body:         `{"filePath":"/videos/video.mp4"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedBody: `{"type":"about:blank","title":"Unprocessable Entity","status":422,"instance":"/work/trigger",
+				"detail":"tiles: must be positive; width: must be positive; height: must be positive",
+				"error":"tiles: must be positive; width: must be positive; height: must be positive",
+				"errors":[{"field":"tiles","message":"must be positive"},{"field":"width","message":"must be positive"},
+					{"field":"height","message":"must be positive"}]}`,
+		},
+		"wrong type": {
+			body:         `{"tiles":4,"width":"1920"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"type":"about:blank","title":"Bad Request","status":400,"instance":"/work/trigger",
+				"detail":"request is malformed: json: cannot unmarshal string into Go struct field EncodeVideoRequest.width of type int",
+				"error":"request is malformed: json: cannot unmarshal string into Go struct field EncodeVideoRequest.width of type int",
+				"errors":[{"field":"width","message":"must be a number, not string"}]}`,
+		},
+		"empty": {
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"type":"about:blank","title":"Bad Request","status":400,"instance":"/work/trigger",
+				"detail":"request body is empty","error":"request body is empty"}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.Trigger(rr, httptest.NewRequest(http.MethodPost, "/work/trigger", strings.NewReader(tt.body)))
+			require.Equal(t, tt.expectedCode, rr.Code)
+			require.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+			require.JSONEq(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+	serviceMock.AssertExpectations(t)
+}
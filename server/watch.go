@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+const (
+	// defaultWatchInterval is how often the watched folders are scanned
+	defaultWatchInterval = 5 * time.Second
+	// defaultSettleTime is how long the file must stay unchanged to be triggered
+	defaultSettleTime = 10 * time.Second
+	// markerExt is an extension of the file marking the source triggered in place, it contains the job id
+	markerExt = ".job"
+)
+
+// defaultVideoExtensions are the extensions of the files triggered in the watched folders
+var defaultVideoExtensions = []string{".mp4", ".m4v", ".mov", ".mkv", ".avi", ".webm", ".ts", ".mxf"}
+
+// WatchConfig configures the folders the new video files are triggered from
+type WatchConfig struct {
+	Folders []WatchFolder
+	// Interval is how often the folders are scanned, 5 seconds is a default
+	Interval time.Duration
+	// SettleTime is how long the size and the modification time of the file must stay unchanged,
+	// so the file being copied isn't triggered, 10 seconds is a default
+	SettleTime time.Duration
+}
+
+// WatchFolder is an input directory which new video files are triggered with the default request of the folder.
+// The subdirectories and the hidden files are not watched
+type WatchFolder struct {
+	// Path is an absolute path of the directory, it must be under the InputRoots when they're set
+	Path string `json:"path"`
+	// Request is the default request of the files of the folder, its FilePath and JobID are set for each file
+	Request EncodeVideoRequest `json:"request"`
+	// ProcessedDir receives the triggered files, it's relative to Path unless it's absolute.
+	// When it's empty the triggered files stay in place and are marked with the <file>.job file with the job id
+	ProcessedDir string `json:"processedDir,omitempty"`
+	// Extensions are the extensions of the triggered files, the common video extensions are a default
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// fileVersion identifies the content of the watched file
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+// observedFile is the watched file which is not triggered yet
+type observedFile struct {
+	version fileVersion
+	// since is when the version was seen first
+	since time.Time
+	// failed is set when the trigger of the version was rejected, the version isn't triggered again
+	failed bool
+}
+
+// folderWatcher triggers the files of the watched folders once they stop growing.
+// The job id of the file is derived from its path and version, so the replicas watching the same folders
+// don't create duplicate jobs
+type folderWatcher struct {
+	folders  []WatchFolder
+	interval time.Duration
+	settle   time.Duration
+	trigger  func(EncodeVideoRequest) (*JobStatus, error)
+	now      func() time.Time
+	log      *slog.Logger
+
+	// files are the observed files by their paths
+	files map[string]*observedFile
+}
+
+// newFolderWatcher checks the folders and creates the processed directories, nil is returned when there are no folders
+func newFolderWatcher(cfg WatchConfig, roots []string, trigger func(EncodeVideoRequest) (*JobStatus, error), log *slog.Logger) (*folderWatcher, error) {
+	if len(cfg.Folders) == 0 {
+		return nil, nil
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultWatchInterval
+	}
+	if cfg.SettleTime <= 0 {
+		cfg.SettleTime = defaultSettleTime
+	}
+
+	folders := make([]WatchFolder, 0, len(cfg.Folders))
+	for _, folder := range cfg.Folders {
+		path, err := resolveInput(folder.Path, roots)
+		if err != nil {
+			return nil, fmt.Errorf("watched folder %s: %w", folder.Path, err)
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("watched folder %s is not a directory", folder.Path)
+		}
+		folder.Path = path
+		request := folder.Request
+		request.FilePath = path
+		if err := request.Validate(); err != nil {
+			return nil, fmt.Errorf("request of the watched folder %s is invalid: %w", folder.Path, err)
+		}
+		if folder.ProcessedDir != "" {
+			if !filepath.IsAbs(folder.ProcessedDir) {
+				folder.ProcessedDir = filepath.Join(path, folder.ProcessedDir)
+			}
+			if err := os.MkdirAll(folder.ProcessedDir, 0755); err != nil {
+				return nil, fmt.Errorf("watched folder %s: %w", folder.Path, err)
+			}
+			if folder.ProcessedDir, err = resolveInput(folder.ProcessedDir, roots); err != nil {
+				return nil, fmt.Errorf("processed folder of %s: %w", folder.Path, err)
+			}
+		}
+		if len(folder.Extensions) == 0 {
+			folder.Extensions = defaultVideoExtensions
+		}
+		folders = append(folders, folder)
+	}
+
+	return &folderWatcher{
+		folders:  folders,
+		interval: cfg.Interval,
+		settle:   cfg.SettleTime,
+		trigger:  trigger,
+		now:      time.Now,
+		log:      log,
+		files:    make(map[string]*observedFile),
+	}, nil
+}
+
+// run scans the folders until the context is canceled
+func (w *folderWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.scan()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan triggers the files which versions are unchanged for the settle time
+func (w *folderWatcher) scan() {
+	now := w.now()
+	present := make(map[string]bool, len(w.files))
+	for _, folder := range w.folders {
+		entries, err := os.ReadDir(folder.Path)
+		if err != nil {
+			w.log.Error("watched folder can't be read", slog.String("folder", folder.Path), logging.Err(err))
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") ||
+				!slices.Contains(folder.Extensions, strings.ToLower(filepath.Ext(name))) {
+				continue
+			}
+			path := filepath.Join(folder.Path, name)
+			if folder.ProcessedDir == "" && exists(path+markerExt) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			present[path] = true
+
+			version := fileVersion{size: info.Size(), modTime: info.ModTime()}
+			file, ok := w.files[path]
+			if !ok || file.version != version {
+				w.files[path] = &observedFile{version: version, since: now}
+				continue
+			}
+			if file.failed || now.Sub(file.since) < w.settle {
+				continue
+			}
+
+			err = w.triggerFile(&folder, path, version)
+			var queueErr *QueueFullError
+			switch {
+			case err == nil:
+				delete(w.files, path)
+			case errors.As(err, &queueErr) && !queueErr.Oversized(), errors.Is(err, ErrRequestInProgress):
+				// the file is triggered again by the next scan
+				w.log.Warn("watched file is postponed", slog.String("file", path), logging.Err(err))
+			default:
+				file.failed = true
+				w.log.Error("watched file can't be triggered", slog.String("file", path), logging.Err(err))
+			}
+		}
+	}
+	for path := range w.files {
+		if !present[path] {
+			delete(w.files, path)
+		}
+	}
+}
+
+// triggerFile triggers the job of the file with the default request of the folder,
+// the file is moved to the processed directory before the trigger and moved back when it fails
+func (w *folderWatcher) triggerFile(folder *WatchFolder, path string, version fileVersion) error {
+	source := path
+	if folder.ProcessedDir != "" {
+		source = filepath.Join(folder.ProcessedDir, filepath.Base(path))
+		if exists(source) {
+			return fmt.Errorf("processed file %s already exists", source)
+		}
+		// the replica which moves the file triggers it
+		if err := os.Rename(path, source); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	request := folder.Request
+	request.FilePath = source
+	request.JobID = watchedJobID(source, version)
+	request.IdempotencyKey = ""
+	status, err := w.trigger(request)
+	if err != nil {
+		if source != path {
+			if err := os.Rename(source, path); err != nil {
+				w.log.Error("watched file can't be moved back", slog.String("file", source), logging.Err(err))
+			}
+		}
+		return err
+	}
+	if source == path {
+		if err := os.WriteFile(path+markerExt, []byte(status.ID+"\n"), 0644); err != nil {
+			return fmt.Errorf("job %s is triggered, but the file can't be marked: %w", status.ID, err)
+		}
+	}
+	w.log.Info("watched file is triggered", slog.String("file", source), slog.String(logging.JobIDKey, status.ID))
+	return nil
+}
+
+// watchedJobID derives the job id from the path and the version of the file
+func watchedJobID(path string, version fileVersion) string {
+	sum := sha256.Sum256([]byte(path + "|" + strconv.FormatInt(version.size, 10) + "|" +
+		strconv.FormatInt(version.modTime.UnixNano(), 10)))
+	return "watch-" + hex.EncodeToString(sum[:20])
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
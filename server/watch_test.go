@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// watchTrigger records the triggered requests
+type watchTrigger struct {
+	requests []EncodeVideoRequest
+	err      error
+}
+
+func (t *watchTrigger) trigger(request EncodeVideoRequest) (*JobStatus, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	t.requests = append(t.requests, request)
+	return &JobStatus{ID: request.JobID, State: StateQueued, Request: request}, nil
+}
+
+func newTestWatcher(t *testing.T, folder WatchFolder, trigger *watchTrigger) (*folderWatcher, *time.Time) {
+	t.Helper()
+	w, err := newFolderWatcher(WatchConfig{Folders: []WatchFolder{folder}, SettleTime: 10 * time.Second}, nil, trigger.trigger, slog.Default())
+	require.NoError(t, err)
+	now := time.Now()
+	w.now = func() time.Time {
+		return now
+	}
+	return w, &now
+}
+
+func TestFolderWatcher_Mark(t *testing.T) {
+	dir := t.TempDir()
+	request := EncodeVideoRequest{Tiles: 4, Width: 1920, Height: 1080, Submitter: "studio"}
+	var trigger watchTrigger
+	w, now := newTestWatcher(t, WatchFolder{Path: dir, Request: request}, &trigger)
+
+	video := filepath.Join(dir, "video.mp4")
+	require.NoError(t, os.WriteFile(video, []byte("part"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden.mp4"), []byte("hidden"), 0644))
+	w.scan()
+
+	// the growing file waits for the settle time again
+	*now = now.Add(8 * time.Second)
+	f, err := os.OpenFile(video, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("rest")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	w.scan()
+	*now = now.Add(8 * time.Second)
+	w.scan()
+	require.Empty(t, trigger.requests)
+
+	*now = now.Add(3 * time.Second)
+	w.scan()
+	require.Len(t, trigger.requests, 1)
+	got := trigger.requests[0]
+	require.Equal(t, video, got.FilePath)
+	require.Regexp(t, jobIDPattern, got.JobID)
+	require.Contains(t, got.JobID, "watch-")
+	got.FilePath, got.JobID = "", ""
+	require.Equal(t, request, got)
+
+	marker, err := os.ReadFile(video + markerExt)
+	require.NoError(t, err)
+	require.Equal(t, trigger.requests[0].JobID+"\n", string(marker))
+
+	// the marked file isn't triggered again
+	*now = now.Add(time.Minute)
+	w.scan()
+	*now = now.Add(time.Minute)
+	w.scan()
+	require.Len(t, trigger.requests, 1)
+}
+
+func TestFolderWatcher_Move(t *testing.T) {
+	dir := t.TempDir()
+	var trigger watchTrigger
+	w, now := newTestWatcher(t, WatchFolder{Path: dir, Request: EncodeVideoRequest{Tiles: 2, Width: 20, Height: 10}, ProcessedDir: "done"}, &trigger)
+	processed := filepath.Join(dir, "done")
+	require.DirExists(t, processed)
+
+	video := filepath.Join(dir, "video.MOV")
+	require.NoError(t, os.WriteFile(video, []byte("video"), 0644))
+	w.scan()
+
+	// the full queue postpones the file, it stays in the folder
+	trigger.err = &QueueFullError{Depth: 10, Limit: 10}
+	*now = now.Add(time.Minute)
+	w.scan()
+	require.FileExists(t, video)
+
+	trigger.err = nil
+	*now = now.Add(time.Second)
+	w.scan()
+	require.Len(t, trigger.requests, 1)
+	require.Equal(t, filepath.Join(processed, "video.MOV"), trigger.requests[0].FilePath)
+	require.NoFileExists(t, video)
+	require.FileExists(t, filepath.Join(processed, "video.MOV"))
+
+	// the rejected file is moved back and isn't triggered again until it changes
+	other := filepath.Join(dir, "other.mp4")
+	require.NoError(t, os.WriteFile(other, []byte("other"), 0644))
+	w.scan()
+	trigger.err = errors.New("rejected")
+	*now = now.Add(time.Minute)
+	w.scan()
+	require.FileExists(t, other)
+	trigger.err = nil
+	*now = now.Add(time.Minute)
+	w.scan()
+	require.Len(t, trigger.requests, 1)
+	require.NoFileExists(t, filepath.Join(processed, "other.mp4"))
+}
+
+func TestNewFolderWatcher(t *testing.T) {
+	root := t.TempDir()
+	request := EncodeVideoRequest{Tiles: 2, Width: 20, Height: 10}
+
+	w, err := newFolderWatcher(WatchConfig{}, nil, nil, slog.Default())
+	require.NoError(t, err)
+	require.Nil(t, w)
+
+	for name, folder := range map[string]WatchFolder{
+		"relative":      {Path: "videos", Request: request},
+		"not found":     {Path: filepath.Join(root, "missing"), Request: request},
+		"outside roots": {Path: t.TempDir(), Request: request},
+		"no tiles":      {Path: root, Request: EncodeVideoRequest{Width: 20, Height: 10}},
+		"no width":      {Path: root, Request: EncodeVideoRequest{Tiles: 2, Height: 10}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newFolderWatcher(WatchConfig{Folders: []WatchFolder{folder}}, []string{root}, nil, slog.Default())
+			require.Error(t, err)
+		})
+	}
+}
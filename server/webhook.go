@@ -0,0 +1,398 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+// webhookEvents is a set of the events delivered to the callback urls
+var webhookEvents = map[EventType]bool{
+	EventJobAccepted:   true,
+	EventTileCompleted: true,
+	EventTileFailed:    true,
+	EventJobCompleted:  true,
+	EventJobFailed:     true,
+}
+
+const (
+	signatureHeader = "X-Signature"
+	timestampHeader = "X-Signature-Timestamp"
+	eventTypeHeader = "X-Event-Type"
+
+	// webhookBuffer is a maximum amount of undelivered events per job
+	webhookBuffer = 1024
+	// webhookIdle is a time the job's hook is kept without events, the terminal event of the job
+	// is not seen when it's finished on another replica
+	webhookIdle = 5 * time.Minute
+)
+
+// ErrCallbackNotAllowed happens when the callback url points to a loopback, link-local or private address
+// which is not allowed by WebhookConfig.AllowedHosts
+var ErrCallbackNotAllowed = errors.New("callback address is not allowed")
+
+// WebhookConfig represents the callbacks delivery configuration
+type WebhookConfig struct {
+	// Secret signs the events body with HMAC-SHA256, signing is disabled when empty
+	Secret string
+	// MaxAttempts is a maximum amount of delivery attempts per event, 5 is a default
+	MaxAttempts int
+	// Backoff is a delay before the first retry, doubled on each next attempt, 1 second is a default
+	Backoff time.Duration
+	// MaxBackoff is a maximum delay between the attempts, 30 seconds is a default
+	MaxBackoff time.Duration
+	// AllowedHosts are the host names, the addresses and the CIDR ranges the events can be delivered to
+	// even though they're loopback, link-local or private, the other hosts must have public addresses
+	AllowedHosts []string
+	// Client is used to deliver the events, http.Client with 10 seconds timeout is a default,
+	// its connections are checked against AllowedHosts. The connections of the custom client are not checked
+	Client *http.Client
+}
+
+// webhookNotifier delivers job events to the callback urls,
+// each job has its own delivery goroutine, so the events of the job are delivered in order
+// and a slow receiver doesn't block the others
+type webhookNotifier struct {
+	cfg   WebhookConfig
+	guard callbackGuard
+	log   *slog.Logger
+	// idle is a time the hook is released after its last event
+	idle time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	hooks map[string]chan Event
+	wg    sync.WaitGroup
+}
+
+func newWebhookNotifier(cfg WebhookConfig) *webhookNotifier {
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	guard := newCallbackGuard(cfg.AllowedHosts)
+	if cfg.Client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// the events are delivered directly, the guard would check the address of the proxy otherwise
+		transport.Proxy = nil
+		transport.DialContext = guard.dialContext
+		cfg.Client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &webhookNotifier{
+		cfg:    cfg,
+		guard:  guard,
+		log:    slog.Default().With(logging.ComponentKey, "webhook"),
+		idle:   webhookIdle,
+		ctx:    ctx,
+		cancel: cancel,
+		hooks:  make(map[string]chan Event),
+	}
+}
+
+// validateCallbackURL checks that callback is an absolute http(s) url
+func validateCallbackURL(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return fmt.Errorf("callback url is invalid: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback url is invalid: %s, absolute http(s) url is expected", callback)
+	}
+	return nil
+}
+
+// checkCallback rejects the callback url with the host which is a disallowed address or localhost,
+// the host names are resolved and checked when the events are delivered
+func (n *webhookNotifier) checkCallback(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return fmt.Errorf("callback url is invalid: %w", err)
+	}
+	host := strings.ToLower(u.Hostname())
+	if n.guard.hosts[host] {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrCallbackNotAllowed, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !n.guard.allowed(addr) {
+		return fmt.Errorf("%w: %s", ErrCallbackNotAllowed, host)
+	}
+	return nil
+}
+
+// callbackGuard limits the addresses the events are delivered to, so the callback urls can't reach
+// the internal services of the server's network
+type callbackGuard struct {
+	// hosts are the allowed host names and addresses
+	hosts map[string]bool
+	// networks are the allowed CIDR ranges
+	networks []netip.Prefix
+}
+
+func newCallbackGuard(allowed []string) callbackGuard {
+	g := callbackGuard{hosts: make(map[string]bool)}
+	for _, host := range allowed {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if prefix, err := netip.ParsePrefix(host); err == nil {
+			g.networks = append(g.networks, prefix.Masked())
+			continue
+		}
+		if host != "" {
+			g.hosts[host] = true
+		}
+	}
+	return g
+}
+
+// allowed reports whether the events can be delivered to the address
+func (g callbackGuard) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if g.hosts[addr.String()] {
+		return true
+	}
+	for _, network := range g.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+// dialContext checks the resolved address right before the connection, so a host name resolving
+// to an internal address is rejected as well
+func (g callbackGuard) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if host, _, err := net.SplitHostPort(address); err != nil || !g.hosts[strings.ToLower(host)] {
+		dialer.Control = g.control
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (g callbackGuard) control(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !g.allowed(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrCallbackNotAllowed, addrPort.Addr())
+	}
+	return nil
+}
+
+// register starts the delivery of the job events to the callback url
+func (n *webhookNotifier) register(jobID, callback string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.hook(jobID, callback)
+}
+
+// unregister stops the delivery of the job events, e.g. when the job isn't queued
+func (n *webhookNotifier) unregister(jobID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if events, ok := n.hooks[jobID]; ok {
+		close(events)
+		delete(n.hooks, jobID)
+	}
+}
+
+// hook returns the delivery queue of the job and starts it when the job has none, it's called with n.mu held
+func (n *webhookNotifier) hook(jobID, callback string) chan Event {
+	if events, ok := n.hooks[jobID]; ok {
+		return events
+	}
+	if n.ctx.Err() != nil {
+		return nil
+	}
+	events := make(chan Event, webhookBuffer)
+	n.hooks[jobID] = events
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		idle := time.NewTimer(n.idle)
+		defer idle.Stop()
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := n.deliver(callback, e); err != nil {
+					n.log.Warn("event is dropped",
+						slog.String(logging.JobIDKey, e.JobID),
+						slog.String("event", string(e.Type)),
+						slog.String("callback", callback),
+						logging.Err(err),
+					)
+				}
+				idle.Reset(n.idle)
+			case <-idle.C:
+				if n.release(jobID, events) {
+					return
+				}
+				idle.Reset(n.idle)
+			}
+		}
+	}()
+	return events
+}
+
+// release drops the idle hook of the job, false is returned when it has events to deliver
+func (n *webhookNotifier) release(jobID string, events chan Event) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(events) > 0 || n.hooks[jobID] != events {
+		return false
+	}
+	delete(n.hooks, jobID)
+	return true
+}
+
+// notify enqueues the event for the delivery, the job's hook is released after the terminal event.
+// The hook of the job triggered on another replica or recovered is started by its event
+func (n *webhookNotifier) notify(e Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !webhookEvents[e.Type] {
+		return
+	}
+	events, ok := n.hooks[e.JobID]
+	if !ok {
+		if e.callback == "" {
+			return
+		}
+		if events = n.hook(e.JobID, e.callback); events == nil {
+			return
+		}
+	}
+	select {
+	case events <- e:
+	default:
+		n.log.Warn("buffer is full, event is dropped",
+			slog.String(logging.JobIDKey, e.JobID),
+			slog.String("event", string(e.Type)),
+		)
+	}
+	if e.terminal() {
+		close(events)
+		delete(n.hooks, e.JobID)
+	}
+}
+
+// close stops retries and waits for the delivery goroutines
+func (n *webhookNotifier) close() {
+	n.cancel()
+
+	n.mu.Lock()
+	for id, events := range n.hooks {
+		close(events)
+		delete(n.hooks, id)
+	}
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+// deliver posts the event retrying with exponential backoff on network errors, 429 and 5xx responses
+func (n *webhookNotifier) deliver(callback string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(callback, e, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.cfg.MaxAttempts {
+			return fmt.Errorf("attempt %v: %w", attempt, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+			return n.ctx.Err()
+		}
+		backoff *= 2
+		if backoff > n.cfg.MaxBackoff {
+			backoff = n.cfg.MaxBackoff
+		}
+	}
+}
+
+func (n *webhookNotifier) post(callback string, e Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventTypeHeader, string(e.Type))
+	if n.cfg.Secret != "" {
+		timestamp := strconv.FormatInt(e.Time.Unix(), 10)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(signatureHeader, SignWebhook(n.cfg.Secret, timestamp, body))
+	}
+
+	res, err := n.cfg.Client.Do(req)
+	if errors.Is(err, ErrCallbackNotAllowed) {
+		return false, err
+	}
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status code: %v", res.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status code: %v", res.StatusCode)
+	}
+}
+
+// SignWebhook returns the X-Signature header value for the event body,
+// receivers compute it with the shared secret and the X-Signature-Timestamp header to verify the event
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
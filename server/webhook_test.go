@@ -0,0 +1,300 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"distributed-encoder/worker"
+)
+
+type webhookReceiver struct {
+	t      *testing.T
+	mu     sync.Mutex
+	events []Event
+	// fails is an amount of requests answered with 503 before the success
+	fails int
+	done  chan struct{}
+}
+
+func newWebhookReceiver(t *testing.T, fails int) (*webhookReceiver, *httptest.Server) {
+	r := &webhookReceiver{t: t, fails: fails, done: make(chan struct{})}
+	return r, httptest.NewServer(r)
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	require.NoError(r.t, err)
+
+	timestamp := req.Header.Get("X-Signature-Timestamp")
+	require.Equal(r.t, SignWebhook("secret", timestamp, body), req.Header.Get("X-Signature"))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fails > 0 {
+		r.fails--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var e Event
+	require.NoError(r.t, json.Unmarshal(body, &e))
+	require.Equal(r.t, string(e.Type), req.Header.Get("X-Event-Type"))
+	r.events = append(r.events, e)
+	if e.terminal() {
+		close(r.done)
+	}
+}
+
+func (r *webhookReceiver) types() []EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var types []EventType
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestServer_Webhooks(t *testing.T) {
+	receiver, callback := newWebhookReceiver(t, 2)
+	defer callback.Close()
+
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	store.On("WriteObject", "1d2f/video_tile_0.ts", nil).Return(nil)
+
+	s, err := New(Config{
+		DispatchTimeout: time.Second,
+		Store:           &store,
+		TileStreamer:    &streamerMock{},
+		Webhook: WebhookConfig{
+			Secret:       "secret",
+			Backoff:      time.Millisecond,
+			AllowedHosts: []string{"127.0.0.1"},
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	s.newID = func() string { return "1d2f" }
+
+	status, err := s.TriggerWork(EncodeVideoRequest{
+		Tiles:       1,
+		Width:       100,
+		Height:      100,
+		FilePath:    "/videos/video.mp4",
+		CallbackURL: callback.URL,
+	})
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, status.State)
+
+	job, err := s.Dispatch("worker-1")
+	require.NoError(t, err)
+	require.Equal(t, status.ID, job.JobID)
+
+	status, err = s.JobStatus(job.JobID)
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, status.State)
+
+	require.NoError(t, s.AcceptResult(&worker.Result{
+		JobID:    job.JobID,
+		Lease:    job.Lease,
+		TileNum:  job.TileNum,
+		FileName: job.TileName + ".ts",
+	}))
+
+	select {
+	case <-receiver.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job events are not delivered")
+	}
+	require.Equal(t, []EventType{EventJobAccepted, EventTileCompleted, EventJobCompleted}, receiver.types())
+
+	status, err = s.JobStatus(job.JobID)
+	require.NoError(t, err)
+	require.Equal(t, StateCompleted, status.State)
+	require.Equal(t, []TileStatus{{Num: 0, Name: "video_tile_0", State: StateCompleted, Worker: "worker-1"}}, status.Tiles)
+}
+
+func TestServer_WebhooksQueueFull(t *testing.T) {
+	var store storeMock
+	store.On("HasObject", "/videos/video.mp4").Return(true)
+	s, err := New(Config{
+		DispatchTimeout: time.Second,
+		Store:           &store,
+		TileStreamer:    &streamerMock{},
+		MaxQueuedTiles:  1,
+		Webhook:         WebhookConfig{AllowedHosts: []string{"127.0.0.1"}},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the hook of the job which isn't queued is dropped
+	_, err = s.TriggerWork(EncodeVideoRequest{
+		Tiles:       2,
+		Width:       100,
+		Height:      100,
+		FilePath:    "/videos/video.mp4",
+		CallbackURL: "http://127.0.0.1:1/hooks",
+	})
+	var queueErr *QueueFullError
+	require.ErrorAs(t, err, &queueErr)
+	s.webhooks.mu.Lock()
+	defer s.webhooks.mu.Unlock()
+	require.Empty(t, s.webhooks.hooks)
+}
+
+func TestJobRegistry_Failed(t *testing.T) {
+	r := newJobRegistry(newMemoryJobStore())
+	r.add("1d2f", EncodeVideoRequest{}, []TileJob{{TileNum: 0, File: "v.mp4"}, {TileNum: 1, File: "v.mp4"}}, 0)
+
+	events := r.tileDispatched("1d2f", 0, "worker-1")
+	require.Len(t, events, 1)
+	require.Equal(t, EventTileDispatched, events[0].Type)
+	require.Equal(t, StateRunning, events[0].Tile.State)
+
+	events = r.tileFinished("1d2f", 0, errFake)
+	require.Len(t, events, 1)
+	require.Equal(t, EventTileFailed, events[0].Type)
+	require.Equal(t, "fake", events[0].Tile.Error)
+
+	events = r.tileFinished("1d2f", 1, nil)
+	require.Len(t, events, 2)
+	require.Equal(t, EventTileCompleted, events[0].Type)
+	require.Equal(t, EventJobFailed, events[1].Type)
+	require.Equal(t, StateFailed, events[1].Job.State)
+
+	// finished jobs are not changed anymore
+	require.Nil(t, r.tileFinished("1d2f", 1, nil))
+	require.Nil(t, r.tileFinished("unknown", 0, nil))
+}
+
+func TestWebhookNotifier_GiveUp(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer callback.Close()
+
+	n := newWebhookNotifier(WebhookConfig{MaxAttempts: 3, Backoff: time.Millisecond, AllowedHosts: []string{"127.0.0.0/8"}})
+	n.register("1d2f", callback.URL)
+	n.notify(Event{Type: EventJobFailed, JobID: "1d2f"})
+	defer n.close()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 3
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 3, attempts)
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	require.NoError(t, validateCallbackURL("https://orchestrator:8080/hooks?job=1"))
+	for _, callback := range []string{"orchestrator/hooks", "ftp://orchestrator/hooks", "http://", "%zz"} {
+		require.Error(t, validateCallbackURL(callback), callback)
+	}
+}
+
+func TestWebhookNotifier_CheckCallback(t *testing.T) {
+	n := newWebhookNotifier(WebhookConfig{AllowedHosts: []string{"orchestrator.local", "10.1.0.0/16", "::1"}})
+	defer n.close()
+
+	for _, callback := range []string{
+		"https://hooks.example.com/events",
+		"http://93.184.216.34:8080/hooks",
+		"http://orchestrator.local/hooks",
+		"http://10.1.2.3/hooks",
+		"http://[::1]:8080/hooks",
+	} {
+		require.NoError(t, n.checkCallback(callback), callback)
+	}
+	for _, callback := range []string{
+		"http://localhost:8080/hooks",
+		"http://api.localhost/hooks",
+		"http://127.0.0.1/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.2.0.1/hooks",
+		"http://192.168.1.1/hooks",
+		"http://[fe80::1]/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+		"http://0.0.0.0/hooks",
+	} {
+		require.ErrorIs(t, n.checkCallback(callback), ErrCallbackNotAllowed, callback)
+	}
+}
+
+func TestWebhookNotifier_NotAllowed(t *testing.T) {
+	var attempts int
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer callback.Close()
+
+	// the loopback address is rejected when the connection is made, so the host names resolving to it are rejected too
+	n := newWebhookNotifier(WebhookConfig{Backoff: time.Millisecond})
+	defer n.close()
+	err := n.deliver(strings.Replace(callback.URL, "127.0.0.1", "localhost", 1), Event{Type: EventJobAccepted, JobID: "1d2f"})
+	require.ErrorIs(t, err, ErrCallbackNotAllowed)
+	require.ErrorContains(t, err, "attempt 1:")
+	require.Zero(t, attempts)
+}
+
+func TestSignWebhook(t *testing.T) {
+	sig := SignWebhook("secret", "1600000000", []byte(`{"type":"job.accepted"}`))
+	require.True(t, strings.HasPrefix(sig, "sha256="))
+	require.Len(t, sig, len("sha256=")+64)
+	require.NotEqual(t, sig, SignWebhook("other", "1600000000", []byte(`{"type":"job.accepted"}`)))
+}
+
+func TestWebhookNotifier_OtherReplica(t *testing.T) {
+	receiver, callback := newWebhookReceiver(t, 1)
+	defer callback.Close()
+
+	// the job is triggered on another replica, its events carry the callback url
+	// and they're delivered in order, the failed delivery is retried before the next event
+	n := newWebhookNotifier(WebhookConfig{Secret: "secret", Backoff: 10 * time.Millisecond, AllowedHosts: []string{"127.0.0.1"}})
+	n.notify(Event{Type: EventTileProgress, JobID: "1d2f", callback: callback.URL})
+	n.notify(Event{Type: EventTileCompleted, JobID: "1d2f", callback: callback.URL})
+	n.notify(Event{Type: EventJobCompleted, JobID: "1d2f", callback: callback.URL})
+	select {
+	case <-receiver.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event is not delivered")
+	}
+	n.close()
+	require.Equal(t, []EventType{EventTileCompleted, EventJobCompleted}, receiver.types())
+}
+
+func TestWebhookNotifier_Idle(t *testing.T) {
+	receiver, callback := newWebhookReceiver(t, 0)
+	defer callback.Close()
+
+	// the job is finished on another replica, so its hook is released once it's idle
+	n := newWebhookNotifier(WebhookConfig{Secret: "secret", AllowedHosts: []string{"127.0.0.1"}})
+	n.idle = 10 * time.Millisecond
+	defer n.close()
+	n.notify(Event{Type: EventTileCompleted, JobID: "1d2f", callback: callback.URL})
+	require.Eventually(t, func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return len(n.hooks) == 0
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []EventType{EventTileCompleted}, receiver.types())
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkerStatus is a worker known to the server
+type WorkerStatus struct {
+	ID string `json:"id"`
+	// LastSeen is when the worker asked this replica for a tile the last time,
+	// it's zero for the workers seen only by the running tiles
+	LastSeen time.Time `json:"lastSeen,omitzero"`
+	// Tiles are the tiles running on the worker
+	Tiles []WorkerTile `json:"tiles"`
+}
+
+// WorkerTile is a tile running on the worker
+type WorkerTile struct {
+	JobID   string  `json:"jobId"`
+	TileNum int     `json:"tileNum"`
+	Percent float64 `json:"percent"`
+}
+
+// workerTable remembers when the workers asked for tiles, a worker is forgotten when it's not seen for ttl
+type workerTable struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+func newWorkerTable(ttl time.Duration) *workerTable {
+	return &workerTable{
+		last: make(map[string]time.Time),
+		ttl:  ttl,
+		now:  time.Now,
+	}
+}
+
+// seen records the poll of the worker
+func (t *workerTable) seen(workerID string) {
+	if t == nil || workerID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last[workerID] = t.now()
+}
+
+// list returns the last polls of the workers seen within ttl
+func (t *workerTable) list() map[string]time.Time {
+	workers := make(map[string]time.Time)
+	if t == nil {
+		return workers
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for id, last := range t.last {
+		if now.Sub(last) > t.ttl {
+			delete(t.last, id)
+			continue
+		}
+		workers[id] = last
+	}
+	return workers
+}
+
+// Workers returns the workers polling this replica and the workers of the running tiles of all the replicas
+func (s *Server) Workers() ([]WorkerStatus, error) {
+	recs, err := s.jobs.store.ListJobs(JobFilter{States: []JobState{StateRunning}})
+	if err != nil {
+		return nil, fmt.Errorf("jobs can't be listed: %w", err)
+	}
+
+	workers := make(map[string]*WorkerStatus)
+	for id, last := range s.workers.list() {
+		workers[id] = &WorkerStatus{ID: id, LastSeen: last, Tiles: []WorkerTile{}}
+	}
+	for _, rec := range recs {
+		for _, tile := range rec.Status.Tiles {
+			if tile.State != StateRunning || tile.Worker == "" {
+				continue
+			}
+			w, ok := workers[tile.Worker]
+			if !ok {
+				w = &WorkerStatus{ID: tile.Worker}
+				workers[tile.Worker] = w
+			}
+			running := WorkerTile{JobID: rec.Status.ID, TileNum: tile.Num}
+			if tile.Progress != nil {
+				running.Percent = tile.Progress.Percent
+			}
+			w.Tiles = append(w.Tiles, running)
+		}
+	}
+
+	list := make([]WorkerStatus, 0, len(workers))
+	for _, w := range workers {
+		list = append(list, *w)
+	}
+	slices.SortFunc(list, func(a, b WorkerStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return list, nil
+}
+
+// GET /work/workers
+func (h HTTPHandler) Workers(w http.ResponseWriter, req *http.Request) {
+	workers, err := h.Service.Workers()
+	if err != nil {
+		h.writeInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"workers": workers,
+	}); err != nil {
+		h.logErr(req, err)
+	}
+}
@@ -0,0 +1,67 @@
+// Package tracing configures OpenTelemetry tracing for the binaries
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+const (
+	// ExporterNone disables the spans export, trace context is still propagated
+	ExporterNone = "none"
+	// ExporterStdout writes spans to stdout
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to OTLP HTTP endpoint configured with OTEL_EXPORTER_OTLP_* env variables
+	ExporterOTLP = "otlp"
+)
+
+// ShutdownFunc flushes the pending spans and stops the exporter
+type ShutdownFunc func(context.Context) error
+
+// Setup sets the global tracer provider and W3C trace context propagator
+func Setup(ctx context.Context, serviceName, exporter string) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New()
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't create %s trace exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
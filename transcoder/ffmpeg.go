@@ -1,55 +1,217 @@
 package transcoder
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	ffmpeg = "ffmpeg"
+	ffmpeg  = "ffmpeg"
+	ffprobe = "ffprobe"
 )
 
 // Transcoder performs video operations
 type Transcoder struct {
 	encodeCmdFunc func(EncodeArgs) *exec.Cmd
 	cropCmdFunc   func(*CropArgs) *exec.Cmd
+	probeCmdFunc  func(string) *exec.Cmd
 }
 
 func New() *Transcoder {
 	return &Transcoder{
 		encodeCmdFunc: encodeVideo,
 		cropCmdFunc:   cropVideo,
+		probeCmdFunc:  probeDuration,
 	}
 }
 
 // TileStream cuts the video and streams the output
 func (t *Transcoder) StreamTile(ops *CropArgs) (io.ReadCloser, error) {
 	cmd := t.cropCmdFunc(ops)
-	return runForget(cmd)
+	return start(cmd, nil)
 }
 
 // Encode encodes the video stream
+// When ops.OnProgress is set it's called with the ffmpeg progress
 func (t *Transcoder) Encode(input io.Reader, ops EncodeArgs) (io.ReadCloser, error) {
 	cmd := t.encodeCmdFunc(ops)
 	cmd.Stdin = input
 
-	return runForget(cmd)
+	return start(cmd, ops.OnProgress)
 }
 
-// dirty solution consider running with context
-func runForget(cmd *exec.Cmd) (io.ReadCloser, error) {
+// Duration returns the duration of the video file
+func (t *Transcoder) Duration(path string) (time.Duration, error) {
+	cmd := t.probeCmdFunc(path)
+	out, err := cmd.Output()
+	if err != nil {
+		cmdErr := &CmdError{Args: cmd.Args, Err: err}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			cmdErr.Stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return 0, fmt.Errorf("can't probe %s: %w", path, cmdErr)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse %s duration: %w", path, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// CmdError is returned when the command fails, Stderr holds the tail of its output
+type CmdError struct {
+	Args   []string
+	Err    error
+	Stderr string
+}
+
+func (e *CmdError) Error() string {
+	if len(e.Args) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Args[0], e.Err)
+}
+
+func (e *CmdError) Unwrap() error {
+	return e.Err
+}
+
+// LogValue logs the error with the tail of the command output as the "error" and "stderr" fields
+func (e *CmdError) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("error", e.Error())}
+	if e.Stderr != "" {
+		attrs = append(attrs, slog.String("stderr", e.Stderr))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// start runs the command and returns its stdout,
+// the command is waited when the output is closed
+func start(cmd *exec.Cmd, onProgress func(Progress)) (io.ReadCloser, error) {
 	out, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
 	}
-	go func() {
-		if err := cmd.Run(); err != nil {
-			log.Printf("CMD ERROR: %s", cmd.Args)
+	r := &cmdReader{
+		ReadCloser: out,
+		cmd:        cmd,
+		progress:   make(chan struct{}),
+	}
+
+	var stderr io.ReadCloser
+	if onProgress != nil {
+		if stderr, err = cmd.StderrPipe(); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stderr = &r.stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	if stderr != nil {
+		go func() {
+			defer close(r.progress)
+			parseProgress(stderr, onProgress, &r.stderr)
+		}()
+	} else {
+		close(r.progress)
+	}
+
+	return r, nil
+}
+
+// cmdReader is a stdout of the running command
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+	// progress is closed when stderr is fully read
+	progress chan struct{}
+	stderr   tailBuffer
+}
+
+// Close closes the output and waits for the command, all reads from the pipes must be completed before Wait
+func (r *cmdReader) Close() error {
+	closeErr := r.ReadCloser.Close()
+	<-r.progress
+	if err := r.cmd.Wait(); err != nil {
+		return &CmdError{Args: r.cmd.Args, Err: err, Stderr: r.stderr.String()}
+	}
+	return closeErr
+}
+
+// maxStderr is a maximum size of the command output kept for the error
+const maxStderr = 4096
+
+// tailBuffer keeps the last maxStderr bytes written to it
+type tailBuffer struct {
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > maxStderr {
+		b.buf = b.buf[len(b.buf)-maxStderr:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return strings.TrimSpace(string(b.buf))
+}
+
+// Progress represents the ffmpeg encoding progress
+type Progress struct {
+	// Frame is an amount of encoded frames
+	Frame int64
+	// FPS is an encoding speed in frames per second
+	FPS float64
+	// OutTime is a timestamp of the encoded output
+	OutTime time.Duration
+	// Speed is an encoding speed relative to the playback speed
+	Speed float64
+	// Done is set on the last progress report
+	Done bool
+}
+
+// parseProgress parses `-progress` key=value blocks, fn is called at the end of each block,
+// the other lines are ffmpeg logs and written to logs
+func parseProgress(r io.Reader, fn func(Progress), logs io.Writer) {
+	var p Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.ContainsAny(key, " \t") {
+			fmt.Fprintln(logs, line)
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			p.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			p.Done = value == "end"
+			fn(p)
 		}
-	}()
-	return out, nil
+	}
 }
 
 // CropArgs for crop stream
@@ -80,11 +242,19 @@ type EncodeArgs struct {
 	Height int
 	// Width pixel resolution
 	Width int
+	// OnProgress receives the encoding progress, optional
+	OnProgress func(Progress)
 }
 
+// Profile identifies the encoding settings of encodeVideo, it's changed with them,
+// so the tiles encoded with the previous settings are not reused
+const Profile = "h264-ultrafast-gray-mpegts-1"
+
 // encodeVideo command using ffmpeg
 func encodeVideo(ops EncodeArgs) *exec.Cmd {
 	return exec.Command(ffmpeg,
+		"-nostats",
+		"-progress", "pipe:2",
 		"-f", "rawvideo",
 		"-pixel_format", "yuv420p",
 		"-video_size", fmt.Sprintf("%vx%v", ops.Width, ops.Height),
@@ -97,6 +267,15 @@ func encodeVideo(ops EncodeArgs) *exec.Cmd {
 		"pipe:1")
 }
 
+// probeDuration command using ffprobe
+func probeDuration(path string) *exec.Cmd {
+	return exec.Command(ffprobe,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path)
+}
+
 func buildCropFilter(ops *CropArgs) string {
 	return fmt.Sprintf("crop=w=%v:h=%v:x=%v:y=%v[a];[a]format=pix_fmts=yuv420p", ops.Width, ops.Height, ops.X, ops.Y)
 }
@@ -2,9 +2,11 @@ package transcoder
 
 import (
 	"io/ioutil"
+	"log/slog"
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -78,6 +80,8 @@ func Test_encodeVideo(t *testing.T) {
 
 	expected := []string{
 		"ffmpeg",
+		"-nostats",
+		"-progress", "pipe:2",
 		"-f", "rawvideo",
 		"-pixel_format", "yuv420p",
 		"-video_size", "50x30",
@@ -91,3 +95,107 @@ func Test_encodeVideo(t *testing.T) {
 	}
 	require.Equal(t, expected, cmd.Args)
 }
+
+func Test_probeDuration(t *testing.T) {
+	cmd := probeDuration("/videos/video.mp4")
+
+	expected := []string{
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		"/videos/video.mp4",
+	}
+	require.Equal(t, expected, cmd.Args)
+}
+
+func TestTranscoder_Duration(t *testing.T) {
+	coder := Transcoder{
+		probeCmdFunc: func(path string) *exec.Cmd {
+			require.Equal(t, "/videos/video.mp4", path)
+			return exec.Command("echo", "12.500000")
+		},
+	}
+
+	duration, err := coder.Duration("/videos/video.mp4")
+	require.NoError(t, err)
+	require.Equal(t, 12500*time.Millisecond, duration)
+}
+
+func TestTranscoder_EncodeProgress(t *testing.T) {
+	progress := "frame=24\nfps=12.5\nout_time_us=1000000\nspeed=0.5x\nprogress=continue\n" +
+		"frame=48\nfps=24.00\nout_time_us=2000000\nspeed=1.01x\nprogress=end\n"
+
+	coder := Transcoder{
+		encodeCmdFunc: func(args EncodeArgs) *exec.Cmd {
+			return exec.Command("sh", "-c", "printf '"+progress+"' >&2; cat")
+		},
+	}
+
+	var reports []Progress
+	out, err := coder.Encode(strings.NewReader(expectedOut), EncodeArgs{
+		OnProgress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := ioutil.ReadAll(out)
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+	require.Equal(t, expectedOut, string(result))
+
+	require.Equal(t, []Progress{
+		{Frame: 24, FPS: 12.5, OutTime: time.Second, Speed: 0.5},
+		{Frame: 48, FPS: 24, OutTime: 2 * time.Second, Speed: 1.01, Done: true},
+	}, reports)
+}
+
+func TestTranscoder_EncodeFailed(t *testing.T) {
+	coder := Transcoder{
+		encodeCmdFunc: func(args EncodeArgs) *exec.Cmd {
+			return exec.Command("sh", "-c", "echo 'pipe:: Invalid data found when processing input' >&2; exit 1")
+		},
+	}
+
+	out, err := coder.Encode(strings.NewReader(expectedOut), encodeArgs)
+	require.NoError(t, err)
+
+	_, err = ioutil.ReadAll(out)
+	require.NoError(t, err)
+
+	var cmdErr *CmdError
+	require.ErrorAs(t, out.Close(), &cmdErr)
+	require.Equal(t, "pipe:: Invalid data found when processing input", cmdErr.Stderr)
+	require.Equal(t, "sh: exit status 1", cmdErr.Error())
+	require.Equal(t, []slog.Attr{
+		slog.String("error", "sh: exit status 1"),
+		slog.String("stderr", "pipe:: Invalid data found when processing input"),
+	}, cmdErr.LogValue().Group())
+}
+
+func TestTranscoder_EncodeProgressFailed(t *testing.T) {
+	coder := Transcoder{
+		encodeCmdFunc: func(args EncodeArgs) *exec.Cmd {
+			return exec.Command("sh", "-c", "printf 'frame=24\\nprogress=continue\\nError while decoding stream #0:0\\n' >&2; exit 1")
+		},
+	}
+
+	out, err := coder.Encode(strings.NewReader(expectedOut), EncodeArgs{OnProgress: func(Progress) {}})
+	require.NoError(t, err)
+
+	_, err = ioutil.ReadAll(out)
+	require.NoError(t, err)
+
+	var cmdErr *CmdError
+	require.ErrorAs(t, out.Close(), &cmdErr)
+	require.Equal(t, "Error while decoding stream #0:0", cmdErr.Stderr)
+}
+
+func TestTailBuffer(t *testing.T) {
+	var b tailBuffer
+	_, _ = b.Write([]byte(strings.Repeat("a", maxStderr)))
+	_, _ = b.Write([]byte("tail"))
+	require.Len(t, b.String(), maxStderr)
+	require.True(t, strings.HasSuffix(b.String(), "tail"))
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// WorkerIDHeader is a self-reported identity of the worker, the client certificate identity is preferred by the server
+	WorkerIDHeader = "X-Worker-Id"
+
+	// AuthTimestampHeader is a unix time of the signed worker request
+	AuthTimestampHeader = "X-Auth-Timestamp"
+	// AuthSignatureHeader is a signature of the worker request, see SignRequest
+	AuthSignatureHeader = "X-Auth-Signature"
+	// AuthNonceHeader is a random value of the signed request, the server accepts each nonce once
+	AuthNonceHeader = "X-Auth-Nonce"
+	// AuthContentHeader is hex SHA-256 of the signed request body or UnsignedPayload
+	AuthContentHeader = "X-Auth-Content-Sha256"
+
+	// UnsignedPayload is the AuthContentHeader of the streamed bodies, the results and their parts,
+	// they're bound to the tile by the signed job, tile and lease headers instead
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var (
+	// ErrUnauthorized happens when the server rejects the missing or invalid credentials
+	ErrUnauthorized = errors.New("unauthorized: credentials are missing or invalid")
+	// ErrForbidden happens when the credentials are valid but not allowed for the endpoint
+	ErrForbidden = errors.New("forbidden: credentials are not allowed for the endpoint")
+)
+
+// SignRequest signs the worker request with the pre-shared secret, the job, tile and lease headers
+// must be set before. The body is signed when it can be read again (http.NewRequest makes such requests
+// of bytes and strings readers), the streamed bodies are sent with UnsignedPayload
+func SignRequest(req *http.Request, secret string, now time.Time) {
+	req.Header.Set(AuthTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(AuthNonceHeader, NewNonce())
+	req.Header.Set(AuthContentHeader, contentSHA256(req))
+	req.Header.Set(AuthSignatureHeader, ParseSignedRequest(req).Signature(secret))
+}
+
+// SignedRequest is the part of the worker request covered by the signature
+type SignedRequest struct {
+	Method    string
+	Path      string
+	Timestamp string
+	Nonce     string
+	// JobID, TileNum and Lease are the values of the job headers, they're empty when the headers are not set
+	JobID   string
+	TileNum string
+	Lease   string
+	// ContentSHA256 is hex SHA-256 of the body or UnsignedPayload
+	ContentSHA256 string
+}
+
+// ParseSignedRequest returns the signed part of the request
+func ParseSignedRequest(req *http.Request) SignedRequest {
+	return SignedRequest{
+		Method:        req.Method,
+		Path:          req.URL.Path,
+		Timestamp:     req.Header.Get(AuthTimestampHeader),
+		Nonce:         req.Header.Get(AuthNonceHeader),
+		JobID:         req.Header.Get(jobIDHeader),
+		TileNum:       req.Header.Get(tileNumHeader),
+		Lease:         req.Header.Get(LeaseHeader),
+		ContentSHA256: req.Header.Get(AuthContentHeader),
+	}
+}
+
+// Signature returns "sha256=" + hex HMAC-SHA256 of the newline separated timestamp, nonce, method, path,
+// job id, tile number, lease and content hash with the secret as a key
+func (r SignedRequest) Signature(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s",
+		r.Timestamp, r.Nonce, r.Method, r.Path, r.JobID, r.TileNum, r.Lease, r.ContentSHA256)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewNonce returns a random nonce of the signed request
+func NewNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ContentSHA256 returns hex SHA-256 of the body
+func ContentSHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentSHA256 hashes the body of the request which can be read again, UnsignedPayload is returned otherwise
+func contentSHA256(req *http.Request) string {
+	if req.Body == nil || req.Body == http.NoBody {
+		return ContentSHA256(nil)
+	}
+	if req.GetBody == nil {
+		return UnsignedPayload
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return UnsignedPayload
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return UnsignedPayload
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkStatus converts the unexpected response status to the error
+func checkStatus(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	default:
+		return fmt.Errorf("unexpected status code: %v", res.StatusCode)
+	}
+}
@@ -1,14 +1,24 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"distributed-encoder/logging"
 )
 
 // HandleJobFunc is triggered when job is called
@@ -18,21 +28,124 @@ const (
 	defaultRetryTimeout = 5 * time.Second
 )
 
+// ClientConfig represents the HTTPClient configuration
+type ClientConfig struct {
+	PollEndpoint     string
+	ResultEndpoint   string
+	ProgressEndpoint string
+	// FailureEndpoint receives the tiles which can't be encoded, the failures are only logged when it's empty
+	FailureEndpoint string
+	// PushEndpoint enables the WebSocket channel the server pushes the jobs to instead of the long polling,
+	// e.g. ws://server/work/push, the tiles are fetched from PollEndpoint/{job id}/tiles/{tile num}
+	PushEndpoint string
+	// UploadEndpoint enables the resumable uploads of the results in parts, the result is sent in a single request
+	// to ResultEndpoint when it's empty
+	UploadEndpoint string
+	// ChunkSize is a size of the uploaded part, 8MiB by default
+	ChunkSize int64
+	// SpoolDir is a directory the result is stored in until it's uploaded, os.TempDir by default
+	SpoolDir string
+	// UploadRetryDelay is a pause before the interrupted part is resumed, 1 second by default
+	UploadRetryDelay time.Duration
+
+	// Secret is a pre-shared secret the requests are signed with, requests are not signed when it's empty
+	Secret string
+	// WorkerID is sent to the server to identify the worker when it has no client certificate
+	WorkerID string
+
+	// CAFile is a PEM file of the CA the server certificate is verified with, system roots are used when it's empty
+	CAFile string
+	// CertFile and KeyFile are PEM files of the client certificate, it's not sent when they're empty
+	CertFile string
+	KeyFile  string
+}
+
 // HTTPClient connects to server and gets jobs using long polling
 type HTTPClient struct {
 	client *http.Client
 
-	pollEndpoint   string
-	resultEndpoint string
+	pollEndpoint     string
+	resultEndpoint   string
+	progressEndpoint string
+	failureEndpoint  string
+	secret           string
+	workerID         string
+
+	pushEndpoint     string
+	uploadEndpoint   string
+	chunkSize        int64
+	spoolDir         string
+	uploadAttempts   int
+	uploadRetryDelay time.Duration
 }
 
 // NewClient creates new HTTPClient
-func NewClient(pollEndpoint, resultEndpoint string) *HTTPClient {
+func NewClient(cfg ClientConfig) (*HTTPClient, error) {
+	if cfg.PollEndpoint == "" || cfg.ResultEndpoint == "" || cfg.ProgressEndpoint == "" {
+		return nil, fmt.Errorf("endpoints are empty")
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	if cfg.UploadRetryDelay <= 0 {
+		cfg.UploadRetryDelay = defaultUploadRetryDelay
+	}
+	client := &http.Client{}
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		tlsConfig, err := LoadClientTLSConfig(cfg.CAFile, cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
 	return &HTTPClient{
-		client:         &http.Client{},
-		pollEndpoint:   pollEndpoint,
-		resultEndpoint: resultEndpoint,
+		client:           client,
+		pollEndpoint:     cfg.PollEndpoint,
+		resultEndpoint:   cfg.ResultEndpoint,
+		progressEndpoint: cfg.ProgressEndpoint,
+		failureEndpoint:  cfg.FailureEndpoint,
+		secret:           cfg.Secret,
+		workerID:         cfg.WorkerID,
+		pushEndpoint:     cfg.PushEndpoint,
+		uploadEndpoint:   cfg.UploadEndpoint,
+		chunkSize:        cfg.ChunkSize,
+		spoolDir:         cfg.SpoolDir,
+		uploadAttempts:   defaultUploadAttempts,
+		uploadRetryDelay: cfg.UploadRetryDelay,
+	}, nil
+}
+
+// LoadClientTLSConfig returns the TLS config verifying the server with the CA (the system roots when it's empty)
+// and presenting the client certificate when it's set
+func LoadClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA %s has no certificates", caFile)
+		}
+		cfg.RootCAs = pool
 	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// logger is resolved on each use, so the default logger configured by the binary is picked up
+func (c *HTTPClient) logger() *slog.Logger {
+	return slog.Default().With(logging.ComponentKey, "client")
 }
 
 var (
@@ -40,16 +153,24 @@ var (
 	ErrCancelled = errors.New("canceled")
 )
 
-// Subscribe subscribes for the jobs
+// Subscribe subscribes for the jobs, they're pushed over WebSocket when the push endpoint is set
 func (c *HTTPClient) Subscribe(ctx context.Context, handlerFunc HandleJobFunc) error {
+	if c.pushEndpoint != "" {
+		return c.subscribePush(ctx, handlerFunc)
+	}
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("[poll] canceled")
+			c.logger().Info("polling is canceled")
 			return ErrCancelled
 		default:
-			if err := c.pollingFlow(handlerFunc); err != nil {
-				log.Printf("[poll] error: %s, retry timeout 5 sec", err)
+			err := c.pollingFlow(handlerFunc)
+			if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) {
+				// retrying won't help until the credentials are fixed
+				return err
+			}
+			if err != nil {
+				c.logger().Error("polling failed, retrying", logging.Err(err), slog.Duration("retry_in", defaultRetryTimeout))
 				time.Sleep(defaultRetryTimeout)
 				return nil
 			}
@@ -58,7 +179,7 @@ func (c *HTTPClient) Subscribe(ctx context.Context, handlerFunc HandleJobFunc) e
 }
 
 func (c *HTTPClient) pollingFlow(handler HandleJobFunc) error {
-	log.Println("[poll] start")
+	c.logger().Debug("polling started")
 	res, err := c.poll()
 	if err != nil {
 		return err
@@ -66,16 +187,19 @@ func (c *HTTPClient) pollingFlow(handler HandleJobFunc) error {
 
 	switch res.StatusCode {
 	case http.StatusNotModified: // timed out try again
-		log.Println("[poll] timeout")
+		c.logger().Debug("polling timed out")
 		return nil
 	case http.StatusOK:
-		log.Println("[poll] answered")
+		c.logger().Debug("job received")
 		err := handle(res, handler)
 		if err != nil {
 			return err
 		}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		res.Body.Close()
+		return checkStatus(res)
 	default:
-		log.Println("[poll] unexpected status code: ", res.StatusCode)
+		c.logger().Warn("unexpected poll status code", slog.Int("status", res.StatusCode))
 	}
 
 	return nil
@@ -99,6 +223,7 @@ func (c *HTTPClient) poll() (*http.Response, error) {
 		return nil, err
 	}
 	req.Header.Set("Connection", "keep-alive")
+	c.sign(req)
 
 	res, err := c.client.Do(req)
 	if err != nil {
@@ -108,17 +233,21 @@ func (c *HTTPClient) poll() (*http.Response, error) {
 	return res, nil
 }
 
-// SendResult send result to server
-func (c *HTTPClient) SendResult(fileName string, body io.Reader) error {
-	req, err := http.NewRequest(http.MethodPost, c.resultEndpoint, body)
+// SendResult send result to server, in parts when the upload endpoint is set
+func (c *HTTPClient) SendResult(result *Result) error {
+	if c.uploadEndpoint != "" {
+		return c.sendChunked(result)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.resultEndpoint, result.Src)
 	if err != nil {
 		return err
 	}
 	defer req.Body.Close()
 
-	header := req.Header
-	header.Set("Content-Disposition", "attachment; filename="+fileName)
-	header.Set("Content-Type", "application/octet-stream")
+	MarshalResultToHeader(result, req.Header)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	c.sign(req)
 
 	res, err := c.client.Do(req)
 	if err != nil {
@@ -126,13 +255,64 @@ func (c *HTTPClient) SendResult(fileName string, body io.Reader) error {
 	}
 	res.Body.Close()
 
-	return nil
+	return checkStatus(res)
+}
+
+// ReportProgress sends the encoding progress to server
+func (c *HTTPClient) ReportProgress(progress *Progress) error {
+	return c.postJSON(c.progressEndpoint, progress)
+}
+
+// ReportFailure sends the encoding failure to server, it's skipped when the failure endpoint is not set
+func (c *HTTPClient) ReportFailure(failure *Failure) error {
+	if c.failureEndpoint == "" {
+		return nil
+	}
+	return c.postJSON(c.failureEndpoint, failure)
+}
+
+func (c *HTTPClient) postJSON(endpoint string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.sign(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return checkStatus(res)
+}
+
+// sign identifies the worker and signs the request when the secret is set
+func (c *HTTPClient) sign(req *http.Request) {
+	if c.workerID != "" {
+		req.Header.Set(WorkerIDHeader, c.workerID)
+	}
+	if c.secret != "" {
+		SignRequest(req, c.secret, time.Now())
+	}
 }
 
+// LeaseHeader carries the lease of the dispatched tile
+const LeaseHeader = "X-Lease"
+
 const (
-	tileHeader   = "X-Tile"
-	heightHeader = "X-Height"
-	widthHeader  = "X-Width"
+	tileHeader    = "X-Tile"
+	heightHeader  = "X-Height"
+	widthHeader   = "X-Width"
+	jobIDHeader   = "X-Job-Id"
+	tileNumHeader = "X-Tile-Num"
+
+	contentDispositionHeader = "Content-Disposition"
 )
 
 // ParseJobFromHTTP parses worker Job from http.Response
@@ -150,17 +330,100 @@ func ParseJobFromHTTP(res *http.Response) (Job, error) {
 	if err != nil {
 		return Job{}, err
 	}
+	jobID, tileNum, err := parseTileRef(h)
+	if err != nil {
+		return Job{}, err
+	}
 
 	return Job{
+		JobID:    jobID,
+		TileNum:  tileNum,
 		TileName: tileName,
 		Height:   height,
 		Width:    width,
+		Lease:    h.Get(LeaseHeader),
+		Trace:    parseTrace(h),
 		Src:      res.Body,
 	}, nil
 }
 
+// MarshalJobToHeader writes worker Job fields to the http.Header
 func MarshalJobToHeader(job *Job, header http.Header) {
+	header.Set(jobIDHeader, job.JobID)
+	header.Set(tileNumHeader, strconv.Itoa(job.TileNum))
 	header.Set(tileHeader, job.TileName)
 	header.Set(heightHeader, strconv.Itoa(job.Height))
 	header.Set(widthHeader, strconv.Itoa(job.Width))
+	marshalLease(job.Lease, header)
+	marshalTrace(job.Trace, header)
+}
+
+// ParseResultFromHTTP parses worker Result from http.Request
+func ParseResultFromHTTP(req *http.Request) (Result, error) {
+	_, params, err := mime.ParseMediaType(req.Header.Get(contentDispositionHeader))
+	if err != nil {
+		return Result{}, fmt.Errorf("%s is invalid: %w", contentDispositionHeader, err)
+	}
+	jobID, tileNum, err := parseTileRef(req.Header)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		JobID:    jobID,
+		TileNum:  tileNum,
+		FileName: params["filename"],
+		Lease:    req.Header.Get(LeaseHeader),
+		Trace:    parseTrace(req.Header),
+		Src:      req.Body,
+	}, nil
+}
+
+// MarshalResultToHeader writes worker Result fields to the http.Header
+func MarshalResultToHeader(result *Result, header http.Header) {
+	header.Set(contentDispositionHeader, mime.FormatMediaType("attachment", map[string]string{
+		"filename": result.FileName,
+	}))
+	header.Set(jobIDHeader, result.JobID)
+	header.Set(tileNumHeader, strconv.Itoa(result.TileNum))
+	marshalLease(result.Lease, header)
+	marshalTrace(result.Trace, header)
+}
+
+func marshalLease(lease string, header http.Header) {
+	if lease != "" {
+		header.Set(LeaseHeader, lease)
+	}
+}
+
+// parseTrace reads the trace context headers of the global propagator
+func parseTrace(h http.Header) propagation.MapCarrier {
+	var carrier propagation.MapCarrier
+	for _, key := range otel.GetTextMapPropagator().Fields() {
+		if value := h.Get(key); value != "" {
+			if carrier == nil {
+				carrier = propagation.MapCarrier{}
+			}
+			carrier.Set(key, value)
+		}
+	}
+	return carrier
+}
+
+func marshalTrace(carrier propagation.MapCarrier, header http.Header) {
+	for key, value := range carrier {
+		header.Set(key, value)
+	}
+}
+
+func parseTileRef(h http.Header) (jobID string, tileNum int, err error) {
+	jobID = h.Get(jobIDHeader)
+	if jobID == "" {
+		return "", 0, fmt.Errorf(jobIDHeader + " is invalid")
+	}
+	tileNum, err = strconv.Atoi(h.Get(tileNumHeader))
+	if err != nil {
+		return "", 0, fmt.Errorf(tileNumHeader+" is invalid: %w", err)
+	}
+	return jobID, tileNum, nil
 }
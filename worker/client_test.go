@@ -10,16 +10,22 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 var (
 	jobHeader = http.Header{
-		"X-Tile":   {"job"},
-		"X-Height": {"4242"},
-		"X-Width":  {"42"},
+		"X-Job-Id":   {"1d2f"},
+		"X-Tile-Num": {"3"},
+		"X-Tile":     {"job"},
+		"X-Height":   {"4242"},
+		"X-Width":    {"42"},
 	}
 
 	testJob = Job{
+		JobID:    "1d2f",
+		TileNum:  3,
 		TileName: "job",
 		Height:   4242,
 		Width:    42,
@@ -84,6 +90,8 @@ func TestHTTPClient_SendResult(t *testing.T) {
 
 		require.Equal(t, "attachment; filename=8k_video", r.Header.Get("Content-Disposition"))
 		require.Equal(t, "application/octet-stream", r.Header.Get("Content-Type"))
+		require.Equal(t, "1d2f", r.Header.Get("X-Job-Id"))
+		require.Equal(t, "3", r.Header.Get("X-Tile-Num"))
 
 		b, err := ioutil.ReadAll(r.Body)
 		require.NoError(t, err)
@@ -96,6 +104,196 @@ func TestHTTPClient_SendResult(t *testing.T) {
 		client:         server.Client(),
 		resultEndpoint: server.URL + "/work/result",
 	}
-	err := c.SendResult("8k_video", strings.NewReader(body))
+	err := c.SendResult(&Result{
+		JobID:    "1d2f",
+		TileNum:  3,
+		FileName: "8k_video",
+		Src:      strings.NewReader(body),
+	})
+	require.NoError(t, err)
+}
+
+func TestParseResultFromHTTP(t *testing.T) {
+	tests := map[string]struct {
+		header  http.Header
+		want    Result
+		wantErr bool
+	}{
+		"correct usage": {
+			header: http.Header{
+				"Content-Disposition": {`attachment; filename="video_tile_3.ts"`},
+				"X-Job-Id":            {"1d2f"},
+				"X-Tile-Num":          {"3"},
+			},
+			want: Result{
+				JobID:    "1d2f",
+				TileNum:  3,
+				FileName: "video_tile_3.ts",
+				Src:      http.NoBody,
+			},
+		},
+		"no content disposition": {
+			header: http.Header{
+				"X-Job-Id":   {"1d2f"},
+				"X-Tile-Num": {"3"},
+			},
+			wantErr: true,
+		},
+		"no job": {
+			header: http.Header{
+				"Content-Disposition": {"attachment; filename=video_tile_3.ts"},
+				"X-Tile-Num":          {"3"},
+			},
+			wantErr: true,
+		},
+		"invalid tile": {
+			header: http.Header{
+				"Content-Disposition": {"attachment; filename=video_tile_3.ts"},
+				"X-Job-Id":            {"1d2f"},
+				"X-Tile-Num":          {"three"},
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &http.Request{Header: tt.header, Body: http.NoBody}
+			got, err := ParseResultFromHTTP(req)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHTTPClient_ReportProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/work/progress", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"jobId":"1d2f","tile":3,"frame":48,"fps":24,"outTime":2000000000,"speed":1.5}`, string(b))
+	}))
+	defer server.Close()
+
+	c := HTTPClient{
+		client:           server.Client(),
+		progressEndpoint: server.URL + "/work/progress",
+	}
+	err := c.ReportProgress(&Progress{
+		JobID:   "1d2f",
+		TileNum: 3,
+		Frame:   48,
+		FPS:     24,
+		OutTime: 2 * time.Second,
+		Speed:   1.5,
+	})
 	require.NoError(t, err)
 }
+
+func TestHTTPClient_ReportFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/work/failure", r.URL.Path)
+
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"jobId":"1d2f","tile":3,"lease":"9a1c","error":"exit status 1"}`, string(b))
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	c := HTTPClient{client: server.Client()}
+	failure := &Failure{JobID: "1d2f", TileNum: 3, Lease: "9a1c", Error: "exit status 1"}
+	require.NoError(t, c.ReportFailure(failure))
+
+	c.failureEndpoint = server.URL + "/work/failure"
+	require.Error(t, c.ReportFailure(failure))
+}
+
+func TestMarshalJobToHeader_Trace(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prev)
+
+	job := testJob
+	job.Trace = propagation.MapCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	header := http.Header{}
+	MarshalJobToHeader(&job, header)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", header.Get("Traceparent"))
+
+	result, err := ParseJobFromHTTP(&http.Response{Header: header})
+	require.NoError(t, err)
+	require.Equal(t, job, result)
+}
+
+func TestMarshalLease(t *testing.T) {
+	job := testJob
+	job.Lease = "9a1c"
+	header := http.Header{}
+	MarshalJobToHeader(&job, header)
+	require.Equal(t, "9a1c", header.Get("X-Lease"))
+
+	parsed, err := ParseJobFromHTTP(&http.Response{Header: header})
+	require.NoError(t, err)
+	require.Equal(t, job, parsed)
+
+	req := httptest.NewRequest(http.MethodPost, "/work/result", nil)
+	MarshalResultToHeader(&Result{JobID: "1d2f", TileNum: 1, FileName: "video_tile_1.ts", Lease: "9a1c"}, req.Header)
+	result, err := ParseResultFromHTTP(req)
+	require.NoError(t, err)
+	require.Equal(t, "9a1c", result.Lease)
+}
+
+func TestHTTPClient_Auth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(AuthSignatureHeader) != ParseSignedRequest(r).Signature("secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(ClientConfig{
+		PollEndpoint:     server.URL + "/work/jobs",
+		ResultEndpoint:   server.URL + "/work/result",
+		ProgressEndpoint: server.URL + "/work/progress",
+		Secret:           "secret",
+	})
+	require.NoError(t, err)
+	c.client = server.Client()
+
+	// the signature is valid, the server forbids the requests
+	require.ErrorIs(t, c.ReportProgress(&Progress{JobID: "1d2f"}), ErrForbidden)
+	require.ErrorIs(t, c.SendResult(&Result{JobID: "1d2f", FileName: "file.ts", Src: strings.NewReader("file")}), ErrForbidden)
+	require.ErrorIs(t, c.Subscribe(context.Background(), func(*Job) error { return nil }), ErrForbidden)
+
+	c.secret = "guess"
+	require.ErrorIs(t, c.ReportProgress(&Progress{JobID: "1d2f"}), ErrUnauthorized)
+	require.ErrorIs(t, c.Subscribe(context.Background(), func(*Job) error { return nil }), ErrUnauthorized)
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(ClientConfig{PollEndpoint: "http://localhost:1111/work/jobs"})
+	require.Error(t, err)
+
+	cfg := ClientConfig{
+		PollEndpoint:     "https://localhost:1111/work/jobs",
+		ResultEndpoint:   "https://localhost:1111/work/result",
+		ProgressEndpoint: "https://localhost:1111/work/progress",
+		CAFile:           "/not/found/ca.pem",
+	}
+	_, err = NewClient(cfg)
+	require.Error(t, err)
+
+	cfg.CAFile = ""
+	cfg.CertFile = "/not/found/worker.pem"
+	_, err = NewClient(cfg)
+	require.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/worker/workerpb"
+)
+
+// GRPCClientConfig represents the GRPCClient configuration
+type GRPCClientConfig struct {
+	// Target is the server address, e.g. encoder:1112
+	Target string
+
+	// Secret is a pre-shared secret the calls are signed with, calls are not signed when it's empty
+	Secret string
+	// WorkerID is sent to the server to identify the worker when it has no client certificate
+	WorkerID string
+
+	// CAFile, CertFile and KeyFile enable TLS, see ClientConfig
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// DialOptions are added to the connection options, e.g. a custom dialer
+	DialOptions []grpc.DialOption
+}
+
+// GRPCClient receives the jobs on a bidirectional gRPC stream, it's an alternative to HTTPClient
+type GRPCClient struct {
+	conn     *grpc.ClientConn
+	client   workerpb.WorkerClient
+	secret   string
+	workerID string
+}
+
+// NewGRPCClient creates new GRPCClient, the connection is established on the first call
+func NewGRPCClient(cfg GRPCClientConfig) (*GRPCClient, error) {
+	if cfg.Target == "" {
+		return nil, fmt.Errorf("target is empty")
+	}
+	creds := insecure.NewCredentials()
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		tlsConfig, err := LoadClientTLSConfig(cfg.CAFile, cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	c := &GRPCClient{secret: cfg.Secret, workerID: cfg.WorkerID}
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(c.signUnary),
+		grpc.WithChainStreamInterceptor(c.signStream),
+	}, cfg.DialOptions...)
+
+	conn, err := grpc.NewClient(cfg.Target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.client = workerpb.NewWorkerClient(conn)
+	return c, nil
+}
+
+// Close closes the connection
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *GRPCClient) logger() *slog.Logger {
+	return slog.Default().With(logging.ComponentKey, "grpc-client")
+}
+
+// Subscribe asks for the jobs one by one on the jobs stream, the stream is opened again after the failures
+func (c *GRPCClient) Subscribe(ctx context.Context, handlerFunc HandleJobFunc) error {
+	for {
+		err := c.receiveJobs(ctx, handlerFunc)
+		if ctx.Err() != nil {
+			c.logger().Info("subscription is canceled")
+			return ErrCancelled
+		}
+		err = fromStatus(err)
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) {
+			// retrying won't help until the credentials are fixed
+			return err
+		}
+		c.logger().Error("jobs stream failed, retrying", logging.Err(err), slog.Duration("retry_in", defaultRetryTimeout))
+
+		select {
+		case <-ctx.Done():
+			return ErrCancelled
+		case <-time.After(defaultRetryTimeout):
+		}
+	}
+}
+
+func (c *GRPCClient) receiveJobs(ctx context.Context, handlerFunc HandleJobFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the next job is asked for only when the previous one is handled and the subscription is not canceled
+	for ctx.Err() == nil {
+		if err := stream.Send(&workerpb.JobRequest{Jobs: 1}); err != nil {
+			return err
+		}
+		chunk, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if chunk.Header == nil {
+			return fmt.Errorf("job header is missing")
+		}
+
+		src := &jobReader{stream: stream}
+		job := jobFromHeader(chunk.Header, src)
+		c.logger().Debug("job received", logging.Job(job.JobID, job.TileNum))
+		if err := handlerFunc(job); err != nil {
+			c.logger().Error("job failed", logging.Job(job.JobID, job.TileNum), logging.Err(err))
+		}
+		// the rest of the tile is skipped, so the stream is ready for the next job
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// SendResult streams the result to the server in chunks, the upload is canceled when the result can't be read,
+// so the server doesn't store a part of it
+func (c *GRPCClient) SendResult(result *Result) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := c.client.Upload(ctx)
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	// a failed send means the stream is closed by the server, its status is received on CloseAndRecv
+	err = stream.Send(&workerpb.ResultChunk{Header: result.resultHeader()})
+	buf := make([]byte, GRPCChunkSize)
+	for err == nil {
+		n, readErr := result.Src.Read(buf)
+		if n > 0 {
+			err = stream.Send(&workerpb.ResultChunk{Data: buf[:n]})
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	_, err = stream.CloseAndRecv()
+	return fromStatus(err)
+}
+
+// ReportProgress sends the encoding progress to server
+func (c *GRPCClient) ReportProgress(progress *Progress) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRetryTimeout)
+	defer cancel()
+
+	_, err := c.client.ReportProgress(ctx, progress.message())
+	return fromStatus(err)
+}
+
+// ReportFailure sends the encoding failure to server
+func (c *GRPCClient) ReportFailure(failure *Failure) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRetryTimeout)
+	defer cancel()
+
+	_, err := c.client.ReportFailure(ctx, failure.message())
+	return fromStatus(err)
+}
+
+// signUnary and signStream sign the calls the same way as the HTTP requests, the path is the gRPC method.
+// The message of the unary call is signed, the streams are signed with UnsignedPayload
+func (c *GRPCClient) signUnary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(c.signContext(ctx, method, MessageSHA256(req)), method, req, reply, cc, opts...)
+}
+
+func (c *GRPCClient) signStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(c.signContext(ctx, method, UnsignedPayload), desc, cc, method, opts...)
+}
+
+func (c *GRPCClient) signContext(ctx context.Context, method, content string) context.Context {
+	var pairs []string
+	if c.workerID != "" {
+		pairs = append(pairs, WorkerIDHeader, c.workerID)
+	}
+	if c.secret != "" {
+		signed := SignedRequest{
+			Method:        GRPCMethod,
+			Path:          method,
+			Timestamp:     strconv.FormatInt(time.Now().Unix(), 10),
+			Nonce:         NewNonce(),
+			ContentSHA256: content,
+		}
+		pairs = append(pairs,
+			AuthTimestampHeader, signed.Timestamp,
+			AuthNonceHeader, signed.Nonce,
+			AuthContentHeader, signed.ContentSHA256,
+			AuthSignatureHeader, signed.Signature(c.secret),
+		)
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// MessageSHA256 returns hex SHA-256 of the deterministic protobuf encoding of the unary call message,
+// UnsignedPayload when it can't be encoded
+func MessageSHA256(msg any) string {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return UnsignedPayload
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return UnsignedPayload
+	}
+	return ContentSHA256(body)
+}
+
+// GRPCMethod is the HTTP method of all the gRPC calls, the signature of the call is made with it
+const GRPCMethod = "POST"
+
+// fromStatus converts the authentication statuses to the errors of HTTPClient
+func fromStatus(err error) error {
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	case codes.PermissionDenied:
+		return fmt.Errorf("%w: %v", ErrForbidden, err)
+	default:
+		return err
+	}
+}
+
+// jobReader reads the tile data from the jobs stream until the EOF chunk
+type jobReader struct {
+	stream grpc.BidiStreamingClient[workerpb.JobRequest, workerpb.JobChunk]
+	buf    []byte
+	eof    bool
+}
+
+func (r *jobReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.buf, r.eof = chunk.Data, chunk.Eof
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *jobReader) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"io"
+	"time"
+
+	"distributed-encoder/worker/workerpb"
+)
+
+// The worker gRPC service is generated from workerpb/worker.proto,
+// its messages are converted to the types shared with the HTTP transport here
+
+// GRPCChunkSize is a maximum size of the tile or result data in a single message
+const GRPCChunkSize = 32 << 10
+
+// JobHeader returns the header chunk of the job streamed to the worker
+func (j *Job) JobHeader() *workerpb.JobHeader {
+	return &workerpb.JobHeader{
+		JobId:    j.JobID,
+		TileNum:  int32(j.TileNum),
+		TileName: j.TileName,
+		Height:   int32(j.Height),
+		Width:    int32(j.Width),
+		Lease:    j.Lease,
+		Trace:    j.Trace,
+	}
+}
+
+// jobFromHeader returns the job of the header chunk, src reads its tile data
+func jobFromHeader(h *workerpb.JobHeader, src io.ReadCloser) *Job {
+	return &Job{
+		JobID:    h.GetJobId(),
+		TileNum:  int(h.GetTileNum()),
+		TileName: h.GetTileName(),
+		Height:   int(h.GetHeight()),
+		Width:    int(h.GetWidth()),
+		Lease:    h.GetLease(),
+		Trace:    h.GetTrace(),
+		Src:      src,
+	}
+}
+
+// resultHeader returns the header chunk of the uploaded result
+func (r *Result) resultHeader() *workerpb.ResultHeader {
+	return &workerpb.ResultHeader{
+		JobId:    r.JobID,
+		TileNum:  int32(r.TileNum),
+		FileName: r.FileName,
+		Lease:    r.Lease,
+		Trace:    r.Trace,
+	}
+}
+
+// ResultFromHeader returns the result of the header chunk, src reads its data
+func ResultFromHeader(h *workerpb.ResultHeader, src io.Reader) *Result {
+	return &Result{
+		JobID:    h.GetJobId(),
+		TileNum:  int(h.GetTileNum()),
+		FileName: h.GetFileName(),
+		Lease:    h.GetLease(),
+		Trace:    h.GetTrace(),
+		Src:      src,
+	}
+}
+
+func (p *Progress) message() *workerpb.Progress {
+	return &workerpb.Progress{
+		JobId:   p.JobID,
+		TileNum: int32(p.TileNum),
+		Frame:   p.Frame,
+		Fps:     p.FPS,
+		OutTime: int64(p.OutTime),
+		Speed:   p.Speed,
+		Lease:   p.Lease,
+	}
+}
+
+// ProgressFromMessage returns the progress reported over gRPC
+func ProgressFromMessage(m *workerpb.Progress) *Progress {
+	return &Progress{
+		JobID:   m.GetJobId(),
+		TileNum: int(m.GetTileNum()),
+		Frame:   m.GetFrame(),
+		FPS:     m.GetFps(),
+		OutTime: time.Duration(m.GetOutTime()),
+		Speed:   m.GetSpeed(),
+		Lease:   m.GetLease(),
+	}
+}
+
+func (f *Failure) message() *workerpb.Failure {
+	return &workerpb.Failure{
+		JobId:   f.JobID,
+		TileNum: int32(f.TileNum),
+		Lease:   f.Lease,
+		Error:   f.Error,
+	}
+}
+
+// FailureFromMessage returns the failure reported over gRPC
+func FailureFromMessage(m *workerpb.Failure) *Failure {
+	return &Failure{
+		JobID:   m.GetJobId(),
+		TileNum: int(m.GetTileNum()),
+		Lease:   m.GetLease(),
+		Error:   m.GetError(),
+	}
+}
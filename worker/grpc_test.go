@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProgressMessage(t *testing.T) {
+	progress := &Progress{JobID: "1d2f", TileNum: 1, Frame: 10, FPS: 25, OutTime: 5 * time.Second, Speed: 1.5, Lease: "9a1c"}
+	require.Equal(t, progress, ProgressFromMessage(progress.message()))
+
+	failure := &Failure{JobID: "1d2f", TileNum: 1, Lease: "9a1c", Error: "exit status 1"}
+	require.Equal(t, failure, FailureFromMessage(failure.message()))
+}
+
+func TestMessageSHA256(t *testing.T) {
+	progress := &Progress{JobID: "1d2f", TileNum: 1, Frame: 10, Lease: "9a1c"}
+	require.Equal(t, MessageSHA256(progress.message()), MessageSHA256(progress.message()))
+
+	other := *progress
+	other.Lease = "5e6f"
+	require.NotEqual(t, MessageSHA256(progress.message()), MessageSHA256(other.message()))
+	require.Equal(t, UnsignedPayload, MessageSHA256(progress))
+}
+
+func TestFromStatus(t *testing.T) {
+	require.ErrorIs(t, fromStatus(status.Error(codes.Unauthenticated, "signature is invalid")), ErrUnauthorized)
+	require.ErrorIs(t, fromStatus(status.Error(codes.PermissionDenied, "API keys are not allowed")), ErrForbidden)
+	require.Equal(t, codes.FailedPrecondition, status.Code(fromStatus(status.Error(codes.FailedPrecondition, "stale"))))
+	require.NoError(t, fromStatus(nil))
+}
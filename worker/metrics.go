@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"distributed-encoder/transcoder"
+)
+
+const metricsNamespace = "encoder_worker"
+
+const (
+	stageEncode = "encode"
+	stageUpload = "upload"
+)
+
+// workerMetrics holds the worker collectors, nil metrics are not collected
+type workerMetrics struct {
+	encodeDuration prometheus.Histogram
+	fps            prometheus.Gauge
+	tilesEncoded   prometheus.Counter
+	bytesIn        prometheus.Counter
+	bytesOut       prometheus.Counter
+	errors         *prometheus.CounterVec
+}
+
+func newWorkerMetrics(reg prometheus.Registerer) (*workerMetrics, error) {
+	m := &workerMetrics{
+		encodeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "encode_duration_seconds",
+			Help:      "Time of the tile encoding including the result upload.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		fps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "encode_fps",
+			Help:      "Latest encoding speed in frames per second.",
+		}),
+		tilesEncoded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tiles_encoded_total",
+			Help:      "Amount of tiles encoded and uploaded.",
+		}),
+		bytesIn: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_in_total",
+			Help:      "Amount of raw tile bytes received from the server.",
+		}),
+		bytesOut: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_out_total",
+			Help:      "Amount of encoded bytes sent to the server.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Amount of failed tiles by the stage.",
+		}, []string{"stage"}),
+	}
+
+	collectors := []prometheus.Collector{m.encodeDuration, m.fps, m.tilesEncoded, m.bytesIn, m.bytesOut, m.errors}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *workerMetrics) progress(p transcoder.Progress) {
+	if m == nil {
+		return
+	}
+	m.fps.Set(p.FPS)
+}
+
+func (m *workerMetrics) encoded(started time.Time) {
+	if m == nil {
+		return
+	}
+	m.tilesEncoded.Inc()
+	m.encodeDuration.Observe(time.Since(started).Seconds())
+}
+
+func (m *workerMetrics) failed(stage string) {
+	if m == nil {
+		return
+	}
+	m.errors.WithLabelValues(stage).Inc()
+}
+
+func (m *workerMetrics) countIn(r io.Reader) io.Reader {
+	if m == nil || r == nil {
+		return r
+	}
+	return &countingReader{Reader: r, counter: m.bytesIn}
+}
+
+func (m *workerMetrics) countOut(r io.Reader) io.Reader {
+	if m == nil || r == nil {
+		return r
+	}
+	return &countingReader{Reader: r, counter: m.bytesOut}
+}
+
+type countingReader struct {
+	io.Reader
+	counter prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"distributed-encoder/logging"
+	"distributed-encoder/transcoder"
+)
+
+// Progress represents the encoding progress of the tile reported to the server
+type Progress struct {
+	JobID   string        `json:"jobId"`
+	TileNum int           `json:"tile"`
+	Frame   int64         `json:"frame"`
+	FPS     float64       `json:"fps"`
+	OutTime time.Duration `json:"outTime"`
+	Speed   float64       `json:"speed"`
+	// Lease is a token of the dispatched job, the progress report renews it, the progress without it is rejected
+	Lease string `json:"lease,omitempty"`
+}
+
+// progressReporter sends the latest encoding progress to the server once per interval,
+// so the slow server doesn't block the ffmpeg progress parsing
+type progressReporter struct {
+	client   Client
+	job      *Job
+	interval time.Duration
+	log      *slog.Logger
+
+	mu     sync.Mutex
+	latest *transcoder.Progress
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func newProgressReporter(client Client, job *Job, interval time.Duration, log *slog.Logger) *progressReporter {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	r := &progressReporter{
+		client:   client,
+		job:      job,
+		interval: interval,
+		log:      log,
+		done:     make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.loop()
+
+	return r
+}
+
+// update stores the progress to be sent on the next tick
+func (r *progressReporter) update(p transcoder.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.latest = &p
+}
+
+// stop stops reporting and drops the pending progress, it's called before the failure is reported and once
+// the result is sent, the lease doesn't accept the progress after them
+func (r *progressReporter) stop() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+}
+
+func (r *progressReporter) loop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *progressReporter) flush() {
+	r.mu.Lock()
+	p := r.latest
+	r.latest = nil
+	r.mu.Unlock()
+
+	if p == nil {
+		return
+	}
+	err := r.client.ReportProgress(&Progress{
+		JobID:   r.job.JobID,
+		TileNum: r.job.TileNum,
+		Frame:   p.Frame,
+		FPS:     p.FPS,
+		OutTime: p.OutTime,
+		Speed:   p.Speed,
+		Lease:   r.job.Lease,
+	})
+	if err != nil {
+		r.log.Warn("progress can't be reported", logging.Err(err))
+	}
+}
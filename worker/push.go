@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"distributed-encoder/logging"
+)
+
+// PushMessageType is a type of the message of the push channel
+type PushMessageType string
+
+const (
+	// PushReady is sent by the worker when it's ready for the next offer
+	PushReady PushMessageType = "ready"
+	// PushOffer is sent by the server with the tile the worker is asked to encode
+	PushOffer PushMessageType = "offer"
+	// PushAck is sent by the worker when it has fetched the offered tile
+	PushAck PushMessageType = "ack"
+	// PushNack is sent by the worker when it can't encode the offered tile, the tile is queued again
+	PushNack PushMessageType = "nack"
+)
+
+// PushMessage is a JSON message of the push channel
+type PushMessage struct {
+	Type  PushMessageType `json:"type"`
+	Offer *Offer          `json:"offer,omitempty"`
+
+	// JobID, TileNum and Lease identify the offer which is acked or nacked
+	JobID   string `json:"jobId,omitempty"`
+	TileNum int    `json:"tileNum,omitempty"`
+	Lease   string `json:"lease,omitempty"`
+	// Reason explains the nack
+	Reason string `json:"reason,omitempty"`
+}
+
+// Offer describes the tile pushed to the worker, the tile itself is fetched over HTTP with the lease
+type Offer struct {
+	JobID    string `json:"jobId"`
+	TileNum  int    `json:"tileNum"`
+	TileName string `json:"tileName"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Lease    string `json:"lease"`
+}
+
+// subscribePush receives the offers on the push channel, the channel is opened again after the failures
+func (c *HTTPClient) subscribePush(ctx context.Context, handlerFunc HandleJobFunc) error {
+	for {
+		err := c.pushSession(ctx, handlerFunc)
+		if ctx.Err() != nil {
+			c.logger().Info("push channel is closed")
+			return ErrCancelled
+		}
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) {
+			// retrying won't help until the credentials are fixed
+			return err
+		}
+		c.logger().Error("push channel failed, retrying", logging.Err(err), slog.Duration("retry_in", defaultRetryTimeout))
+
+		select {
+		case <-ctx.Done():
+			return ErrCancelled
+		case <-time.After(defaultRetryTimeout):
+		}
+	}
+}
+
+func (c *HTTPClient) pushSession(ctx context.Context, handlerFunc HandleJobFunc) error {
+	dialer := websocket.Dialer{HandshakeTimeout: defaultRetryTimeout}
+	if transport, ok := c.client.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+	req, err := http.NewRequest(http.MethodGet, c.pushEndpoint, http.NoBody)
+	if err != nil {
+		return err
+	}
+	c.sign(req)
+
+	conn, res, err := dialer.DialContext(ctx, c.pushEndpoint, req.Header)
+	if err != nil {
+		if res != nil {
+			if statusErr := checkStatus(res); statusErr != nil {
+				return statusErr
+			}
+		}
+		return err
+	}
+	defer conn.Close()
+	// the blocked read is finished when the subscription is canceled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// the messages are read all the time, so the pings of the server are answered while the job is encoded
+	messages := make(chan PushMessage)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			var msg PushMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				readErr <- err
+				return
+			}
+			messages <- msg
+		}
+	}()
+
+	for {
+		if err := conn.WriteJSON(PushMessage{Type: PushReady}); err != nil {
+			return err
+		}
+
+		var offer *Offer
+		for offer == nil {
+			select {
+			case err := <-readErr:
+				return err
+			case msg := <-messages:
+				if msg.Type == PushOffer {
+					offer = msg.Offer
+				}
+			}
+		}
+
+		log := c.logger().With(logging.Job(offer.JobID, offer.TileNum))
+		job, err := c.fetchTile(offer)
+		if err != nil {
+			// the tile is offered to another worker, this one reconnects after a pause
+			nack := PushMessage{Type: PushNack, JobID: offer.JobID, TileNum: offer.TileNum, Lease: offer.Lease, Reason: err.Error()}
+			if writeErr := conn.WriteJSON(nack); writeErr != nil {
+				return writeErr
+			}
+			return fmt.Errorf("tile can't be fetched: %w", err)
+		}
+		ack := PushMessage{Type: PushAck, JobID: offer.JobID, TileNum: offer.TileNum, Lease: offer.Lease}
+		if err := conn.WriteJSON(ack); err != nil {
+			job.Src.Close()
+			return err
+		}
+
+		log.Debug("job received")
+		if err := handlerFunc(job); err != nil {
+			log.Error("job failed", logging.Err(err))
+		}
+		job.Src.Close()
+	}
+}
+
+// fetchTile streams the offered tile, the server streams it only for the current lease
+func (c *HTTPClient) fetchTile(offer *Offer) (*Job, error) {
+	url := c.pollEndpoint + "/" + offer.JobID + "/tiles/" + strconv.Itoa(offer.TileNum)
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	marshalLease(offer.Lease, req.Header)
+	c.sign(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	job, err := ParseJobFromHTTP(res)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return &job, nil
+}
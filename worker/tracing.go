@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "distributed-encoder/worker"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startSpan starts a span of the job continuing the trace of the dispatch
+func startSpan(carrier propagation.MapCarrier, name string, job *Job) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	return tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String("job.id", job.JobID),
+		attribute.Int("tile.num", job.TileNum),
+		attribute.String("tile.name", job.TileName),
+	))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTrace returns the trace context of ctx, nil when there is nothing to propagate
+func injectTrace(ctx context.Context) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
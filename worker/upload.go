@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"distributed-encoder/logging"
+)
+
+const (
+	// UploadOffsetHeader is an offset of the uploaded part, the server answers with the amount of the received bytes
+	UploadOffsetHeader = "X-Upload-Offset"
+	// UploadLengthHeader is a full length of the result, it's sent when the upload is initiated
+	UploadLengthHeader = "X-Upload-Length"
+
+	defaultChunkSize        = 8 << 20
+	defaultUploadAttempts   = 5
+	defaultUploadRetryDelay = time.Second
+)
+
+// UploadStatus is a state of the result upload in parts
+type UploadStatus struct {
+	ID string `json:"id"`
+	// Offset is an amount of the bytes received by the server, the next part starts at it
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// errUploadRetry is returned for the failures the part can be resumed after
+var errUploadRetry = errors.New("upload is interrupted")
+
+// sendChunked spools the result to the file and uploads it in parts, an interrupted part is resumed
+// from the offset acknowledged by the server instead of encoding the tile again
+func (c *HTTPClient) sendChunked(result *Result) error {
+	spool, err := os.CreateTemp(c.spoolDir, "result-*")
+	if err != nil {
+		return fmt.Errorf("can't spool result: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	length, err := io.Copy(spool, result.Src)
+	if err != nil {
+		return err
+	}
+
+	status, err := c.initiateUpload(result, length)
+	if err != nil {
+		return err
+	}
+	log := c.logger().With(logging.Job(result.JobID, result.TileNum), slog.String("upload_id", status.ID))
+
+	for attempt := 1; status.Offset < length; {
+		size := min(c.chunkSize, length-status.Offset)
+		next, err := c.uploadPart(status.ID, status.Offset, io.NewSectionReader(spool, status.Offset, size))
+		if err == nil {
+			status = next
+			attempt = 1
+			continue
+		}
+		if !errors.Is(err, errUploadRetry) || attempt >= c.uploadAttempts {
+			return err
+		}
+		log.Warn("part upload failed, resuming", logging.Err(err), slog.Int64("offset", status.Offset))
+		attempt++
+		time.Sleep(c.uploadRetryDelay)
+
+		if next != nil {
+			status = next
+		} else if next, err := c.uploadStatus(status.ID); err == nil {
+			status = next
+		}
+	}
+
+	return c.completeUpload(status.ID)
+}
+
+func (c *HTTPClient) initiateUpload(result *Result, length int64) (*UploadStatus, error) {
+	req, err := http.NewRequest(http.MethodPost, c.uploadEndpoint, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	MarshalResultToHeader(result, req.Header)
+	req.Header.Set(UploadLengthHeader, strconv.FormatInt(length, 10))
+	c.sign(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := checkStatus(res); err != nil {
+		return nil, err
+	}
+
+	var status UploadStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// uploadPart sends the part at the offset, errUploadRetry is returned with the status of the server when it's known
+func (c *HTTPClient) uploadPart(id string, offset int64, part io.Reader) (*UploadStatus, error) {
+	req, err := http.NewRequest(http.MethodPut, c.uploadEndpoint+"/"+id, part)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+	c.sign(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUploadRetry, err)
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusConflict && res.Header.Get(UploadOffsetHeader) != "":
+		// the server has a different offset, the upload is resumed from it
+		status, err := parseUploadStatus(id, res.Header)
+		if err != nil {
+			return nil, err
+		}
+		return status, fmt.Errorf("%w: server offset is %v", errUploadRetry, status.Offset)
+	case res.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: unexpected status code %v", errUploadRetry, res.StatusCode)
+	}
+	if err := checkStatus(res); err != nil {
+		return nil, err
+	}
+	return parseUploadStatus(id, res.Header)
+}
+
+// uploadStatus asks the server for the amount of the received bytes
+func (c *HTTPClient) uploadStatus(id string) (*UploadStatus, error) {
+	req, err := http.NewRequest(http.MethodHead, c.uploadEndpoint+"/"+id, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if err := checkStatus(res); err != nil {
+		return nil, err
+	}
+	return parseUploadStatus(id, res.Header)
+}
+
+func (c *HTTPClient) completeUpload(id string) error {
+	req, err := http.NewRequest(http.MethodPost, c.uploadEndpoint+"/"+id+"/complete", http.NoBody)
+	if err != nil {
+		return err
+	}
+	c.sign(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return checkStatus(res)
+}
+
+func parseUploadStatus(id string, h http.Header) (*UploadStatus, error) {
+	offset, err := strconv.ParseInt(h.Get(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload offset: %w", err)
+	}
+	length, err := strconv.ParseInt(h.Get(UploadLengthHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload length: %w", err)
+	}
+	return &UploadStatus{ID: id, Offset: offset, Length: length}, nil
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadServer acknowledges only a half of the first part, so the client resumes from the server offset
+type uploadServer struct {
+	t         *testing.T
+	received  []byte
+	parts     int
+	completed bool
+}
+
+func (s *uploadServer) handler() http.Handler {
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/uploads", func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(s.t, "9a1c", req.Header.Get("X-Lease"))
+		length, err := strconv.ParseInt(req.Header.Get(UploadLengthHeader), 10, 64)
+		require.NoError(s.t, err)
+		w.WriteHeader(http.StatusCreated)
+		require.NoError(s.t, json.NewEncoder(w).Encode(UploadStatus{ID: "u1", Length: length}))
+	})
+	router.HandlerFunc(http.MethodPut, "/uploads/:id", func(w http.ResponseWriter, req *http.Request) {
+		offset, err := strconv.ParseInt(req.Header.Get(UploadOffsetHeader), 10, 64)
+		require.NoError(s.t, err)
+		part, err := io.ReadAll(req.Body)
+		require.NoError(s.t, err)
+
+		w.Header().Set(UploadLengthHeader, "19")
+		if offset != int64(len(s.received)) {
+			w.Header().Set(UploadOffsetHeader, strconv.Itoa(len(s.received)))
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.parts++
+		if s.parts == 1 {
+			part = part[:len(part)/2]
+		}
+		s.received = append(s.received, part...)
+		w.Header().Set(UploadOffsetHeader, strconv.Itoa(len(s.received)))
+		if s.parts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.HandlerFunc(http.MethodHead, "/uploads/:id", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(UploadOffsetHeader, strconv.Itoa(len(s.received)))
+		w.Header().Set(UploadLengthHeader, "19")
+	})
+	router.HandlerFunc(http.MethodPost, "/uploads/:id/complete", func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(s.t, "u1", httprouter.ParamsFromContext(req.Context()).ByName("id"))
+		s.completed = true
+	})
+	return router
+}
+
+func TestHTTPClient_SendResultChunked(t *testing.T) {
+	upload := &uploadServer{t: t}
+	server := httptest.NewServer(upload.handler())
+	defer server.Close()
+
+	c, err := NewClient(ClientConfig{
+		PollEndpoint:     server.URL + "/jobs",
+		ResultEndpoint:   server.URL + "/result",
+		ProgressEndpoint: server.URL + "/progress",
+		UploadEndpoint:   server.URL + "/uploads",
+		ChunkSize:        8,
+		SpoolDir:         t.TempDir(),
+		UploadRetryDelay: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	err = c.SendResult(&Result{
+		JobID:    "1d2f",
+		TileNum:  1,
+		FileName: "video_tile_1.ts",
+		Lease:    "9a1c",
+		Src:      strings.NewReader("i'm an encoded file"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "i'm an encoded file", string(upload.received))
+	require.True(t, upload.completed)
+}
+
+func TestHTTPClient_SendResultChunkedRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the lease is stale, retrying won't help
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(ClientConfig{
+		PollEndpoint:     server.URL + "/jobs",
+		ResultEndpoint:   server.URL + "/result",
+		ProgressEndpoint: server.URL + "/progress",
+		UploadEndpoint:   server.URL + "/uploads",
+		SpoolDir:         t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	err = c.SendResult(&Result{JobID: "1d2f", FileName: "video_tile_0.ts", Src: strings.NewReader("encoded")})
+	require.EqualError(t, err, "unexpected status code: 409")
+}
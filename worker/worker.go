@@ -5,15 +5,22 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/propagation"
+
+	"distributed-encoder/logging"
 	"distributed-encoder/transcoder"
 )
 
 // Client is the consumer client for a worker
 type Client interface {
 	Subscribe(context.Context, HandleJobFunc) error
-	SendResult(filename string, src io.Reader) error
+	SendResult(result *Result) error
+	ReportProgress(progress *Progress) error
+	ReportFailure(failure *Failure) error
 }
 
 // VideoEncoder encodes video as a stream
@@ -21,10 +28,17 @@ type VideoEncoder interface {
 	Encode(reader io.Reader, args transcoder.EncodeArgs) (io.ReadCloser, error)
 }
 
+const (
+	defaultProgressInterval = 2 * time.Second
+)
+
 // Worker accepts jobs from the server process them and returns the result
 type Worker struct {
 	client  Client
 	encoder VideoEncoder
+
+	progressInterval time.Duration
+	metrics          *workerMetrics
 }
 
 // New creates a new worker
@@ -38,18 +52,34 @@ func New(client Client, encoder VideoEncoder) (*Worker, error) {
 	w := Worker{
 		client:  client,
 		encoder: encoder,
+
+		progressInterval: defaultProgressInterval,
 	}
 
 	return &w, nil
 }
 
+// logger is resolved on each use, so the default logger configured by the binary is picked up
+func (w *Worker) logger() *slog.Logger {
+	return slog.Default().With(logging.ComponentKey, "worker")
+}
+
+// RegisterMetrics enables the worker metrics collection
+func (w *Worker) RegisterMetrics(reg prometheus.Registerer) error {
+	metrics, err := newWorkerMetrics(reg)
+	if err != nil {
+		return err
+	}
+	w.metrics = metrics
+	return nil
+}
+
 // Start starts worker and blo
 func (w *Worker) Start(ctx context.Context) error {
 	err := w.client.Subscribe(ctx, func(job *Job) error {
-		log.Printf("Job received: %+v", job)
 		err := w.work(job)
 		if err != nil {
-			log.Println("Error work:", err)
+			w.logger().Error("job failed", logging.Job(job.JobID, job.TileNum), logging.Err(err))
 		}
 		return nil
 	})
@@ -60,27 +90,141 @@ func (w *Worker) Start(ctx context.Context) error {
 	return nil
 }
 
-func (w *Worker) work(job *Job) error {
-	output, err := w.encoder.Encode(job.Src, transcoder.EncodeArgs{
+func (w *Worker) work(job *Job) (err error) {
+	started := time.Now()
+	log := w.logger().With(logging.Job(job.JobID, job.TileNum))
+	log.Info("job received", slog.String("tile_name", job.TileName), slog.Int("height", job.Height), slog.Int("width", job.Width))
+
+	ctx, span := startSpan(job.Trace, "worker.Encode", job)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	// the progress is reported until the result is sent, the encoder runs while it's uploaded
+	reporter := newProgressReporter(w.client, job, w.progressInterval, log)
+
+	output, err := w.encoder.Encode(w.metrics.countIn(job.Src), transcoder.EncodeArgs{
 		Height: job.Height,
 		Width:  job.Width,
+		OnProgress: func(p transcoder.Progress) {
+			w.metrics.progress(p)
+			reporter.update(p)
+		},
 	})
 	if err != nil {
+		reporter.stop()
+		w.metrics.failed(stageEncode)
+		w.reportFailure(job, err, log)
 		return err
 	}
-	defer output.Close()
-	err = w.client.SendResult(job.TileName+".ts", bufio.NewReader(output))
+	encoded := &encoderOutput{rc: output}
+	defer func() {
+		if closeErr := encoded.close(); closeErr != nil && err == nil {
+			w.metrics.failed(stageEncode)
+			err = closeErr
+		}
+	}()
+
+	uploadCtx, uploadSpan := tracer().Start(ctx, "worker.SendResult")
+	err = w.client.SendResult(&Result{
+		JobID:    job.JobID,
+		TileNum:  job.TileNum,
+		FileName: job.TileName + ".ts",
+		Lease:    job.Lease,
+		Trace:    injectTrace(uploadCtx),
+		Src:      w.metrics.countOut(bufio.NewReader(encoded)),
+	})
+	endSpan(uploadSpan, err)
+	reporter.stop()
+	if encoded.err != nil {
+		// the upload of the broken output is aborted, the server fails the tile with the encoder error
+		w.metrics.failed(stageEncode)
+		w.reportFailure(job, encoded.err, log)
+		return encoded.err
+	}
 	if err != nil {
+		w.metrics.failed(stageUpload)
 		return err
 	}
-	log.Println("Job is completed!")
+	w.metrics.encoded(started)
+	log.Info("job is completed", slog.Duration("duration", time.Since(started)))
 	return nil
 }
 
+// reportFailure tells the server the tile can't be encoded, so the tile is failed without waiting for its lease to expire
+func (w *Worker) reportFailure(job *Job, encodeErr error, log *slog.Logger) {
+	err := w.client.ReportFailure(&Failure{
+		JobID:   job.JobID,
+		TileNum: job.TileNum,
+		Lease:   job.Lease,
+		Error:   encodeErr.Error(),
+	})
+	if err != nil {
+		log.Warn("failure can't be reported", logging.Err(err))
+	}
+}
+
+// encoderOutput closes the encoder at the end of its output, the encoder error is returned instead of io.EOF,
+// so the truncated output of the failed encoder isn't uploaded as the result
+type encoderOutput struct {
+	rc     io.ReadCloser
+	closed bool
+	// err is the error of the encoder found at the end of the output
+	err error
+}
+
+func (o *encoderOutput) Read(p []byte) (int, error) {
+	n, err := o.rc.Read(p)
+	if err == io.EOF {
+		if o.err = o.close(); o.err != nil {
+			return n, o.err
+		}
+	}
+	return n, err
+}
+
+func (o *encoderOutput) close() error {
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	return o.rc.Close()
+}
+
 // Job represents worker's job
 type Job struct {
+	// JobID is an id of the encode request the tile belongs to
+	JobID string
+	// TileNum is a number of the tile in the request
+	TileNum int
+
 	TileName string
 	Height   int
 	Width    int
-	Src      io.ReadCloser
+	// Lease is an opaque token the result is uploaded with
+	Lease string
+	// Trace is a trace context of the dispatch
+	Trace propagation.MapCarrier
+	Src   io.ReadCloser
+}
+
+// Result represents the encoded tile sent back to the server
+type Result struct {
+	JobID    string
+	TileNum  int
+	FileName string
+	// Lease is a token of the dispatched job
+	Lease string
+	// Trace is a trace context of the upload
+	Trace propagation.MapCarrier
+	Src   io.Reader
+}
+
+// Failure reports the tile the worker couldn't encode
+type Failure struct {
+	JobID   string `json:"jobId"`
+	TileNum int    `json:"tile"`
+	// Lease is a token of the dispatched job, the failure of the stale lease is rejected
+	Lease string `json:"lease"`
+	Error string `json:"error"`
 }
@@ -2,14 +2,19 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
 	"distributed-encoder/transcoder"
@@ -31,6 +36,8 @@ func TestNew(t *testing.T) {
 			want: &Worker{
 				client:  client,
 				encoder: encoder,
+
+				progressInterval: 2 * time.Second,
 			},
 			wantErr: false,
 		},
@@ -60,6 +67,8 @@ func TestWorker_Start(t *testing.T) {
 	input := newStringReader("i'm a file")
 
 	server := fakeServer(t, &Job{
+		JobID:    "1d2f",
+		TileNum:  1,
 		TileName: "1",
 		Height:   100,
 		Width:    200,
@@ -105,6 +114,138 @@ func fakeServer(t *testing.T, sendJob *Job, expected string) *httptest.Server {
 	return httptest.NewServer(router)
 }
 
+func TestWorker_workProgress(t *testing.T) {
+	client := &clientMock{reported: make(chan struct{})}
+	w := Worker{
+		client: client,
+		encoder: progressEncoder{progress: []transcoder.Progress{
+			{Frame: 10, OutTime: time.Second},
+			{Frame: 20, OutTime: 2 * time.Second, Speed: 2, Done: true},
+		}, wait: client.reported},
+		progressInterval: time.Millisecond,
+	}
+
+	err := w.work(&Job{JobID: "1d2f", TileNum: 3, TileName: "video_tile_3", Lease: "9a1c", Src: newStringReader("i'm a file")})
+	require.NoError(t, err)
+
+	// only the latest progress is sent on the tick, the output is read after it's reported
+	require.Equal(t, &Progress{
+		JobID:   "1d2f",
+		TileNum: 3,
+		Frame:   20,
+		OutTime: 2 * time.Second,
+		Speed:   2,
+		Lease:   "9a1c",
+	}, client.progress[0])
+	require.Equal(t, "video_tile_3.ts", client.result.FileName)
+	require.Equal(t, "9a1c", client.result.Lease)
+	// the lease is used by the result, the progress isn't reported after it
+	require.Zero(t, client.lateProgress)
+}
+
+func TestWorker_Metrics(t *testing.T) {
+	w := Worker{
+		client: &clientMock{},
+		encoder: progressEncoder{progress: []transcoder.Progress{
+			{Frame: 20, FPS: 25},
+		}},
+	}
+	require.NoError(t, w.RegisterMetrics(prometheus.NewRegistry()))
+
+	err := w.work(&Job{JobID: "1d2f", Src: newStringReader("i'm a file")})
+	require.NoError(t, err)
+
+	require.Equal(t, float64(25), testutil.ToFloat64(w.metrics.fps))
+	require.Equal(t, float64(1), testutil.ToFloat64(w.metrics.tilesEncoded))
+	require.Equal(t, float64(len("i'm a file")), testutil.ToFloat64(w.metrics.bytesIn))
+	require.Equal(t, float64(len("i'm an encoded file")), testutil.ToFloat64(w.metrics.bytesOut))
+
+	w.client = &clientMock{sendErr: errors.New("connection refused")}
+	require.Error(t, w.work(&Job{JobID: "1d2f", Src: newStringReader("i'm a file")}))
+	require.Equal(t, float64(1), testutil.ToFloat64(w.metrics.errors.WithLabelValues(stageUpload)))
+}
+
+type clientMock struct {
+	mu       sync.Mutex
+	progress []*Progress
+	result   *Result
+	failure  *Failure
+	sendErr  error
+	// reported is closed on the first progress when it's set
+	reported chan struct{}
+	// finished is set once the result is sent or the failure is reported,
+	// lateProgress counts the progress reported after it
+	finished     bool
+	lateProgress int
+}
+
+func (c *clientMock) Subscribe(ctx context.Context, handlerFunc HandleJobFunc) error {
+	return nil
+}
+
+func (c *clientMock) SendResult(result *Result) error {
+	c.result = result
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	_, err := io.Copy(io.Discard, result.Src)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished = true
+	return err
+}
+
+func (c *clientMock) ReportProgress(progress *Progress) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		c.lateProgress++
+	}
+	if c.reported != nil && len(c.progress) == 0 {
+		close(c.reported)
+	}
+	c.progress = append(c.progress, progress)
+	return nil
+}
+
+func (c *clientMock) ReportFailure(failure *Failure) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failure = failure
+	c.finished = true
+	return nil
+}
+
+type progressEncoder struct {
+	progress []transcoder.Progress
+	// wait blocks the output until it's closed when it's set
+	wait chan struct{}
+}
+
+func (e progressEncoder) Encode(reader io.Reader, args transcoder.EncodeArgs) (io.ReadCloser, error) {
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, err
+	}
+	for _, p := range e.progress {
+		args.OnProgress(p)
+	}
+	if e.wait != nil {
+		return waitingReader{ReadCloser: newStringReader("i'm an encoded file"), wait: e.wait}, nil
+	}
+	return newStringReader("i'm an encoded file"), nil
+}
+
+// waitingReader blocks the reads until wait is closed
+type waitingReader struct {
+	io.ReadCloser
+	wait chan struct{}
+}
+
+func (r waitingReader) Read(p []byte) (int, error) {
+	<-r.wait
+	return r.ReadCloser.Read(p)
+}
+
 type encoderMock struct{}
 
 func (e encoderMock) Encode(reader io.Reader, args transcoder.EncodeArgs) (io.ReadCloser, error) {
@@ -116,3 +257,40 @@ func newStringReader(src string) io.ReadCloser {
 	encoded := ioutil.NopCloser(strings.NewReader(src))
 	return encoded
 }
+
+func TestWorker_workEncoderFailed(t *testing.T) {
+	cmdErr := &transcoder.CmdError{Args: []string{"ffmpeg"}, Err: errors.New("exit status 1"), Stderr: "Invalid data found"}
+	client := &clientMock{}
+	w := Worker{
+		client:           client,
+		encoder:          failedEncoder{err: cmdErr},
+		progressInterval: time.Hour,
+	}
+	require.NoError(t, w.RegisterMetrics(prometheus.NewRegistry()))
+
+	// the encoder failure is found at the end of its output, the upload fails instead of sending the truncated result
+	err := w.work(&Job{JobID: "1d2f", TileNum: 2, Lease: "9a1c", Src: newStringReader("i'm a file")})
+	require.ErrorIs(t, err, cmdErr)
+	require.Equal(t, float64(1), testutil.ToFloat64(w.metrics.errors.WithLabelValues(stageEncode)))
+	require.Equal(t, &Failure{JobID: "1d2f", TileNum: 2, Lease: "9a1c", Error: cmdErr.Error()}, client.failure)
+	// the pending progress is dropped, the failure revokes the lease
+	require.Empty(t, client.progress)
+}
+
+type failedEncoder struct {
+	err error
+}
+
+func (e failedEncoder) Encode(reader io.Reader, args transcoder.EncodeArgs) (io.ReadCloser, error) {
+	args.OnProgress(transcoder.Progress{Frame: 10})
+	return failedOutput{Reader: strings.NewReader(""), err: e.err}, nil
+}
+
+type failedOutput struct {
+	io.Reader
+	err error
+}
+
+func (o failedOutput) Close() error {
+	return o.err
+}
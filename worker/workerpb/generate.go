@@ -0,0 +1,4 @@
+// Package workerpb is the generated code of the worker gRPC service, see worker.proto
+package workerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative worker.proto
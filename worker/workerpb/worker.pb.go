@@ -0,0 +1,743 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: worker.proto
+
+package workerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// JobRequest is sent by the worker on the jobs stream when it's ready for more jobs
+type JobRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// jobs is an amount of the jobs the worker is ready for, the server sends them one after another
+	Jobs          int32 `protobuf:"varint,1,opt,name=jobs,proto3" json:"jobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobRequest) Reset() {
+	*x = JobRequest{}
+	mi := &file_worker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobRequest) ProtoMessage() {}
+
+func (x *JobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobRequest.ProtoReflect.Descriptor instead.
+func (*JobRequest) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *JobRequest) GetJobs() int32 {
+	if x != nil {
+		return x.Jobs
+	}
+	return 0
+}
+
+// JobChunk is a part of the dispatched job, the first chunk has the header, the following ones have the tile data
+// and the last one has eof set
+type JobChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *JobHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Eof           bool                   `protobuf:"varint,3,opt,name=eof,proto3" json:"eof,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobChunk) Reset() {
+	*x = JobChunk{}
+	mi := &file_worker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobChunk) ProtoMessage() {}
+
+func (x *JobChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobChunk.ProtoReflect.Descriptor instead.
+func (*JobChunk) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *JobChunk) GetHeader() *JobHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *JobChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *JobChunk) GetEof() bool {
+	if x != nil {
+		return x.Eof
+	}
+	return false
+}
+
+// JobHeader describes the dispatched job
+type JobHeader struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	JobId    string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	TileNum  int32                  `protobuf:"varint,2,opt,name=tile_num,json=tileNum,proto3" json:"tile_num,omitempty"`
+	TileName string                 `protobuf:"bytes,3,opt,name=tile_name,json=tileName,proto3" json:"tile_name,omitempty"`
+	Height   int32                  `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	Width    int32                  `protobuf:"varint,5,opt,name=width,proto3" json:"width,omitempty"`
+	// lease is an opaque token the result is uploaded with
+	Lease string `protobuf:"bytes,6,opt,name=lease,proto3" json:"lease,omitempty"`
+	// trace is a trace context of the dispatch
+	Trace         map[string]string `protobuf:"bytes,7,rep,name=trace,proto3" json:"trace,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobHeader) Reset() {
+	*x = JobHeader{}
+	mi := &file_worker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobHeader) ProtoMessage() {}
+
+func (x *JobHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobHeader.ProtoReflect.Descriptor instead.
+func (*JobHeader) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *JobHeader) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *JobHeader) GetTileNum() int32 {
+	if x != nil {
+		return x.TileNum
+	}
+	return 0
+}
+
+func (x *JobHeader) GetTileName() string {
+	if x != nil {
+		return x.TileName
+	}
+	return ""
+}
+
+func (x *JobHeader) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *JobHeader) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *JobHeader) GetLease() string {
+	if x != nil {
+		return x.Lease
+	}
+	return ""
+}
+
+func (x *JobHeader) GetTrace() map[string]string {
+	if x != nil {
+		return x.Trace
+	}
+	return nil
+}
+
+// ResultChunk is a part of the uploaded result, the first chunk has the header
+type ResultChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *ResultHeader          `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResultChunk) Reset() {
+	*x = ResultChunk{}
+	mi := &file_worker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResultChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResultChunk) ProtoMessage() {}
+
+func (x *ResultChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResultChunk.ProtoReflect.Descriptor instead.
+func (*ResultChunk) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ResultChunk) GetHeader() *ResultHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ResultChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// ResultHeader describes the uploaded result
+type ResultHeader struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	JobId    string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	TileNum  int32                  `protobuf:"varint,2,opt,name=tile_num,json=tileNum,proto3" json:"tile_num,omitempty"`
+	FileName string                 `protobuf:"bytes,3,opt,name=file_name,json=fileName,proto3" json:"file_name,omitempty"`
+	// lease is a token of the dispatched job
+	Lease string `protobuf:"bytes,4,opt,name=lease,proto3" json:"lease,omitempty"`
+	// trace is a trace context of the upload
+	Trace         map[string]string `protobuf:"bytes,5,rep,name=trace,proto3" json:"trace,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResultHeader) Reset() {
+	*x = ResultHeader{}
+	mi := &file_worker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResultHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResultHeader) ProtoMessage() {}
+
+func (x *ResultHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResultHeader.ProtoReflect.Descriptor instead.
+func (*ResultHeader) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ResultHeader) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *ResultHeader) GetTileNum() int32 {
+	if x != nil {
+		return x.TileNum
+	}
+	return 0
+}
+
+func (x *ResultHeader) GetFileName() string {
+	if x != nil {
+		return x.FileName
+	}
+	return ""
+}
+
+func (x *ResultHeader) GetLease() string {
+	if x != nil {
+		return x.Lease
+	}
+	return ""
+}
+
+func (x *ResultHeader) GetTrace() map[string]string {
+	if x != nil {
+		return x.Trace
+	}
+	return nil
+}
+
+// UploadReply is sent when the result is stored
+type UploadReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// size is an amount of the stored bytes
+	Size          int64 `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadReply) Reset() {
+	*x = UploadReply{}
+	mi := &file_worker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadReply) ProtoMessage() {}
+
+func (x *UploadReply) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadReply.ProtoReflect.Descriptor instead.
+func (*UploadReply) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UploadReply) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+// Progress is the encoding progress of the tile
+type Progress struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	JobId   string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	TileNum int32                  `protobuf:"varint,2,opt,name=tile_num,json=tileNum,proto3" json:"tile_num,omitempty"`
+	Frame   int64                  `protobuf:"varint,3,opt,name=frame,proto3" json:"frame,omitempty"`
+	Fps     float64                `protobuf:"fixed64,4,opt,name=fps,proto3" json:"fps,omitempty"`
+	// out_time is an encoded duration in nanoseconds
+	OutTime int64   `protobuf:"varint,5,opt,name=out_time,json=outTime,proto3" json:"out_time,omitempty"`
+	Speed   float64 `protobuf:"fixed64,6,opt,name=speed,proto3" json:"speed,omitempty"`
+	// lease is a token of the dispatched job, the progress renews it
+	Lease         string `protobuf:"bytes,7,opt,name=lease,proto3" json:"lease,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Progress) Reset() {
+	*x = Progress{}
+	mi := &file_worker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Progress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Progress) ProtoMessage() {}
+
+func (x *Progress) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Progress.ProtoReflect.Descriptor instead.
+func (*Progress) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Progress) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *Progress) GetTileNum() int32 {
+	if x != nil {
+		return x.TileNum
+	}
+	return 0
+}
+
+func (x *Progress) GetFrame() int64 {
+	if x != nil {
+		return x.Frame
+	}
+	return 0
+}
+
+func (x *Progress) GetFps() float64 {
+	if x != nil {
+		return x.Fps
+	}
+	return 0
+}
+
+func (x *Progress) GetOutTime() int64 {
+	if x != nil {
+		return x.OutTime
+	}
+	return 0
+}
+
+func (x *Progress) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+func (x *Progress) GetLease() string {
+	if x != nil {
+		return x.Lease
+	}
+	return ""
+}
+
+// Failure reports the tile the worker couldn't encode
+type Failure struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	JobId   string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	TileNum int32                  `protobuf:"varint,2,opt,name=tile_num,json=tileNum,proto3" json:"tile_num,omitempty"`
+	// lease is a token of the dispatched job, the failure of the stale lease is rejected
+	Lease         string `protobuf:"bytes,3,opt,name=lease,proto3" json:"lease,omitempty"`
+	Error         string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Failure) Reset() {
+	*x = Failure{}
+	mi := &file_worker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Failure) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Failure) ProtoMessage() {}
+
+func (x *Failure) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Failure.ProtoReflect.Descriptor instead.
+func (*Failure) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Failure) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *Failure) GetTileNum() int32 {
+	if x != nil {
+		return x.TileNum
+	}
+	return 0
+}
+
+func (x *Failure) GetLease() string {
+	if x != nil {
+		return x.Lease
+	}
+	return ""
+}
+
+func (x *Failure) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Ack acknowledges the call without a result
+type Ack struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ack) Reset() {
+	*x = Ack{}
+	mi := &file_worker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_worker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_worker_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Ack) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+var File_worker_proto protoreflect.FileDescriptor
+
+const file_worker_proto_rawDesc = "" +
+	"\n" +
+	"\fworker.proto\x12\aencoder\" \n" +
+	"\n" +
+	"JobRequest\x12\x12\n" +
+	"\x04jobs\x18\x01 \x01(\x05R\x04jobs\"\\\n" +
+	"\bJobChunk\x12*\n" +
+	"\x06header\x18\x01 \x01(\v2\x12.encoder.JobHeaderR\x06header\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x10\n" +
+	"\x03eof\x18\x03 \x01(\bR\x03eof\"\x8d\x02\n" +
+	"\tJobHeader\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x19\n" +
+	"\btile_num\x18\x02 \x01(\x05R\atileNum\x12\x1b\n" +
+	"\ttile_name\x18\x03 \x01(\tR\btileName\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\x12\x14\n" +
+	"\x05width\x18\x05 \x01(\x05R\x05width\x12\x14\n" +
+	"\x05lease\x18\x06 \x01(\tR\x05lease\x123\n" +
+	"\x05trace\x18\a \x03(\v2\x1d.encoder.JobHeader.TraceEntryR\x05trace\x1a8\n" +
+	"\n" +
+	"TraceEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"P\n" +
+	"\vResultChunk\x12-\n" +
+	"\x06header\x18\x01 \x01(\v2\x15.encoder.ResultHeaderR\x06header\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"\xe5\x01\n" +
+	"\fResultHeader\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x19\n" +
+	"\btile_num\x18\x02 \x01(\x05R\atileNum\x12\x1b\n" +
+	"\tfile_name\x18\x03 \x01(\tR\bfileName\x12\x14\n" +
+	"\x05lease\x18\x04 \x01(\tR\x05lease\x126\n" +
+	"\x05trace\x18\x05 \x03(\v2 .encoder.ResultHeader.TraceEntryR\x05trace\x1a8\n" +
+	"\n" +
+	"TraceEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"!\n" +
+	"\vUploadReply\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x03R\x04size\"\xab\x01\n" +
+	"\bProgress\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x19\n" +
+	"\btile_num\x18\x02 \x01(\x05R\atileNum\x12\x14\n" +
+	"\x05frame\x18\x03 \x01(\x03R\x05frame\x12\x10\n" +
+	"\x03fps\x18\x04 \x01(\x01R\x03fps\x12\x19\n" +
+	"\bout_time\x18\x05 \x01(\x03R\aoutTime\x12\x14\n" +
+	"\x05speed\x18\x06 \x01(\x01R\x05speed\x12\x14\n" +
+	"\x05lease\x18\a \x01(\tR\x05lease\"g\n" +
+	"\aFailure\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x19\n" +
+	"\btile_num\x18\x02 \x01(\x05R\atileNum\x12\x14\n" +
+	"\x05lease\x18\x03 \x01(\tR\x05lease\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"\x15\n" +
+	"\x03Ack\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok2\xd8\x01\n" +
+	"\x06Worker\x122\n" +
+	"\x04Jobs\x12\x13.encoder.JobRequest\x1a\x11.encoder.JobChunk(\x010\x01\x126\n" +
+	"\x06Upload\x12\x14.encoder.ResultChunk\x1a\x14.encoder.UploadReply(\x01\x121\n" +
+	"\x0eReportProgress\x12\x11.encoder.Progress\x1a\f.encoder.Ack\x12/\n" +
+	"\rReportFailure\x12\x10.encoder.Failure\x1a\f.encoder.AckB%Z#distributed-encoder/worker/workerpbb\x06proto3"
+
+var (
+	file_worker_proto_rawDescOnce sync.Once
+	file_worker_proto_rawDescData []byte
+)
+
+func file_worker_proto_rawDescGZIP() []byte {
+	file_worker_proto_rawDescOnce.Do(func() {
+		file_worker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_worker_proto_rawDesc), len(file_worker_proto_rawDesc)))
+	})
+	return file_worker_proto_rawDescData
+}
+
+var file_worker_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_worker_proto_goTypes = []any{
+	(*JobRequest)(nil),   // 0: encoder.JobRequest
+	(*JobChunk)(nil),     // 1: encoder.JobChunk
+	(*JobHeader)(nil),    // 2: encoder.JobHeader
+	(*ResultChunk)(nil),  // 3: encoder.ResultChunk
+	(*ResultHeader)(nil), // 4: encoder.ResultHeader
+	(*UploadReply)(nil),  // 5: encoder.UploadReply
+	(*Progress)(nil),     // 6: encoder.Progress
+	(*Failure)(nil),      // 7: encoder.Failure
+	(*Ack)(nil),          // 8: encoder.Ack
+	nil,                  // 9: encoder.JobHeader.TraceEntry
+	nil,                  // 10: encoder.ResultHeader.TraceEntry
+}
+var file_worker_proto_depIdxs = []int32{
+	2,  // 0: encoder.JobChunk.header:type_name -> encoder.JobHeader
+	9,  // 1: encoder.JobHeader.trace:type_name -> encoder.JobHeader.TraceEntry
+	4,  // 2: encoder.ResultChunk.header:type_name -> encoder.ResultHeader
+	10, // 3: encoder.ResultHeader.trace:type_name -> encoder.ResultHeader.TraceEntry
+	0,  // 4: encoder.Worker.Jobs:input_type -> encoder.JobRequest
+	3,  // 5: encoder.Worker.Upload:input_type -> encoder.ResultChunk
+	6,  // 6: encoder.Worker.ReportProgress:input_type -> encoder.Progress
+	7,  // 7: encoder.Worker.ReportFailure:input_type -> encoder.Failure
+	1,  // 8: encoder.Worker.Jobs:output_type -> encoder.JobChunk
+	5,  // 9: encoder.Worker.Upload:output_type -> encoder.UploadReply
+	8,  // 10: encoder.Worker.ReportProgress:output_type -> encoder.Ack
+	8,  // 11: encoder.Worker.ReportFailure:output_type -> encoder.Ack
+	8,  // [8:12] is the sub-list for method output_type
+	4,  // [4:8] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_worker_proto_init() }
+func file_worker_proto_init() {
+	if File_worker_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_worker_proto_rawDesc), len(file_worker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_worker_proto_goTypes,
+		DependencyIndexes: file_worker_proto_depIdxs,
+		MessageInfos:      file_worker_proto_msgTypes,
+	}.Build()
+	File_worker_proto = out.File
+	file_worker_proto_goTypes = nil
+	file_worker_proto_depIdxs = nil
+}
@@ -0,0 +1,235 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: worker.proto
+
+package workerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Worker_Jobs_FullMethodName           = "/encoder.Worker/Jobs"
+	Worker_Upload_FullMethodName         = "/encoder.Worker/Upload"
+	Worker_ReportProgress_FullMethodName = "/encoder.Worker/ReportProgress"
+	Worker_ReportFailure_FullMethodName  = "/encoder.Worker/ReportFailure"
+)
+
+// WorkerClient is the client API for Worker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Worker receives the tiles and their results, the calls are signed the same way as the HTTP requests
+type WorkerClient interface {
+	// Jobs is a bidirectional stream, the worker asks for the jobs and receives them in chunks
+	Jobs(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[JobRequest, JobChunk], error)
+	// Upload is a client stream of the result chunks, the first chunk has the header
+	Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ResultChunk, UploadReply], error)
+	// ReportProgress reports the encoding progress and renews the lease
+	ReportProgress(ctx context.Context, in *Progress, opts ...grpc.CallOption) (*Ack, error)
+	// ReportFailure reports the tile which can't be encoded, the tile fails
+	ReportFailure(ctx context.Context, in *Failure, opts ...grpc.CallOption) (*Ack, error)
+}
+
+type workerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWorkerClient(cc grpc.ClientConnInterface) WorkerClient {
+	return &workerClient{cc}
+}
+
+func (c *workerClient) Jobs(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[JobRequest, JobChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Worker_ServiceDesc.Streams[0], Worker_Jobs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[JobRequest, JobChunk]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Worker_JobsClient = grpc.BidiStreamingClient[JobRequest, JobChunk]
+
+func (c *workerClient) Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ResultChunk, UploadReply], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Worker_ServiceDesc.Streams[1], Worker_Upload_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ResultChunk, UploadReply]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Worker_UploadClient = grpc.ClientStreamingClient[ResultChunk, UploadReply]
+
+func (c *workerClient) ReportProgress(ctx context.Context, in *Progress, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, Worker_ReportProgress_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) ReportFailure(ctx context.Context, in *Failure, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, Worker_ReportFailure_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WorkerServer is the server API for Worker service.
+// All implementations must embed UnimplementedWorkerServer
+// for forward compatibility.
+//
+// Worker receives the tiles and their results, the calls are signed the same way as the HTTP requests
+type WorkerServer interface {
+	// Jobs is a bidirectional stream, the worker asks for the jobs and receives them in chunks
+	Jobs(grpc.BidiStreamingServer[JobRequest, JobChunk]) error
+	// Upload is a client stream of the result chunks, the first chunk has the header
+	Upload(grpc.ClientStreamingServer[ResultChunk, UploadReply]) error
+	// ReportProgress reports the encoding progress and renews the lease
+	ReportProgress(context.Context, *Progress) (*Ack, error)
+	// ReportFailure reports the tile which can't be encoded, the tile fails
+	ReportFailure(context.Context, *Failure) (*Ack, error)
+	mustEmbedUnimplementedWorkerServer()
+}
+
+// UnimplementedWorkerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWorkerServer struct{}
+
+func (UnimplementedWorkerServer) Jobs(grpc.BidiStreamingServer[JobRequest, JobChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method Jobs not implemented")
+}
+func (UnimplementedWorkerServer) Upload(grpc.ClientStreamingServer[ResultChunk, UploadReply]) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedWorkerServer) ReportProgress(context.Context, *Progress) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportProgress not implemented")
+}
+func (UnimplementedWorkerServer) ReportFailure(context.Context, *Failure) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportFailure not implemented")
+}
+func (UnimplementedWorkerServer) mustEmbedUnimplementedWorkerServer() {}
+func (UnimplementedWorkerServer) testEmbeddedByValue()                {}
+
+// UnsafeWorkerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WorkerServer will
+// result in compilation errors.
+type UnsafeWorkerServer interface {
+	mustEmbedUnimplementedWorkerServer()
+}
+
+func RegisterWorkerServer(s grpc.ServiceRegistrar, srv WorkerServer) {
+	// If the following call pancis, it indicates UnimplementedWorkerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Worker_ServiceDesc, srv)
+}
+
+func _Worker_Jobs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WorkerServer).Jobs(&grpc.GenericServerStream[JobRequest, JobChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Worker_JobsServer = grpc.BidiStreamingServer[JobRequest, JobChunk]
+
+func _Worker_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WorkerServer).Upload(&grpc.GenericServerStream[ResultChunk, UploadReply]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Worker_UploadServer = grpc.ClientStreamingServer[ResultChunk, UploadReply]
+
+func _Worker_ReportProgress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Progress)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).ReportProgress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_ReportProgress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).ReportProgress(ctx, req.(*Progress))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_ReportFailure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Failure)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).ReportFailure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_ReportFailure_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).ReportFailure(ctx, req.(*Failure))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Worker_ServiceDesc is the grpc.ServiceDesc for Worker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Worker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "encoder.Worker",
+	HandlerType: (*WorkerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReportProgress",
+			Handler:    _Worker_ReportProgress_Handler,
+		},
+		{
+			MethodName: "ReportFailure",
+			Handler:    _Worker_ReportFailure_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Jobs",
+			Handler:       _Worker_Jobs_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Upload",
+			Handler:       _Worker_Upload_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "worker.proto",
+}